@@ -4,6 +4,7 @@ go 1.14
 
 require (
 	github.com/ethereum/go-ethereum v1.9.22-0.20200915092951-cf2a77af28e5
+	github.com/golang/snappy v0.0.2-0.20200707131729-196ae77b8a26
 	github.com/naoina/toml v0.1.2-0.20170918210437-9fafd6967416
 	github.com/syndtr/goleveldb v1.0.1-0.20200815110645-5c35d600f0ca
 	go.uber.org/atomic v1.7.0 // indirect
@@ -2,51 +2,178 @@ package main
 
 import (
 	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
-	"time"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/holiman/nodemonitor/nodes"
 	"github.com/naoina/toml"
 )
 
+// Exit codes used by the check command
+const (
+	exitOK          = 0
+	exitError       = 1
+	exitUnreachable = 2
+	exitSplit       = 3
+)
+
+// command is a subcommand of the binary
+type command struct {
+	name  string
+	usage string // arguments, following the name
+	help  string
+	run   func(cmd *command, args []string) int
+}
+
+var commands = []*command{
+	{"run", "[options] <config.toml>", "Monitor the nodes and serve the dashboard", runCmd},
+	{"check", "[options] <config.toml>", "Run the checks once, print the report and exit. " +
+		"Exits with code 2 if any node is unreachable, and 3 if a split was detected", checkCmd},
+	{"agent", "<config.toml>", "Poll the local nodes and push their blocks to a central monitor", agentCmd},
+	{"config validate", "<config.toml>", "Check the config file for errors", validateCmd},
+	{"db inspect", "[options]", "Show what's stored in the block database", dbInspectCmd},
+	{"db export", "[options]", "Dump the block database as json or rlp", dbExportCmd},
+	{"db import", "[options] <file>", "Restore entries from a dump into the block database", dbImportCmd},
+	{"report verify", "[options] <data.json>", "Verify the signature of a report, stored next to it as <file>.sig", reportVerifyCmd},
+}
+
 // ssh -L 8546:localhost:8545 ubuntu@nethermind.ethdevops.io
 // ssh -L 8547:localhost:8545 ubuntu@besu.ethdevops.io
 // ssh -L 8548:localhost:8545 ubuntu@mon02.ethdevops.io
 func main() {
 	// Initialize the logger
 	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+	os.Exit(dispatch(os.Args[1:]))
+}
+
+// dispatch runs the subcommand given by the first (or first two) arguments
+func dispatch(args []string) int {
+	if len(args) > 0 {
+		// Before there were subcommands, the config file was the only
+		// argument, optionally preceded by -once
+		switch {
+		case args[0] == "-once" || args[0] == "--once":
+			log.Warn("The -once flag is deprecated, use the 'check' command")
+			args = append([]string{"check"}, args[1:]...)
+		case strings.HasSuffix(args[0], ".toml"):
+			log.Warn("Running without a command is deprecated, use the 'run' command")
+			args = append([]string{"run"}, args...)
+		}
+	}
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == cmd.name {
+			return cmd.run(cmd, args[len(words):])
+		}
+	}
+	usage()
+	return exitError
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [arguments]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %v\n", cmd.name, cmd.help)
+	}
+}
+
+// flagSet creates the flag set of a command, with usage output listing its
+// arguments
+func (cmd *command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s %s\n\n%s\n\n", os.Args[0], cmd.name, cmd.usage, cmd.help)
+		fs.PrintDefaults()
+	}
+	return fs
+}
 
-	if len(os.Args) < 2 {
-		log.Error("Second arg must be path to config file")
-		os.Exit(1)
+// loadConfig reads and decodes the config file given as the only positional
+// argument
+func loadConfig(fs *flag.FlagSet) (*nodes.Config, error) {
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return nil, errors.New("path to config file required")
 	}
-	cFile := os.Args[1]
-	f, err := os.Open(cFile)
+	f, err := os.Open(fs.Arg(0))
 	if err != nil {
-		log.Error("Error", "error", err)
-		os.Exit(1)
+		return nil, err
 	}
 	defer f.Close()
 
 	var config nodes.Config
 	if err := toml.NewDecoder(f).Decode(&config); err != nil {
-		log.Error("Error", "error", err)
-		os.Exit(1)
+		return nil, err
 	}
-	nodes.EnableMetrics(&config)
+	return &config, nil
+}
+
+// network is a monitored network, along with its config
+type network struct {
+	conf *nodes.Config
+	mon  *nodes.NodeMonitor
+}
 
-	mon, err := spinupMonitor(config)
+// startMonitors loads the config, sets up logging and metrics, and creates a
+// monitor per network, each of which does one round of checks upon creation.
+func startMonitors(fs *flag.FlagSet, dbPath string) ([]*network, *nodes.Config, error) {
+	config, err := loadConfig(fs)
 	if err != nil {
-		log.Error("Error", "error", err)
-		os.Exit(1)
+		return nil, nil, err
 	}
+	if err := nodes.SetupLogging(config); err != nil {
+		return nil, nil, err
+	}
+	nodes.EnableMetrics(config)
+	confs := config.NetworkConfigs()
+	if len(confs) == 0 {
+		return nil, nil, errors.New("no clients configured")
+	}
+	var networks []*network
+	for _, conf := range confs {
+		mon, err := spinupMonitor(*conf, conf.DBPath(dbPath))
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(config.Networks) == 0 {
+			mon.SetConfigFile(fs.Arg(0))
+		}
+		networks = append(networks, &network{conf, mon})
+	}
+	if len(config.Networks) > 0 {
+		log.Info("Monitoring multiple networks, changes to the nodes made via the API are not persisted")
+	}
+	return networks, config, nil
+}
 
-	spinupServer(config)
+func runCmd(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	dbPath := fs.String("db", "blockDB", "Path to the block database")
+	fs.Parse(args)
 
-	mon.Start()
+	networks, config, err := startMonitors(fs, *dbPath)
+	if err != nil {
+		log.Error("Error", "error", err)
+		return exitError
+	}
+	if err := spinupServer(*config, networks); err != nil {
+		log.Error("Error", "error", err)
+		return exitError
+	}
+
+	for _, n := range networks {
+		n.mon.Start()
+	}
 	// Wait for ctrl-c
 	quitCh := make(chan os.Signal, 1)
 	signal.Notify(quitCh, os.Interrupt)
@@ -54,55 +181,360 @@ func main() {
 	// TODO: Monitor changes to the config file
 
 	<-quitCh
-	mon.Stop()
-	os.Exit(0)
+	for _, n := range networks {
+		n.mon.Stop()
+	}
+	return exitOK
 }
 
-func spinupMonitor(config nodes.Config) (*nodes.NodeMonitor, error) {
-	db, err := nodes.NewBlockDB()
+func checkCmd(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	dbPath := fs.String("db", "blockDB", "Path to the block database")
+	fs.Parse(args)
+
+	networks, _, err := startMonitors(fs, *dbPath)
 	if err != nil {
-		return nil, err
+		log.Error("Error", "error", err)
+		return exitError
+	}
+	// The exit codes are ordered by severity, the worst one is returned
+	code := exitOK
+	for _, n := range networks {
+		if len(n.conf.Namespace()) > 0 {
+			log.Info("Checked network", "network", n.conf.Namespace())
+		}
+		if c := checkOnce(n.mon); c > code {
+			code = c
+		}
+	}
+	return code
+}
+
+// checkOnce prints the result of the last check and returns the exit code
+// to use.
+func checkOnce(mon *nodes.NodeMonitor) int {
+	if r := mon.Report(); r != nil {
+		r.Print()
+	}
+	err := mon.Verdict()
+	switch {
+	case err == nil:
+		log.Info("All nodes in agreement")
+		return exitOK
+	case errors.Is(err, nodes.ErrSplitDetected):
+		log.Error("Check failed", "error", err)
+		return exitSplit
+	case errors.Is(err, nodes.ErrNodesUnreachable):
+		log.Error("Check failed", "error", err)
+		return exitUnreachable
+	default:
+		log.Error("Check failed", "error", err)
+		return exitError
+	}
+}
+
+func agentCmd(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	fs.Parse(args)
+
+	config, err := loadConfig(fs)
+	if err == nil {
+		err = config.Validate()
+	}
+	if err == nil && len(config.Agent.Upstream) == 0 {
+		err = errors.New("no agent upstream configured")
 	}
-	reload, err := time.ParseDuration(config.ReloadInterval)
 	if err != nil {
-		return nil, err
+		log.Error("Invalid config", "error", err)
+		return exitError
 	}
+	if err := nodes.SetupLogging(config); err != nil {
+		log.Error("Error", "error", err)
+		return exitError
+	}
+	// The agent keeps no block database, the central monitor does
 	var clients []nodes.Node
-	for _, c := range config.Clients {
-		var (
-			node nodes.Node
-			err  error
-		)
-		switch c.Kind {
-		case "infura":
-			node, err = nodes.NewInfuraNode(c.Name, config.InfuraKey, config.InfuraEndpoint,
-				db, c.Ratelimit)
-		case "alchemy":
-			node, err = nodes.NewAlchemyNode(c.Name, config.AlchemyKey, config.AlchemyEndpoint,
-				db, c.Ratelimit)
-		case "rpc":
-			node, err = nodes.NewRPCNode(c.Name, c.Url, db, c.Ratelimit)
-		default:
-			log.Error("Wrong client type", "kind", c.Kind, "available", "[rpc, infura, alchemy]")
-			return nil, errors.New("invalid config")
+	for i, c := range config.Clients {
+		if c.Kind == "beacon" || c.Kind == "agent" || c.Kind == "ethstats" {
+			log.Warn("Skipping client, agents only observe execution nodes", "name", c.Name, "kind", c.Kind)
+			continue
 		}
+		node, _, err := nodes.NewClient(&config.Clients[i], config, nil)
+		if err != nil {
+			log.Error("Error", "error", err)
+			return exitError
+		}
+		clients = append(clients, node)
+	}
+	agent, err := nodes.NewAgent(clients, config)
+	if err != nil {
+		log.Error("Error", "error", err)
+		return exitError
+	}
+	log.Info("Pushing observations", "upstream", config.Agent.Upstream, "nodes", len(clients))
+	agent.Start()
+
+	quitCh := make(chan os.Signal, 1)
+	signal.Notify(quitCh, os.Interrupt)
+	<-quitCh
+	agent.Stop()
+	return exitOK
+}
+
+func validateCmd(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	fs.Parse(args)
+
+	config, err := loadConfig(fs)
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		log.Error("Invalid config", "error", err)
+		return exitError
+	}
+	log.Info("Config OK", "clients", len(config.Clients), "networks", len(config.Networks))
+	return exitOK
+}
+
+func dbInspectCmd(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	dbPath := fs.String("db", "blockDB", "Path to the block database")
+	listHeaders := fs.Bool("headers", false, "List the stored headers")
+	fs.Parse(args)
+
+	db, err := nodes.OpenBlockDB(*dbPath)
+	if err != nil {
+		log.Error("Error opening database", "error", err)
+		return exitError
+	}
+	defer db.Close()
+	if *listHeaders {
+		headers, err := db.Headers()
+		if err != nil {
+			log.Error("Error reading headers", "error", err)
+			return exitError
+		}
+		for _, h := range headers {
+			fmt.Printf("%d %x parent=%x time=%d\n", h.Number, h.Hash, h.Parent, h.Time)
+		}
+		return exitOK
+	}
+	stats := db.Stats()
+	var kinds []string
+	for kind := range stats {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Printf("%-10s %d\n", kind, stats[kind])
+	}
+	return exitOK
+}
+
+func dbExportCmd(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	dbPath := fs.String("db", "blockDB", "Path to the block database")
+	out := fs.String("out", "", "File to write to (default stdout)")
+	format := fs.String("format", "json", "Output format, json or rlp")
+	fs.Parse(args)
+
+	db, err := nodes.OpenBlockDB(*dbPath)
+	if err != nil {
+		log.Error("Error opening database", "error", err)
+		return exitError
+	}
+	defer db.Close()
+	w := os.Stdout
+	if len(*out) > 0 {
+		if w, err = os.Create(*out); err != nil {
+			log.Error("Error creating output file", "error", err)
+			return exitError
+		}
+		defer w.Close()
+	}
+	if err := db.Export(w, *format); err != nil {
+		log.Error("Export failed", "error", err)
+		return exitError
+	}
+	return exitOK
+}
+
+func dbImportCmd(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	dbPath := fs.String("db", "blockDB", "Path to the block database")
+	format := fs.String("format", "json", "Input format, json or rlp")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitError
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Error("Error opening dump", "error", err)
+		return exitError
+	}
+	defer f.Close()
+	db, err := nodes.OpenBlockDB(*dbPath)
+	if err != nil {
+		log.Error("Error opening database", "error", err)
+		return exitError
+	}
+	defer db.Close()
+	count, err := db.Import(f, *format)
+	if err != nil {
+		log.Error("Import failed", "imported", count, "error", err)
+		return exitError
+	}
+	log.Info("Import done", "entries", count)
+	return exitOK
+}
+
+func reportVerifyCmd(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	key := fs.String("key", "", "Public key the report must be signed with, hex-encoded, as served at /api/identity")
+	anyKey := fs.Bool("any-key", false, "Accept the key embedded in the signature, only checking the report wasn't corrupted")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitError
+	}
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		log.Error("Error reading report", "error", err)
+		return exitError
+	}
+	sig, err := ioutil.ReadFile(fs.Arg(0) + ".sig")
+	if err != nil {
+		log.Error("Error reading signature", "error", err)
+		return exitError
+	}
+	if len(*key) == 0 {
+		if !*anyKey {
+			log.Error("No public key given, pass the key of the monitor with -key")
+			return exitError
+		}
+		// Anyone can sign a forged report with a key of their own
+		if *key, err = nodes.SignatureKey(sig); err != nil {
+			log.Error("Verification failed", "error", err)
+			return exitError
+		}
+		log.Warn("NOT VERIFYING WHO SIGNED THE REPORT: trusting the key embedded in the signature", "key", *key)
+	}
+	identity, err := nodes.VerifyReport(data, sig, *key)
+	if err != nil {
+		log.Error("Verification failed", "error", err)
+		return exitError
+	}
+	log.Info("Signature valid", "identity", identity, "key", *key)
+	return exitOK
+}
+
+func spinupMonitor(config nodes.Config, dbPath string) (*nodes.NodeMonitor, error) {
+	db, err := nodes.OpenBlockDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		clients []nodes.Node
+		beacons []*nodes.BeaconNode
+	)
+	for i := range config.Clients {
+		node, beacon, err := nodes.NewClient(&config.Clients[i], &config, db)
 		if err != nil {
 			return nil, err
 		}
+		if beacon != nil {
+			beacons = append(beacons, beacon)
+			log.Info("Beacon node configured", "name", beacon.Name())
+			continue
+		}
 		clients = append(clients, node)
-		log.Info("Client configured", "name", c.Name)
+		log.Info("Client configured", "name", node.Name())
 	}
 
-	return nodes.NewMonitor(clients, db, reload)
+	return nodes.NewMonitor(clients, beacons, db, &config)
 }
 
-func spinupServer(config nodes.Config) error {
+// spinupServer serves the dashboard and API of the first network at the root,
+// and those of every network under /networks/<name>/
+func spinupServer(config nodes.Config, networks []*network) error {
 	if len(config.ServerAddress) == 0 {
 		return nil
 	}
-	fs := http.FileServer(http.Dir("www/"))
-	http.Handle("/", http.StripPrefix("/", fs))
+	auth, err := nodes.NewAPIAuth(config.API)
+	if err != nil {
+		return err
+	}
+	// Not the default mux, which the pprof handlers register with on import
+	mux := http.NewServeMux()
+	mux.Handle("/", auth.Wrap(networkMux(networks[0])))
+	for _, n := range networks {
+		if name := n.conf.Namespace(); len(name) > 0 {
+			prefix := "/networks/" + name
+			mux.Handle(prefix+"/", http.StripPrefix(prefix, auth.Wrap(networkMux(n))))
+		}
+	}
+	if config.Pprof {
+		mux.Handle("/debug/pprof/", auth.Wrap(pprofMux()))
+	}
+	handler, err := nodes.NewHTTPHandler(config.HTTP, mux)
+	if err != nil {
+		return err
+	}
 	log.Info("Starting web server", "address", config.ServerAddress)
-	go http.ListenAndServe(config.ServerAddress, nil)
+	go http.ListenAndServe(config.ServerAddress, handler)
 	return nil
 }
+
+// pprofMux routes the profiling handlers of net/http/pprof
+func pprofMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// networkMux routes the dashboard and API of the network
+func networkMux(n *network) *http.ServeMux {
+	mon, mux := n.mon, http.NewServeMux()
+	mux.Handle("/", networkFiles(n.conf.OutputDir()))
+	mux.HandleFunc("/healthz", mon.HandleHealthz)
+	mux.HandleFunc("/readyz", mon.HandleReadyz)
+	mux.HandleFunc("/api/reports", mon.Signed(mon.HandleReports))
+	mux.HandleFunc("/api/mute", mon.HandleMute)
+	mux.HandleFunc("/api/check", mon.HandleCheck)
+	mux.HandleFunc("/api/nodes", mon.Signed(mon.HandleNodes))
+	mux.HandleFunc("/api/nodes/", mon.Signed(mon.HandleNodes))
+	mux.HandleFunc("/api/height/", mon.Signed(mon.HandleHeight))
+	mux.HandleFunc("/api/heads", mon.Signed(mon.HandleHeads))
+	mux.HandleFunc("/api/graphql", mon.Signed(mon.HandleGraphQL))
+	mux.HandleFunc("/api/grafana/", mon.Signed(mon.HandleGrafana))
+	mux.HandleFunc("/api/identity", mon.HandleIdentity)
+	mux.HandleFunc("/api/federation", mon.Signed(mon.HandleFederation))
+	mux.HandleFunc("/api/agent", mon.HandleAgent)
+	// Nodes report to ws://<host>/api with --ethstats
+	mux.HandleFunc("/api", mon.HandleEthstats)
+	mux.HandleFunc("/rpc", mon.HandleRPC)
+	mux.HandleFunc("/api/checkpoint", mon.Signed(mon.HandleCheckpoint))
+	return mux
+}
+
+// networkFiles serves the reports and artifacts from the directory of the
+// network, and the dashboard itself from www/
+func networkFiles(dir string) http.Handler {
+	own, shared := http.FileServer(http.Dir(dir)), http.FileServer(http.Dir("www/"))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+		if fi, err := os.Stat(name); err == nil && !fi.IsDir() {
+			own.ServeHTTP(w, r)
+			return
+		}
+		shared.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,470 @@
+package nodes
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// agentMaxObservation is the max size of the observations pushed by an agent
+const agentMaxObservation = 4 * 1024 * 1024
+
+type agentConfig struct {
+	// Upstream is the agent endpoint of the central monitor, e.g.
+	// https://central.example.org/api/agent, which the observations of the
+	// local nodes are pushed to when running as an agent
+	Upstream string
+	// Token is the bearer token the agent authenticates with
+	Token string
+	// Depth is the number of recent blocks pushed per node, which is how
+	// deep splits can be located by the central monitor. Defaults to 128.
+	Depth uint64
+}
+
+type collectorConfig struct {
+	// Agents are the agents which may push the observations of their nodes.
+	// Their nodes are configured as clients of kind "agent".
+	Agents []agentAuth
+	// MaxAge is how old the last observation of an agent node may be before
+	// the node is considered unreachable. Defaults to 1m.
+	MaxAge string
+}
+
+type agentAuth struct {
+	Name  string
+	Token string
+}
+
+// agentBlockJson is a block observed by an agent
+type agentBlockJson struct {
+	Number   uint64
+	Hash     common.Hash
+	Time     uint64
+	GasUsed  uint64
+	GasLimit uint64
+	BaseFee  *big.Int       `json:",omitempty"`
+	Miner    common.Address `json:",omitempty"`
+	TxCount  int
+}
+
+// agentNodeJson is what an agent observed of a node in one cycle
+type agentNodeJson struct {
+	Name    string
+	Version string `json:",omitempty"`
+	// Error is set if the node could not be reached
+	Error string `json:",omitempty"`
+	Head  uint64
+	// Blocks are the recent blocks of the node, from the head down
+	Blocks []*agentBlockJson `json:",omitempty"`
+}
+
+// agentObservationJson is what an agent pushes each cycle
+type agentObservationJson struct {
+	Time  time.Time
+	Nodes []*agentNodeJson
+}
+
+// Agent polls nodes it runs next to and pushes their recent blocks to a
+// central monitor, which does the split analysis. Only the agent needs
+// access to the RPC of the nodes.
+type Agent struct {
+	nodes    []Node
+	url      string
+	token    string
+	depth    uint64
+	interval time.Duration
+	client   *http.Client
+	versions map[string]string
+	// sent are the hashes pushed in the last cycle, by node and number
+	sent   map[string]map[uint64]common.Hash
+	quitCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewAgent creates an agent pushing the observations of the nodes to the
+// upstream configured under Agent
+func NewAgent(nodes []Node, conf *Config) (*Agent, error) {
+	if _, err := url.ParseRequestURI(conf.Agent.Upstream); err != nil {
+		return nil, fmt.Errorf("invalid agent upstream: %v", err)
+	}
+	interval, auto, err := parseInterval(conf.ReloadInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reload_interval: %v", err)
+	}
+	if interval == 0 || auto {
+		interval = 10 * time.Second
+	}
+	a := &Agent{
+		nodes:    nodes,
+		url:      conf.Agent.Upstream,
+		token:    conf.Agent.Token,
+		depth:    conf.Agent.Depth,
+		interval: interval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		versions: make(map[string]string),
+		sent:     make(map[string]map[uint64]common.Hash),
+		quitCh:   make(chan struct{}),
+	}
+	if a.depth == 0 {
+		a.depth = 128
+	}
+	return a, nil
+}
+
+func (a *Agent) Start() {
+	a.wg.Add(1)
+	go a.loop()
+}
+
+func (a *Agent) Stop() {
+	close(a.quitCh)
+	a.wg.Wait()
+}
+
+func (a *Agent) loop() {
+	defer a.wg.Done()
+	for {
+		if err := a.push(a.observe()); err != nil {
+			log.Warn("Failed to push observations", "upstream", a.url, "error", err)
+		}
+		select {
+		case <-a.quitCh:
+			return
+		case <-time.After(a.interval):
+		}
+	}
+}
+
+// observe fetches the heads of the nodes, and the blocks below them
+func (a *Agent) observe() *agentObservationJson {
+	obs := &agentObservationJson{Time: time.Now()}
+	for _, node := range a.nodes {
+		nj := &agentNodeJson{Name: node.Name()}
+		obs.Nodes = append(obs.Nodes, nj)
+		if len(a.versions[node.Name()]) == 0 {
+			if v, err := node.Version(); err == nil {
+				a.versions[node.Name()] = v
+			}
+		}
+		nj.Version = a.versions[node.Name()]
+		if err := node.UpdateLatest(); err != nil {
+			nj.Error = err.Error()
+			continue
+		}
+		nj.Head = node.HeadNum()
+		nj.Blocks = a.recentBlocks(node)
+	}
+	return obs
+}
+
+// recentBlocks returns the blocks from the head down to the depth. Blocks are
+// refetched until one matches what was pushed last time, as a reorg only
+// replaces the blocks above that.
+func (a *Agent) recentBlocks(node Node) []*agentBlockJson {
+	var (
+		head   = node.HeadNum()
+		sent   = a.sent[node.Name()]
+		hashes = make(map[uint64]common.Hash)
+		blocks []*agentBlockJson
+		force  = true
+	)
+	for i := uint64(0); i < a.depth && i <= head; i++ {
+		bl := node.BlockAt(head-i, force)
+		if bl == nil {
+			break
+		}
+		if force && sent[bl.num] == bl.hash {
+			force = false
+		}
+		hashes[bl.num] = bl.hash
+		blocks = append(blocks, &agentBlockJson{
+			Number:   bl.num,
+			Hash:     bl.hash,
+			Time:     bl.time,
+			GasUsed:  bl.gasUsed,
+			GasLimit: bl.gasLimit,
+			BaseFee:  bl.baseFee,
+			Miner:    bl.miner,
+			TxCount:  bl.txCount,
+		})
+	}
+	a.sent[node.Name()] = hashes
+	return blocks
+}
+
+func (a *Agent) push(obs *agentObservationJson) error {
+	data, err := json.Marshal(obs)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", a.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	res, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("agent push failed: %v: %s", res.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// agentNode is a node observed by an agent. It only knows the blocks the
+// agent pushed, and is unreachable if the agent stopped pushing.
+type agentNode struct {
+	name      string
+	agent     string
+	maxAge    time.Duration
+	headGauge metrics.Gauge
+
+	mu           sync.Mutex
+	version      string
+	status       int
+	latest       *blockInfo
+	chainHistory *blockCache
+	obs          *agentNodeJson // last pushed
+	received     time.Time
+}
+
+// newAgentNode creates a node whose observations are pushed by the agent
+func newAgentNode(name, agent string, maxAge time.Duration, reg metrics.Registry) *agentNode {
+	return &agentNode{
+		name:         name,
+		agent:        agent,
+		maxAge:       maxAge,
+		headGauge:    metrics.GetOrRegisterGauge(fmt.Sprintf("head/%v", name), reg),
+		version:      "n/a",
+		chainHistory: newBlockCache(hashCacheSize),
+	}
+}
+
+func (node *agentNode) Name() string {
+	return node.name
+}
+
+func (node *agentNode) SetStatus(status int) {
+	node.status = status
+}
+
+func (node *agentNode) Status() int {
+	return node.status
+}
+
+func (node *agentNode) Version() (string, error) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	return node.version, nil
+}
+
+func (node *agentNode) HeadNum() uint64 {
+	if node.latest != nil {
+		return node.latest.num
+	}
+	return 0
+}
+
+// UpdateLatest takes the head from the last observation pushed by the agent
+func (node *agentNode) UpdateLatest() error {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	switch {
+	case node.obs == nil:
+		return fmt.Errorf("no observations from agent %v", node.agent)
+	case time.Since(node.received) > node.maxAge:
+		return fmt.Errorf("no observations from agent %v since %v", node.agent, node.received.Format(time.RFC3339))
+	case len(node.obs.Error) > 0:
+		return fmt.Errorf("agent %v: %v", node.agent, node.obs.Error)
+	}
+	bl, ok := node.chainHistory.get(node.obs.Head)
+	if !ok {
+		return fmt.Errorf("agent %v didn't push the head block", node.agent)
+	}
+	node.latest = bl
+	node.headGauge.Update(int64(bl.num))
+	return nil
+}
+
+// BlockAt returns the block if the agent pushed it, the node is never asked
+// directly
+func (node *agentNode) BlockAt(num uint64, force bool) *blockInfo {
+	if node.latest != nil && node.latest.num < num {
+		return nil
+	}
+	bl, _ := node.chainHistory.get(num)
+	return bl
+}
+
+func (node *agentNode) HashAt(num uint64, force bool) common.Hash {
+	if bl := node.BlockAt(num, force); bl != nil {
+		return bl.hash
+	}
+	return common.Hash{}
+}
+
+// receive stores the observation pushed by the agent
+func (node *agentNode) receive(nj *agentNodeJson, now time.Time) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.obs, node.received = nj, now
+	if len(nj.Version) > 0 {
+		node.version = nj.Version
+	}
+	for _, b := range nj.Blocks {
+		bl := &blockInfo{
+			num:      b.Number,
+			hash:     b.Hash,
+			time:     b.Time,
+			gasUsed:  b.GasUsed,
+			gasLimit: b.GasLimit,
+			baseFee:  b.BaseFee,
+			miner:    b.Miner,
+			txCount:  b.TxCount,
+			received: now,
+		}
+		if prev, ok := node.chainHistory.get(bl.num); ok && prev.hash == bl.hash {
+			bl.received = prev.received
+		}
+		node.chainHistory.add(bl)
+	}
+}
+
+// collector receives the observations pushed by agents
+type collector struct {
+	agents []agentAuth
+
+	mu    sync.Mutex
+	nodes map[string]*agentNode // by name
+}
+
+// newCollector returns nil if no agents are configured
+func newCollector(conf collectorConfig) (*collector, error) {
+	if len(conf.Agents) == 0 {
+		return nil, nil
+	}
+	names, tokens := make(map[string]bool), make(map[string]bool)
+	for _, a := range conf.Agents {
+		if len(a.Name) == 0 || len(a.Token) == 0 {
+			return nil, errors.New("agent without name or token")
+		}
+		if names[a.Name] || tokens[a.Token] {
+			return nil, fmt.Errorf("duplicate agent %v", a.Name)
+		}
+		names[a.Name], tokens[a.Token] = true, true
+	}
+	if _, err := conf.maxAge(); err != nil {
+		return nil, err
+	}
+	return &collector{agents: conf.Agents, nodes: make(map[string]*agentNode)}, nil
+}
+
+func (conf *collectorConfig) maxAge() (time.Duration, error) {
+	if len(conf.MaxAge) == 0 {
+		return time.Minute, nil
+	}
+	d, err := time.ParseDuration(conf.MaxAge)
+	if err != nil {
+		return 0, fmt.Errorf("invalid collector max age: %v", err)
+	}
+	return d, nil
+}
+
+// hasAgent returns whether the agent is configured under Collector
+func (c *Config) hasAgent(name string) bool {
+	for _, a := range c.Collector.Agents {
+		if a.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// add registers the node if it's observed by an agent
+func (c *collector) add(node Node) {
+	if an, ok := node.(*agentNode); ok {
+		c.mu.Lock()
+		c.nodes[an.name] = an
+		c.mu.Unlock()
+	}
+}
+
+func (c *collector) forget(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.nodes, name)
+}
+
+// agent returns the agent authenticating with the bearer token, or ""
+func (c *collector) agent(r *http.Request) string {
+	have := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	for _, a := range c.agents {
+		if subtle.ConstantTimeCompare([]byte(have), []byte(a.Token)) == 1 {
+			return a.Name
+		}
+	}
+	return ""
+}
+
+// receive hands the observations to the nodes of the agent. It returns
+// whether any node was updated.
+func (c *collector) receive(agent string, obs *agentObservationJson, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	updated := false
+	for _, nj := range obs.Nodes {
+		node, ok := c.nodes[nj.Name]
+		if !ok || node.agent != agent {
+			log.Debug("Ignoring observation of unknown node", "agent", agent, "node", nj.Name)
+			continue
+		}
+		node.receive(nj, now)
+		updated = true
+	}
+	return updated
+}
+
+// HandleAgent receives the observations pushed by agents to /api/agent, and
+// schedules a check cycle
+func (mon *NodeMonitor) HandleAgent(w http.ResponseWriter, r *http.Request) {
+	c := mon.collector
+	if c == nil {
+		http.Error(w, "no agents configured", http.StatusNotFound)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	agent := c.agent(r)
+	if len(agent) == 0 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var obs agentObservationJson
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, agentMaxObservation)).Decode(&obs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if c.receive(agent, &obs, time.Now()) {
+		mon.headChanged()
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
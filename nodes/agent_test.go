@@ -0,0 +1,73 @@
+package nodes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestAgent(t *testing.T) {
+	var a, b = make([]*blockInfo, 200), make([]*blockInfo, 200)
+	for i := range a {
+		a[i] = &blockInfo{num: uint64(i), hash: common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("a :%d", i))))}
+		b[i] = a[i]
+		if i >= 150 {
+			b[i] = &blockInfo{num: uint64(i), hash: common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("b :%d", i))))}
+		}
+	}
+	local := []Node{newTestNode("a", 199, a), newTestNode("b", 190, b)}
+
+	// The central monitor only sees what the agent pushes
+	conf := &Config{ReloadInterval: "1s", Collector: collectorConfig{Agents: []agentAuth{{Name: "eu", Token: "eu-token"}}}}
+	var remote []Node
+	for _, node := range local {
+		remote = append(remote, newAgentNode(node.Name(), "eu", time.Minute, registry))
+	}
+	mon, err := NewMonitor(remote, nil, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(mon.HandleAgent))
+	defer srv.Close()
+
+	agent, err := NewAgent(local, &Config{Agent: agentConfig{Upstream: srv.URL, Token: "wrong", Depth: 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := agent.push(agent.observe()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected unauthorized, got %v", err)
+	}
+	agent.token = "eu-token"
+	if err := agent.push(agent.observe()); err != nil {
+		t.Fatal(err)
+	}
+	mon.doChecks()
+	if err := mon.Verdict(); !errors.Is(err, ErrSplitDetected) {
+		t.Fatalf("expected split, got %v", err)
+	}
+	found := false
+	for _, ev := range mon.Report().Events {
+		if ev.Kind == EventSplit && strings.Contains(ev.Message, "block 150") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("split not located: %+v", mon.Report().Events)
+	}
+	// Only the blocks within the depth are pushed
+	if bl := remote[0].BlockAt(99, false); bl != nil {
+		t.Errorf("block below the depth known: %v", bl.num)
+	}
+	// Nodes are unreachable once the agent stops pushing
+	remote[0].(*agentNode).received = time.Now().Add(-2 * time.Minute)
+	if err := remote[0].UpdateLatest(); err == nil {
+		t.Error("stale observation accepted")
+	}
+}
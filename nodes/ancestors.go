@@ -0,0 +1,59 @@
+package nodes
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ancestorSet holds the last block each pair of nodes was seen to agree on,
+// so that the search for an ongoing split doesn't start over every cycle. It's
+// safe for concurrent use, as pairs are compared in parallel.
+type ancestorSet struct {
+	mu     sync.Mutex
+	blocks map[[2]string]uint64
+}
+
+func newAncestorSet() *ancestorSet {
+	return &ancestorSet{blocks: make(map[[2]string]uint64)}
+}
+
+func pairKey(a, b string) [2]string {
+	if b < a {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+func (s *ancestorSet) set(a, b Node, num uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocks[pairKey(a.Name(), b.Name())] = num
+}
+
+// forget drops all pairs involving the named node
+func (s *ancestorSet) forget(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.blocks {
+		if key[0] == name || key[1] == name {
+			delete(s.blocks, key)
+		}
+	}
+}
+
+// get returns the last block the nodes agreed on below the given number. The
+// block is refetched from both nodes, since either may have reorged since.
+func (s *ancestorSet) get(a, b Node, below uint64) (uint64, bool) {
+	s.mu.Lock()
+	num, ok := s.blocks[pairKey(a.Name(), b.Name())]
+	s.mu.Unlock()
+	if !ok || num >= below {
+		return 0, false
+	}
+	ha, hb := a.HashAt(num, true), b.HashAt(num, true)
+	if ha != hb || ha == (common.Hash{}) {
+		return 0, false
+	}
+	return num, true
+}
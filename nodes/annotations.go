@@ -0,0 +1,113 @@
+package nodes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type grafanaConfig struct {
+	// Url is the base url of the Grafana instance
+	Url string
+	// Token is a service account token, allowed to write annotations
+	Token string
+	// DashboardUID limits the annotations to a dashboard, and PanelID to a
+	// panel on it. Without a dashboard, the annotations are organization
+	// wide.
+	DashboardUID string
+	PanelID      int
+	// Tags are added to the annotations, along with the event kind and the
+	// nodes
+	Tags []string
+	// Kinds are the event kinds to annotate. Defaults to splits and reorgs.
+	Kinds []string
+}
+
+// grafanaAnnotator pushes annotations to Grafana when splits and reorgs are
+// detected. Once the condition is over, the annotation is turned into a
+// region ending at the recovery.
+type grafanaAnnotator struct {
+	url       string
+	header    http.Header
+	dashboard string
+	panel     int
+	tags      []string
+	kinds     kindFilter
+	// ids are the annotations of the ongoing conditions, by fingerprint
+	ids map[string]int64
+}
+
+func newGrafanaAnnotator(conf grafanaConfig) (*grafanaAnnotator, error) {
+	if len(conf.Url) == 0 {
+		return nil, nil
+	}
+	if len(conf.Token) == 0 {
+		return nil, errors.New("grafana annotations need a token")
+	}
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer "+conf.Token)
+	return &grafanaAnnotator{
+		url:       strings.TrimSuffix(conf.Url, "/") + "/api/annotations",
+		header:    header,
+		dashboard: conf.DashboardUID,
+		panel:     conf.PanelID,
+		tags:      conf.Tags,
+		kinds:     newKindFilter(conf.Kinds, splitKinds...),
+		ids:       make(map[string]int64),
+	}, nil
+}
+
+type grafanaAnnotation struct {
+	DashboardUID string   `json:"dashboardUID,omitempty"`
+	PanelID      int      `json:"panelId,omitempty"`
+	Time         int64    `json:"time,omitempty"`
+	TimeEnd      int64    `json:"timeEnd,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	Text         string   `json:"text,omitempty"`
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// Notify annotates the start of the condition. Reminders about a condition
+// which is still ongoing aren't annotated again.
+func (n *grafanaAnnotator) Notify(ev *Event) error {
+	if !n.kinds.match(ev) {
+		return nil
+	}
+	if _, ok := n.ids[ev.fingerprint()]; ok {
+		return nil
+	}
+	tags := append(append([]string{}, n.tags...), ev.Kind)
+	tags = append(tags, ev.Nodes...)
+	var res struct {
+		ID int64 `json:"id"`
+	}
+	err := sendJSON("POST", n.url, &grafanaAnnotation{
+		DashboardUID: n.dashboard,
+		PanelID:      n.panel,
+		Time:         millis(ev.Time),
+		Tags:         tags,
+		Text:         fmt.Sprintf("[%v] %v: %v", ev.Severity, ev.Kind, ev.Message),
+	}, n.header, &res)
+	if err != nil {
+		return err
+	}
+	n.ids[ev.fingerprint()] = res.ID
+	return nil
+}
+
+func (n *grafanaAnnotator) Recovered(ev *Event) error {
+	fp := ev.fingerprint()
+	id, ok := n.ids[fp]
+	if !ok {
+		return nil
+	}
+	delete(n.ids, fp)
+	return postJSON("PATCH", fmt.Sprintf("%v/%d", n.url, id), &grafanaAnnotation{
+		TimeEnd: millis(time.Now()),
+	}, n.header)
+}
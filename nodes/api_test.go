@@ -9,7 +9,7 @@ import (
 func TestInfura(t *testing.T) {
 	key := os.Getenv("INFURA_KEY")
 	fmt.Printf("key: %v\n", key)
-	node, err := NewInfuraNode("Infura", key, "https://mainnet.infura.io/v3/", nil, 1)
+	node, err := NewInfuraNode("Infura", key, "https://mainnet.infura.io/v3/", nil, 1, nil, registry)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -25,7 +25,7 @@ func TestInfura(t *testing.T) {
 func TestAlchemy(t *testing.T) {
 	key := os.Getenv("ALCHEMY_KEY")
 	fmt.Printf("key: %v\n", key)
-	node, err := NewAlchemyNode("Alchemy", key, "https://eth-mainnet.alchemyapi.io/v2/", nil, 1)
+	node, err := NewAlchemyNode("Alchemy", key, "https://eth-mainnet.alchemyapi.io/v2/", nil, 1, nil, registry)
 	if err != nil {
 		t.Fatal(err)
 	}
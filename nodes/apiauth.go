@@ -0,0 +1,372 @@
+package nodes
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// API roles. Admins may also do everything readers can.
+const (
+	RoleRead  = "read"
+	RoleAdmin = "admin"
+)
+
+// oidcKeyRefresh is how often the keys of the OIDC provider may be refetched,
+// when a token is signed by an unknown key
+const oidcKeyRefresh = time.Minute
+
+type apiAuthConfig struct {
+	// Users may authenticate with basic auth or a bearer token
+	Users []apiUser
+	// OIDC accepts bearer tokens issued by an OpenID Connect provider
+	OIDC oidcConfig
+}
+
+type apiUser struct {
+	Name string
+	// Password is used for basic auth
+	Password string
+	// Token is used as bearer token
+	Token string
+	// Role is "read" (the default) or "admin"
+	Role string
+}
+
+type oidcConfig struct {
+	// Issuer is the url of the provider, whose discovery document lists its
+	// signing keys
+	Issuer string
+	// ClientID is the audience the tokens must be issued for
+	ClientID string
+	// RolesClaim is the claim listing the groups or roles of the user.
+	// Defaults to "groups".
+	RolesClaim string
+	// AdminRoles are the groups or roles granting the admin role. Other
+	// users of the provider get the read role.
+	AdminRoles []string
+}
+
+func (c *apiAuthConfig) enabled() bool {
+	return len(c.Users) > 0 || len(c.OIDC.Issuer) > 0
+}
+
+// APIAuth authenticates the requests to the dashboard and API, and checks
+// the role of the user against the endpoint
+type APIAuth struct {
+	users []apiUser
+	oidc  *oidcVerifier
+}
+
+// NewAPIAuth returns nil if no users or provider are configured
+func NewAPIAuth(conf apiAuthConfig) (*APIAuth, error) {
+	if !conf.enabled() {
+		return nil, nil
+	}
+	a := new(APIAuth)
+	for _, u := range conf.Users {
+		if len(u.Role) == 0 {
+			u.Role = RoleRead
+		}
+		if u.Role != RoleRead && u.Role != RoleAdmin {
+			return nil, fmt.Errorf("api user %v: invalid role %q", u.Name, u.Role)
+		}
+		if len(u.Password) == 0 && len(u.Token) == 0 {
+			return nil, fmt.Errorf("api user %v: missing password or token", u.Name)
+		}
+		a.users = append(a.users, u)
+	}
+	if len(conf.OIDC.Issuer) > 0 {
+		if _, err := url.Parse(conf.OIDC.Issuer); err != nil {
+			return nil, fmt.Errorf("invalid oidc issuer: %v", err)
+		}
+		if len(conf.OIDC.ClientID) == 0 {
+			return nil, errors.New("missing oidc client id")
+		}
+		a.oidc = newOIDCVerifier(conf.OIDC)
+	}
+	return a, nil
+}
+
+// requiredRole returns the role needed for the request, or an empty string
+// for endpoints which authenticate their peers on their own, or are probed by
+// orchestrators
+func requiredRole(r *http.Request) string {
+	path := r.URL.Path
+	switch {
+	case path == "/healthz" || path == "/readyz":
+		return ""
+	case path == "/api" || path == "/api/agent":
+		return ""
+	case path == "/api/federation" && r.Method == "POST":
+		return ""
+	case path == "/api/mute" || path == "/api/check" || strings.HasPrefix(path, "/debug/pprof/"):
+		return RoleAdmin
+	case (path == "/api/nodes" || strings.HasPrefix(path, "/api/nodes/")) && r.Method != "GET" && r.Method != "HEAD":
+		return RoleAdmin
+	}
+	return RoleRead
+}
+
+// authenticate returns the name and role of the user making the request
+func (a *APIAuth) authenticate(r *http.Request) (string, string, error) {
+	if name, password, ok := r.BasicAuth(); ok {
+		for _, u := range a.users {
+			if len(u.Password) > 0 && u.Name == name && equalSecret(u.Password, password) {
+				return u.Name, u.Role, nil
+			}
+		}
+		return "", "", errors.New("invalid username or password")
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", "", errors.New("missing credentials")
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	for _, u := range a.users {
+		if len(u.Token) > 0 && equalSecret(u.Token, token) {
+			return u.Name, u.Role, nil
+		}
+	}
+	if a.oidc != nil {
+		return a.oidc.verify(token, time.Now())
+	}
+	return "", "", errors.New("invalid token")
+}
+
+// equalSecret compares the secrets in constant time
+func equalSecret(a, b string) bool {
+	ha, hb := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// apiRoleKey is the context key of the role of the authenticated user
+type apiRoleKey struct{}
+
+// apiRole returns the role of the user, if the request was authenticated by
+// APIAuth
+func apiRole(r *http.Request) string {
+	role, _ := r.Context().Value(apiRoleKey{}).(string)
+	return role
+}
+
+// Wrap returns the handler, only passing on the requests of users with the
+// role required by the endpoint. The role is passed on in the context of the
+// request. If a is nil, the handler is returned as is.
+func (a *APIAuth) Wrap(h http.Handler) http.Handler {
+	if a == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		need := requiredRole(r)
+		if len(need) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		name, role, err := a.authenticate(r)
+		if err != nil {
+			log.Debug("Unauthenticated API request", "path", r.URL.Path, "remote", r.RemoteAddr, "error", err)
+			if len(a.users) > 0 {
+				// Have browsers prompt for the dashboard credentials
+				w.Header().Set("WWW-Authenticate", `Basic realm="nodemonitor"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if need == RoleAdmin && role != RoleAdmin {
+			log.Info("Forbidden API request", "user", name, "method", r.Method, "path", r.URL.Path)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiRoleKey{}, role)))
+	})
+}
+
+// oidcVerifier checks RS256-signed ID or access tokens against the keys
+// published by the provider
+type oidcVerifier struct {
+	conf   oidcConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+func newOIDCVerifier(conf oidcConfig) *oidcVerifier {
+	if len(conf.RolesClaim) == 0 {
+		conf.RolesClaim = "groups"
+	}
+	conf.Issuer = strings.TrimSuffix(conf.Issuer, "/")
+	return &oidcVerifier{conf: conf, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (v *oidcVerifier) getJSON(url string, result interface{}) error {
+	res, err := v.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v: %v", url, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+// fetchKeys loads the RSA signing keys of the provider via its discovery
+// document
+func (v *oidcVerifier) fetchKeys() (map[string]*rsa.PublicKey, error) {
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := v.getJSON(v.conf.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := v.getJSON(discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+// key returns the key with the given id, refetching the keys of the provider
+// if it's not known, as after a key rotation
+func (v *oidcVerifier) key(kid string, now time.Time) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if k, ok := v.keys[kid]; ok {
+		return k, nil
+	}
+	if now.Sub(v.fetched) < oidcKeyRefresh {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	v.fetched = now
+	keys, err := v.fetchKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch oidc keys: %v", err)
+	}
+	v.keys = keys
+	if k, ok := v.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// verify checks the signature and claims of the token, and returns the
+// subject and its role
+func (v *oidcVerifier) verify(token string, now time.Time) (string, string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", "", errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", "", err
+	}
+	if header.Alg != "RS256" {
+		return "", "", fmt.Errorf("unsupported token algorithm %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", "", errors.New("malformed token signature")
+	}
+	key, err := v.key(header.Kid, now)
+	if err != nil {
+		return "", "", err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return "", "", errors.New("invalid token signature")
+	}
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", "", err
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != v.conf.Issuer {
+		return "", "", fmt.Errorf("wrong token issuer %q", iss)
+	}
+	if !hasClaim(claims["aud"], v.conf.ClientID) {
+		return "", "", errors.New("token not issued for this client")
+	}
+	exp, _ := claims["exp"].(float64)
+	if now.Unix() >= int64(exp) {
+		return "", "", errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Unix() < int64(nbf) {
+		return "", "", errors.New("token not valid yet")
+	}
+	name, _ := claims["sub"].(string)
+	if email, ok := claims["email"].(string); ok {
+		name = email
+	}
+	for _, role := range v.conf.AdminRoles {
+		if hasClaim(claims[v.conf.RolesClaim], role) {
+			return name, RoleAdmin, nil
+		}
+	}
+	return name, RoleRead, nil
+}
+
+// decodeSegment decodes a base64url-encoded JSON segment of a token
+func decodeSegment(seg string, result interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return errors.New("malformed token")
+	}
+	return nil
+}
+
+// hasClaim checks whether the claim, a string or list of strings, holds the
+// value
+func hasClaim(claim interface{}, value string) bool {
+	switch c := claim.(type) {
+	case string:
+		return c == value
+	case []interface{}:
+		for _, v := range c {
+			if s, ok := v.(string); ok && s == value {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package nodes
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPIAuth(t *testing.T) {
+	auth, err := NewAPIAuth(apiAuthConfig{Users: []apiUser{
+		{Name: "viewer", Password: "pw"},
+		{Name: "ops", Token: "secret", Role: RoleAdmin},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := auth.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		method, path string
+		auth         func(r *http.Request)
+		status       int
+	}{
+		{"GET", "/api/reports", nil, http.StatusUnauthorized},
+		{"GET", "/healthz", nil, http.StatusOK},
+		{"POST", "/api/agent", nil, http.StatusOK},
+		{"GET", "/api/reports", func(r *http.Request) { r.SetBasicAuth("viewer", "pw") }, http.StatusOK},
+		{"GET", "/api/reports", func(r *http.Request) { r.SetBasicAuth("viewer", "wrong") }, http.StatusUnauthorized},
+		{"GET", "/api/nodes", func(r *http.Request) { r.SetBasicAuth("viewer", "pw") }, http.StatusOK},
+		{"POST", "/api/nodes", func(r *http.Request) { r.SetBasicAuth("viewer", "pw") }, http.StatusForbidden},
+		{"GET", "/api/mute", func(r *http.Request) { r.SetBasicAuth("viewer", "pw") }, http.StatusForbidden},
+		{"DELETE", "/api/nodes/geth", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, http.StatusOK},
+		{"POST", "/api/mute", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, http.StatusOK},
+		{"POST", "/api/mute", func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") }, http.StatusUnauthorized},
+	}
+	for i, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.auth != nil {
+			tt.auth(req)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("test %d: %v %v: have status %d, want %d", i, tt.method, tt.path, rec.Code, tt.status)
+		}
+	}
+	if _, err := NewAPIAuth(apiAuthConfig{Users: []apiUser{{Name: "x", Password: "pw", Role: "root"}}}); err == nil {
+		t.Error("invalid role accepted")
+	}
+}
+
+func TestOIDCVerifier(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := base64.RawURLEncoding
+	var provider *httptest.Server
+	provider = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"jwks_uri": provider.URL + "/keys"})
+		case "/keys":
+			json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+				"kid": "k1", "kty": "RSA",
+				"n": enc.EncodeToString(key.N.Bytes()), "e": enc.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer provider.Close()
+
+	sign := func(claims map[string]interface{}) string {
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+		body, _ := json.Marshal(claims)
+		data := enc.EncodeToString(header) + "." + enc.EncodeToString(body)
+		digest := sha256.Sum256([]byte(data))
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		return data + "." + enc.EncodeToString(sig)
+	}
+	now := time.Now()
+	v := newOIDCVerifier(oidcConfig{Issuer: provider.URL, ClientID: "nodemonitor", AdminRoles: []string{"sre"}})
+	claims := map[string]interface{}{"iss": provider.URL, "aud": "nodemonitor", "sub": "alice", "exp": now.Add(time.Hour).Unix()}
+	if name, role, err := v.verify(sign(claims), now); err != nil || name != "alice" || role != RoleRead {
+		t.Errorf("wrong result: %v %v %v", name, role, err)
+	}
+	claims["groups"] = []string{"dev", "sre"}
+	if _, role, err := v.verify(sign(claims), now); err != nil || role != RoleAdmin {
+		t.Errorf("admin group not recognized: %v %v", role, err)
+	}
+	claims["aud"] = []string{"other"}
+	if _, _, err := v.verify(sign(claims), now); err == nil {
+		t.Error("token for another client accepted")
+	}
+	claims["aud"] = "nodemonitor"
+	if _, _, err := v.verify(sign(claims), now.Add(2*time.Hour)); err == nil {
+		t.Error("expired token accepted")
+	}
+	token := sign(claims)
+	if _, _, err := v.verify(token[:len(token)-4]+"AAAA", now); err == nil {
+		t.Error("forged token accepted")
+	}
+}
@@ -0,0 +1,101 @@
+package nodes
+
+import "github.com/ethereum/go-ethereum/common"
+
+// retainedNode is a view of a pruned node which doesn't request the blocks
+// below its retained range, but reports them as unavailable right away
+type retainedNode struct {
+	Node
+	floor uint64 // lowest retained block
+}
+
+func (r *retainedNode) BlockAt(num uint64, force bool) *blockInfo {
+	if num < r.floor {
+		return nil
+	}
+	return r.Node.BlockAt(num, force)
+}
+
+func (r *retainedNode) HashAt(num uint64, force bool) common.Hash {
+	if num < r.floor {
+		return common.Hash{}
+	}
+	return r.Node.HashAt(num, force)
+}
+
+func (r *retainedNode) LookupHash(num uint64) (common.Hash, error) {
+	if num < r.floor {
+		return common.Hash{}, nil
+	}
+	return lookupHash(r.Node, num)
+}
+
+// retainedBatchNode is a retainedNode for nodes supporting batching
+type retainedBatchNode struct {
+	*retainedNode
+	batch batchNode
+}
+
+func (r *retainedBatchNode) HashesAt(nums []uint64) ([]common.Hash, error) {
+	var (
+		hashes = make([]common.Hash, len(nums))
+		ask    []uint64
+		index  []int
+	)
+	for i, num := range nums {
+		if num >= r.floor {
+			ask = append(ask, num)
+			index = append(index, i)
+		}
+	}
+	if len(ask) > 0 {
+		found, err := r.batch.HashesAt(ask)
+		if err != nil {
+			return nil, err
+		}
+		for i, hash := range found {
+			hashes[index[i]] = hash
+		}
+	}
+	return hashes, nil
+}
+
+// retained returns the node as seen by the split search: limited to its
+// retained range, if it's configured with one
+func (set nodeOptionSet) retained(node Node) Node {
+	opts := set[node.Name()]
+	if opts == nil || opts.history == 0 || node.HeadNum() < opts.history {
+		return node
+	}
+	view := &retainedNode{node, node.HeadNum() - opts.history + 1}
+	if bn, ok := node.(batchNode); ok {
+		return &retainedBatchNode{view, bn}
+	}
+	return view
+}
+
+// archiveFor finds a node to stand in for the given one, below the split
+// block: either the node itself if it has the block below the split, or an
+// archive node on the same chain. It returns nil if there's none.
+func (mon *NodeMonitor) archiveFor(node Node, split uint64, active []Node) Node {
+	if split == 0 {
+		return nil
+	}
+	want := node.HashAt(split, false)
+	if want == (common.Hash{}) {
+		return nil
+	}
+	candidates := []Node{node}
+	for _, n := range active {
+		if opts := mon.opts[n.Name()]; opts != nil && opts.archive && n != node {
+			candidates = append(candidates, n)
+		}
+	}
+	for _, n := range candidates {
+		n = mon.opts.retained(n)
+		if n.HashAt(split-1, false) != (common.Hash{}) && n.HashAt(split, false) == want {
+			return n
+		}
+	}
+	return nil
+}
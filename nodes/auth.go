@@ -0,0 +1,174 @@
+package nodes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// jwtTransport authenticates each request with a freshly minted JWT, as
+// required by the authenticated (engine API) RPC endpoints of execution clients.
+// Tokens are only valid for a short time around their 'iat' claim, so a new
+// one is created for every request.
+type jwtTransport struct {
+	secret []byte
+	base   http.RoundTripper
+}
+
+func (t *jwtTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+newJWT(t.secret, time.Now()))
+	return t.base.RoundTrip(req)
+}
+
+// newJWT creates a HS256-signed token carrying only the 'iat' claim
+func newJWT(secret []byte, now time.Time) string {
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	claims := enc.EncodeToString([]byte(fmt.Sprintf(`{"iat":%d}`, now.Unix())))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(header + "." + claims))
+	return header + "." + claims + "." + enc.EncodeToString(mac.Sum(nil))
+}
+
+// loadJWTSecret reads a 32-byte hex-encoded secret. The input is either the
+// path to a file holding the secret (like the jwt.hex used by clients), or the
+// secret itself.
+func loadJWTSecret(s string) ([]byte, error) {
+	if _, err := os.Stat(s); err == nil {
+		data, err := ioutil.ReadFile(s)
+		if err != nil {
+			return nil, err
+		}
+		s = string(data)
+	}
+	s = strings.TrimPrefix(strings.TrimSpace(s), "0x")
+	secret, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jwt secret: %v", err)
+	}
+	if len(secret) != 32 {
+		return nil, fmt.Errorf("invalid jwt secret length %d, expected 32 bytes", len(secret))
+	}
+	return secret, nil
+}
+
+// headerTransport adds fixed headers, such as credentials, to each request
+type headerTransport struct {
+	header http.Header
+	base   http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, v := range t.header {
+		req.Header[k] = v
+	}
+	return t.base.RoundTrip(req)
+}
+
+// requestHeader returns the headers to add to each request to the node
+func (c *ClientInfo) requestHeader() http.Header {
+	header := make(http.Header)
+	for k, v := range c.Headers {
+		header.Set(k, v)
+	}
+	switch {
+	case len(c.BearerToken) > 0:
+		header.Set("Authorization", "Bearer "+c.BearerToken)
+	case len(c.Username) > 0 || len(c.Password) > 0:
+		creds := base64.StdEncoding.EncodeToString([]byte(c.Username + ":" + c.Password))
+		header.Set("Authorization", "Basic "+creds)
+	}
+	return header
+}
+
+// tlsConfig returns the TLS settings of the node, or nil if there are none
+func (c *ClientInfo) tlsConfig() (*tls.Config, error) {
+	if len(c.CAFile) == 0 && len(c.CertFile) == 0 && len(c.KeyFile) == 0 && !c.InsecureSkipVerify {
+		return nil, nil
+	}
+	conf := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if len(c.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %v", c.CAFile)
+		}
+	}
+	if len(c.CertFile) > 0 || len(c.KeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %v", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// proxyURL returns the proxy to route the node's traffic through, or nil to
+// use the environment (HTTP_PROXY and friends)
+func (c *ClientInfo) proxyURL() (*url.URL, error) {
+	if len(c.Proxy) == 0 {
+		return nil, nil
+	}
+	u, err := url.Parse(c.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, available: [http, https, socks5]", u.Scheme)
+	}
+	return u, nil
+}
+
+// HTTPClient returns the http client to use for talking to the node, or nil if
+// the node doesn't need any special treatment.
+func (c *ClientInfo) HTTPClient() (*http.Client, error) {
+	var transport http.RoundTripper = http.DefaultTransport
+	tlsConf, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := c.proxyURL()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConf != nil || proxy != nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = tlsConf
+		if proxy != nil {
+			t.Proxy = http.ProxyURL(proxy)
+		}
+		transport = t
+	}
+	if header := c.requestHeader(); len(header) > 0 {
+		transport = &headerTransport{header, transport}
+	}
+	if len(c.JWTSecret) > 0 {
+		secret, err := loadJWTSecret(c.JWTSecret)
+		if err != nil {
+			return nil, err
+		}
+		transport = &jwtTransport{secret, transport}
+	}
+	if transport == http.DefaultTransport {
+		return nil, nil
+	}
+	return &http.Client{Transport: transport}, nil
+}
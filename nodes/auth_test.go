@@ -0,0 +1,273 @@
+package nodes
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "0x7365637265747365637265747365637265747365637265747365637265747365"
+
+func TestLoadJWTSecret(t *testing.T) {
+	if _, err := loadJWTSecret(testSecret); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jwt.hex")
+	ioutil.WriteFile(path, []byte(testSecret+"\n"), 0600)
+	if _, err := loadJWTSecret(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadJWTSecret("0xdeadbeef"); err == nil {
+		t.Fatal("expected error for short secret")
+	}
+}
+
+func TestJWTTransport(t *testing.T) {
+	secret, _ := loadJWTSecret(testSecret)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		parts := strings.Split(token, ".")
+		if len(parts) != 3 {
+			t.Fatalf("malformed token %q", token)
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(parts[0] + "." + parts[1]))
+		if base64.RawURLEncoding.EncodeToString(mac.Sum(nil)) != parts[2] {
+			t.Errorf("invalid signature")
+		}
+		claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		if !strings.HasPrefix(string(claims), `{"iat":`) {
+			t.Errorf("unexpected claims %s", claims)
+		}
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &jwtTransport{secret, http.DefaultTransport}, Timeout: time.Second}
+	if _, err := client.Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+	}))
+	defer srv.Close()
+
+	extra := map[string]string{"X-Api-Key": "key"}
+	for i, tt := range []struct {
+		info ClientInfo
+		auth string
+	}{
+		{ClientInfo{Username: "user", Password: "pass", Headers: extra}, "Basic dXNlcjpwYXNz"},
+		{ClientInfo{BearerToken: "token", Headers: extra}, "Bearer token"},
+		{ClientInfo{Headers: map[string]string{"X-Api-Key": "key", "authorization": "custom"}}, "custom"},
+	} {
+		client, err := tt.info.HTTPClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Get(srv.URL); err != nil {
+			t.Fatal(err)
+		}
+		if have := got.Get("X-Api-Key"); have != "key" {
+			t.Errorf("test %d: missing extra header, have %q", i, have)
+		}
+		if have := got.Get("Authorization"); have != tt.auth {
+			t.Errorf("test %d: wrong auth header %q, want %q", i, have, tt.auth)
+		}
+	}
+	if client, _ := (&ClientInfo{}).HTTPClient(); client != nil {
+		t.Errorf("expected no client without any auth")
+	}
+}
+
+// writeTestCert creates a self-signed certificate, and writes it and its key
+// as PEM files into dir
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "nodemonitor"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+func TestClientTLS(t *testing.T) {
+	var peerCerts int
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peerCerts = len(r.TLS.PeerCertificates)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600)
+	certFile, keyFile := writeTestCert(t, dir)
+
+	get := func(info ClientInfo) error {
+		client, err := info.HTTPClient()
+		if err != nil {
+			return err
+		}
+		if client == nil {
+			client = http.DefaultClient
+		}
+		_, err = client.Get(srv.URL)
+		return err
+	}
+	if err := get(ClientInfo{}); err == nil {
+		t.Errorf("expected unknown CA to be rejected")
+	}
+	if err := get(ClientInfo{InsecureSkipVerify: true}); err != nil {
+		t.Errorf("skip-verify failed: %v", err)
+	}
+	if err := get(ClientInfo{CAFile: caFile}); err != nil || peerCerts != 0 {
+		t.Errorf("custom CA failed: %v, %d client certs", err, peerCerts)
+	}
+	if err := get(ClientInfo{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}); err != nil || peerCerts != 1 {
+		t.Errorf("mutual TLS failed: %v, %d client certs", err, peerCerts)
+	}
+	if err := get(ClientInfo{CertFile: certFile}); err == nil {
+		t.Errorf("expected error for missing key")
+	}
+}
+
+func TestClientProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A forward proxy gets the absolute url of the target
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	client, err := (&ClientInfo{Proxy: proxy.URL}).HTTPClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get("http://node.invalid:8545/"); err != nil {
+		t.Fatal(err)
+	}
+	if proxied != "http://node.invalid:8545/" {
+		t.Errorf("request not proxied, have %q", proxied)
+	}
+	if _, err := (&ClientInfo{Proxy: "socks5://127.0.0.1:9050"}).HTTPClient(); err != nil {
+		t.Errorf("socks5 proxy rejected: %v", err)
+	}
+	if _, err := (&ClientInfo{Proxy: "ftp://proxy"}).HTTPClient(); err == nil {
+		t.Errorf("expected error for unsupported scheme")
+	}
+}
+
+func TestReportSigning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodemonitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := signingConfig{KeyFile: filepath.Join(dir, "signing.key")}
+	signer, err := newReportSigner(conf, "monitor.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The generated key is used from then on
+	again, err := newReportSigner(conf, "monitor.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer.publicKey() != again.publicKey() {
+		t.Fatalf("key not reloaded")
+	}
+	report := []byte(`{"Cols": []}`)
+	sig, _ := json.Marshal(signer.sign(report))
+	identity, err := VerifyReport(report, sig, signer.publicKey())
+	if err != nil || identity != "monitor.example.org" {
+		t.Errorf("verification failed: %v %v", identity, err)
+	}
+	if _, err := VerifyReport([]byte(`{"Cols": null}`), sig, signer.publicKey()); err == nil {
+		t.Errorf("tampered report verified")
+	}
+	if _, err := VerifyReport(report, sig, strings.Repeat("00", 32)); err == nil {
+		t.Errorf("report verified against the wrong key")
+	}
+	if _, err := VerifyReport(report, sig, ""); err == nil {
+		t.Errorf("report verified without a key")
+	}
+	// The identity is covered by the signature too
+	forged := signer.sign(report)
+	forged.Identity = "other.example.org"
+	sig, _ = json.Marshal(forged)
+	if _, err := VerifyReport(report, sig, signer.publicKey()); err == nil {
+		t.Errorf("report verified with a swapped identity")
+	}
+
+	mon := &NodeMonitor{signer: signer}
+	h := mon.Signed(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write(report)
+	})
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/api/nodes", nil))
+	if rec.Code != http.StatusTeapot || rec.Body.String() != string(report) {
+		t.Fatalf("response altered: %v %v", rec.Code, rec.Body)
+	}
+	sig, _ = json.Marshal(&signatureJson{
+		Identity:  rec.Header().Get("X-Monitor-Identity"),
+		PublicKey: rec.Header().Get("X-Monitor-Key"),
+		Signature: rec.Header().Get("X-Signature"),
+	})
+	if _, err := VerifyReport(rec.Body.Bytes(), sig, signer.publicKey()); err != nil {
+		t.Errorf("response signature invalid: %v", err)
+	}
+
+	ioutil.WriteFile(conf.KeyFile, []byte("nothex"), 0600)
+	if _, err := newReportSigner(conf, ""); err == nil {
+		t.Errorf("expected error for invalid key")
+	}
+}
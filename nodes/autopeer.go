@@ -0,0 +1,186 @@
+package nodes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+type autoPeerConfig struct {
+	// Enabled connects execution nodes which diverged to each other, via
+	// admin_addPeer, which heals splits caused by the peering topology
+	Enabled bool
+	// AuditLog is the file every peering is appended to, as json lines.
+	// Defaults to autopeer.log.
+	AuditLog string
+	// Cooldown is how long to wait before peering the same nodes again.
+	// Defaults to 30m.
+	Cooldown string
+}
+
+// peeringNode is implemented by nodes which can be told to connect to a peer
+type peeringNode interface {
+	Node
+	// Enode returns the enode url of the node
+	Enode() (string, error)
+	// AddPeer connects the node to the peer with the enode url
+	AddPeer(enode string) (bool, error)
+}
+
+func (node *RPCNode) Enode() (string, error) {
+	id, err := node.Identity()
+	if err != nil {
+		return "", err
+	}
+	return id.Enode, nil
+}
+
+func (node *RPCNode) AddPeer(enode string) (bool, error) {
+	node.throttle.Take()
+	var ok bool
+	err := node.rpcCli.CallContext(context.Background(), &ok, "admin_addPeer", enode)
+	return ok, wrapNodeError(node.name, "addPeer", err)
+}
+
+// autoPeerEntry is a peering as written to the audit log
+type autoPeerEntry struct {
+	Time  time.Time
+	Nodes [2]string
+	// Block is the first block the nodes disagree on
+	Block  int
+	Enodes [2]string
+	// Added is whether either node accepted the other as peer, and Errors
+	// why not, if it failed
+	Added  [2]bool
+	Errors [2]string
+}
+
+// autoPeerer connects diverged nodes to each other
+type autoPeerer struct {
+	auditLog string
+	cooldown time.Duration
+	last     map[[2]string]time.Time // when each pair was last peered
+}
+
+// newAutoPeerer returns nil if automatic peering is disabled
+func newAutoPeerer(conf autoPeerConfig) (*autoPeerer, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+	ap := &autoPeerer{
+		auditLog: conf.AuditLog,
+		cooldown: 30 * time.Minute,
+		last:     make(map[[2]string]time.Time),
+	}
+	if len(ap.auditLog) == 0 {
+		ap.auditLog = "autopeer.log"
+	}
+	if len(conf.Cooldown) > 0 {
+		d, err := time.ParseDuration(conf.Cooldown)
+		if err != nil {
+			return nil, fmt.Errorf("invalid auto peer cooldown: %v", err)
+		}
+		ap.cooldown = d
+	}
+	return ap, nil
+}
+
+func (ap *autoPeerer) forget(name string) {
+	for pair := range ap.last {
+		if pair[0] == name || pair[1] == name {
+			delete(ap.last, pair)
+		}
+	}
+}
+
+// peer connects the two nodes to each other
+func (ap *autoPeerer) peer(a, b peeringNode, block int, now time.Time) *autoPeerEntry {
+	entry := &autoPeerEntry{Time: now, Nodes: [2]string{a.Name(), b.Name()}, Block: block}
+	nodes := [2]peeringNode{a, b}
+	for i, node := range nodes {
+		enode, err := node.Enode()
+		if err != nil {
+			entry.Errors[1-i] = fmt.Sprintf("failed to get enode of %v: %v", node.Name(), err)
+			continue
+		}
+		entry.Enodes[i] = enode
+	}
+	for i, node := range nodes {
+		other := entry.Enodes[1-i]
+		if len(other) == 0 {
+			continue
+		}
+		added, err := node.AddPeer(other)
+		if err != nil {
+			entry.Errors[i] = err.Error()
+		}
+		entry.Added[i] = added
+	}
+	return entry
+}
+
+// audit appends the entry to the audit log
+func (ap *autoPeerer) audit(entry *autoPeerEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(ap.auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// autoPeer connects the diverged pairs of nodes which can be peered, unless
+// they were peered within the cooldown
+func (mon *NodeMonitor) autoPeer(nodes []Node, splits splitSet) {
+	ap := mon.autoPeers
+	if ap == nil {
+		return
+	}
+	byName := make(map[string]peeringNode)
+	for _, n := range nodes {
+		if node, ok := n.(peeringNode); ok {
+			byName[node.Name()] = node
+		}
+	}
+	var pairs [][2]string
+	for pair := range splits {
+		if pair[0] > pair[1] {
+			pair[0], pair[1] = pair[1], pair[0]
+		}
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	now := time.Now()
+	for _, pair := range pairs {
+		a, b := byName[pair[0]], byName[pair[1]]
+		if a == nil || b == nil || now.Sub(ap.last[pair]) < ap.cooldown {
+			continue
+		}
+		ap.last[pair] = now
+		block, _ := splits.get(pair[0], pair[1])
+		entry := ap.peer(a, b, block, now)
+		if err := ap.audit(entry); err != nil {
+			log.Warn("Failed to write auto peer audit log", "error", err)
+		}
+		log.Warn("Peered diverged nodes", "nodes", pair, "added", entry.Added, "errors", entry.Errors)
+		mon.emit(EventAutoPeer, SeverityInfo, []string{pair[0], pair[1]},
+			"Connected the diverged nodes to each other (added: %v, %v)", entry.Added[0], entry.Added[1])
+	}
+}
@@ -0,0 +1,253 @@
+package nodes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// badBlock is a block which a node rejected as invalid
+type badBlock struct {
+	Hash   common.Hash
+	Number uint64
+	// Raw is the entry as reported by the node
+	Raw json.RawMessage
+}
+
+// badBlockNode is implemented by nodes which can list the blocks they
+// rejected
+type badBlockNode interface {
+	Node
+	BadBlocks() ([]badBlock, error)
+}
+
+// BadBlocks fetches the bad blocks via debug_getBadBlocks. The entries differ
+// between clients, so only the hash and number are picked out, from the entry
+// itself or from its 'block' field.
+func (node *RPCNode) BadBlocks() ([]badBlock, error) {
+	node.throttle.Take()
+	var entries []json.RawMessage
+	if err := node.rpcCli.CallContext(context.Background(), &entries, "debug_getBadBlocks"); err != nil {
+		return nil, wrapNodeError(node.name, "badBlocks", err)
+	}
+	var blocks []badBlock
+	for _, raw := range entries {
+		var entry struct {
+			Hash   common.Hash  `json:"hash"`
+			Number *hexutil.Big `json:"number"`
+			Block  *struct {
+				Hash   common.Hash  `json:"hash"`
+				Number *hexutil.Big `json:"number"`
+			} `json:"block"`
+		}
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return nil, wrapNodeError(node.name, "badBlocks", err)
+		}
+		if entry.Block != nil {
+			if entry.Hash == (common.Hash{}) {
+				entry.Hash = entry.Block.Hash
+			}
+			if entry.Number == nil {
+				entry.Number = entry.Block.Number
+			}
+		}
+		bb := badBlock{Hash: entry.Hash, Raw: raw}
+		if entry.Number != nil {
+			bb.Number = entry.Number.ToInt().Uint64()
+		}
+		blocks = append(blocks, bb)
+	}
+	return blocks, nil
+}
+
+// isMethodNotFound checks whether the error is the node not supporting the
+// method, e.g. because the namespace isn't enabled
+func isMethodNotFound(err error) bool {
+	var e rpc.Error
+	return errors.As(err, &e) && e.ErrorCode() == -32601
+}
+
+// badBlockJson is a bad block, along with the nodes which rejected it
+type badBlockJson struct {
+	Hash      common.Hash
+	Number    uint64
+	Nodes     []string
+	FirstSeen time.Time
+	// Block is the entry as first reported, it's only part of the stored
+	// file and not of the report
+	Block json.RawMessage `json:"Block,omitempty"`
+}
+
+// badBlockCollector periodically collects the bad blocks of the nodes
+type badBlockCollector struct {
+	interval time.Duration
+	dir      string
+	last     time.Time
+	// unsupported are the nodes which don't support the method, and aren't
+	// asked again
+	unsupported map[string]bool
+	found       map[common.Hash]*badBlockJson
+	// floor is the block below which bad blocks are forgotten, and not
+	// collected again
+	floor uint64
+	// copies are the stores which get a copy of the bad blocks
+	copies artifactStores
+}
+
+// newBadBlockCollector returns nil if the collection is disabled
+func newBadBlockCollector(interval string) (*badBlockCollector, error) {
+	if len(interval) == 0 {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bad block interval: %v", err)
+	}
+	return &badBlockCollector{
+		interval:    d,
+		dir:         "www/badblocks",
+		unsupported: make(map[string]bool),
+		found:       make(map[common.Hash]*badBlockJson),
+	}, nil
+}
+
+func (bc *badBlockCollector) forget(name string) {
+	delete(bc.unsupported, name)
+}
+
+// collect asks the nodes for their bad blocks, if the interval passed. It
+// returns the blocks which are new, or were reported by another node.
+func (bc *badBlockCollector) collect(nodes []Node, now time.Time) (updated []*badBlockJson, reporters [][]string) {
+	if now.Sub(bc.last) < bc.interval {
+		return nil, nil
+	}
+	bc.last = now
+	var (
+		changed = make(map[common.Hash][]string)
+		order   []common.Hash
+	)
+	for _, n := range nodes {
+		node, ok := n.(badBlockNode)
+		if !ok || bc.unsupported[node.Name()] {
+			continue
+		}
+		blocks, err := node.BadBlocks()
+		if err != nil {
+			if isMethodNotFound(err) {
+				log.Info("Node doesn't support bad block collection", "node", node.Name())
+				bc.unsupported[node.Name()] = true
+			} else {
+				log.Debug("Failed to fetch bad blocks", "node", node.Name(), "error", err)
+			}
+			continue
+		}
+		for _, b := range blocks {
+			if b.Number < bc.floor {
+				continue
+			}
+			bb, ok := bc.found[b.Hash]
+			if !ok {
+				bb = &badBlockJson{Hash: b.Hash, Number: b.Number, FirstSeen: now, Block: b.Raw}
+				bc.found[b.Hash] = bb
+			}
+			var known bool
+			for _, name := range bb.Nodes {
+				known = known || name == node.Name()
+			}
+			if known {
+				continue
+			}
+			bb.Nodes = append(bb.Nodes, node.Name())
+			if _, ok := changed[b.Hash]; !ok {
+				order = append(order, b.Hash)
+			}
+			changed[b.Hash] = append(changed[b.Hash], node.Name())
+		}
+	}
+	for _, hash := range order {
+		updated = append(updated, bc.found[hash])
+		reporters = append(reporters, changed[hash])
+	}
+	return updated, reporters
+}
+
+// prune forgets the bad blocks below the given block, e.g. the finalized one.
+// Nodes keep reporting them for a while, but they can't become canonical
+// anymore.
+func (bc *badBlockCollector) prune(floor uint64) {
+	if floor <= bc.floor {
+		return
+	}
+	bc.floor = floor
+	for hash, bb := range bc.found {
+		if bb.Number < floor {
+			delete(bc.found, hash)
+		}
+	}
+}
+
+// write stores the bad block as json in the bad block directory
+func (bc *badBlockCollector) write(bb *badBlockJson) error {
+	if err := os.MkdirAll(bc.dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(bb, "", " ")
+	if err != nil {
+		return err
+	}
+	fname := fmt.Sprintf("0x%x.json", bb.Hash)
+	bc.copies.put("splits/badblocks/"+fname, data)
+	return ioutil.WriteFile(filepath.Join(bc.dir, fname), data, 0644)
+}
+
+// report lists the bad blocks found so far, the most recent first
+func (bc *badBlockCollector) report() []*badBlockJson {
+	var list []*badBlockJson
+	for _, bb := range bc.found {
+		list = append(list, &badBlockJson{
+			Hash:      bb.Hash,
+			Number:    bb.Number,
+			Nodes:     append([]string{}, bb.Nodes...),
+			FirstSeen: bb.FirstSeen,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Number != list[j].Number {
+			return list[i].Number > list[j].Number
+		}
+		return list[i].Hash.Hex() < list[j].Hash.Hex()
+	})
+	return list
+}
+
+// checkBadBlocks collects the bad blocks of the nodes, reports the ones not
+// seen before and stores them. The ones below the finalized block are
+// forgotten.
+func (mon *NodeMonitor) checkBadBlocks(nodes []Node) {
+	bc := mon.badBlocks
+	if bc == nil {
+		return
+	}
+	if num, ok := finalizedNum(nodes); ok {
+		bc.prune(num)
+	}
+	updated, reporters := bc.collect(nodes, time.Now())
+	for i, bb := range updated {
+		mon.emitBlock(EventBadBlock, SeverityWarning, reporters[i], bb.Number, []common.Hash{bb.Hash},
+			"Rejected block %d [%x] as invalid", bb.Number, bb.Hash)
+		if err := bc.write(bb); err != nil {
+			log.Warn("Failed to write bad block", "hash", bb.Hash, "error", err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package nodes
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// balancePrefix is the key prefix for validator balances, followed by the
+// big-endian validator index and epoch.
+var balancePrefix = []byte("balance-")
+
+func balanceKey(index, epoch uint64) []byte {
+	key := make([]byte, len(balancePrefix)+16)
+	copy(key, balancePrefix)
+	binary.BigEndian.PutUint64(key[len(balancePrefix):], index)
+	binary.BigEndian.PutUint64(key[len(balancePrefix)+8:], epoch)
+	return key
+}
+
+func (db *blockDB) addBalance(index, epoch, gwei uint64) error {
+	var val [8]byte
+	binary.BigEndian.PutUint64(val[:], gwei)
+	return db.db.Put(balanceKey(index, epoch), val[:], nil)
+}
+
+func (db *blockDB) balanceAt(index, epoch uint64) (uint64, bool) {
+	val, err := db.db.Get(balanceKey(index, epoch), nil)
+	if err != nil || len(val) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(val), true
+}
+
+// balanceHistory holds the sampled balances (in gwei) of the monitored
+// validators, by index and epoch. Samples are kept in memory for the duration
+// of the window, and persisted to the database if there is one.
+type balanceHistory struct {
+	db      *blockDB
+	window  uint64
+	samples map[uint64]map[uint64]uint64
+	epoch   uint64 // last sampled epoch
+}
+
+func newBalanceHistory(db *blockDB, window uint64) *balanceHistory {
+	if window == 0 {
+		window = 8
+	}
+	return &balanceHistory{
+		db:      db,
+		window:  window,
+		samples: make(map[uint64]map[uint64]uint64),
+	}
+}
+
+func (bh *balanceHistory) add(index, epoch, gwei uint64) {
+	if bh.samples[index] == nil {
+		bh.samples[index] = make(map[uint64]uint64)
+	}
+	bh.samples[index][epoch] = gwei
+	for e := range bh.samples[index] {
+		if e+bh.window < epoch {
+			delete(bh.samples[index], e)
+		}
+	}
+	if bh.db != nil {
+		if err := bh.db.addBalance(index, epoch, gwei); err != nil {
+			log.Warn("Failed to store balance", "index", index, "error", err)
+		}
+	}
+}
+
+func (bh *balanceHistory) get(index, epoch uint64) (uint64, bool) {
+	if gwei, ok := bh.samples[index][epoch]; ok {
+		return gwei, true
+	}
+	if bh.db != nil {
+		return bh.db.balanceAt(index, epoch)
+	}
+	return 0, false
+}
+
+// change returns the current balance, and the change since the start of the
+// window, if known.
+func (bh *balanceHistory) change(index uint64) (uint64, int64, bool) {
+	now, ok := bh.get(index, bh.epoch)
+	if !ok {
+		return 0, 0, false
+	}
+	if bh.epoch < bh.window {
+		return now, 0, false
+	}
+	then, ok := bh.get(index, bh.epoch-bh.window)
+	if !ok {
+		return now, 0, false
+	}
+	return now, int64(now) - int64(then), true
+}
+
+// checkBalances samples the balances of the monitored validators once per
+// epoch, and alerts on any which decreased over the window.
+func (mon *NodeMonitor) checkBalances(node *BeaconNode) {
+	vt, bh := mon.validators, mon.balances
+	epoch := node.currentEpoch()
+	if len(vt.labels) == 0 || (bh.epoch == epoch && epoch != 0) {
+		return
+	}
+	var balances []struct {
+		Index   flexUint `json:"index"`
+		Balance flexUint `json:"balance"`
+	}
+	path := "/eth/v1/beacon/states/head/validator_balances?id=" + strings.Join(vt.indices(), ",")
+	if err := node.get(path, &balances); err != nil {
+		log.Warn("Failed to fetch validator balances", "error", err)
+		return
+	}
+	bh.epoch = epoch
+	for _, b := range balances {
+		bh.add(uint64(b.Index), epoch, uint64(b.Balance))
+		if _, diff, ok := bh.change(uint64(b.Index)); ok && diff < 0 {
+			mon.emit(EventBalanceDecrease, SeverityWarning, []string{node.Name()},
+				"Balance of validator %v decreased by %v gwei over the last %d epochs",
+				vt.labels[uint64(b.Index)], -diff, bh.window)
+		}
+	}
+}
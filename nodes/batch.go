@@ -0,0 +1,187 @@
+package nodes
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// splitProbes is the number of blocks probed per round of the split search,
+// when both nodes support batching
+var splitProbes = 16
+
+// errBlockNotFound is returned for blocks a node doesn't have, e.g. because
+// it's pruned, as opposed to failed requests
+var errBlockNotFound = errors.New("block not found")
+
+// isBlockNotFound checks whether the error means the node doesn't have the
+// block. Some nodes answer with an error rather than null.
+func isBlockNotFound(err error) bool {
+	if errors.Is(err, errBlockNotFound) {
+		return true
+	}
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "header not found") || strings.Contains(msg, "block not found")
+}
+
+// hashLookup is implemented by nodes which tell blocks they don't have apart
+// from failed requests
+type hashLookup interface {
+	// LookupHash returns the hash of the block at the number, or a zero hash
+	// if the node doesn't have it
+	LookupHash(num uint64) (common.Hash, error)
+}
+
+func (node *RPCNode) LookupHash(num uint64) (common.Hash, error) {
+	if node.latest != nil && node.latest.num < num {
+		return common.Hash{}, nil
+	}
+	if bl, ok := node.chainHistory.get(num); ok {
+		return bl.hash, nil
+	}
+	bl, err := node.fetchHeader(new(big.Int).SetUint64(num))
+	if isBlockNotFound(err) {
+		return common.Hash{}, nil
+	}
+	if err != nil {
+		return common.Hash{}, wrapNodeError(node.name, "header", err)
+	}
+	return bl.hash, nil
+}
+
+// lookupHash returns the hash of the block at the number, or a zero hash if
+// the node doesn't have it. For nodes which can't tell, every missing block
+// is taken as not there.
+func lookupHash(node Node, num uint64) (common.Hash, error) {
+	if l, ok := node.(hashLookup); ok {
+		return l.LookupHash(num)
+	}
+	return node.HashAt(num, false), nil
+}
+
+// batchNode is implemented by nodes which can fetch many hashes in a single
+// request
+type batchNode interface {
+	Node
+	// HashesAt returns the hashes of the blocks at the given numbers, with
+	// zero hashes for blocks it doesn't have. Failed requests are returned as
+	// error.
+	HashesAt(nums []uint64) ([]common.Hash, error)
+}
+
+// HashesAt fetches the headers not already known in a single batch request
+func (node *RPCNode) HashesAt(nums []uint64) ([]common.Hash, error) {
+	var (
+		hashes = make([]common.Hash, len(nums))
+		batch  []rpc.BatchElem
+		index  []int // position in nums of each batch element
+	)
+	for i, num := range nums {
+		if node.latest != nil && node.latest.num < num {
+			continue
+		}
+		if bl, ok := node.chainHistory.get(num); ok {
+			hashes[i] = bl.hash
+			continue
+		}
+		batch = append(batch, rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []interface{}{hexutil.EncodeUint64(num), false},
+			Result: new(types.Header),
+		})
+		index = append(index, i)
+	}
+	if len(batch) == 0 {
+		return hashes, nil
+	}
+	node.throttle.Take()
+	log.Debug("Doing batch check", "node", node.name, "blocks", len(batch))
+	if err := node.rpcCli.BatchCallContext(context.Background(), batch); err != nil {
+		return nil, wrapNodeError(node.name, "batch", err)
+	}
+	for i, el := range batch {
+		if el.Error != nil {
+			if isBlockNotFound(el.Error) {
+				continue
+			}
+			return nil, wrapNodeError(node.name, "batch", el.Error)
+		}
+		// Missing blocks are returned as null, leaving the header empty
+		if h := el.Result.(*types.Header); h.Number != nil {
+			hashes[index[i]] = node.store(h, nil).hash
+		}
+	}
+	return hashes, nil
+}
+
+// probePoints returns up to n numbers evenly spread over [lo, hi), in
+// ascending order
+func probePoints(lo, hi, n int) []uint64 {
+	var points []uint64
+	if hi-lo <= n {
+		for i := lo; i < hi; i++ {
+			points = append(points, uint64(i))
+		}
+		return points
+	}
+	for i := 1; i <= n; i++ {
+		points = append(points, uint64(lo+(hi-lo)*i/(n+1)))
+	}
+	return points
+}
+
+// findSplitBatched is findSplit for nodes supporting batching. Each round
+// probes several blocks at once, fetched from both nodes in parallel, which
+// narrows the range down in far fewer round trips than bisecting.
+func findSplitBatched(from, num int, a, b batchNode) (int, bool, error) {
+	// All blocks below lo are agreed on (or unavailable), and the nodes
+	// disagree at hi (unless hi is num)
+	lo, hi := from, num
+	missing := make(map[int]bool)
+	for lo < hi {
+		var (
+			probes = probePoints(lo, hi, splitProbes)
+			hashes []common.Hash
+			errA   error
+			wg     sync.WaitGroup
+		)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hashes, errA = a.HashesAt(probes)
+		}()
+		other, errB := b.HashesAt(probes)
+		wg.Wait()
+		if errA != nil {
+			return 0, false, errA
+		}
+		if errB != nil {
+			return 0, false, errB
+		}
+
+		next := hi
+		for i, p := range probes {
+			if hashes[i] == (common.Hash{}) || other[i] == (common.Hash{}) {
+				missing[int(p)] = true
+			} else if hashes[i] != other[i] {
+				next = int(p)
+				break
+			}
+			lo = int(p) + 1
+		}
+		hi = next
+	}
+	return lo, missing[lo-1], nil
+}
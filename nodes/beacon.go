@@ -0,0 +1,448 @@
+package nodes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"go.uber.org/ratelimit"
+)
+
+// errNotFound is returned when the beacon API responds with 404
+var errNotFound = errors.New("not found")
+
+type checkpoint struct {
+	Epoch uint64      `json:"epoch,string"`
+	Root  common.Hash `json:"root"`
+}
+
+type finalityCheckpoints struct {
+	PreviousJustified checkpoint `json:"previous_justified"`
+	CurrentJustified  checkpoint `json:"current_justified"`
+	Finalized         checkpoint `json:"finalized"`
+}
+
+type beaconHeader struct {
+	Root      common.Hash `json:"root"`
+	Canonical bool        `json:"canonical"`
+	Header    struct {
+		Message struct {
+			Slot          uint64      `json:"slot,string"`
+			ProposerIndex uint64      `json:"proposer_index,string"`
+			ParentRoot    common.Hash `json:"parent_root"`
+			StateRoot     common.Hash `json:"state_root"`
+			BodyRoot      common.Hash `json:"body_root"`
+		} `json:"message"`
+	} `json:"header"`
+}
+
+// BeaconNode represents a consensus layer node, reachable via the standard
+// beacon node REST API.
+type BeaconNode struct {
+	name     string
+	url      string
+	client   *http.Client
+	version  string
+	status   int
+	throttle ratelimit.Limiter
+
+	head          *beaconHeader
+	finality      *finalityCheckpoints
+	participation *participation
+	// participationSupport is whether the node turned out to serve the
+	// participation endpoint, or nil until known
+	participationSupport *bool
+	fork                 *fork
+	forkDigest           string
+	// optimistic is set if the head hasn't been verified by the execution
+	// layer yet
+	optimistic bool
+
+	// chain parameters, fetched once
+	genesisTime    time.Time
+	secondsPerSlot uint64
+	slotsPerEpoch  uint64
+}
+
+// NewBeaconNode creates a beacon node reachable at the given url. If client is
+// non-nil, it is used for the http requests.
+func NewBeaconNode(name, url string, rateLimit int, client *http.Client) (*BeaconNode, error) {
+	if len(url) == 0 {
+		return nil, fmt.Errorf("missing url for beacon node %v", name)
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	throttle := ratelimit.NewUnlimited()
+	if rateLimit > 0 {
+		throttle = ratelimit.New(rateLimit)
+	}
+	return &BeaconNode{
+		name:     name,
+		url:      strings.TrimSuffix(url, "/"),
+		client:   client,
+		version:  "n/a",
+		throttle: throttle,
+	}, nil
+}
+
+// get performs a GET request against the API, and decodes the 'data' field of
+// the response into result
+func (node *BeaconNode) get(path string, result interface{}) error {
+	return node.do("GET", path, nil, result)
+}
+
+// post performs a POST request with the json-encoded body
+func (node *BeaconNode) post(path string, body interface{}, result interface{}) error {
+	return node.do("POST", path, body, result)
+}
+
+func (node *BeaconNode) do(method, path string, body interface{}, result interface{}) error {
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := node.fetch(method, path, body, &envelope); err != nil {
+		return err
+	}
+	return wrapNodeError(node.name, path, json.Unmarshal(envelope.Data, result))
+}
+
+// fetch performs the request, and decodes the entire response into result
+func (node *BeaconNode) fetch(method, path string, body interface{}, result interface{}) error {
+	data, err := node.request(method, path, body, "")
+	if err != nil {
+		return err
+	}
+	return wrapNodeError(node.name, path, json.Unmarshal(data, result))
+}
+
+// request performs the request, and returns the response body. The accept
+// header is only set if given.
+func (node *BeaconNode) request(method, path string, body interface{}, accept string) ([]byte, error) {
+	data, err := node.doRequest(method, path, body, accept)
+	return data, wrapNodeError(node.name, path, err)
+}
+
+func (node *BeaconNode) doRequest(method, path string, body interface{}, accept string) ([]byte, error) {
+	node.throttle.Take()
+	log.Debug("Beacon request", "node", node.name, "method", method, "path", path)
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, node.url+path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(accept) > 0 {
+		req.Header.Set("Accept", accept)
+	}
+	res, err := node.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return nil, &statusError{res.StatusCode, fmt.Sprintf("request %v failed: %v: %s", path, res.Status, body)}
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+func (node *BeaconNode) Name() string {
+	return node.name
+}
+
+func (node *BeaconNode) Status() int {
+	return node.status
+}
+
+func (node *BeaconNode) SetStatus(status int) {
+	if status != NodeStatusOK {
+		// Refetch the ENR once reconnected, the node may have been replaced
+		node.forkDigest = ""
+	}
+	node.status = status
+}
+
+func (node *BeaconNode) Version() (string, error) {
+	var res struct {
+		Version string `json:"version"`
+	}
+	if err := node.get("/eth/v1/node/version", &res); err != nil {
+		return node.version, err
+	}
+	node.version = res.Version
+	return node.version, nil
+}
+
+// HeadSlot returns the slot of the last fetched head
+func (node *BeaconNode) HeadSlot() uint64 {
+	if node.head != nil {
+		return node.head.Header.Message.Slot
+	}
+	return 0
+}
+
+// fetchGenesis fetches the genesis time and slot duration of the chain
+func (node *BeaconNode) fetchGenesis() error {
+	var genesis struct {
+		GenesisTime int64 `json:"genesis_time,string"`
+	}
+	if err := node.get("/eth/v1/beacon/genesis", &genesis); err != nil {
+		return err
+	}
+	var spec map[string]interface{}
+	if err := node.get("/eth/v1/config/spec", &spec); err != nil {
+		return err
+	}
+	sps, err := strconv.ParseUint(fmt.Sprint(spec["SECONDS_PER_SLOT"]), 10, 64)
+	if err != nil || sps == 0 {
+		return fmt.Errorf("invalid SECONDS_PER_SLOT %v", spec["SECONDS_PER_SLOT"])
+	}
+	spe, err := strconv.ParseUint(fmt.Sprint(spec["SLOTS_PER_EPOCH"]), 10, 64)
+	if err != nil || spe == 0 {
+		return fmt.Errorf("invalid SLOTS_PER_EPOCH %v", spec["SLOTS_PER_EPOCH"])
+	}
+	node.genesisTime = time.Unix(genesis.GenesisTime, 0)
+	node.secondsPerSlot = sps
+	node.slotsPerEpoch = spe
+	return nil
+}
+
+// wallclockSlot returns the slot that the chain should be at, at the given time
+func (node *BeaconNode) wallclockSlot(now time.Time) uint64 {
+	if node.secondsPerSlot == 0 || now.Before(node.genesisTime) {
+		return 0
+	}
+	return uint64(now.Sub(node.genesisTime)/time.Second) / node.secondsPerSlot
+}
+
+// SlotLag returns how many slots the head lags behind the wall clock
+func (node *BeaconNode) SlotLag() uint64 {
+	expected, head := node.wallclockSlot(time.Now()), node.HeadSlot()
+	if node.head == nil || expected < head {
+		return 0
+	}
+	return expected - head
+}
+
+// Update fetches the current head and finality checkpoints
+func (node *BeaconNode) Update() error {
+	return wrapNodeError(node.name, "update", node.update())
+}
+
+func (node *BeaconNode) update() error {
+	if node.secondsPerSlot == 0 {
+		if err := node.fetchGenesis(); err != nil {
+			return err
+		}
+	}
+	var head beaconHeader
+	if err := node.get("/eth/v1/beacon/headers/head", &head); err != nil {
+		return err
+	}
+	var finality finalityCheckpoints
+	if err := node.get("/eth/v1/beacon/states/head/finality_checkpoints", &finality); err != nil {
+		return err
+	}
+	node.head, node.finality = &head, &finality
+
+	// Nodes predating the merge don't know about optimistic sync
+	var syncing struct {
+		IsOptimistic bool `json:"is_optimistic"`
+	}
+	if err := node.get("/eth/v1/node/syncing", &syncing); err != nil {
+		log.Debug("Failed to fetch sync status", "node", node.name, "error", err)
+	}
+	node.optimistic = syncing.IsOptimistic
+	return nil
+}
+
+// header fetches a block header by block id (slot, root, 'head', ...)
+func (node *BeaconNode) header(id string) (*beaconHeader, error) {
+	var h beaconHeader
+	if err := node.get("/eth/v1/beacon/headers/"+id, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+type beaconJson struct {
+	Name          string
+	Version       string
+	Status        int
+	HeadSlot      uint64
+	HeadRoot      common.Hash
+	SlotLag       uint64
+	Justified     checkpoint
+	Finalized     checkpoint
+	Participation *participation
+	ForkVersion   string
+	ForkDigest    string
+	Optimistic    bool
+	Labels        map[string]string
+	// Identity is the p2p identity of the node, if it tells it
+	Identity *identityJson `json:",omitempty"`
+	// Metrics are the series scraped from the node's metrics url
+	Metrics map[string]float64 `json:",omitempty"`
+	// Health is the health score of the node, if it's computed
+	Health *healthScoreJson `json:",omitempty"`
+}
+
+func newBeaconJson(node *BeaconNode) *beaconJson {
+	bj := &beaconJson{
+		Name:    node.name,
+		Version: node.version,
+		Status:  node.status,
+	}
+	if node.head != nil {
+		bj.HeadSlot = node.HeadSlot()
+		bj.HeadRoot = node.head.Root
+		bj.SlotLag = node.SlotLag()
+	}
+	if node.finality != nil {
+		bj.Justified = node.finality.CurrentJustified
+		bj.Finalized = node.finality.Finalized
+	}
+	bj.Participation = node.participation
+	if node.fork != nil {
+		bj.ForkVersion = node.fork.CurrentVersion
+	}
+	bj.ForkDigest = node.forkDigest
+	bj.Optimistic = node.optimistic
+	return bj
+}
+
+// checkBeacons updates all beacon nodes, and cross-checks their finality
+// checkpoints. It returns the names of the unreachable nodes, and the number
+// of throttled ones.
+func (mon *NodeMonitor) checkBeacons() ([]string, int) {
+	var (
+		active      []*BeaconNode
+		unreachable []string
+		throttled   int
+	)
+	for _, node := range mon.beacons {
+		if err := node.Update(); err != nil {
+			if mon.nodeFailed(node, "updating beacon node", err) {
+				unreachable = append(unreachable, node.Name())
+			} else {
+				throttled++
+			}
+			continue
+		}
+		node.SetStatus(NodeStatusOK)
+		log.Info("Beacon head", "node", node.name, "slot", node.HeadSlot(),
+			"finalized", node.finality.Finalized.Epoch, "optimistic", node.optimistic)
+		active = append(active, node)
+		if max := mon.beaconConf.MaxSlotLag; max > 0 {
+			if lag := node.SlotLag(); lag > max {
+				mon.emit(EventSlotLag, SeverityWarning, []string{node.Name()},
+					"Head slot %d lags the wall clock by %d slots", node.HeadSlot(), lag)
+			}
+		}
+	}
+	if mon.rawBlocks != nil {
+		mon.rawBlocks.pruneBeacon(active)
+	}
+	for i := 0; i < len(active); i++ {
+		for j := i + 1; j < len(active); j++ {
+			mon.compareCheckpoints(active[i], active[j])
+		}
+	}
+	if mon.beaconConf.Checkpoints {
+		mon.updateCheckpoint(active)
+	}
+	mon.checkForks(active)
+	if mon.beaconConf.ForkChoice {
+		mon.checkForkChoice(active)
+	}
+	// The remaining checks query the first node, so prefer one whose head is
+	// fully verified
+	sort.SliceStable(active, func(i, j int) bool {
+		return !active[i].optimistic && active[j].optimistic
+	})
+	mon.slots.update(active)
+	metrics.GetOrRegisterGaugeFloat64("beacon/missedslots/rate", mon.registry).Update(mon.slots.rate())
+	mon.checkParticipation(active)
+	mon.checkValidators(active)
+	mon.scanBlocks(active)
+	return unreachable, throttled
+}
+
+// compareCheckpoints reports conflicting checkpoints. Conflicts involving an
+// optimistic node are only warnings, since its view isn't fully verified.
+func (mon *NodeMonitor) compareCheckpoints(a, b *BeaconNode) {
+	var (
+		names    = []string{a.name, b.name}
+		severity = SeverityCritical
+		note     string
+	)
+	if a.optimistic || b.optimistic {
+		severity, note = SeverityWarning, " (optimistic)"
+	}
+	if !checkpointsAgree(a, b, a.finality.Finalized, b.finality.Finalized) {
+		mon.emit(EventCheckpointMismatch, severity, names,
+			"Finalized checkpoints conflict: %d/%x vs %d/%x%s",
+			a.finality.Finalized.Epoch, a.finality.Finalized.Root,
+			b.finality.Finalized.Epoch, b.finality.Finalized.Root, note)
+		mon.storeBeaconBlocks(a, b, a.finality.Finalized, b.finality.Finalized)
+	}
+	ja, jb := a.finality.CurrentJustified, b.finality.CurrentJustified
+	if ja.Epoch == jb.Epoch && ja.Root != jb.Root {
+		mon.emit(EventCheckpointMismatch, severity, names,
+			"Justified checkpoints conflict at epoch %d: %x vs %x%s", ja.Epoch, ja.Root, jb.Root, note)
+		mon.storeBeaconBlocks(a, b, ja, jb)
+	}
+}
+
+// storeBeaconBlocks stores the SSZ of the conflicting checkpoint blocks, each
+// as served by the node which has it
+func (mon *NodeMonitor) storeBeaconBlocks(a, b *BeaconNode, ca, cb checkpoint) {
+	if mon.rawBlocks == nil {
+		return
+	}
+	mon.rawBlocks.storeBeacon(a, ca)
+	mon.rawBlocks.storeBeacon(b, cb)
+}
+
+// checkpointsAgree checks whether two checkpoints are on the same chain. If
+// they're from different epochs, the node with the later one is asked whether
+// it considers the earlier checkpoint block canonical.
+func checkpointsAgree(a, b *BeaconNode, ca, cb checkpoint) bool {
+	if ca.Epoch == cb.Epoch {
+		return ca.Root == cb.Root
+	}
+	if ca.Epoch < cb.Epoch {
+		a, ca, cb = b, cb, ca
+	}
+	h, err := a.header(cb.Root.Hex())
+	if err != nil {
+		// Can't tell. The node may never have seen the block, but it may
+		// also just not have it, e.g. if checkpoint-synced
+		return true
+	}
+	return h.Canonical
+}
@@ -0,0 +1,688 @@
+package nodes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// fakeBeacon serves canned beacon API responses, keyed by path. Headers by
+// slot are served for all slots up to the head, except for the missed ones.
+type fakeBeacon struct {
+	responses map[string]interface{}
+	head      uint64
+	missed    map[uint64]bool
+	proposers map[uint64]uint64
+	// raw responses are served as is, without the 'data' envelope
+	raw map[string]interface{}
+	// ssz responses are served to requests accepting octet streams
+	ssz map[string][]byte
+}
+
+func (f *fakeBeacon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if res, ok := f.ssz[r.URL.Path]; ok && r.Header.Get("Accept") == "application/octet-stream" {
+		w.Write(res)
+		return
+	}
+	if res, ok := f.raw[r.URL.Path]; ok {
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	data, ok := f.responses[r.URL.Path]
+	if !ok {
+		slot, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/eth/v1/beacon/headers/"), 10, 64)
+		if err != nil || slot > f.head || f.missed[slot] {
+			http.NotFound(w, r)
+			return
+		}
+		data = map[string]interface{}{
+			"root":      common.Hash{byte(slot)},
+			"canonical": true,
+			"header": map[string]interface{}{
+				"message": map[string]string{
+					"slot":           fmt.Sprint(slot),
+					"proposer_index": fmt.Sprint(f.proposers[slot]),
+				},
+			},
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func newFakeBeacon(t *testing.T, slot uint64, finalized, justified checkpoint) (*fakeBeacon, *BeaconNode, func()) {
+	t.Helper()
+	fb := &fakeBeacon{head: slot, missed: make(map[uint64]bool), proposers: make(map[uint64]uint64), responses: map[string]interface{}{
+		"/eth/v1/node/version": map[string]string{"version": "Fake/v1.0.0"},
+		// Genesis is set so that the given slot is the current one
+		"/eth/v1/beacon/genesis": map[string]string{
+			"genesis_time": fmt.Sprint(time.Now().Unix() - int64(slot)*12),
+		},
+		"/eth/v1/config/spec": map[string]string{"SECONDS_PER_SLOT": "12", "SLOTS_PER_EPOCH": "32"},
+		"/eth/v1/beacon/headers/head": map[string]interface{}{
+			"root":      common.Hash{byte(slot)},
+			"canonical": true,
+			"header": map[string]interface{}{
+				"message": map[string]string{"slot": fmt.Sprint(slot)},
+			},
+		},
+		"/eth/v1/beacon/states/head/finality_checkpoints": map[string]interface{}{
+			"previous_justified": justified,
+			"current_justified":  justified,
+			"finalized":          finalized,
+		},
+	}}
+	srv := httptest.NewServer(fb)
+	node, err := NewBeaconNode(srv.URL, srv.URL, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fb, node, srv.Close
+}
+
+func TestCheckpointMismatch(t *testing.T) {
+	finalized := checkpoint{Epoch: 10, Root: common.Hash{0x10}}
+	justified := checkpoint{Epoch: 11, Root: common.Hash{0x11}}
+
+	_, a, closeA := newFakeBeacon(t, 360, finalized, justified)
+	defer closeA()
+	_, b, closeB := newFakeBeacon(t, 360, finalized, justified)
+	defer closeB()
+	_, c, closeC := newFakeBeacon(t, 360, checkpoint{Epoch: 10, Root: common.Hash{0xff}}, justified)
+	defer closeC()
+
+	mon, _ := NewMonitor(nil, []*BeaconNode{a, b}, nil, &Config{ReloadInterval: "1s"})
+	if evs := mon.Report().Events; len(evs) != 0 {
+		t.Fatalf("expected no events, got %v", evs)
+	}
+	if have := mon.Report().Beacons[0].Finalized; have != finalized {
+		t.Errorf("wrong finalized checkpoint in report: %v", have)
+	}
+	mon, _ = NewMonitor(nil, []*BeaconNode{a, b, c}, nil, &Config{ReloadInterval: "1s"})
+	var mismatches int
+	for _, ev := range mon.Report().Events {
+		if ev.Kind == EventCheckpointMismatch && ev.Severity == SeverityCritical {
+			mismatches++
+			if !strings.Contains(strings.Join(ev.Nodes, ","), c.Name()) {
+				t.Errorf("event doesn't blame the right node: %v", ev)
+			}
+		}
+	}
+	if mismatches != 2 {
+		t.Errorf("expected 2 mismatches, got %d", mismatches)
+	}
+}
+
+func TestSlotLag(t *testing.T) {
+	cp := checkpoint{Epoch: 10, Root: common.Hash{0x10}}
+	_, a, closeA := newFakeBeacon(t, 360, cp, cp)
+	defer closeA()
+	fb, b, closeB := newFakeBeacon(t, 360, cp, cp)
+	defer closeB()
+	// Make b's head lag ten slots behind
+	fb.responses["/eth/v1/beacon/genesis"] = map[string]string{
+		"genesis_time": fmt.Sprint(time.Now().Unix() - 370*12),
+	}
+	conf := &Config{ReloadInterval: "1s", Beacon: beaconConfig{MaxSlotLag: 5}}
+	mon, _ := NewMonitor(nil, []*BeaconNode{a, b}, nil, conf)
+
+	var lagging []string
+	for _, ev := range mon.Report().Events {
+		if ev.Kind == EventSlotLag {
+			lagging = append(lagging, ev.Nodes...)
+		}
+	}
+	if len(lagging) != 1 || lagging[0] != b.Name() {
+		t.Errorf("expected %v to lag, got %v", b.Name(), lagging)
+	}
+	if lag := mon.Report().Beacons[1].SlotLag; lag < 9 || lag > 11 {
+		t.Errorf("unexpected slot lag %d", lag)
+	}
+}
+
+func TestMissedSlots(t *testing.T) {
+	cp := checkpoint{Epoch: 10, Root: common.Hash{0x10}}
+	fa, a, closeA := newFakeBeacon(t, 360, cp, cp)
+	defer closeA()
+	fb, b, closeB := newFakeBeacon(t, 360, cp, cp)
+	defer closeB()
+	// Slot 350 is missed by both, 355 only by a
+	fa.missed[350], fb.missed[350] = true, true
+	fa.missed[355] = true
+	fa.responses["/eth/v1/validator/duties/proposer/10"] = []map[string]string{
+		{"validator_index": "1234", "slot": "350"},
+	}
+	conf := &Config{ReloadInterval: "1s", Beacon: beaconConfig{MissedSlotWindow: 32}}
+	mon, _ := NewMonitor(nil, []*BeaconNode{a, b}, nil, conf)
+
+	r := mon.Report()
+	if len(r.MissedSlots) != 1 || r.MissedSlots[0].Slot != 350 || r.MissedSlots[0].Proposer == nil || *r.MissedSlots[0].Proposer != 1234 {
+		t.Fatalf("unexpected missed slots: %v", r.MissedSlots)
+	}
+	if want := 1.0 / 32; r.MissedSlotRate != want {
+		t.Errorf("wrong missed slot rate: have %v, want %v", r.MissedSlotRate, want)
+	}
+	// Advance the chain past the window. The duties of slot 390 can't be
+	// looked up, so its proposer is unknown.
+	fa.head, fb.head = 400, 400
+	fa.missed[390], fb.missed[390] = true, true
+	for _, f := range []*fakeBeacon{fa, fb} {
+		f.responses["/eth/v1/beacon/headers/head"] = map[string]interface{}{
+			"root": common.Hash{0x01}, "canonical": true,
+			"header": map[string]interface{}{"message": map[string]string{"slot": "400"}},
+		}
+	}
+	mon.doChecks()
+	if r := mon.Report(); len(r.MissedSlots) != 1 || r.MissedSlots[0].Slot != 390 || r.MissedSlots[0].Proposer != nil {
+		t.Errorf("expected missed slot to leave the window, have %v", r.MissedSlots)
+	}
+}
+
+func TestParticipation(t *testing.T) {
+	cp := checkpoint{Epoch: 10, Root: common.Hash{0x10}}
+	fa, a, closeA := newFakeBeacon(t, 360, cp, cp)
+	defer closeA()
+	fb, b, closeB := newFakeBeacon(t, 360, cp, cp)
+	defer closeB()
+	// Slot 360 is in epoch 11, so participation is fetched for epoch 10. The
+	// endpoints differ in whether they quote the numbers.
+	fa.responses["/lighthouse/validator_inclusion/10/global"] = map[string]interface{}{
+		"previous_epoch_active_gwei":           1000,
+		"previous_epoch_target_attesting_gwei": 950,
+	}
+	fb.responses["/lighthouse/validator_inclusion/10/global"] = map[string]interface{}{
+		"previous_epoch_active_gwei":           "1000",
+		"previous_epoch_target_attesting_gwei": "700",
+	}
+	conf := &Config{ReloadInterval: "1s", Beacon: beaconConfig{
+		MinParticipation:       0.8,
+		MaxParticipationSpread: 0.1,
+	}}
+	mon, _ := NewMonitor(nil, []*BeaconNode{a, b}, nil, conf)
+	r := mon.Report()
+	if p := r.Beacons[0].Participation; p == nil || p.Epoch != 10 || p.Rate != 0.95 {
+		t.Errorf("unexpected participation: %v", p)
+	}
+	var events []string
+	for _, ev := range r.Events {
+		if ev.Kind == EventParticipation {
+			events = append(events, strings.Join(ev.Nodes, ","))
+		}
+	}
+	want := []string{b.Name(), a.Name() + "," + b.Name()}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("unexpected events: have %v, want %v", events, want)
+	}
+	if s := a.participationSupport; s == nil || !*s {
+		t.Error("participation support not detected")
+	}
+}
+
+func TestParticipationUnsupported(t *testing.T) {
+	cp := checkpoint{Epoch: 10, Root: common.Hash{0x10}}
+	_, a, closeA := newFakeBeacon(t, 360, cp, cp)
+	defer closeA()
+	conf := &Config{ReloadInterval: "1s", Beacon: beaconConfig{MinParticipation: 0.8}}
+	mon, _ := NewMonitor(nil, []*BeaconNode{a}, nil, conf)
+	if s := a.participationSupport; s == nil || *s {
+		t.Fatal("expected node without the endpoint to be detected")
+	}
+	mon.doChecks()
+	for _, ev := range mon.Report().Events {
+		if ev.Kind == EventParticipation {
+			t.Errorf("unexpected event: %v", ev)
+		}
+	}
+}
+
+func TestValidatorDuties(t *testing.T) {
+	cp := checkpoint{Epoch: 10, Root: common.Hash{0x10}}
+	fa, a, closeA := newFakeBeacon(t, 360, cp, cp)
+	defer closeA()
+
+	fa.responses["/eth/v1/beacon/states/head/validators"] = []map[string]interface{}{
+		{"index": "5", "validator": map[string]string{"pubkey": "0xaa"}},
+		{"index": "7", "validator": map[string]string{"pubkey": "0xbb"}},
+	}
+	// Slot 360 is in epoch 11, so epoch 9 is checked
+	fa.responses["/eth/v1/beacon/rewards/attestations/9"] = map[string]interface{}{
+		"total_rewards": []map[string]string{
+			{"validator_index": "5", "source": "1000"},
+			{"validator_index": "7", "source": "-1000"},
+		},
+	}
+	fa.responses["/eth/v1/validator/duties/proposer/9"] = []map[string]string{
+		{"validator_index": "5", "slot": "290"},
+		{"validator_index": "7", "slot": "300"},
+	}
+	fa.proposers[290] = 5
+	fa.missed[300] = true
+
+	conf := &Config{ReloadInterval: "1s", Beacon: beaconConfig{Validators: []string{"5", "0xBB"}}}
+	mon, _ := NewMonitor(nil, []*BeaconNode{a}, nil, conf)
+	vals := mon.Report().Validators
+	if len(vals) != 2 {
+		t.Fatalf("expected 2 validators, got %d", len(vals))
+	}
+	if v := vals[0]; v.Label != "5" || !v.Attested || len(v.MissedProposals) != 0 {
+		t.Errorf("unexpected result for validator 5: %+v", v)
+	}
+	if v := vals[1]; v.Label != "0xbb" || v.Attested || fmt.Sprint(v.MissedProposals) != "[300]" {
+		t.Errorf("unexpected result for validator 7: %+v", v)
+	}
+	var kinds []string
+	for _, ev := range mon.Report().Events {
+		if ev.Kind == EventMissedAttestation || ev.Kind == EventMissedProposal {
+			kinds = append(kinds, ev.Kind)
+		}
+	}
+	if len(kinds) != 2 {
+		t.Errorf("expected two missed duty events, got %v", kinds)
+	}
+}
+
+func TestSlashings(t *testing.T) {
+	cp := checkpoint{Epoch: 10, Root: common.Hash{0x10}}
+	fa, a, closeA := newFakeBeacon(t, 100, cp, cp)
+	defer closeA()
+
+	header := func(root byte) map[string]interface{} {
+		return map[string]interface{}{"message": map[string]string{
+			"slot": "90", "proposer_index": "3", "body_root": common.Hash{root}.Hex(),
+		}}
+	}
+	attestation := func(root byte, indices ...string) map[string]interface{} {
+		return map[string]interface{}{
+			"attesting_indices": indices,
+			"data":              map[string]string{"beacon_block_root": common.Hash{root}.Hex()},
+		}
+	}
+	fa.responses["/eth/v2/beacon/blocks/95"] = map[string]interface{}{
+		"message": map[string]interface{}{"body": map[string]interface{}{
+			"proposer_slashings": []interface{}{
+				map[string]interface{}{"signed_header_1": header(1), "signed_header_2": header(2)},
+			},
+			"attester_slashings": []interface{}{
+				map[string]interface{}{
+					"attestation_1": attestation(3, "4", "5", "6"),
+					"attestation_2": attestation(4, "6", "5", "8"),
+				},
+			},
+		}},
+	}
+	mon, _ := NewMonitor(nil, []*BeaconNode{a}, nil, &Config{ReloadInterval: "1s"})
+	r := mon.Report()
+	if len(r.Slashings) != 2 {
+		t.Fatalf("expected 2 slashings, got %d", len(r.Slashings))
+	}
+	if s := r.Slashings[0]; s.Kind != "proposer" || fmt.Sprint(s.Indices) != "[3]" || s.Evidence[1] != (common.Hash{2}) {
+		t.Errorf("unexpected proposer slashing: %+v", s)
+	}
+	if s := r.Slashings[1]; s.Kind != "attester" || fmt.Sprint(s.Indices) != "[5 6]" || s.Evidence[0] != (common.Hash{3}) {
+		t.Errorf("unexpected attester slashing: %+v", s)
+	}
+	var events int
+	for _, ev := range r.Events {
+		if ev.Kind == EventSlashing && ev.Severity == SeverityCritical {
+			events++
+		}
+	}
+	if events != 2 {
+		t.Errorf("expected 2 slashing events, got %d", events)
+	}
+	// Blocks are only scanned once
+	mon.doChecks()
+	if r := mon.Report(); len(r.Slashings) != 2 {
+		t.Errorf("expected slashings to be reported once, got %d", len(r.Slashings))
+	}
+}
+
+func TestSyncCommittee(t *testing.T) {
+	cp := checkpoint{Epoch: 1, Root: common.Hash{0x10}}
+	fa, a, closeA := newFakeBeacon(t, 100, cp, cp)
+	defer closeA()
+
+	fa.responses["/eth/v1/beacon/states/head/validators"] = []map[string]interface{}{
+		{"index": "5", "validator": map[string]string{"pubkey": "0xaa"}},
+		{"index": "7", "validator": map[string]string{"pubkey": "0xbb"}},
+	}
+	fa.responses["/eth/v1/validator/duties/sync/3"] = []map[string]string{{"validator_index": "5"}}
+	fa.responses["/eth/v1/validator/duties/sync/256"] = []map[string]string{{"validator_index": "7"}}
+	for slot := 97; slot <= 100; slot++ {
+		fa.responses[fmt.Sprintf("/eth/v1/beacon/rewards/sync_committee/%d", slot)] = []map[string]string{
+			{"validator_index": "5", "reward": "-100"},
+		}
+	}
+	conf := &Config{ReloadInterval: "1s", Beacon: beaconConfig{Validators: []string{"5", "7"}}}
+	mon, _ := NewMonitor(nil, []*BeaconNode{a}, nil, conf)
+	r := mon.Report()
+	if len(r.Validators) != 2 {
+		t.Fatalf("expected 2 validators, got %d", len(r.Validators))
+	}
+	if v := r.Validators[0]; v.SyncCommittee != "current" || v.SyncMisses != 4 {
+		t.Errorf("unexpected sync committee result for validator 5: %+v", v)
+	}
+	if v := r.Validators[1]; v.SyncCommittee != "next" || v.SyncMisses != 0 {
+		t.Errorf("unexpected sync committee result for validator 7: %+v", v)
+	}
+	var events int
+	for _, ev := range r.Events {
+		if ev.Kind == EventSyncCommittee {
+			events++
+		}
+	}
+	if events != 1 {
+		t.Errorf("expected 1 sync committee event, got %d", events)
+	}
+}
+
+func TestForkMismatch(t *testing.T) {
+	var (
+		cp      = checkpoint{Epoch: 10, Root: common.Hash{0x10}}
+		enrA    = "enr:-F-4QAEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBhGV0aDKQqrvM3QAAAAAAAAAAAAAAAIJpZIJ2NA"
+		enrB    = "enr:-F-4QAEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBhGV0aDKQqrvM7gAAAAAAAAAAAAAAAIJpZIJ2NA"
+		version = map[string]string{"previous_version": "0x01000000", "current_version": "0x02000000", "epoch": "5"}
+	)
+	if digest, err := enrForkDigest(enrA); err != nil || digest != "0xaabbccdd" {
+		t.Fatalf("wrong fork digest: %v %v", digest, err)
+	}
+	var (
+		fakes []*fakeBeacon
+		nodes []*BeaconNode
+	)
+	for _, enr := range []string{enrA, enrA, enrB} {
+		fb, node, closeFn := newFakeBeacon(t, 360, cp, cp)
+		defer closeFn()
+		fb.responses["/eth/v1/beacon/states/head/fork"] = version
+		fb.responses["/eth/v1/node/identity"] = map[string]string{"enr": enr}
+		fakes = append(fakes, fb)
+		nodes = append(nodes, node)
+	}
+	mon, _ := NewMonitor(nil, nodes, nil, &Config{ReloadInterval: "1s"})
+	var mismatches [][]string
+	for _, ev := range mon.Report().Events {
+		if ev.Kind == EventForkMismatch {
+			mismatches = append(mismatches, ev.Nodes)
+		}
+	}
+	want := fmt.Sprint([][]string{{nodes[0].Name(), nodes[2].Name()}, {nodes[1].Name(), nodes[2].Name()}})
+	if fmt.Sprint(mismatches) != want {
+		t.Errorf("wrong fork mismatches: have %v, want %v", mismatches, want)
+	}
+	if bj := mon.Report().Beacons[0]; bj.ForkVersion != "0x02000000" || bj.ForkDigest != "0xaabbccdd" {
+		t.Errorf("wrong fork in report: %+v", bj)
+	}
+	// The ENR isn't fetched again while the fork stays the same, but it is
+	// once the fork changed
+	delete(fakes[0].responses, "/eth/v1/node/identity")
+	mon.doChecks()
+	if bj := mon.Report().Beacons[0]; bj.ForkDigest != "0xaabbccdd" {
+		t.Errorf("fork digest not kept: %+v", bj)
+	}
+	fakes[0].responses["/eth/v1/beacon/states/head/fork"] = map[string]string{"previous_version": "0x02000000", "current_version": "0x03000000", "epoch": "11"}
+	mon.doChecks()
+	if bj := mon.Report().Beacons[0]; bj.ForkDigest != "" {
+		t.Errorf("fork digest not refetched after fork: %+v", bj)
+	}
+}
+
+func TestOptimisticCheckpoints(t *testing.T) {
+	cp := checkpoint{Epoch: 10, Root: common.Hash{0x10}}
+	_, a, closeA := newFakeBeacon(t, 360, cp, cp)
+	defer closeA()
+	fc, c, closeC := newFakeBeacon(t, 360, checkpoint{Epoch: 10, Root: common.Hash{0xff}}, cp)
+	defer closeC()
+	fc.responses["/eth/v1/node/syncing"] = map[string]interface{}{"head_slot": "360", "is_optimistic": true}
+
+	mon, _ := NewMonitor(nil, []*BeaconNode{a, c}, nil, &Config{ReloadInterval: "1s"})
+	r := mon.Report()
+	if r.Beacons[0].Optimistic || !r.Beacons[1].Optimistic {
+		t.Errorf("wrong optimistic status in report")
+	}
+	var severities []Severity
+	for _, ev := range r.Events {
+		if ev.Kind == EventCheckpointMismatch {
+			severities = append(severities, ev.Severity)
+		}
+	}
+	if len(severities) != 1 || severities[0] != SeverityWarning {
+		t.Errorf("expected a single mismatch warning, got %v", severities)
+	}
+}
+
+func TestPairs(t *testing.T) {
+	cp := checkpoint{Epoch: 10, Root: common.Hash{0x10}}
+	chain := makeChain("base", 60, nil)
+	exec := newTestNode("exec", 50, chain)
+
+	payload := func(num uint64, hash common.Hash) map[string]interface{} {
+		return map[string]interface{}{"message": map[string]interface{}{"body": map[string]interface{}{
+			"execution_payload": map[string]interface{}{"block_number": fmt.Sprint(num), "block_hash": hash},
+		}}}
+	}
+	fa, a, closeA := newFakeBeacon(t, 360, cp, cp)
+	defer closeA()
+	fa.responses["/eth/v2/beacon/blocks/head"] = payload(49, chain[49].hash)
+	fb, b, closeB := newFakeBeacon(t, 360, cp, cp)
+	defer closeB()
+	fb.responses["/eth/v2/beacon/blocks/head"] = payload(49, common.Hash{0xff})
+	fc, c, closeC := newFakeBeacon(t, 360, cp, cp)
+	defer closeC()
+	fc.responses["/eth/v2/beacon/blocks/head"] = payload(55, common.Hash{0xff})
+
+	conf := &Config{ReloadInterval: "1s", Clients: []ClientInfo{
+		{Name: a.Name(), Kind: "beacon", Pair: exec.Name()},
+		{Name: b.Name(), Kind: "beacon", Pair: exec.Name()},
+		{Name: c.Name(), Kind: "beacon", Pair: exec.Name()},
+	}}
+	mon, err := NewMonitor([]Node{exec}, []*BeaconNode{a, b, c}, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := mon.Report()
+	if len(r.Pairs) != 3 {
+		t.Fatalf("expected 3 pairs, got %d", len(r.Pairs))
+	}
+	for i, want := range []bool{true, false, false} {
+		if r.Pairs[i].InSync != want {
+			t.Errorf("pair %d: wrong sync status %v", i, r.Pairs[i].InSync)
+		}
+	}
+	var severities []Severity
+	for _, ev := range r.Events {
+		if ev.Kind == EventPairDrift {
+			severities = append(severities, ev.Severity)
+		}
+	}
+	if fmt.Sprint(severities) != fmt.Sprint([]Severity{SeverityCritical, SeverityWarning}) {
+		t.Errorf("wrong pair events: %v", severities)
+	}
+	conf.Clients[0].Pair = "missing"
+	if _, err := NewMonitor([]Node{exec}, []*BeaconNode{a}, nil, conf); err == nil {
+		t.Errorf("expected error for unknown pair")
+	}
+}
+
+func TestBlobs(t *testing.T) {
+	for excess, want := range map[uint64]uint64{0: 1, 3338477: 2, 33384770: 22026} {
+		if have := blobBaseFee(excess, blobUpdateFraction("deneb", 0)).Uint64(); have != want {
+			t.Errorf("wrong blob base fee for excess %d: have %d, want %d", excess, have, want)
+		}
+	}
+	if f := blobUpdateFraction("electra", 0); f != 5007716 {
+		t.Errorf("wrong electra update fraction %d", f)
+	}
+	if f := blobUpdateFraction("fulu", 8346193); f != 8346193 {
+		t.Errorf("configured update fraction not used: %d", f)
+	}
+	cp := checkpoint{Epoch: 10, Root: common.Hash{0x10}}
+	fa, a, closeA := newFakeBeacon(t, 100, cp, cp)
+	defer closeA()
+	_, b, closeB := newFakeBeacon(t, 100, cp, cp)
+	defer closeB()
+
+	// With the electra update fraction, the excess gas is the one for a fee
+	// of 2 wei
+	fa.raw = map[string]interface{}{"/eth/v2/beacon/blocks/99": map[string]interface{}{
+		"version": "electra",
+		"data": map[string]interface{}{"message": map[string]interface{}{"body": map[string]interface{}{
+			"blob_kzg_commitments": []string{"0x01", "0x02"},
+			"execution_payload":    map[string]string{"blob_gas_used": "262144", "excess_blob_gas": "5007716"},
+		}}},
+	}}
+	fa.responses["/eth/v1/beacon/blob_sidecars/99"] = []map[string]string{{"index": "0"}, {"index": "1"}}
+
+	mon, _ := NewMonitor(nil, []*BeaconNode{a, b}, nil, &Config{ReloadInterval: "1s"})
+	r := mon.Report()
+	if len(r.Blobs) != 1 {
+		t.Fatalf("expected 1 block with blobs, got %d", len(r.Blobs))
+	}
+	want := blobJson{Slot: 99, Blobs: 2, BlobGasUsed: 262144, BlobBaseFee: 2, Unavailable: []string{b.Name()}}
+	if fmt.Sprint(*r.Blobs[0]) != fmt.Sprint(want) {
+		t.Errorf("wrong blob report: have %+v, want %+v", *r.Blobs[0], want)
+	}
+	var events []string
+	for _, ev := range r.Events {
+		if ev.Kind == EventBlobUnavailable {
+			events = append(events, ev.Nodes...)
+		}
+	}
+	if fmt.Sprint(events) != fmt.Sprint([]string{b.Name()}) {
+		t.Errorf("wrong blob events: %v", events)
+	}
+}
+
+func TestForkChoiceWeights(t *testing.T) {
+	var (
+		cp   = checkpoint{Epoch: 1, Root: common.Hash{0x01}}
+		tree = map[string]interface{}{
+			"fork_choice_nodes": []map[string]interface{}{
+				{"block_root": common.Hash{1}, "parent_root": common.Hash{}, "weight": "30"},
+				{"block_root": common.Hash{50}, "parent_root": common.Hash{1}, "weight": "10"},
+				{"block_root": common.Hash{100}, "parent_root": common.Hash{50}, "weight": "10"},
+				{"block_root": common.Hash{60}, "parent_root": common.Hash{1}, "weight": "20"},
+				{"block_root": common.Hash{101}, "parent_root": common.Hash{60}, "weight": "20"},
+			},
+		}
+	)
+	// The heads are at the slots 100 and 101, on different branches
+	fa, a, closeA := newFakeBeacon(t, 100, cp, cp)
+	defer closeA()
+	fb, b, closeB := newFakeBeacon(t, 101, cp, cp)
+	defer closeB()
+	fa.raw = map[string]interface{}{"/eth/v1/debug/fork_choice": tree}
+	fb.raw = fa.raw
+
+	conf := &Config{ReloadInterval: "1s", Beacon: beaconConfig{ForkChoice: true}}
+	mon, _ := NewMonitor(nil, []*BeaconNode{a, b}, nil, conf)
+	var light []string
+	for _, ev := range mon.Report().Events {
+		if ev.Kind == EventLightChain {
+			light = append(light, ev.Nodes...)
+		}
+	}
+	if len(light) != 1 || light[0] != a.Name() {
+		t.Errorf("expected only %v on the lighter branch, got %v", a.Name(), light)
+	}
+
+	fc, err := a.forkChoice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Heads on the same branch aren't competing
+	if _, _, ok := fc.branches(common.Hash{100}, common.Hash{50}); ok {
+		t.Error("expected no branches for an ancestor")
+	}
+	if _, _, ok := fc.branches(common.Hash{50}, common.Hash{100}); ok {
+		t.Error("expected no branches for a descendant")
+	}
+	if own, other, ok := fc.branches(common.Hash{100}, common.Hash{101}); !ok || own.Weight != 10 || other.Weight != 20 {
+		t.Errorf("wrong branches: %v %v %v", own, other, ok)
+	}
+}
+
+func TestBeaconRawBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rawblocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := common.Hash{0x10}
+	fb, node, closeFn := newFakeBeacon(t, 360, checkpoint{Epoch: 10, Root: root}, checkpoint{})
+	defer closeFn()
+	fb.ssz = map[string][]byte{fmt.Sprintf("/eth/v2/beacon/blocks/0x%x", root): {0x01, 0x02}}
+
+	rs := newRawBlockStore(true)
+	rs.dir = dir
+	rs.storeBeacon(node, checkpoint{Epoch: 10, Root: root}, checkpoint{Epoch: 10, Root: common.Hash{0x11}})
+	data, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("0x%x.ssz", root)))
+	if err != nil || string(data) != "\x01\x02" {
+		t.Errorf("wrong block stored: %x, %v", data, err)
+	}
+	// The unknown block isn't stored, and not asked for again
+	if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("0x%x.ssz", common.Hash{0x11}))); !os.IsNotExist(err) {
+		t.Errorf("missing block stored: %v", err)
+	}
+	if _, ok := rs.roots[common.Hash{0x11}]; !ok {
+		t.Error("missing block not marked as done")
+	}
+	// Once all nodes finalized a later epoch, the blocks are forgotten
+	if err := node.Update(); err != nil {
+		t.Fatal(err)
+	}
+	rs.pruneBeacon([]*BeaconNode{node})
+	if len(rs.roots) != 2 {
+		t.Errorf("blocks of the finalized epoch forgotten: %v", rs.roots)
+	}
+	node.finality.Finalized.Epoch = 11
+	rs.pruneBeacon([]*BeaconNode{node})
+	if len(rs.roots) != 0 {
+		t.Errorf("blocks below the finalized epoch not forgotten: %v", rs.roots)
+	}
+}
+
+func TestNodeIdentities(t *testing.T) {
+	cp := checkpoint{Epoch: 10, Root: common.Hash{0x10}}
+	fa, a, closeA := newFakeBeacon(t, 360, cp, cp)
+	defer closeA()
+	fb, b, closeB := newFakeBeacon(t, 360, cp, cp)
+	defer closeB()
+	_, c, closeC := newFakeBeacon(t, 360, cp, cp)
+	defer closeC()
+	identity := map[string]interface{}{
+		"peer_id":             "16Uiu2HAm",
+		"enr":                 "enr:-IS4QHCYr",
+		"p2p_addresses":       []string{"/ip4/7.7.7.7/tcp/9000/p2p/16Uiu2HAm"},
+		"discovery_addresses": []string{"/ip4/7.7.7.7/udp/9000/p2p/16Uiu2HAm"},
+	}
+	fa.responses["/eth/v1/node/identity"] = identity
+	fb.responses["/eth/v1/node/identity"] = identity
+
+	mon, _ := NewMonitor(nil, []*BeaconNode{a, b, c}, nil, &Config{ReloadInterval: "1s"})
+	r := mon.Report()
+	if id := r.Beacons[0].Identity; id == nil || id.ID != "16Uiu2HAm" || len(id.Addresses) != 2 {
+		t.Fatalf("wrong identity: %+v", id)
+	}
+	// The node without the endpoint isn't asked again
+	if r.Beacons[2].Identity != nil || !mon.identities.unsupported[c.Name()] {
+		t.Errorf("unexpected identity: %+v", r.Beacons[2].Identity)
+	}
+	var dups []*Event
+	for _, ev := range r.Events {
+		if ev.Kind == EventDuplicateNode {
+			dups = append(dups, ev)
+		}
+	}
+	if len(dups) != 1 || len(dups[0].Nodes) != 2 {
+		t.Fatalf("expected a duplicate node event, got %v", r.Events)
+	}
+}
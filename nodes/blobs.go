@@ -0,0 +1,118 @@
+package nodes
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// maxBlobBlocks is the number of recent blocks with blobs kept for the report
+const maxBlobBlocks = 32
+
+// minBlobBaseFee is the EIP-4844 minimum blob base fee
+const minBlobBaseFee = 1
+
+// blobUpdateFractions are the blob base fee update fractions by fork, as
+// named in the version of the beacon blocks. Fulu keeps the Prague (EIP-7691)
+// one, later blob-parameter-only forks need it configured.
+var blobUpdateFractions = map[string]uint64{
+	"deneb":   3338477,
+	"electra": 5007716,
+	"fulu":    5007716,
+}
+
+// blobUpdateFraction returns the update fraction for blocks of the fork. A
+// configured one takes precedence, and unknown forks are assumed to be later
+// ones, keeping the latest known fraction.
+func blobUpdateFraction(fork string, configured uint64) uint64 {
+	if configured > 0 {
+		return configured
+	}
+	if f, ok := blobUpdateFractions[fork]; ok {
+		return f
+	}
+	if len(fork) == 0 {
+		return blobUpdateFractions["deneb"]
+	}
+	return blobUpdateFractions["fulu"]
+}
+
+// blobJson is the blob usage and availability of a block
+type blobJson struct {
+	Slot        uint64
+	Blobs       int
+	BlobGasUsed uint64
+	BlobBaseFee uint64 // in wei
+	// Unavailable are the nodes which failed to serve the blob sidecars
+	Unavailable []string
+}
+
+// blobTracker keeps the blob usage of recent blocks
+type blobTracker struct {
+	recent []*blobJson
+}
+
+// blobBaseFee calculates the blob base fee from the excess blob gas
+func blobBaseFee(excessBlobGas, updateFraction uint64) *big.Int {
+	return fakeExponential(big.NewInt(minBlobBaseFee), new(big.Int).SetUint64(excessBlobGas),
+		new(big.Int).SetUint64(updateFraction))
+}
+
+// fakeExponential approximates factor * e ** (numerator / denominator), as
+// specified in EIP-4844
+func fakeExponential(factor, numerator, denominator *big.Int) *big.Int {
+	var (
+		output = new(big.Int)
+		accum  = new(big.Int).Mul(factor, denominator)
+	)
+	for i := 1; accum.Sign() > 0; i++ {
+		output.Add(output, accum)
+		accum.Mul(accum, numerator)
+		accum.Div(accum, denominator)
+		accum.Div(accum, big.NewInt(int64(i)))
+	}
+	return output.Div(output, denominator)
+}
+
+// checkBlobs asks all nodes for the blob sidecars of a block with blobs, and
+// reports the ones which can't serve them. The fork is the version of the
+// block.
+func (mon *NodeMonitor) checkBlobs(nodes []*BeaconNode, slot uint64, fork string, body *beaconBlockBody) {
+	if len(body.BlobKZGCommitments) == 0 || body.ExecutionPayload == nil {
+		return
+	}
+	bj := &blobJson{
+		Slot:        slot,
+		Blobs:       len(body.BlobKZGCommitments),
+		BlobGasUsed: uint64(body.ExecutionPayload.BlobGasUsed),
+		BlobBaseFee: blobBaseFee(uint64(body.ExecutionPayload.ExcessBlobGas),
+			blobUpdateFraction(fork, mon.beaconConf.BlobUpdateFraction)).Uint64(),
+	}
+	for _, node := range nodes {
+		if node.HeadSlot() < slot {
+			continue // hasn't got the block yet
+		}
+		var sidecars []struct {
+			Index flexUint `json:"index"`
+		}
+		err := node.get(fmt.Sprintf("/eth/v1/beacon/blob_sidecars/%d", slot), &sidecars)
+		if err == nil && len(sidecars) == bj.Blobs {
+			continue
+		}
+		if err != nil {
+			log.Debug("Failed to fetch blob sidecars", "node", node.name, "slot", slot, "error", err)
+		}
+		bj.Unavailable = append(bj.Unavailable, node.Name())
+		mon.emit(EventBlobUnavailable, SeverityWarning, []string{node.Name()},
+			"Node serves %d of %d blob sidecars for slot %d", len(sidecars), bj.Blobs, slot)
+	}
+	bt := mon.blobs
+	bt.recent = append(bt.recent, bj)
+	if len(bt.recent) > maxBlobBlocks {
+		bt.recent = bt.recent[len(bt.recent)-maxBlobBlocks:]
+	}
+	metrics.GetOrRegisterGauge("beacon/blobs/count", mon.registry).Update(int64(bj.Blobs))
+	metrics.GetOrRegisterGauge("beacon/blobs/basefee", mon.registry).Update(int64(bj.BlobBaseFee))
+}
@@ -0,0 +1,69 @@
+package nodes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// maxBlockScan is the max number of blocks scanned per cycle, for slashings,
+// blobs or sync committee contributions
+const maxBlockScan = 32
+
+// beaconBlockBody is the part of a block body checked by the block scan
+type beaconBlockBody struct {
+	slashingsBody
+	BlobKZGCommitments []string `json:"blob_kzg_commitments"`
+	ExecutionPayload   *struct {
+		BlobGasUsed   flexUint `json:"blob_gas_used"`
+		ExcessBlobGas flexUint `json:"excess_blob_gas"`
+	} `json:"execution_payload"`
+}
+
+// blockScanner keeps track of the blocks scanned so far
+type blockScanner struct {
+	next uint64 // next slot to scan
+}
+
+// scanStart returns the first slot to scan, given the next unscanned one. It
+// doesn't go too far back on startup, or after a longer hiccup.
+func scanStart(next, head uint64) uint64 {
+	if next+maxBlockScan < head {
+		return head - maxBlockScan + 1
+	}
+	return next
+}
+
+// scanBlocks fetches the blocks since the last cycle from the first node, and
+// checks them for slashings and blob availability.
+func (mon *NodeMonitor) scanBlocks(nodes []*BeaconNode) {
+	if len(nodes) == 0 {
+		return
+	}
+	bs, node := mon.scanner, nodes[0]
+	head := node.HeadSlot()
+	for bs.next = scanStart(bs.next, head); bs.next <= head; bs.next++ {
+		// The version is needed for fork-dependent parameters, so the
+		// response is decoded as a whole
+		var res struct {
+			Version string `json:"version"`
+			Data    struct {
+				Message struct {
+					Body beaconBlockBody `json:"body"`
+				} `json:"message"`
+			} `json:"data"`
+		}
+		err := node.fetch("GET", fmt.Sprintf("/eth/v2/beacon/blocks/%d", bs.next), nil, &res)
+		if errors.Is(err, errNotFound) {
+			continue // empty slot
+		}
+		if err != nil {
+			log.Warn("Failed to fetch block", "slot", bs.next, "error", err)
+			return
+		}
+		mon.checkSlashings(node, bs.next, &res.Data.Message.Body)
+		mon.checkBlobs(nodes, bs.next, strings.ToLower(res.Version), &res.Data.Message.Body)
+	}
+}
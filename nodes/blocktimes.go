@@ -0,0 +1,103 @@
+package nodes
+
+import "sort"
+
+// blockTimeWindow is the number of inter-block times the statistics are
+// computed over
+const blockTimeWindow = 256
+
+// blockTimeJson holds statistics over the recent inter-block times, in seconds
+type blockTimeJson struct {
+	Average float64
+	Median  float64
+	P95     float64
+	// Samples is the number of blocks the statistics are based on
+	Samples int
+}
+
+// blockTimes tracks the time between blocks, from the timestamps of the
+// highest head seen in each cycle
+type blockTimes struct {
+	last    *blockInfo
+	samples []float64 // oldest first
+}
+
+// observe records the head of the nodes. If the head advanced by several
+// blocks since the last cycle, each of them is counted with the average
+// time between the two heads, so the average stays exact while the median
+// and p95 are approximations.
+func (bt *blockTimes) observe(active []Node) {
+	head := headBlock(active)
+	if head == nil || head.time == 0 {
+		return
+	}
+	// Heads going backwards are ignored, so the blocks in between aren't
+	// counted twice
+	last := bt.last
+	if last != nil && head.num <= last.num {
+		return
+	}
+	bt.last = head
+	if last == nil || head.time < last.time {
+		return
+	}
+	var (
+		blocks = head.num - last.num
+		avg    = float64(head.time-last.time) / float64(blocks)
+	)
+	if blocks > blockTimeWindow {
+		blocks = blockTimeWindow
+	}
+	for i := uint64(0); i < blocks; i++ {
+		bt.samples = append(bt.samples, avg)
+	}
+	if len(bt.samples) > blockTimeWindow {
+		bt.samples = append([]float64{}, bt.samples[len(bt.samples)-blockTimeWindow:]...)
+	}
+}
+
+// stats returns the statistics over the window, or nil if no blocks were
+// seen yet.
+func (bt *blockTimes) stats() *blockTimeJson {
+	if len(bt.samples) == 0 {
+		return nil
+	}
+	sorted := append([]float64{}, bt.samples...)
+	sort.Float64s(sorted)
+	var sum float64
+	for _, s := range sorted {
+		sum += s
+	}
+	st := &blockTimeJson{
+		Average: sum / float64(len(sorted)),
+		Median:  percentile(sorted, 50),
+		P95:     percentile(sorted, 95),
+		Samples: len(sorted),
+	}
+	return st
+}
+
+// headBlock returns the block at the highest head of the nodes, or nil if
+// it's unknown
+func headBlock(active []Node) *blockInfo {
+	var head Node
+	for _, node := range active {
+		if head == nil || node.HeadNum() > head.HeadNum() {
+			head = node
+		}
+	}
+	if head == nil {
+		return nil
+	}
+	return head.BlockAt(head.HeadNum(), false)
+}
+
+// percentile returns the p'th percentile of the sorted values, using the
+// nearest rank
+func percentile(sorted []float64, p int) float64 {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
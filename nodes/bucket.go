@@ -0,0 +1,296 @@
+package nodes
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+type bucketConfig struct {
+	// Bucket is the name of the bucket. Archiving is disabled if empty.
+	Bucket string
+	// Endpoint is the url of the S3 API, defaults to AWS. Other stores with
+	// an S3 compatible API work too, e.g. https://storage.googleapis.com
+	// for GCS, with HMAC keys.
+	Endpoint string
+	// Region defaults to us-east-1. GCS uses auto.
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Prefix is prepended to the object keys, e.g. mainnet/
+	Prefix string
+	// StorageClass of the uploaded objects, e.g. STANDARD_IA or NEARLINE
+	StorageClass string
+	// RetentionDays is how long objects under the prefix are kept. Older
+	// ones are deleted once a day. Zero keeps them forever.
+	RetentionDays int
+}
+
+// bucketQueue is the number of objects waiting to be uploaded, above which
+// new objects are dropped
+const bucketQueue = 256
+
+// bucketObject is an object waiting to be uploaded
+type bucketObject struct {
+	key  string
+	data []byte
+}
+
+// bucketArchiver uploads the reports, split artifacts and digests to an
+// object storage bucket, so the history survives the monitoring host. The
+// uploads happen in the background, requests are signed with AWS signature
+// version 4.
+type bucketArchiver struct {
+	conf   bucketConfig
+	base   *url.URL // url of the bucket
+	client *http.Client
+	queue  chan *bucketObject
+}
+
+func newBucketArchiver(conf bucketConfig) (*bucketArchiver, error) {
+	if len(conf.Bucket) == 0 {
+		return nil, nil
+	}
+	if len(conf.AccessKey) == 0 || len(conf.SecretKey) == 0 {
+		return nil, errors.New("bucket archiving needs an access_key and secret_key")
+	}
+	if conf.RetentionDays < 0 {
+		return nil, fmt.Errorf("invalid bucket retention: %d days", conf.RetentionDays)
+	}
+	if len(conf.Region) == 0 {
+		conf.Region = "us-east-1"
+	}
+	// AWS gets virtual-hosted urls, other endpoints path style urls, which
+	// all of them support
+	endpoint := fmt.Sprintf("https://%v.s3.%v.amazonaws.com", conf.Bucket, conf.Region)
+	if len(conf.Endpoint) > 0 {
+		endpoint = strings.TrimSuffix(conf.Endpoint, "/") + "/" + conf.Bucket
+	}
+	base, err := url.Parse(endpoint)
+	if err != nil || len(base.Host) == 0 {
+		return nil, fmt.Errorf("invalid bucket endpoint %q", conf.Endpoint)
+	}
+	return &bucketArchiver{
+		conf:   conf,
+		base:   base,
+		client: &http.Client{Timeout: time.Minute},
+		queue:  make(chan *bucketObject, bucketQueue),
+	}, nil
+}
+
+// put queues the object for uploading, under the prefix
+func (b *bucketArchiver) put(key string, data []byte) {
+	select {
+	case b.queue <- &bucketObject{b.conf.Prefix + key, data}:
+	default:
+		log.Warn("Bucket upload queue full, dropping object", "key", key)
+	}
+}
+
+// reportObject is the object key of a report, by date
+func reportObject(t time.Time) string {
+	return "reports/" + t.UTC().Format("2006/01/02/150405.000") + ".json"
+}
+
+// s3Escape percent-encodes everything but the unreserved characters, and
+// the slashes if not escapeSlash
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !escapeSlash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery encodes the query parameters, sorted
+func canonicalQuery(query url.Values) string {
+	var params []string
+	for k, vs := range query {
+		for _, v := range vs {
+			params = append(params, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sign adds the signature version 4 authorization to the request. All the
+// headers set on the request are signed.
+func (b *bucketArchiver) sign(req *http.Request, payload []byte, now time.Time) {
+	var (
+		stamp = now.UTC().Format("20060102T150405Z")
+		scope = fmt.Sprintf("%v/%v/s3/aws4_request", stamp[:8], b.conf.Region)
+		hash  = sha256.Sum256(payload)
+	)
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(hash[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signed,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+b.conf.SecretKey), stamp[:8])
+	key = hmacSHA256(key, b.conf.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%x",
+		b.conf.AccessKey, scope, signed, hmacSHA256(key, toSign)))
+}
+
+// do sends a signed request for the object key, or the bucket if the key is
+// empty, and returns the response body
+func (b *bucketArchiver) do(method, key string, query url.Values, body []byte) ([]byte, error) {
+	u := *b.base
+	// Encode the path and query the same way as they are signed
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if method == "PUT" {
+		req.Header.Set("Content-Type", http.DetectContentType(body))
+		if strings.HasSuffix(key, ".json") {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if len(b.conf.StorageClass) > 0 {
+			req.Header.Set("X-Amz-Storage-Class", b.conf.StorageClass)
+		}
+	}
+	b.sign(req, body, time.Now())
+	res, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%v %v: %v: %s", method, key, res.Status, data)
+	}
+	return data, nil
+}
+
+// listBucketResult is the part of the ListObjectsV2 response that's needed
+type listBucketResult struct {
+	Contents []struct {
+		Key          string
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// prune deletes the objects under the prefix which are older than the
+// retention, and returns how many were deleted
+func (b *bucketArchiver) prune(now time.Time) (int, error) {
+	var (
+		cutoff  = now.Add(-time.Duration(b.conf.RetentionDays) * 24 * time.Hour)
+		token   string
+		deleted int
+	)
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {b.conf.Prefix}}
+		if len(token) > 0 {
+			query.Set("continuation-token", token)
+		}
+		data, err := b.do("GET", "", query, nil)
+		if err != nil {
+			return deleted, err
+		}
+		var list listBucketResult
+		if err := xml.Unmarshal(data, &list); err != nil {
+			return deleted, err
+		}
+		for _, obj := range list.Contents {
+			if obj.LastModified.After(cutoff) {
+				continue
+			}
+			if _, err := b.do("DELETE", obj.Key, nil, nil); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+		if !list.IsTruncated || len(list.NextContinuationToken) == 0 {
+			return deleted, nil
+		}
+		token = list.NextContinuationToken
+	}
+}
+
+// uploadObjects uploads the queued objects until the monitor stops, and
+// prunes the bucket daily if a retention is set
+func (mon *NodeMonitor) uploadObjects() {
+	defer mon.wg.Done()
+	var prune <-chan time.Time
+	if mon.bucket.conf.RetentionDays > 0 {
+		prune = time.After(time.Minute)
+	}
+	for {
+		select {
+		case <-mon.quitCh:
+			return
+		case obj := <-mon.bucket.queue:
+			if _, err := mon.bucket.do("PUT", obj.key, nil, obj.data); err != nil {
+				log.Warn("Failed to upload object", "key", obj.key, "error", err)
+			}
+		case <-prune:
+			deleted, err := mon.bucket.prune(time.Now())
+			if err != nil {
+				log.Warn("Failed to prune bucket", "error", err)
+			} else if deleted > 0 {
+				log.Info("Pruned bucket", "deleted", deleted)
+			}
+			prune = time.After(24 * time.Hour)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package nodes
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+type canaryConfig struct {
+	// Interval is how often a canary transaction is sent. Disabled if empty.
+	Interval string
+	// KeyFile holds the hex private key of the account sending the canaries,
+	// a zero value transfer to itself. The account needs ether for the fees.
+	KeyFile string
+	// ChainID is signed into the transactions, if zero it's asked from the
+	// node sending it
+	ChainID uint64
+	// RawTxs are signed transactions (hex) which are sent, in order, before
+	// any self-transfers. Each is sent once.
+	RawTxs []string
+	// Timeout is how long a canary may take to be included before it's
+	// reported as stuck. Defaults to 5m.
+	Timeout string
+}
+
+// canaryHistory is the number of finished canaries kept in the report
+const canaryHistory = 16
+
+// canaryNode is implemented by nodes which transactions can be sent through
+type canaryNode interface {
+	Node
+	// SendRawTransaction submits the signed transaction to the txpool
+	SendRawTransaction(raw []byte) error
+	// TransactionBlock returns whether the node knows the transaction, and
+	// the number of the block including it, zero while it's pending
+	TransactionBlock(hash common.Hash) (bool, uint64, error)
+	// TxParams returns what's needed to sign a transaction from the account
+	TxParams(from common.Address) (*txParams, error)
+}
+
+type txParams struct {
+	// Nonce is that of the account at the head, so a pending canary which
+	// got stuck is replaced by the next one
+	Nonce    uint64
+	GasPrice *big.Int
+	ChainID  *big.Int
+}
+
+func (node *RPCNode) SendRawTransaction(raw []byte) error {
+	node.throttle.Take()
+	err := node.rpcCli.CallContext(context.Background(), nil, "eth_sendRawTransaction", hexutil.Bytes(raw))
+	return wrapNodeError(node.name, "sendTransaction", err)
+}
+
+func (node *RPCNode) TransactionBlock(hash common.Hash) (bool, uint64, error) {
+	node.throttle.Take()
+	var tx *struct {
+		BlockNumber *hexutil.Uint64 `json:"blockNumber"`
+	}
+	if err := node.rpcCli.CallContext(context.Background(), &tx, "eth_getTransactionByHash", hash); err != nil {
+		return false, 0, wrapNodeError(node.name, "transaction", err)
+	}
+	if tx == nil {
+		return false, 0, nil
+	}
+	if tx.BlockNumber == nil {
+		return true, 0, nil
+	}
+	return true, uint64(*tx.BlockNumber), nil
+}
+
+func (node *RPCNode) TxParams(from common.Address) (*txParams, error) {
+	node.throttle.Take()
+	var (
+		nonce          hexutil.Uint64
+		price, chainID hexutil.Big
+	)
+	batch := []rpc.BatchElem{
+		{Method: "eth_getTransactionCount", Args: []interface{}{from, "latest"}, Result: &nonce},
+		{Method: "eth_gasPrice", Result: &price},
+		{Method: "eth_chainId", Result: &chainID},
+	}
+	if err := node.rpcCli.BatchCallContext(context.Background(), batch); err != nil {
+		return nil, wrapNodeError(node.name, "txParams", err)
+	}
+	for _, el := range batch {
+		if el.Error != nil {
+			return nil, wrapNodeError(node.name, "txParams", el.Error)
+		}
+	}
+	return &txParams{Nonce: uint64(nonce), GasPrice: price.ToInt(), ChainID: chainID.ToInt()}, nil
+}
+
+// canaryJson is a canary transaction as shown in the report. The times are in
+// seconds after it was sent.
+type canaryJson struct {
+	Hash common.Hash
+	// Node is the node the transaction was sent through
+	Node string
+	Sent time.Time
+	// Accepted is how long the node took to accept the transaction
+	Accepted float64
+	// Error is why the node rejected the transaction, if it did
+	Error string `json:",omitempty"`
+	// Seen is when each node was first found to know the transaction
+	Seen map[string]float64
+	// Block is the block which included the transaction, and Included when
+	// that was found
+	Block    uint64  `json:",omitempty"`
+	Included float64 `json:",omitempty"`
+	// Stuck is set if it wasn't included within the timeout
+	Stuck bool `json:",omitempty"`
+}
+
+// canaryTracker sends a transaction through one of the nodes every interval,
+// in turn, and follows it until it's included: which nodes get it in their
+// txpool, and how long the inclusion takes. This checks the transaction path,
+// which block reads don't. Only one canary is in flight at a time, and it's
+// followed once per check cycle, which bounds the precision of the times.
+type canaryTracker struct {
+	interval time.Duration
+	timeout  time.Duration
+	key      *ecdsa.PrivateKey // nil if only raw transactions are sent
+	from     common.Address
+	chainID  *big.Int // nil to ask the node
+	raw      [][]byte // raw transactions not sent yet
+	turn     int      // index of the next node to send through
+	last     time.Time
+	// nonce and price of the last self-transfer, to outbid it if it's replaced
+	nonce uint64
+	price *big.Int
+
+	current *canaryJson   // in flight, nil if none
+	recent  []*canaryJson // finished, oldest first
+}
+
+// newCanaryTracker returns nil if canaries are disabled
+func newCanaryTracker(conf canaryConfig) (*canaryTracker, error) {
+	if len(conf.Interval) == 0 {
+		return nil, nil
+	}
+	interval, err := time.ParseDuration(conf.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid canary interval: %v", err)
+	}
+	timeout := 5 * time.Minute
+	if len(conf.Timeout) > 0 {
+		if timeout, err = time.ParseDuration(conf.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid canary timeout: %v", err)
+		}
+	}
+	if len(conf.KeyFile) == 0 && len(conf.RawTxs) == 0 {
+		return nil, errors.New("canaries need a key_file or raw_txs")
+	}
+	c := &canaryTracker{interval: interval, timeout: timeout}
+	for i, tx := range conf.RawTxs {
+		raw, err := hexutil.Decode(tx)
+		if err != nil {
+			return nil, fmt.Errorf("invalid canary raw tx %d: %v", i, err)
+		}
+		c.raw = append(c.raw, raw)
+	}
+	if len(conf.KeyFile) > 0 {
+		if c.key, err = crypto.LoadECDSA(conf.KeyFile); err != nil {
+			return nil, fmt.Errorf("invalid canary key: %v", err)
+		}
+		c.from = crypto.PubkeyToAddress(c.key.PublicKey)
+	}
+	if conf.ChainID > 0 {
+		c.chainID = new(big.Int).SetUint64(conf.ChainID)
+	}
+	return c, nil
+}
+
+// transaction returns the next transaction to send through the node, or nil
+// if there's nothing left to send
+func (c *canaryTracker) transaction(node canaryNode) ([]byte, error) {
+	if len(c.raw) > 0 {
+		raw := c.raw[0]
+		c.raw = c.raw[1:]
+		return raw, nil
+	}
+	if c.key == nil {
+		return nil, nil
+	}
+	params, err := node.TxParams(c.from)
+	if err != nil {
+		return nil, err
+	}
+	price := params.GasPrice
+	if c.price != nil && params.Nonce == c.nonce {
+		// Replacing the previous canary, which needs a higher price
+		if bumped := new(big.Int).Div(new(big.Int).Mul(c.price, big.NewInt(125)), big.NewInt(100)); bumped.Cmp(price) > 0 {
+			price = bumped
+		}
+	}
+	chainID := c.chainID
+	if chainID == nil {
+		chainID = params.ChainID
+	}
+	tx := types.NewTransaction(params.Nonce, c.from, new(big.Int), 21000, price, nil)
+	signed, err := types.SignTx(tx, types.NewEIP155Signer(chainID), c.key)
+	if err != nil {
+		return nil, err
+	}
+	c.nonce, c.price = params.Nonce, price
+	return rlp.EncodeToBytes(signed)
+}
+
+// send sends a new canary if the interval passed and none is in flight. It
+// returns the canary, or nil if none was sent.
+func (c *canaryTracker) send(nodes []canaryNode, now time.Time) *canaryJson {
+	if c.current != nil || len(nodes) == 0 || now.Sub(c.last) < c.interval {
+		return nil
+	}
+	c.last = now
+	node := nodes[c.turn%len(nodes)]
+	c.turn++
+	cj := &canaryJson{Node: node.Name(), Sent: now, Seen: make(map[string]float64)}
+	raw, err := c.transaction(node)
+	if err != nil {
+		cj.Error = err.Error()
+		c.finish(cj)
+		return cj
+	}
+	if raw == nil {
+		return nil
+	}
+	cj.Hash = crypto.Keccak256Hash(raw)
+	start := time.Now()
+	if err := node.SendRawTransaction(raw); err != nil {
+		cj.Error = err.Error()
+		c.finish(cj)
+		return cj
+	}
+	cj.Accepted = time.Since(start).Seconds()
+	cj.Seen[node.Name()] = cj.Accepted
+	c.current = cj
+	return cj
+}
+
+// follow checks which nodes know the canary in flight, and whether it's been
+// included. It returns the canary if it's been included or timed out.
+func (c *canaryTracker) follow(nodes []canaryNode, now time.Time) *canaryJson {
+	cj := c.current
+	if cj == nil {
+		return nil
+	}
+	elapsed := now.Sub(cj.Sent).Seconds()
+	for _, node := range nodes {
+		known, block, err := node.TransactionBlock(cj.Hash)
+		if err != nil {
+			log.Debug("Failed to look up canary", "node", node.Name(), "hash", cj.Hash, "error", err)
+			continue
+		}
+		if !known {
+			continue
+		}
+		if _, ok := cj.Seen[node.Name()]; !ok {
+			cj.Seen[node.Name()] = elapsed
+		}
+		if block > 0 && cj.Block == 0 {
+			cj.Block, cj.Included = block, elapsed
+		}
+	}
+	if cj.Block == 0 && now.Sub(cj.Sent) < c.timeout {
+		return nil
+	}
+	cj.Stuck = cj.Block == 0
+	c.finish(cj)
+	return cj
+}
+
+func (c *canaryTracker) finish(cj *canaryJson) {
+	c.current = nil
+	c.recent = append(c.recent, cj)
+	if len(c.recent) > canaryHistory {
+		c.recent = c.recent[len(c.recent)-canaryHistory:]
+	}
+}
+
+// report returns the recent canaries, newest first, including the one in
+// flight
+func (c *canaryTracker) report() []*canaryJson {
+	var list []*canaryJson
+	if c.current != nil {
+		list = append(list, c.copy(c.current))
+	}
+	for i := len(c.recent) - 1; i >= 0; i-- {
+		list = append(list, c.copy(c.recent[i]))
+	}
+	return list
+}
+
+func (c *canaryTracker) copy(cj *canaryJson) *canaryJson {
+	cpy := *cj
+	cpy.Seen = make(map[string]float64)
+	for k, v := range cj.Seen {
+		cpy.Seen[k] = v
+	}
+	return &cpy
+}
+
+// checkCanary follows the canary in flight and sends the next one, reporting
+// the canaries which are rejected or not included in time
+func (mon *NodeMonitor) checkCanary(nodes []Node) {
+	c := mon.canary
+	if c == nil {
+		return
+	}
+	var senders []canaryNode
+	for _, n := range nodes {
+		if node, ok := n.(canaryNode); ok && node.Status() == NodeStatusOK {
+			senders = append(senders, node)
+		}
+	}
+	now := time.Now()
+	if cj := c.follow(senders, now); cj != nil && cj.Stuck {
+		mon.emit(EventCanaryStuck, SeverityWarning, []string{cj.Node},
+			"Canary transaction %x not included within %v", cj.Hash, c.timeout)
+	}
+	if cj := c.send(senders, now); cj != nil && len(cj.Error) > 0 {
+		mon.emit(EventCanaryRejected, SeverityWarning, []string{cj.Node},
+			"Failed to send canary transaction: %v", cj.Error)
+	}
+}
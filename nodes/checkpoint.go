@@ -0,0 +1,123 @@
+package nodes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// checkpointJson is the latest finalized checkpoint agreed on by a quorum of
+// the beacon nodes
+type checkpointJson struct {
+	checkpoint
+	// Nodes are the beacon nodes which finalized the checkpoint, or a later
+	// one descending from it
+	Nodes   []string  `json:"nodes"`
+	Updated time.Time `json:"updated"`
+}
+
+// checkpointQuorum returns how many beacon nodes must agree on a checkpoint
+func (mon *NodeMonitor) checkpointQuorum() int {
+	if q := mon.beaconConf.CheckpointQuorum; q > 0 {
+		return q
+	}
+	return len(mon.beacons)/2 + 1
+}
+
+// supports checks whether the node finalized the checkpoint. A node which
+// finalized a later checkpoint supports it if it confirms the checkpoint
+// block as canonical.
+func supports(node *BeaconNode, cp checkpoint) bool {
+	fin := node.finality.Finalized
+	switch {
+	case fin.Epoch == cp.Epoch:
+		return fin.Root == cp.Root
+	case fin.Epoch < cp.Epoch:
+		return false
+	}
+	h, err := node.header(cp.Root.Hex())
+	if err != nil {
+		log.Debug("Failed to confirm checkpoint", "node", node.name, "epoch", cp.Epoch, "error", err)
+		return false
+	}
+	return h.Canonical
+}
+
+// updateCheckpoint picks the latest finalized checkpoint supported by a
+// quorum of the active beacon nodes. Optimistic nodes aren't counted, since
+// their view isn't verified. Without a quorum, no checkpoint is served.
+func (mon *NodeMonitor) updateCheckpoint(active []*BeaconNode) {
+	var (
+		need       = mon.checkpointQuorum()
+		candidates []checkpoint
+		seen       = make(map[checkpoint]bool)
+		verified   []*BeaconNode
+	)
+	for _, node := range active {
+		if node.optimistic {
+			continue
+		}
+		verified = append(verified, node)
+		if cp := node.finality.Finalized; !seen[cp] {
+			seen[cp] = true
+			candidates = append(candidates, cp)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Epoch > candidates[j].Epoch
+	})
+	var agreed *checkpointJson
+	for _, cp := range candidates {
+		var nodes []string
+		for _, node := range verified {
+			if supports(node, cp) {
+				nodes = append(nodes, node.name)
+			}
+		}
+		if len(nodes) >= need {
+			agreed = &checkpointJson{checkpoint: cp, Nodes: nodes, Updated: time.Now()}
+			break
+		}
+	}
+	if agreed == nil {
+		log.Warn("No finalized checkpoint agreed on by a quorum", "needed", need, "verified", len(verified))
+	} else {
+		metrics.GetOrRegisterGauge("beacon/checkpoint/epoch", mon.registry).Update(int64(agreed.Epoch))
+	}
+	mon.mu.Lock()
+	mon.checkpoint = agreed
+	mon.mu.Unlock()
+}
+
+// HandleCheckpoint serves the latest finalized checkpoint agreed on by a
+// quorum of the beacon nodes. With ?format=ws, it's served as root:epoch,
+// the format of the weak subjectivity checkpoint flags of the clients.
+func (mon *NodeMonitor) HandleCheckpoint(w http.ResponseWriter, r *http.Request) {
+	if !mon.beaconConf.Checkpoints {
+		http.Error(w, "checkpoint provider not enabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	mon.mu.Lock()
+	cp := mon.checkpoint
+	mon.mu.Unlock()
+	if cp == nil {
+		http.Error(w, "no finalized checkpoint agreed on by a quorum", http.StatusServiceUnavailable)
+		return
+	}
+	if r.URL.Query().Get("format") == "ws" {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "%s:%d\n", cp.Root.Hex(), cp.Epoch)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": cp})
+}
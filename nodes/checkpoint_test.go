@@ -0,0 +1,62 @@
+package nodes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestCheckpointProvider(t *testing.T) {
+	finalized := checkpoint{Epoch: 10, Root: common.Hash{0x10}}
+	justified := checkpoint{Epoch: 11, Root: common.Hash{0x11}}
+
+	_, a, closeA := newFakeBeacon(t, 360, finalized, justified)
+	defer closeA()
+	_, b, closeB := newFakeBeacon(t, 360, finalized, justified)
+	defer closeB()
+	_, c, closeC := newFakeBeacon(t, 360, checkpoint{Epoch: 10, Root: common.Hash{0xff}}, justified)
+	defer closeC()
+	// A node which finalized further only counts if it confirms the block
+	later, d, closeD := newFakeBeacon(t, 400, checkpoint{Epoch: 12, Root: common.Hash{0x12}}, justified)
+	defer closeD()
+
+	mon, err := NewMonitor(nil, []*BeaconNode{a, b, c, d}, nil,
+		&Config{ReloadInterval: "1s", Beacon: beaconConfig{Checkpoints: true, CheckpointQuorum: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(mon.HandleCheckpoint))
+	defer srv.Close()
+	get := func(query string) (int, string) {
+		res, err := http.Get(srv.URL + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+	if status, _ := get(""); status != http.StatusServiceUnavailable {
+		t.Fatalf("checkpoint served without a quorum: %d", status)
+	}
+
+	later.responses["/eth/v1/beacon/headers/"+finalized.Root.Hex()] = map[string]interface{}{"root": finalized.Root, "canonical": true}
+	mon.doChecks()
+	status, body := get("")
+	var res struct {
+		Data checkpointJson `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(body), &res); err != nil || status != http.StatusOK {
+		t.Fatalf("bad response %d: %v %v", status, body, err)
+	}
+	if res.Data.checkpoint != finalized || len(res.Data.Nodes) != 3 {
+		t.Errorf("wrong checkpoint: %+v", res.Data)
+	}
+	if _, body := get("?format=ws"); body != finalized.Root.Hex()+":10\n" {
+		t.Errorf("wrong ws checkpoint: %q", body)
+	}
+}
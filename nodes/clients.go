@@ -0,0 +1,46 @@
+package nodes
+
+import "fmt"
+
+// NewClient creates the node described by the client config. Depending on the
+// kind, either an execution node or a beacon node is returned. The metrics of
+// the node go to the registry of its network, as the nodes of different
+// networks may have the same names.
+func NewClient(c *ClientInfo, conf *Config, db *blockDB) (Node, *BeaconNode, error) {
+	client, err := c.HTTPClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	reg := conf.metricsRegistry()
+	switch c.Kind {
+	case "infura":
+		node, err := NewInfuraNode(c.Name, conf.InfuraKey, conf.InfuraEndpoint, db, c.Ratelimit, client, reg)
+		return node, nil, err
+	case "alchemy":
+		node, err := NewAlchemyNode(c.Name, conf.AlchemyKey, conf.AlchemyEndpoint, db, c.Ratelimit, client, reg)
+		return node, nil, err
+	case "rpc":
+		if len(c.IPC) > 0 {
+			node, err := NewIPCNode(c.Name, c.IPC, db, c.Ratelimit, reg)
+			return node, nil, err
+		}
+		node, err := NewRPCNode(c.Name, c.Url, db, c.Ratelimit, client, reg)
+		return node, nil, err
+	case "beacon":
+		beacon, err := NewBeaconNode(c.Name, c.Url, c.Ratelimit, client)
+		return nil, beacon, err
+	case "agent":
+		maxAge, err := conf.Collector.maxAge()
+		if err != nil {
+			return nil, nil, err
+		}
+		return newAgentNode(c.Name, c.Agent, maxAge, reg), nil, nil
+	case "ethstats":
+		maxAge, historyTimeout, err := conf.Ethstats.durations()
+		if err != nil {
+			return nil, nil, err
+		}
+		return newEthstatsNode(c.Name, maxAge, historyTimeout, reg), nil, nil
+	}
+	return nil, nil, fmt.Errorf("wrong client type %q, available: [rpc, infura, alchemy, beacon, agent, ethstats]", c.Kind)
+}
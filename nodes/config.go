@@ -1,33 +1,527 @@
 package nodes
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
 )
 
 type Config struct {
+	// ReloadInterval is the interval between check cycles. Cycles are also
+	// triggered by new heads, so this is a fallback if heads are pushed or
+	// polled. "auto" sets it to a third of the block time.
 	ReloadInterval string
 	ServerAddress  string
 	Clients        []ClientInfo
-	Metrics metricsConfig
+	Metrics        metricsConfig
+	// Identity names the monitor in the reports and their signatures, e.g.
+	// the domain of the operator
+	Identity string
+	// Network is the name of the monitored network, e.g. mainnet
+	Network string
+	// HeadPollInterval is how often the head numbers of nodes that can't push
+	// new heads are polled, to check right away when they change. Disabled if
+	// empty, "auto" sets it to a third of the block time.
+	HeadPollInterval string
+	// Influx configures pushing per-cycle data to an InfluxDB v2 instance
+	Influx influxConfig
+	// RemoteWrite configures pushing per-cycle samples via the Prometheus
+	// remote write protocol
+	RemoteWrite remoteWriteConfig
+	// Statsd configures sending per-cycle metrics to a statsd agent
+	Statsd statsdConfig
+	// Kafka configures publishing monitoring events to a Kafka topic
+	Kafka kafkaConfig
+	// Nats configures publishing monitoring events to NATS subjects
+	Nats natsConfig
+	// Mqtt configures publishing monitoring events to MQTT topics
+	Mqtt mqttConfig
+	// Bucket configures uploading the reports, split artifacts and digests
+	// to S3 or GCS
+	Bucket bucketConfig
+	// IPFS configures pinning the reports and split artifacts to IPFS
+	IPFS ipfsConfig
+	// Signing configures signing the reports and API responses
+	Signing signingConfig
+	// API configures authenticating the requests to the dashboard and API
+	API apiAuthConfig
+	// HTTP configures serving the dashboard and API behind reverse proxies
+	// and to other origins
+	HTTP httpConfig
+	// Pprof serves the profiling handlers of net/http/pprof under
+	// /debug/pprof/, for debugging the monitor itself
+	Pprof bool
+	// Tracing configures exporting traces of the check cycles via OTLP
+	Tracing tracingConfig
+	Logging loggingConfig
+	// Beacon configures the checks on beacon nodes
+	Beacon beaconConfig
+	// Stall configures alerting on heads which stop advancing
+	Stall stallConfig
+	// Alerts configures the deduplication of alerts
+	Alerts alertsConfig
+	// Digest configures the periodic summaries of the check cycles
+	Digest digestConfig
+	// Email configures sending alerts and digests via SMTP
+	Email emailConfig
+	// Telegram configures sending alerts to a Telegram chat
+	Telegram telegramConfig
+	// Discord configures posting alerts to a Discord webhook
+	Discord discordConfig
+	// Matrix configures sending alerts to a Matrix room
+	Matrix matrixConfig
+	// Grafana configures pushing annotations about splits to Grafana
+	Grafana grafanaConfig
+	// Canary configures sending transactions through the nodes, to check
+	// that they're accepted, propagated and included
+	Canary canaryConfig
+	// Scrape configures picking series from the metrics of the nodes
+	Scrape scrapeConfig
+	// Txpool configures monitoring the txpool sizes of the nodes
+	Txpool txpoolConfig
+	// AutoPeer configures connecting diverged nodes to each other
+	AutoPeer autoPeerConfig
+	// HealthScore configures combining the status, lag, latency, peer count
+	// and sync state of the nodes into a single score
+	HealthScore healthScoreConfig
+	// Rollup configures checking OP-Stack rollup nodes
+	Rollup rollupConfig
+	// Federation configures pushing the reports to a central monitor, or
+	// merging those pushed by remote monitors
+	Federation federationConfig
+	// Agent configures pushing the observations of the nodes to a central
+	// monitor, when run with the agent command
+	Agent agentConfig
+	// Collector configures the agents which push the observations of the
+	// nodes of kind "agent"
+	Collector collectorConfig
+	// Leader configures active/passive mode, in which several instances
+	// monitor the same nodes and only the elected leader writes the reports
+	// and sends alerts
+	Leader leaderConfig
+	// Ethstats configures accepting the ethstats reports of the nodes of
+	// kind "ethstats"
+	Ethstats ethstatsConfig
+	// RPCProxy configures serving blocks agreed on by a quorum of the nodes
+	// as a JSON-RPC endpoint
+	RPCProxy rpcProxyConfig
+	// Relays are MEV-boost relays whose health is reported along with the nodes
+	Relays []relayConfig
+	// If set, every new report is archived in the block database, and can be
+	// retrieved later via the /api/reports endpoint
+	ArchiveReports bool
+	// If set, the head of every node is recorded in the block database each
+	// cycle, and can be retrieved via the /api/heads endpoint
+	RecordHeads bool
+	// HistoryRetention is how long the recorded heads, and the blocks in the
+	// height index, are kept. Defaults to 720h (30 days).
+	HistoryRetention string
+	// ReportFormats are the formats (json, csv or html) the report is written
+	// in, to www/. The json report is always written.
+	ReportFormats []string
+	// AdminToken is the bearer token required by the node management API. If
+	// empty, the API is disabled.
+	AdminToken string
+	// SplitGroupLabel is the node label by which splits are aggregated, so
+	// the report can tell when one group of nodes diverged from another.
+	// Defaults to "client".
+	SplitGroupLabel string
+	// MaxSplitDepth bounds the search for the first diverging block to this
+	// many blocks below the compared head. Deeper splits are reported as
+	// such, without searching further. Zero searches all the way to genesis.
+	MaxSplitDepth uint64
+	// CompareWorkers is the number of node pairs compared concurrently.
+	// Defaults to 8.
+	CompareWorkers int
+	// UncleWindow is the number of recent blocks over which the uncle rate is
+	// tracked. Defaults to 64.
+	UncleWindow uint64
+	// MaxUncleRate is the share (0-1) of blocks with uncles within the window
+	// above which an alert is raised. Zero disables tracking the uncle rate.
+	MaxUncleRate float64
+	// StateChecks are reads of the state which are compared across the nodes
+	// agreeing on the block at their lowest head
+	StateChecks []stateProbeConfig
+	// LogChecks are eth_getLogs queries whose results are compared across the
+	// nodes on the same chain
+	LogChecks []logQueryConfig
+	// BadBlockInterval is how often the nodes are asked for the blocks they
+	// rejected as invalid, via debug_getBadBlocks. Disabled if empty.
+	BadBlockInterval string
+	// PeerOverlap enables collecting the peers of the nodes each cycle, via
+	// admin_peers, to compare them and to tell whether splits follow
+	// disjoint peer sets
+	PeerOverlap bool
+	// SplitTracer is the tracer, e.g. "callTracer", with which the first
+	// diverging block of a split is traced on both sides, via
+	// debug_traceBlockByHash. Disabled if empty.
+	SplitTracer string
+	// RawBlocks enables storing the raw blocks at and around split points in
+	// www/hashes/, as RLP via debug_getRawBlock, or as SSZ for beacon blocks
+	RawBlocks bool
 
-	InfuraKey string
+	InfuraKey      string
 	InfuraEndpoint string
 
-	AlchemyKey string
+	AlchemyKey      string
 	AlchemyEndpoint string
+
+	// Networks are further sets of nodes, e.g. of testnets, monitored by the
+	// same process. Each is configured like the top level and named by its
+	// Network, and gets its own check loop, block database, report directory
+	// and metrics. The server, metrics and logging settings of the top level
+	// apply to all.
+	Networks []Config
+
+	// namespace is the name of the network, if it's one of the Networks
+	namespace string
 }
 
-type metricsConfig struct{
-	Enabled bool
-	Endpoint string
-	Username string
-	Database string
-	Password string
+type metricsConfig struct {
+	Enabled   bool
+	Endpoint  string
+	Username  string
+	Database  string
+	Password  string
 	Namespace string
 }
 
+type influxConfig struct {
+	Url         string
+	Token       string
+	Org         string
+	Bucket      string
+	Measurement string
+}
+
+type tracingConfig struct {
+	// Endpoint is the OTLP/http endpoint of the collector, e.g. http://localhost:4318
+	Endpoint    string
+	ServiceName string
+}
+
+type loggingConfig struct {
+	Level  string // trace, debug, info, warn, error or crit
+	Format string // terminal, json or logfmt
+	File   string // if set, logs go to this file instead of stderr
+}
+
+type alertsConfig struct {
+	// Cooldown is how long an ongoing event is suppressed after alerting on
+	// it. Defaults to 30m.
+	Cooldown string
+	// Cooldowns overrides the cooldown per event kind, e.g. split = "5m"
+	Cooldowns map[string]string
+	// Maintenance windows, during which no alerts are sent for a node (or
+	// for all nodes, if no node is given)
+	Maintenance []muteWindow
+}
+
+type beaconConfig struct {
+	// MaxSlotLag is the number of slots a head may lag behind the wall clock
+	// before it's reported. Zero disables the check.
+	MaxSlotLag uint64
+	// MissedSlotWindow is the number of recent slots over which the missed
+	// slot rate is tracked. Defaults to 64.
+	MissedSlotWindow uint64
+	// MinParticipation is the participation rate (0-1) below which an alert
+	// is raised. Zero disables the check.
+	MinParticipation float64
+	// MaxParticipationSpread is how much (0-1) nodes may disagree about the
+	// participation rate. Zero disables the check.
+	MaxParticipationSpread float64
+	// Validators is a list of validator indices or pubkeys, whose attestation
+	// and proposal duties are checked each epoch
+	Validators []string
+	// BalanceWindow is the number of epochs over which a validator balance
+	// decrease is alerted on. Defaults to 8.
+	BalanceWindow uint64
+	// MaxSyncMisses is the number of consecutive sync committee contributions
+	// a monitored validator may miss before it's reported. Defaults to 4.
+	MaxSyncMisses uint64
+	// MaxPairDrift is the number of blocks a paired execution node may be
+	// apart from its beacon node. Defaults to 2.
+	MaxPairDrift uint64
+	// ForkChoice enables comparing the heads by their fork choice weights,
+	// which needs the debug API of the beacon nodes
+	ForkChoice bool
+	// Checkpoints enables serving the latest finalized checkpoint agreed on
+	// by a quorum of the beacon nodes on /api/checkpoint
+	Checkpoints bool
+	// CheckpointQuorum is how many beacon nodes must agree on the checkpoint.
+	// Defaults to a majority of the beacon nodes.
+	CheckpointQuorum int
+	// BlobUpdateFraction overrides the blob base fee update fraction, which
+	// otherwise follows the fork of the blocks. Needed on blob-parameter-only
+	// forks, which change it without a new block version.
+	BlobUpdateFraction uint64
+}
+
 type ClientInfo struct {
-	Url  string
-	Name string
-	Kind string
+	Url string
+	// IPC is the path to the IPC socket of a local 'rpc' node, used instead
+	// of the url
+	IPC       string
+	Name      string
+	Kind      string
 	Ratelimit int
+	// JWTSecret is the hex-encoded secret (or path to a file containing it)
+	// for nodes which require JWT authentication, such as the engine API port
+	JWTSecret string
+	// Username and Password are sent as basic auth, and BearerToken as a
+	// bearer token, e.g. for nodes behind an authenticating reverse proxy
+	Username    string
+	Password    string
+	BearerToken string
+	// Headers are extra http headers sent with every request to the node
+	Headers map[string]string
+	// CAFile is a PEM bundle of the CAs to trust instead of the system ones.
+	// CertFile and KeyFile are the client certificate for mutual TLS.
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify disables verification of the node's certificate
+	InsecureSkipVerify bool
+	// Proxy is an http(s) or socks5 proxy url, e.g. socks5://127.0.0.1:9050
+	// for Tor. Hostnames are resolved by socks5 proxies.
+	Proxy string
+	// Labels are arbitrary key/value pairs, such as client=geth or
+	// region=eu, attached to the node in reports and exported metrics
+	Labels map[string]string
+	// Reference marks a trusted node. If any are configured, the other nodes
+	// are classified as agreeing with or diverging from the reference chain.
+	Reference bool
+	// Pair is the name of the execution client driven by this beacon node
+	Pair string
+	// Interval overrides the reload interval for an execution node, e.g. to
+	// spare a rate-limited endpoint. Its head is only refreshed once the
+	// interval has passed, and reused by the cycles in between.
+	Interval string
+	// Skip disables checks for the node: "split_search" to not locate the
+	// block where it diverged, "tagged" to not cross-check its safe and
+	// finalized blocks
+	Skip []string
+	// Archive marks a node with the full chain history. Archive nodes stand
+	// in for pruned ones when a split lies below their retained blocks.
+	Archive bool
+	// HistoryBlocks is the number of recent blocks a pruned node retains.
+	// Older blocks aren't requested from it.
+	HistoryBlocks uint64
+	// MetricsUrl is the Prometheus metrics endpoint of the node, which the
+	// series configured under Scrape are picked from
+	MetricsUrl string
+	// MetricsSeries adds to or overrides the scraped series for the node,
+	// as their names differ between clients
+	MetricsSeries map[string]string
+	// Agent is the name of the agent pushing the observations of a node of
+	// kind "agent"
+	Agent string
+}
+
+// Validate checks the config for errors which would otherwise only surface
+// when starting the monitor, without contacting any nodes.
+func (c *Config) Validate() error {
+	if err := c.validateNetworks(); err != nil {
+		return err
+	}
+	if len(c.Clients) == 0 && len(c.Networks) > 0 {
+		// Only the networks are monitored
+		return nil
+	}
+	if _, _, err := parseInterval(c.ReloadInterval); err != nil {
+		return fmt.Errorf("invalid reload_interval: %v", err)
+	}
+	if _, _, err := parseInterval(c.HeadPollInterval); err != nil {
+		return fmt.Errorf("invalid head_poll_interval: %v", err)
+	}
+	if _, err := parseRetention(c.HistoryRetention); err != nil {
+		return fmt.Errorf("invalid history_retention: %v", err)
+	}
+	if len(c.Clients) == 0 {
+		return errors.New("no clients configured")
+	}
+	names := make(map[string]string)
+	for _, client := range c.Clients {
+		if len(client.Name) == 0 {
+			return errors.New("client without name")
+		}
+		if _, ok := names[client.Name]; ok {
+			return fmt.Errorf("duplicate client name %v", client.Name)
+		}
+		names[client.Name] = client.Kind
+		switch client.Kind {
+		case "rpc", "beacon":
+			if len(client.Url) == 0 && (client.Kind != "rpc" || len(client.IPC) == 0) {
+				return fmt.Errorf("missing url for client %v", client.Name)
+			}
+		case "infura", "alchemy":
+		case "agent":
+			if !c.hasAgent(client.Agent) {
+				return fmt.Errorf("client %v: agent %q is not configured under collector", client.Name, client.Agent)
+			}
+		case "ethstats":
+			if len(c.Ethstats.Secret) == 0 {
+				return fmt.Errorf("client %v: no ethstats secret configured", client.Name)
+			}
+		default:
+			return fmt.Errorf("wrong client type %q for %v, available: [rpc, infura, alchemy, beacon, agent, ethstats]", client.Kind, client.Name)
+		}
+		if len(client.Agent) > 0 && client.Kind != "agent" {
+			return fmt.Errorf("client %v: only agent nodes have an agent", client.Name)
+		}
+		if len(client.JWTSecret) > 0 {
+			if _, err := loadJWTSecret(client.JWTSecret); err != nil {
+				return fmt.Errorf("client %v: %v", client.Name, err)
+			}
+		}
+		if len(client.IPC) > 0 {
+			if client.Kind != "rpc" {
+				return fmt.Errorf("client %v: only rpc nodes can use ipc", client.Name)
+			}
+			if len(client.Url) > 0 {
+				return fmt.Errorf("client %v: both url and ipc given", client.Name)
+			}
+		}
+		if _, err := client.tlsConfig(); err != nil {
+			return fmt.Errorf("client %v: %v", client.Name, err)
+		}
+		if _, err := client.proxyURL(); err != nil {
+			return fmt.Errorf("client %v: %v", client.Name, err)
+		}
+		if opts, err := client.options(); err != nil {
+			return fmt.Errorf("client %v: %v", client.Name, err)
+		} else if opts != nil && client.Kind == "beacon" {
+			return fmt.Errorf("client %v: interval, skip, archive and history_blocks are only supported for execution nodes", client.Name)
+		}
+		if isWebsocket(client.Url) {
+			if hc, _ := client.HTTPClient(); hc != nil {
+				return fmt.Errorf("client %v: auth, headers, tls and proxy options are not supported for websocket endpoints", client.Name)
+			}
+		}
+		auths := 0
+		for _, set := range []bool{len(client.JWTSecret) > 0, len(client.BearerToken) > 0,
+			len(client.Username) > 0 || len(client.Password) > 0} {
+			if set {
+				auths++
+			}
+		}
+		if auths > 1 {
+			return fmt.Errorf("client %v: only one of jwt_secret, bearer_token and username/password can be used", client.Name)
+		}
+	}
+	for _, client := range c.Clients {
+		if len(client.Pair) == 0 {
+			continue
+		}
+		if client.Kind != "beacon" {
+			return fmt.Errorf("client %v: only beacon nodes can be paired", client.Name)
+		}
+		if kind, ok := names[client.Pair]; !ok || kind == "beacon" {
+			return fmt.Errorf("client %v: pair %v is not an execution client", client.Name, client.Pair)
+		}
+	}
+	if _, err := newAlertManager(c.Alerts); err != nil {
+		return err
+	}
+	if _, err := newHeadTracker(c.Stall); err != nil {
+		return err
+	}
+	if _, err := newStateProbes(c.StateChecks); err != nil {
+		return err
+	}
+	if _, err := newLogQueries(c.LogChecks); err != nil {
+		return err
+	}
+	if _, err := newBadBlockCollector(c.BadBlockInterval); err != nil {
+		return err
+	}
+	if _, err := newCanaryTracker(c.Canary); err != nil {
+		return err
+	}
+	if _, err := newTxpoolTracker(c.Txpool); err != nil {
+		return err
+	}
+	if _, err := newAutoPeerer(c.AutoPeer); err != nil {
+		return err
+	}
+	if _, err := newMetricsScraper(c.Scrape, c.Clients); err != nil {
+		return err
+	}
+	if _, err := newHealthScorer(c.HealthScore); err != nil {
+		return err
+	}
+	if err := c.Rollup.validate(); err != nil {
+		return err
+	}
+	if _, err := newFederationPusher(c.Federation); err != nil {
+		return err
+	}
+	if _, err := newFederation(c.Federation, c.Identity); err != nil {
+		return err
+	}
+	if _, err := newCollector(c.Collector); err != nil {
+		return err
+	}
+	if _, err := newLeaderElector(c.Leader, c.Identity); err != nil {
+		return err
+	}
+	if _, err := newEthstatsServer(c.Ethstats); err != nil {
+		return err
+	}
+	if _, err := newRPCProxy(c.RPCProxy, 0); err != nil {
+		return err
+	}
+	if _, err := NewAPIAuth(c.API); err != nil {
+		return err
+	}
+	if _, err := NewHTTPHandler(c.HTTP, nil); err != nil {
+		return err
+	}
+	if c.Beacon.CheckpointQuorum < 0 {
+		return fmt.Errorf("invalid checkpoint quorum %d", c.Beacon.CheckpointQuorum)
+	}
+	if len(c.Agent.Upstream) > 0 {
+		if _, err := url.ParseRequestURI(c.Agent.Upstream); err != nil {
+			return fmt.Errorf("invalid agent upstream: %v", err)
+		}
+		if len(c.Agent.Token) == 0 {
+			return errors.New("missing agent token")
+		}
+	}
+	if _, err := newDigester(c.Digest); err != nil {
+		return err
+	}
+	if _, err := newEmailNotifier(c.Email); err != nil {
+		return err
+	}
+	if _, err := newTelegramNotifier(c.Telegram); err != nil {
+		return err
+	}
+	if _, err := newMatrixNotifier(c.Matrix); err != nil {
+		return err
+	}
+	if _, err := newGrafanaAnnotator(c.Grafana); err != nil {
+		return err
+	}
+	if _, err := newPublishers(c); err != nil {
+		return err
+	}
+	if _, err := newBucketArchiver(c.Bucket); err != nil {
+		return err
+	}
+	// Missing keys are generated on startup, not while validating
+	if _, err := os.Stat(c.Signing.KeyFile); err == nil {
+		if _, err := newReportSigner(c.Signing, c.Identity); err != nil {
+			return err
+		}
+	}
+	if len(c.Statsd.Address) > 0 {
+		// Not dialed here, to not leave a socket behind
+		if _, err := net.ResolveUDPAddr("udp", c.Statsd.Address); err != nil {
+			return fmt.Errorf("invalid statsd address: %v", err)
+		}
+	}
+	return checkReportFormats(c.ReportFormats)
 }
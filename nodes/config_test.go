@@ -0,0 +1,112 @@
+package nodes
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+func TestConfigValidate(t *testing.T) {
+	valid := func() *Config {
+		return &Config{
+			ReloadInterval: "10s",
+			Clients: []ClientInfo{
+				{Name: "geth", Kind: "rpc", Url: "http://localhost:8545"},
+				{Name: "lighthouse", Kind: "beacon", Url: "http://localhost:5052", Pair: "geth"},
+			},
+		}
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("valid config rejected: %v", err)
+	}
+	ipc := valid()
+	ipc.Clients[0].Url, ipc.Clients[0].IPC = "", "/tmp/geth.ipc"
+	if err := ipc.Validate(); err != nil {
+		t.Fatalf("ipc config rejected: %v", err)
+	}
+	tests := []struct {
+		modify func(c *Config)
+		want   string
+	}{
+		{func(c *Config) { c.ReloadInterval = "often" }, "reload_interval"},
+		{func(c *Config) { c.Clients = nil }, "no clients"},
+		{func(c *Config) { c.Clients[1].Name = "geth" }, "duplicate"},
+		{func(c *Config) { c.Clients[0].Kind = "parity" }, "wrong client type"},
+		{func(c *Config) { c.Clients[0].Url = "" }, "missing url"},
+		{func(c *Config) { c.Clients[0].IPC = "/tmp/geth.ipc" }, "both url and ipc"},
+		{func(c *Config) { c.Clients[1].IPC = "/tmp/geth.ipc" }, "only rpc nodes"},
+		{func(c *Config) { c.Clients[0].JWTSecret = "0x1234" }, "jwt secret"},
+		{func(c *Config) { c.Clients[0].BearerToken, c.Clients[0].Username = "token", "user" }, "only one of"},
+		{func(c *Config) { c.Clients[1].Pair = "lighthouse" }, "not an execution client"},
+		{func(c *Config) { c.Alerts.Cooldown = "1 hour" }, "1 hour"},
+		{func(c *Config) { c.Networks = []Config{{Network: "holesky/1"}} }, "invalid network name"},
+		{func(c *Config) { c.Networks = []Config{*valid(), *valid()} }, "invalid network name"},
+		{func(c *Config) {
+			c.Networks = []Config{*valid(), *valid()}
+			c.Networks[0].Network, c.Networks[1].Network = "holesky", "holesky"
+		}, "duplicate network"},
+		{func(c *Config) {
+			c.Networks = []Config{*valid()}
+			c.Networks[0].Network, c.Networks[0].Clients = "holesky", nil
+		}, "network holesky: no clients"},
+		{func(c *Config) {
+			c.Networks = []Config{*valid()}
+			c.Networks[0].Network, c.Networks[0].ServerAddress = "holesky", ":8080"
+		}, "only configured at the top level"},
+		{func(c *Config) {
+			c.Networks = []Config{*valid()}
+			c.Networks[0].Network, c.Networks[0].Networks = "holesky", []Config{*valid()}
+		}, "can't be nested"},
+	}
+	for i, test := range tests {
+		c := valid()
+		test.modify(c)
+		err := c.Validate()
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("test %d: have error %v, want %q", i, err, test.want)
+		}
+	}
+}
+
+func TestNetworkConfigs(t *testing.T) {
+	network := func(name string) Config {
+		return Config{
+			Network: name,
+			Clients: []ClientInfo{{Name: "geth", Kind: "rpc", Url: "http://localhost:8545"}},
+		}
+	}
+	c := &Config{Identity: "monitor", Networks: []Config{network("holesky"), network("devnet-1")}}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("networks without top level clients rejected: %v", err)
+	}
+	confs := c.NetworkConfigs()
+	if len(confs) != 2 || confs[0].Namespace() != "holesky" || confs[1].Namespace() != "devnet-1" {
+		t.Fatalf("wrong networks: %+v", confs)
+	}
+	if have := confs[0].DBPath("blockDB"); have != "blockDB-holesky" {
+		t.Errorf("wrong db path %v", have)
+	}
+	if have := confs[1].OutputDir(); have != filepath.Join("www", "networks", "devnet-1") {
+		t.Errorf("wrong output dir %v", have)
+	}
+	if confs[1].Identity != "monitor" {
+		t.Errorf("identity not inherited: %q", confs[1].Identity)
+	}
+	// The head gauges of the nodes are kept apart by network
+	metrics.Enabled = true
+	defer func() { metrics.Enabled = false }()
+	if _, _, err := NewClient(&ClientInfo{Name: "pushed", Kind: "agent", Agent: "eu"}, confs[1], nil); err != nil {
+		t.Fatal(err)
+	}
+	if registry.Get("devnet-1/head/pushed") == nil || registry.Get("head/pushed") != nil {
+		t.Errorf("head gauge not in the network registry")
+	}
+	// The top level nodes come first, and are kept apart from nothing
+	c.Clients = network("").Clients
+	confs = c.NetworkConfigs()
+	if len(confs) != 3 || confs[0] != c || c.DBPath("blockDB") != "blockDB" || c.OutputDir() != "www" {
+		t.Fatalf("wrong top level network: %+v", confs)
+	}
+}
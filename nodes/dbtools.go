@@ -0,0 +1,159 @@
+package nodes
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// keyKind classifies a database key by what's stored under it
+func keyKind(key []byte) string {
+	switch {
+	case bytes.HasPrefix(key, reportPrefix):
+		return "reports"
+	case bytes.HasPrefix(key, balancePrefix):
+		return "balances"
+	case bytes.HasPrefix(key, heightPrefix):
+		return "heights"
+	case bytes.HasPrefix(key, headPrefix):
+		// Checked before headers, since these may be as long as a hash
+		return "heads"
+	case len(key) == common.HashLength:
+		return "headers"
+	}
+	return "other"
+}
+
+// Stats returns the number of entries in the database, by kind
+func (db *blockDB) Stats() map[string]int {
+	stats := make(map[string]int)
+	it := db.db.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		stats[keyKind(it.Key())]++
+	}
+	return stats
+}
+
+// HeaderInfo is the summary of a stored header
+type HeaderInfo struct {
+	Hash   common.Hash
+	Number uint64
+	Parent common.Hash
+	Time   uint64
+}
+
+// Headers returns all stored headers, ordered by number
+func (db *blockDB) Headers() ([]HeaderInfo, error) {
+	it := db.db.NewIterator(nil, nil)
+	defer it.Release()
+	var headers []HeaderInfo
+	for it.Next() {
+		if keyKind(it.Key()) != "headers" {
+			continue
+		}
+		var h types.Header
+		if err := rlp.DecodeBytes(it.Value(), &h); err != nil {
+			return nil, fmt.Errorf("header %x: %v", it.Key(), err)
+		}
+		info := HeaderInfo{
+			Hash:   common.BytesToHash(it.Key()),
+			Parent: h.ParentHash,
+			Time:   h.Time,
+		}
+		if h.Number != nil {
+			info.Number = h.Number.Uint64()
+		}
+		headers = append(headers, info)
+	}
+	sort.SliceStable(headers, func(i, j int) bool {
+		return headers[i].Number < headers[j].Number
+	})
+	return headers, it.Error()
+}
+
+// dbEntry is a raw key/value pair, as exported. In the json format, headers
+// also carry the decoded header, for offline analysis.
+type dbEntry struct {
+	Key    hexutil.Bytes
+	Value  hexutil.Bytes
+	Header *types.Header `json:",omitempty" rlp:"-"`
+}
+
+// Export writes every entry in the database to w, either as json (one entry
+// per line) or as a stream of rlp-encoded entries.
+func (db *blockDB) Export(w io.Writer, format string) error {
+	if format != "json" && format != "rlp" {
+		return fmt.Errorf("unknown format %q, available: [json, rlp]", format)
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	it := db.db.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		entry := dbEntry{
+			Key:   common.CopyBytes(it.Key()),
+			Value: common.CopyBytes(it.Value()),
+		}
+		var err error
+		if format == "rlp" {
+			err = rlp.Encode(bw, &entry)
+		} else {
+			if keyKind(entry.Key) == "headers" {
+				entry.Header = new(types.Header)
+				if rlp.DecodeBytes(entry.Value, entry.Header) != nil {
+					entry.Header = nil
+				}
+			}
+			err = enc.Encode(&entry)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Import reads entries in the given format, as written by Export, and stores
+// them in the database. Existing entries with the same keys are overwritten.
+// It returns the number of imported entries.
+func (db *blockDB) Import(r io.Reader, format string) (int, error) {
+	var next func(*dbEntry) error
+	switch format {
+	case "json":
+		dec := json.NewDecoder(r)
+		next = func(e *dbEntry) error { return dec.Decode(e) }
+	case "rlp":
+		stream := rlp.NewStream(bufio.NewReader(r), 0)
+		next = func(e *dbEntry) error { return stream.Decode(e) }
+	default:
+		return 0, fmt.Errorf("unknown format %q, available: [json, rlp]", format)
+	}
+	count := 0
+	for {
+		var entry dbEntry
+		if err := next(&entry); err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, fmt.Errorf("entry %d: %v", count, err)
+		}
+		if len(entry.Key) == 0 {
+			return count, fmt.Errorf("entry %d: missing key", count)
+		}
+		if err := db.db.Put(entry.Key, entry.Value, nil); err != nil {
+			return count, err
+		}
+		count++
+	}
+}
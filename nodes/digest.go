@@ -0,0 +1,251 @@
+package nodes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+type digestConfig struct {
+	// Period is "daily" or "weekly". Digests are disabled if empty.
+	Period string
+	// Dir is where the digests are written to. Defaults to www/digests.
+	Dir string
+}
+
+// nodeDigestJson summarizes a single node over a digest period
+type nodeDigestJson struct {
+	Name   string
+	Cycles int // cycles during which the node was configured
+	Up     int // cycles during which the node was reachable
+	Uptime float64
+	// Reorgs is the number of times the node's head was replaced by a block
+	// at the same or a lower height
+	Reorgs int
+}
+
+// digestJson summarizes the check cycles of a day or a week
+type digestJson struct {
+	Period string
+	Start  time.Time
+	End    time.Time
+	Cycles int
+	Nodes  []*nodeDigestJson
+	// Splits is the number of times the nodes went from agreeing to split, and
+	// MaxSplitDepth the deepest split seen
+	Splits        int
+	MaxSplitDepth int64
+	Reorgs        int
+	// AvgBlockTime is the average time in seconds between blocks, as observed
+	// by the advance of the highest head
+	AvgBlockTime float64
+	// Events counts the events raised, by kind
+	Events map[string]int
+}
+
+// digestSender is implemented by the notifiers which deliver digests
+type digestSender interface {
+	SendDigest(dg *digestJson) error
+}
+
+type headSample struct {
+	num  int
+	hash string
+}
+
+// digester aggregates the reports of each check cycle into digests, which are
+// written out when the period is over.
+type digester struct {
+	period string
+	dir    string
+
+	current *digestJson
+	nodes   map[string]*nodeDigestJson
+	heads   map[string]headSample // last head of each node
+	split   bool                  // whether the last cycle had a split
+	// highest head at the first cycle of the period, and when the highest
+	// head of the period was first seen
+	firstHead, lastHead uint64
+	firstTime, lastTime time.Time
+	// copies are the stores which get a copy of the digests
+	copies artifactStores
+}
+
+func newDigester(conf digestConfig) (*digester, error) {
+	if len(conf.Period) == 0 {
+		return nil, nil
+	}
+	if conf.Period != "daily" && conf.Period != "weekly" {
+		return nil, fmt.Errorf("invalid digest period %q, available: [daily, weekly]", conf.Period)
+	}
+	dir := conf.Dir
+	if len(dir) == 0 {
+		dir = "www/digests"
+	}
+	return &digester{
+		period: conf.Period,
+		dir:    dir,
+		heads:  make(map[string]headSample),
+	}, nil
+}
+
+// periodStart returns the start of the period containing t. Days start at
+// midnight UTC, and weeks on mondays.
+func periodStart(t time.Time, period string) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if period == "weekly" {
+		offset := (int(day.Weekday()) + 6) % 7
+		day = day.AddDate(0, 0, -offset)
+	}
+	return day
+}
+
+func (d *digester) periodEnd(start time.Time) time.Time {
+	if d.period == "weekly" {
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// record adds the report of a check cycle. If it's the first one after the
+// end of the current period, the digest of that period is returned.
+func (d *digester) record(r *Report, now time.Time) *digestJson {
+	var done *digestJson
+	if d.current != nil && !now.Before(d.current.End) {
+		done = d.finish()
+	}
+	if d.current == nil {
+		start := periodStart(now.UTC(), d.period)
+		d.current = &digestJson{
+			Period: d.period,
+			Start:  start,
+			End:    d.periodEnd(start),
+			Events: make(map[string]int),
+		}
+		d.nodes = make(map[string]*nodeDigestJson)
+		d.firstTime = time.Time{}
+	}
+	dg := d.current
+	dg.Cycles++
+
+	node := func(name string, status int) *nodeDigestJson {
+		nd, ok := d.nodes[name]
+		if !ok {
+			nd = &nodeDigestJson{Name: name}
+			d.nodes[name] = nd
+		}
+		nd.Cycles++
+		if status == NodeStatusOK {
+			nd.Up++
+		}
+		return nd
+	}
+	var highest uint64
+	for i, c := range r.Cols {
+		nd := node(c.Name, c.Status)
+		if c.Status != NodeStatusOK {
+			continue
+		}
+		if c.Head > highest {
+			highest = c.Head
+		}
+		// The report has a row for the head of every reachable node
+		var hash string
+		if row := r.Rows[int(c.Head)]; i < len(row) {
+			hash = row[i]
+		}
+		cur := headSample{int(c.Head), hash}
+		if prev, ok := d.heads[c.Name]; ok && len(hash) > 0 {
+			if cur.num < prev.num || (cur.num == prev.num && cur.hash != prev.hash) {
+				nd.Reorgs++
+				dg.Reorgs++
+			}
+		}
+		d.heads[c.Name] = cur
+	}
+	for _, b := range r.Beacons {
+		node(b.Name, b.Status)
+	}
+	if r.SplitDepth > 0 && !d.split {
+		dg.Splits++
+	}
+	d.split = r.SplitDepth > 0
+	if r.SplitDepth > dg.MaxSplitDepth {
+		dg.MaxSplitDepth = r.SplitDepth
+	}
+	for _, ev := range r.Events {
+		dg.Events[ev.Kind]++
+	}
+	if highest > 0 && d.firstTime.IsZero() {
+		d.firstHead, d.firstTime = highest, now
+		d.lastHead, d.lastTime = highest, now
+	}
+	if highest > d.lastHead {
+		d.lastHead, d.lastTime = highest, now
+	}
+	return done
+}
+
+// finish completes the current digest
+func (d *digester) finish() *digestJson {
+	dg := d.current
+	d.current = nil
+	var names []string
+	for name := range d.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		nd := d.nodes[name]
+		if nd.Cycles > 0 {
+			nd.Uptime = float64(nd.Up) / float64(nd.Cycles)
+		}
+		dg.Nodes = append(dg.Nodes, nd)
+	}
+	if !d.firstTime.IsZero() && d.lastHead > d.firstHead {
+		dg.AvgBlockTime = d.lastTime.Sub(d.firstTime).Seconds() / float64(d.lastHead-d.firstHead)
+	}
+	return dg
+}
+
+// write stores the digest as json in the digest directory, named after the
+// start of the period
+func (d *digester) write(dg *digestJson) error {
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(dg, "", "  ")
+	if err != nil {
+		return err
+	}
+	fname := fmt.Sprintf("%v-%v.json", d.period, dg.Start.Format("2006-01-02"))
+	d.copies.put("digests/"+fname, data)
+	return ioutil.WriteFile(filepath.Join(d.dir, fname), data, 0644)
+}
+
+// updateDigest feeds the report into the digest, and writes out the digest of
+// the previous period once it's over.
+func (mon *NodeMonitor) updateDigest(r *Report) {
+	if mon.digest == nil {
+		return
+	}
+	dg := mon.digest.record(r, time.Now())
+	if dg == nil {
+		return
+	}
+	log.Info("Digest complete", "period", dg.Period, "start", dg.Start, "splits", dg.Splits, "reorgs", dg.Reorgs)
+	if err := mon.digest.write(dg); err != nil {
+		log.Warn("Failed to write digest", "error", err)
+	}
+	for _, s := range mon.digestSenders {
+		if err := s.SendDigest(dg); err != nil {
+			log.Warn("Failed to send digest", "error", err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package nodes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type discordConfig struct {
+	Webhook string
+	// DashboardUrl is the public url of the dashboard, used to link to the
+	// json of the blocks involved
+	DashboardUrl string
+	// Kinds are the event kinds to send. Defaults to splits and unreachable
+	// nodes.
+	Kinds []string
+}
+
+// discordNotifier posts alerts, and their recoveries, as embeds to a Discord
+// webhook
+type discordNotifier struct {
+	webhook   string
+	dashboard string
+	kinds     kindFilter
+}
+
+// Embed colors
+const (
+	discordRed    = 0xd0312d
+	discordOrange = 0xf5a623
+	discordGreen  = 0x2ecc71
+)
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	Color       int            `json:"color"`
+	Timestamp   string         `json:"timestamp,omitempty"`
+	Fields      []discordField `json:"fields,omitempty"`
+}
+
+// newDiscordNotifier returns nil if no webhook is configured. The artifacts
+// of networks other than the top level one are linked under their path.
+func newDiscordNotifier(conf discordConfig, network string) *discordNotifier {
+	if len(conf.Webhook) == 0 {
+		return nil
+	}
+	dashboard := strings.TrimSuffix(conf.DashboardUrl, "/")
+	if len(dashboard) > 0 && len(network) > 0 {
+		dashboard += "/networks/" + network
+	}
+	return &discordNotifier{
+		webhook:   conf.Webhook,
+		dashboard: dashboard,
+		kinds:     newKindFilter(conf.Kinds, outageKinds...),
+	}
+}
+
+// embed describes the event, with links to the json of the blocks involved
+func (n *discordNotifier) embed(ev *Event) discordEmbed {
+	color := discordOrange
+	if ev.Severity == SeverityCritical {
+		color = discordRed
+	}
+	e := discordEmbed{
+		Title:       fmt.Sprintf("%v (%v)", ev.Kind, ev.Severity),
+		Description: ev.Message,
+		Color:       color,
+		Timestamp:   ev.Time.UTC().Format(time.RFC3339),
+		Fields: []discordField{
+			{Name: "Nodes", Value: strings.Join(ev.Nodes, ", "), Inline: true},
+		},
+	}
+	if ev.Block > 0 || len(ev.Hashes) > 0 {
+		e.Fields = append(e.Fields, discordField{Name: "Block", Value: fmt.Sprint(ev.Block), Inline: true})
+	}
+	var links []string
+	for i, hash := range ev.Hashes {
+		name := fmt.Sprintf("0x%x", hash[:4])
+		if i < len(ev.HashNodes) {
+			name = strings.Join(ev.HashNodes[i], ", ")
+		}
+		if len(n.dashboard) > 0 {
+			links = append(links, fmt.Sprintf("[%v](%v/hashes/0x%x.json)", name, n.dashboard, hash))
+		} else {
+			links = append(links, fmt.Sprintf("%v: 0x%x", name, hash))
+		}
+	}
+	if len(links) > 0 {
+		e.Fields = append(e.Fields, discordField{Name: "Hashes", Value: strings.Join(links, "\n")})
+	}
+	return e
+}
+
+func (n *discordNotifier) Notify(ev *Event) error {
+	if !n.kinds.match(ev) {
+		return nil
+	}
+	return postJSON("POST", n.webhook, map[string]interface{}{
+		"embeds": []discordEmbed{n.embed(ev)},
+	}, nil)
+}
+
+func (n *discordNotifier) Recovered(ev *Event) error {
+	if !n.kinds.match(ev) {
+		return nil
+	}
+	return postJSON("POST", n.webhook, map[string]interface{}{
+		"embeds": []discordEmbed{{
+			Title:       fmt.Sprintf("Resolved: %v", ev.Kind),
+			Description: fmt.Sprintf("No longer seen: %v", ev.Message),
+			Color:       discordGreen,
+			Timestamp:   time.Now().UTC().Format(time.RFC3339),
+			Fields: []discordField{
+				{Name: "Nodes", Value: strings.Join(ev.Nodes, ", "), Inline: true},
+			},
+		}},
+	}, nil)
+}
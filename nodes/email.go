@@ -0,0 +1,181 @@
+package nodes
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+)
+
+type emailConfig struct {
+	// Host and port of the SMTP server, e.g. smtp.example.com:587
+	Server   string
+	Username string
+	Password string
+	From     string
+	To       []string
+	// TLS is "starttls" (the default), "tls" for implicit TLS (usually port
+	// 465), or "none"
+	TLS string
+	// InsecureSkipVerify disables verification of the server certificate
+	InsecureSkipVerify bool
+	// Alerts enables sending critical alerts right away
+	Alerts bool
+	// Digests enables sending the periodic digests
+	Digests bool
+}
+
+// emailTimeout bounds the whole exchange with the SMTP server, as alerts are
+// sent from the check loop
+const emailTimeout = 30 * time.Second
+
+// emailNotifier sends critical alerts and digests by email
+type emailNotifier struct {
+	conf emailConfig
+	host string
+	// send is replaced in tests
+	send func(subject, body string) error
+}
+
+func newEmailNotifier(conf emailConfig) (*emailNotifier, error) {
+	if len(conf.Server) == 0 {
+		return nil, nil
+	}
+	host, _, err := net.SplitHostPort(conf.Server)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email server %q: %v", conf.Server, err)
+	}
+	if len(conf.From) == 0 || len(conf.To) == 0 {
+		return nil, errors.New("email needs a sender and at least one recipient")
+	}
+	switch conf.TLS {
+	case "":
+		conf.TLS = "starttls"
+	case "starttls", "tls", "none":
+	default:
+		return nil, fmt.Errorf("invalid email tls mode %q, available: [starttls, tls, none]", conf.TLS)
+	}
+	n := &emailNotifier{conf: conf, host: host}
+	n.send = n.sendMail
+	return n, nil
+}
+
+// Notify sends critical events, less severe ones are left to the dashboard
+func (n *emailNotifier) Notify(ev *Event) error {
+	if !n.conf.Alerts || ev.Severity < SeverityCritical {
+		return nil
+	}
+	subject := fmt.Sprintf("[nodemonitor] %v: %v", ev.Kind, strings.Join(ev.Nodes, ", "))
+	body := fmt.Sprintf("%v\n\nTime: %v\nNodes: %v\n", ev.Message,
+		ev.Time.UTC().Format(time.RFC1123), strings.Join(ev.Nodes, ", "))
+	return n.send(subject, body)
+}
+
+// SendDigest sends the digest as a plain text summary
+func (n *emailNotifier) SendDigest(dg *digestJson) error {
+	if !n.conf.Digests {
+		return nil
+	}
+	subject := fmt.Sprintf("[nodemonitor] %v digest for %v", dg.Period, dg.Start.Format("2006-01-02"))
+	return n.send(subject, dg.text())
+}
+
+// text renders the digest for humans
+func (dg *digestJson) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Summary of %d check cycles, %v - %v\n\n", dg.Cycles,
+		dg.Start.Format("2006-01-02 15:04 MST"), dg.End.Format("2006-01-02 15:04 MST"))
+	fmt.Fprintf(&b, "Splits: %d (deepest %d blocks)\n", dg.Splits, dg.MaxSplitDepth)
+	fmt.Fprintf(&b, "Reorgs: %d\n", dg.Reorgs)
+	fmt.Fprintf(&b, "Average block time: %.1fs\n\n", dg.AvgBlockTime)
+	fmt.Fprintf(&b, "%-20s %8s %7s\n", "Node", "Uptime", "Reorgs")
+	for _, nd := range dg.Nodes {
+		fmt.Fprintf(&b, "%-20s %7.2f%% %7d\n", nd.Name, 100*nd.Uptime, nd.Reorgs)
+	}
+	if len(dg.Events) > 0 {
+		var kinds []string
+		for kind := range dg.Events {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		fmt.Fprintf(&b, "\nEvents:\n")
+		for _, kind := range kinds {
+			fmt.Fprintf(&b, "  %-24s %d\n", kind, dg.Events[kind])
+		}
+	}
+	return b.String()
+}
+
+// message builds the email, headers included
+func (n *emailNotifier) message(subject, body string, now time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %v\r\n", n.conf.From)
+	fmt.Fprintf(&buf, "To: %v\r\n", strings.Join(n.conf.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %v\r\n", subject)
+	fmt.Fprintf(&buf, "Date: %v\r\n", now.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+func (n *emailNotifier) sendMail(subject, body string) error {
+	tlsConf := &tls.Config{ServerName: n.host, InsecureSkipVerify: n.conf.InsecureSkipVerify}
+	var (
+		conn net.Conn
+		err  error
+	)
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if n.conf.TLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", n.conf.Server, tlsConf)
+	} else {
+		conn, err = dialer.Dial("tcp", n.conf.Server)
+	}
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(emailTimeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	c, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if n.conf.TLS == "starttls" {
+		if err := c.StartTLS(tlsConf); err != nil {
+			return err
+		}
+	}
+	if len(n.conf.Username) > 0 {
+		if err := c.Auth(smtp.PlainAuth("", n.conf.Username, n.conf.Password, n.host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.conf.From); err != nil {
+		return err
+	}
+	for _, to := range n.conf.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(n.message(subject, body, time.Now())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
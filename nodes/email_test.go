@@ -0,0 +1,130 @@
+package nodes
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP accepts a single mail, and returns what was received
+func fakeSMTP(t *testing.T) (addr string, received chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received = make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var (
+			r    = bufio.NewReader(conn)
+			mail strings.Builder
+			data bool
+		)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if data {
+				if line == ".\r\n" {
+					data = false
+					received <- mail.String()
+					reply("250 OK")
+				} else {
+					mail.WriteString(line)
+				}
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				mail.WriteString(line)
+				reply("250 OK")
+			case "DATA":
+				data = true
+				reply("354 Go ahead")
+			case "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("502 Not implemented")
+			}
+		}
+	}()
+	return l.Addr().String(), received
+}
+
+func TestEmailNotifier(t *testing.T) {
+	addr, received := fakeSMTP(t)
+	n, err := newEmailNotifier(emailConfig{
+		Server: addr,
+		From:   "monitor@example.com",
+		To:     []string{"ops@example.com"},
+		TLS:    "none",
+		Alerts: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Warnings aren't mailed
+	n.Notify(&Event{Kind: EventUnreachable, Severity: SeverityWarning, Nodes: []string{"geth"}})
+	if err := n.Notify(&Event{Kind: EventSplit, Severity: SeverityCritical, Nodes: []string{"geth", "besu"},
+		Message: "Split found at block 100", Time: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case mail := <-received:
+		for _, want := range []string{
+			"MAIL FROM:<monitor@example.com>",
+			"RCPT TO:<ops@example.com>",
+			"Subject: [nodemonitor] split: geth, besu",
+			"Split found at block 100",
+		} {
+			if !strings.Contains(mail, want) {
+				t.Errorf("mail missing %q:\n%v", want, mail)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail received")
+	}
+	// Digests are disabled
+	if err := n.SendDigest(&digestJson{}); err != nil {
+		t.Errorf("expected disabled digest to be skipped: %v", err)
+	}
+
+	for _, conf := range []emailConfig{
+		{Server: "no-port", From: "a", To: []string{"b"}},
+		{Server: "host:25", From: "a"},
+		{Server: "host:25", From: "a", To: []string{"b"}, TLS: "ssl"},
+	} {
+		if _, err := newEmailNotifier(conf); err == nil {
+			t.Errorf("expected error for %+v", conf)
+		}
+	}
+}
+
+func TestDigestText(t *testing.T) {
+	dg := &digestJson{
+		Period: "daily",
+		Start:  time.Date(2021, 3, 10, 0, 0, 0, 0, time.UTC),
+		End:    time.Date(2021, 3, 11, 0, 0, 0, 0, time.UTC),
+		Cycles: 10,
+		Nodes:  []*nodeDigestJson{{Name: "geth", Uptime: 0.9, Reorgs: 2}},
+		Splits: 1, MaxSplitDepth: 3, AvgBlockTime: 12.5,
+		Events: map[string]int{EventSplit: 4},
+	}
+	text := dg.text()
+	for _, want := range []string{"Summary of 10 check cycles", "deepest 3 blocks", "12.5s", "90.00%", "split"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("digest text missing %q:\n%v", want, text)
+		}
+	}
+}
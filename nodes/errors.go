@@ -0,0 +1,155 @@
+package nodes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// ErrorKind classifies the errors of node operations, to tell a node being
+// down from the provider throttling or rejecting the monitor
+type ErrorKind string
+
+const (
+	ErrKindTimeout     ErrorKind = "timeout"
+	ErrKindRefused     ErrorKind = "connection-refused"
+	ErrKindAuth        ErrorKind = "auth"
+	ErrKindRateLimited ErrorKind = "rate-limited"
+	ErrKindDecode      ErrorKind = "decode"
+	ErrKindOther       ErrorKind = "other"
+)
+
+// NodeError is the error of an operation on a node, along with its kind
+type NodeError struct {
+	Node string
+	Op   string
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *NodeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *NodeError) Unwrap() error {
+	return e.Err
+}
+
+// statusError is returned for HTTP requests answered with an unexpected
+// status code
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+// wrapNodeError classifies the error of the operation, or returns nil if
+// there's none
+func wrapNodeError(node, op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var ne *NodeError
+	if errors.As(err, &ne) {
+		return err
+	}
+	return &NodeError{Node: node, Op: op, Kind: classifyError(err), Err: err}
+}
+
+// ErrorKindOf returns the kind of the error
+func ErrorKindOf(err error) ErrorKind {
+	var ne *NodeError
+	if errors.As(err, &ne) {
+		return ne.Kind
+	}
+	return classifyError(err)
+}
+
+// statusKind returns the kind of an HTTP status code
+func statusKind(code int) ErrorKind {
+	switch code {
+	case 401, 403:
+		return ErrKindAuth
+	case 429:
+		return ErrKindRateLimited
+	case 408, 504:
+		return ErrKindTimeout
+	}
+	return ErrKindOther
+}
+
+func classifyError(err error) ErrorKind {
+	var (
+		se      *statusError
+		netErr  net.Error
+		rpcErr  rpc.Error
+		syntax  *json.SyntaxError
+		typeErr *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &se):
+		return statusKind(se.code)
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrKindTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrKindTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrKindRefused
+	case errors.As(err, &syntax) || errors.As(err, &typeErr):
+		return ErrKindDecode
+	case errors.As(err, &rpcErr) && rpcErr.ErrorCode() == -32005:
+		// Used by providers for exceeded request limits
+		return ErrKindRateLimited
+	}
+	// The RPC client doesn't keep the underlying errors of http requests,
+	// they start with the status instead
+	msg := strings.ToLower(err.Error())
+	if len(msg) >= 3 {
+		if code, err := strconv.Atoi(msg[:3]); err == nil && code >= 400 {
+			return statusKind(code)
+		}
+	}
+	switch {
+	case strings.Contains(msg, "connection refused"):
+		return ErrKindRefused
+	case strings.Contains(msg, "timeout") || strings.Contains(msg, "deadline exceeded"):
+		return ErrKindTimeout
+	case strings.Contains(msg, "rate limit") || strings.Contains(msg, "too many requests"):
+		return ErrKindRateLimited
+	case strings.Contains(msg, "unauthorized") || strings.Contains(msg, "forbidden"):
+		return ErrKindAuth
+	}
+	return ErrKindOther
+}
+
+// statusNode is a node whose status is tracked
+type statusNode interface {
+	Name() string
+	SetStatus(int)
+}
+
+// nodeFailed counts the error by kind, sets the status of the node after a
+// failed update, and raises the matching event. It returns false if the
+// node is throttled rather than down.
+func (mon *NodeMonitor) nodeFailed(node statusNode, what string, err error) bool {
+	kind := ErrorKindOf(err)
+	metrics.GetOrRegisterCounter("errors/"+string(kind), mon.registry).Inc(1)
+	if kind == ErrKindRateLimited {
+		node.SetStatus(NodeStatusThrottled)
+		mon.emit(EventRateLimited, SeverityInfo, []string{node.Name()}, "Rate limited %v: %v", what, err)
+		return false
+	}
+	node.SetStatus(NodeStatusUnreachable)
+	mon.emit(EventUnreachable, SeverityWarning, []string{node.Name()}, "Error %v (%v): %v", what, kind, err)
+	return true
+}
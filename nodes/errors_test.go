@@ -0,0 +1,106 @@
+package nodes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	// A port nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	_, refused := net.Dial("tcp", addr)
+
+	var syntax *json.SyntaxError
+	decodeErr := json.Unmarshal([]byte("{"), &struct{}{})
+	errors.As(decodeErr, &syntax)
+
+	tests := []struct {
+		err  error
+		kind ErrorKind
+	}{
+		{refused, ErrKindRefused},
+		{context.DeadlineExceeded, ErrKindTimeout},
+		{fmt.Errorf("call failed: %w", context.DeadlineExceeded), ErrKindTimeout},
+		{decodeErr, ErrKindDecode},
+		{errors.New("429 Too Many Requests: "), ErrKindRateLimited},
+		{errors.New("401 Unauthorized: invalid project id"), ErrKindAuth},
+		{&statusError{403, "request /eth/v1/node/version failed: 403 Forbidden"}, ErrKindAuth},
+		{&statusError{503, "request /eth/v1/node/version failed: 503 Service Unavailable"}, ErrKindOther},
+		{errors.New("daily request count exceeded, request rate limited"), ErrKindRateLimited},
+		{wrapNodeError("geth", "latest", errors.New("429 Too Many Requests")), ErrKindRateLimited},
+		{errors.New("missing trie node"), ErrKindOther},
+	}
+	for i, tt := range tests {
+		if kind := ErrorKindOf(tt.err); kind != tt.kind {
+			t.Errorf("test %d: %v: have kind %v, want %v", i, tt.err, kind, tt.kind)
+		}
+	}
+	if wrapNodeError("geth", "latest", nil) != nil {
+		t.Error("nil error wrapped")
+	}
+}
+
+// throttledNode is a node whose provider rate limits every request
+type throttledNode struct {
+	*testNode
+	status int
+}
+
+func (n *throttledNode) UpdateLatest() error {
+	return wrapNodeError(n.Name(), "latest", errors.New("429 Too Many Requests"))
+}
+
+func (n *throttledNode) Status() int          { return n.status }
+func (n *throttledNode) SetStatus(status int) { n.status = status }
+
+func TestThrottledNodes(t *testing.T) {
+	chain := makeChain("a", 20, nil)
+	limited := &throttledNode{testNode: newTestNode("limited", 10, chain)}
+	mon, err := NewMonitor([]Node{newTestNode("a", 10, chain), limited, &brokenNode{"down"}}, nil, nil, &Config{ReloadInterval: "1s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mon.doChecks()
+	if limited.Status() != NodeStatusThrottled {
+		t.Errorf("wrong status of throttled node: %d", limited.Status())
+	}
+	var kinds = make(map[string][]string)
+	for _, ev := range mon.Report().Events {
+		kinds[ev.Kind] = append(kinds[ev.Kind], ev.Nodes...)
+	}
+	if len(kinds[EventRateLimited]) != 1 || len(kinds[EventUnreachable]) != 1 || kinds[EventUnreachable][0] != "down" {
+		t.Errorf("throttling not told apart from outages: %v", kinds)
+	}
+	if err := mon.Verdict(); err == nil || err.Error() != "unreachable nodes: down" {
+		t.Errorf("wrong verdict: %v", err)
+	}
+	if c := mon.health().Cycle; c == nil || c.NodesOK != 1 || c.NodesFailed != 1 || c.NodesThrottled != 1 {
+		t.Errorf("throttled node not counted apart: %+v", c)
+	}
+	// Beacon API errors carry their status code
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	beacon, _ := NewBeaconNode("beacon", srv.URL, 0, &http.Client{Timeout: time.Second})
+	if err := beacon.Update(); ErrorKindOf(err) != ErrKindRateLimited {
+		t.Errorf("wrong kind of beacon error: %v", err)
+	}
+	// Not only the updates are classified
+	var ne *NodeError
+	if _, err := beacon.header("head"); !errors.As(err, &ne) || ne.Node != "beacon" || ne.Kind != ErrKindRateLimited {
+		t.Errorf("beacon error not wrapped: %v", err)
+	}
+}
@@ -0,0 +1,460 @@
+package nodes
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+type ethstatsConfig struct {
+	// Secret is the secret the nodes report with, as in
+	// --ethstats <name>:<secret>@<monitor host:port>
+	Secret string
+	// MaxAge is how long a node may go without reporting a block before it's
+	// considered unreachable. Defaults to 2m.
+	MaxAge string
+	// HistoryTimeout is how long to wait for a node to send a block the
+	// monitor asked for. Defaults to 3s.
+	HistoryTimeout string
+}
+
+// ethstatsMessage is the envelope of the ethstats protocol:
+// {"emit": [<command>, <payload>]}
+type ethstatsMessage struct {
+	Emit []json.RawMessage `json:"emit"`
+}
+
+type ethstatsHello struct {
+	ID   string `json:"id"`
+	Info struct {
+		Node string `json:"node"`
+	} `json:"info"`
+	Secret string `json:"secret"`
+}
+
+// ethstatsBlock is a block as reported by the nodes
+type ethstatsBlock struct {
+	Number     uint64            `json:"number"`
+	Hash       common.Hash       `json:"hash"`
+	ParentHash common.Hash       `json:"parentHash"`
+	Timestamp  uint64            `json:"timestamp"`
+	Miner      common.Address    `json:"miner"`
+	GasUsed    uint64            `json:"gasUsed"`
+	GasLimit   uint64            `json:"gasLimit"`
+	TotalDiff  string            `json:"totalDifficulty"`
+	Txs        []json.RawMessage `json:"transactions"`
+	Uncles     []json.RawMessage `json:"uncles"`
+}
+
+type ethstatsStats struct {
+	Syncing bool `json:"syncing"`
+	Peers   int  `json:"peers"`
+}
+
+// ethstatsNode is a node which reports to the monitor via the ethstats
+// protocol, instead of being polled. Blocks it didn't report are requested
+// via a history request, and waited for.
+type ethstatsNode struct {
+	name           string
+	maxAge         time.Duration
+	historyTimeout time.Duration
+	headGauge      metrics.Gauge
+	status         int
+	latest         *blockInfo
+	chainHistory   *blockCache
+
+	mu       sync.Mutex
+	version  string
+	conn     *wsConn // nil if not connected
+	head     *blockInfo
+	received time.Time // when the head was reported
+	stats    *ethstatsStats
+	waiters  map[uint64][]chan struct{} // by requested block number
+}
+
+func newEthstatsNode(name string, maxAge, historyTimeout time.Duration, reg metrics.Registry) *ethstatsNode {
+	return &ethstatsNode{
+		name:           name,
+		maxAge:         maxAge,
+		historyTimeout: historyTimeout,
+		headGauge:      metrics.GetOrRegisterGauge(fmt.Sprintf("head/%v", name), reg),
+		version:        "n/a",
+		chainHistory:   newBlockCache(hashCacheSize),
+		waiters:        make(map[uint64][]chan struct{}),
+	}
+}
+
+func (node *ethstatsNode) Name() string {
+	return node.name
+}
+
+func (node *ethstatsNode) SetStatus(status int) {
+	node.status = status
+}
+
+func (node *ethstatsNode) Status() int {
+	return node.status
+}
+
+func (node *ethstatsNode) Version() (string, error) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	return node.version, nil
+}
+
+func (node *ethstatsNode) HeadNum() uint64 {
+	if node.latest != nil {
+		return node.latest.num
+	}
+	return 0
+}
+
+// UpdateLatest takes the head last reported by the node
+func (node *ethstatsNode) UpdateLatest() error {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	switch {
+	case node.conn == nil:
+		return errors.New("not connected via ethstats")
+	case node.head == nil:
+		return errors.New("no block reported via ethstats")
+	case time.Since(node.received) > node.maxAge:
+		return fmt.Errorf("no block reported via ethstats since %v", node.received.Format(time.RFC3339))
+	}
+	node.latest = node.head
+	node.headGauge.Update(int64(node.latest.num))
+	return nil
+}
+
+func (node *ethstatsNode) BlockAt(num uint64, force bool) *blockInfo {
+	if node.latest != nil && node.latest.num < num {
+		return nil
+	}
+	if bl, ok := node.chainHistory.get(num); ok && !force {
+		return bl
+	}
+	ch := node.requestHistory(num)
+	if ch == nil {
+		return nil
+	}
+	select {
+	case <-ch:
+	case <-time.After(node.historyTimeout):
+		log.Debug("Ethstats history request timed out", "node", node.name, "number", num)
+		return nil
+	}
+	bl, _ := node.chainHistory.get(num)
+	return bl
+}
+
+func (node *ethstatsNode) HashAt(num uint64, force bool) common.Hash {
+	if bl := node.BlockAt(num, force); bl != nil {
+		return bl.hash
+	}
+	return common.Hash{}
+}
+
+// Vitals returns the peer count and sync state last reported by the node
+func (node *ethstatsNode) Vitals() (*nodeVitals, error) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.stats == nil {
+		return nil, errNotFound
+	}
+	return &nodeVitals{Peers: node.stats.Peers, Syncing: node.stats.Syncing}, nil
+}
+
+// requestHistory asks the node for the blocks, and returns a channel closed
+// once the first of them arrives, or nil if the node isn't connected
+func (node *ethstatsNode) requestHistory(nums ...uint64) chan struct{} {
+	node.mu.Lock()
+	conn := node.conn
+	ch := make(chan struct{})
+	if conn != nil {
+		for _, num := range nums {
+			node.waiters[num] = append(node.waiters[num], ch)
+		}
+	}
+	node.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	if err := ethstatsSend(conn, "history", map[string]interface{}{"list": nums}); err != nil {
+		log.Debug("Failed to request ethstats history", "node", node.name, "error", err)
+	}
+	return ch
+}
+
+// addBlock stores a reported block. If it's the head and doesn't extend the
+// known chain, the blocks below are requested again, as they were reorged.
+func (node *ethstatsNode) addBlock(b *ethstatsBlock, head bool) {
+	bl := &blockInfo{
+		num:      b.Number,
+		hash:     b.Hash,
+		time:     b.Timestamp,
+		gasUsed:  b.GasUsed,
+		gasLimit: b.GasLimit,
+		uncles:   len(b.Uncles) > 0,
+		miner:    b.Miner,
+		txCount:  len(b.Txs),
+		received: time.Now(),
+	}
+	if td, ok := new(big.Int).SetString(b.TotalDiff, 10); ok {
+		bl.td = td
+	}
+	if prev, ok := node.chainHistory.get(bl.num); ok && prev.hash == bl.hash {
+		bl.received = prev.received
+	}
+	reorged := false
+	if parent, ok := node.chainHistory.get(bl.num - 1); ok && bl.num > 0 && parent.hash != b.ParentHash {
+		reorged = true
+	}
+	node.chainHistory.add(bl)
+
+	node.mu.Lock()
+	if head {
+		node.head, node.received = bl, time.Now()
+	}
+	for _, ch := range node.waiters[bl.num] {
+		select {
+		case <-ch:
+		default:
+			close(ch)
+		}
+	}
+	delete(node.waiters, bl.num)
+	node.mu.Unlock()
+
+	if head && reorged {
+		var nums []uint64
+		for i := uint64(1); i <= 16 && i <= bl.num; i++ {
+			nums = append(nums, bl.num-i)
+		}
+		node.requestHistory(nums...)
+	}
+}
+
+// attach makes the connection the one the node reports on, replacing any
+// previous one
+func (node *ethstatsNode) attach(conn *wsConn, version string) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.conn != nil {
+		node.conn.Close()
+	}
+	node.conn = conn
+	if len(version) > 0 {
+		node.version = version
+	}
+}
+
+// detach forgets the connection, unless it was already replaced
+func (node *ethstatsNode) detach(conn *wsConn) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.conn == conn {
+		node.conn = nil
+		// Requested blocks won't arrive anymore
+		node.waiters = make(map[uint64][]chan struct{})
+	}
+}
+
+// ethstatsSend sends a command in the ethstats envelope
+func ethstatsSend(conn *wsConn, command string, payload interface{}) error {
+	emit := []interface{}{command}
+	if payload != nil {
+		emit = append(emit, payload)
+	}
+	data, err := json.Marshal(map[string]interface{}{"emit": emit})
+	if err != nil {
+		return err
+	}
+	return conn.WriteText(data)
+}
+
+// ethstatsServer accepts the ethstats connections of the nodes
+type ethstatsServer struct {
+	secret string
+
+	mu    sync.Mutex
+	nodes map[string]*ethstatsNode // by name
+}
+
+// newEthstatsServer returns nil if no secret is configured
+func newEthstatsServer(conf ethstatsConfig) (*ethstatsServer, error) {
+	if len(conf.Secret) == 0 {
+		return nil, nil
+	}
+	if _, _, err := conf.durations(); err != nil {
+		return nil, err
+	}
+	return &ethstatsServer{secret: conf.Secret, nodes: make(map[string]*ethstatsNode)}, nil
+}
+
+func (conf *ethstatsConfig) durations() (maxAge, historyTimeout time.Duration, err error) {
+	maxAge, historyTimeout = 2*time.Minute, 3*time.Second
+	if len(conf.MaxAge) > 0 {
+		if maxAge, err = time.ParseDuration(conf.MaxAge); err != nil {
+			return 0, 0, fmt.Errorf("invalid ethstats max age: %v", err)
+		}
+	}
+	if len(conf.HistoryTimeout) > 0 {
+		if historyTimeout, err = time.ParseDuration(conf.HistoryTimeout); err != nil {
+			return 0, 0, fmt.Errorf("invalid ethstats history timeout: %v", err)
+		}
+	}
+	return maxAge, historyTimeout, nil
+}
+
+// add registers the node if it reports via ethstats
+func (s *ethstatsServer) add(node Node) {
+	if en, ok := node.(*ethstatsNode); ok {
+		s.mu.Lock()
+		s.nodes[en.name] = en
+		s.mu.Unlock()
+	}
+}
+
+func (s *ethstatsServer) forget(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if node, ok := s.nodes[name]; ok {
+		node.mu.Lock()
+		if node.conn != nil {
+			node.conn.Close()
+		}
+		node.mu.Unlock()
+		delete(s.nodes, name)
+	}
+}
+
+// login checks the hello message, and returns the node it's from
+func (s *ethstatsServer) login(data []byte) (*ethstatsNode, *ethstatsHello, error) {
+	var msg ethstatsMessage
+	if err := json.Unmarshal(data, &msg); err != nil || len(msg.Emit) != 2 {
+		return nil, nil, errors.New("invalid hello")
+	}
+	var command string
+	var hello ethstatsHello
+	if json.Unmarshal(msg.Emit[0], &command) != nil || command != "hello" || json.Unmarshal(msg.Emit[1], &hello) != nil {
+		return nil, nil, errors.New("invalid hello")
+	}
+	if subtle.ConstantTimeCompare([]byte(hello.Secret), []byte(s.secret)) != 1 {
+		return nil, nil, errors.New("wrong secret")
+	}
+	s.mu.Lock()
+	node, ok := s.nodes[hello.ID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown node %q", hello.ID)
+	}
+	return node, &hello, nil
+}
+
+// serve handles the messages of a logged in node until it disconnects
+func (s *ethstatsServer) serve(node *ethstatsNode, conn *wsConn) error {
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var msg ethstatsMessage
+		if err := json.Unmarshal(data, &msg); err != nil || len(msg.Emit) == 0 {
+			log.Debug("Invalid ethstats message", "node", node.name, "error", err)
+			continue
+		}
+		var command string
+		json.Unmarshal(msg.Emit[0], &command)
+		var payload json.RawMessage
+		if len(msg.Emit) > 1 {
+			payload = msg.Emit[1]
+		}
+		switch command {
+		case "node-ping":
+			var ping struct {
+				ClientTime interface{} `json:"clientTime"`
+			}
+			json.Unmarshal(payload, &ping)
+			err = ethstatsSend(conn, "node-pong", map[string]interface{}{
+				"id":         node.name,
+				"clientTime": ping.ClientTime,
+				"serverTime": time.Now().UnixNano() / int64(time.Millisecond),
+			})
+		case "block":
+			var report struct {
+				Block *ethstatsBlock `json:"block"`
+			}
+			if json.Unmarshal(payload, &report) == nil && report.Block != nil {
+				node.addBlock(report.Block, true)
+			}
+		case "history":
+			var report struct {
+				History []*ethstatsBlock `json:"history"`
+			}
+			if json.Unmarshal(payload, &report) == nil {
+				for _, b := range report.History {
+					if b != nil {
+						node.addBlock(b, false)
+					}
+				}
+			}
+		case "stats":
+			var report struct {
+				Stats *ethstatsStats `json:"stats"`
+			}
+			if json.Unmarshal(payload, &report) == nil && report.Stats != nil {
+				node.mu.Lock()
+				node.stats = report.Stats
+				node.mu.Unlock()
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// HandleEthstats accepts the ethstats connections of the nodes configured
+// with kind "ethstats", which report to ws://<monitor>/api
+func (mon *NodeMonitor) HandleEthstats(w http.ResponseWriter, r *http.Request) {
+	s := mon.ethstats
+	if s == nil {
+		http.Error(w, "ethstats not enabled", http.StatusNotFound)
+		return
+	}
+	conn, err := wsUpgrade(w, r)
+	if err != nil {
+		log.Debug("Rejected ethstats connection", "error", err)
+		return
+	}
+	defer conn.Close()
+	data, err := conn.ReadMessage()
+	if err != nil {
+		return
+	}
+	node, hello, err := s.login(data)
+	if err != nil {
+		log.Warn("Rejected ethstats login", "remote", r.RemoteAddr, "error", err)
+		return
+	}
+	if err := ethstatsSend(conn, "ready", nil); err != nil {
+		return
+	}
+	log.Info("Node connected via ethstats", "node", node.name, "version", hello.Info.Node)
+	node.attach(conn, hello.Info.Node)
+	defer node.detach(conn)
+	if err := s.serve(node, conn); err != nil && err != io.EOF {
+		log.Debug("Ethstats connection failed", "node", node.name, "error", err)
+	}
+	log.Info("Node disconnected from ethstats", "node", node.name)
+}
@@ -0,0 +1,184 @@
+package nodes
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// ethstatsTestClient reports a chain like a node started with --ethstats
+type ethstatsTestClient struct {
+	conn  net.Conn
+	br    *bufio.Reader
+	chain []*blockInfo
+}
+
+func dialEthstats(t *testing.T, addr string, chain []*blockInfo) *ethstatsTestClient {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(addr, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "GET /api HTTP/1.1\r\nHost: monitor\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols || res.Header.Get("Sec-Websocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("wrong handshake: %v %v", res.Status, res.Header)
+	}
+	return &ethstatsTestClient{conn: conn, br: br, chain: chain}
+}
+
+// emit sends a masked text frame
+func (c *ethstatsTestClient) emit(command string, payload interface{}) {
+	data, _ := json.Marshal(map[string]interface{}{"emit": []interface{}{command, payload}})
+	hdr := []byte{0x81, 0x80 | 127, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(hdr[2:], uint64(len(data)))
+	mask := []byte{1, 2, 3, 4}
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+	c.conn.Write(append(append(hdr, mask...), data...))
+}
+
+// read returns the command and payload of the next message
+func (c *ethstatsTestClient) read() (string, json.RawMessage, error) {
+	fin, opcode, payload, err := (&wsConn{br: c.br}).readUnmasked()
+	if err != nil {
+		return "", nil, err
+	}
+	if !fin || opcode != wsText {
+		return "", nil, errors.New("unexpected frame")
+	}
+	var msg ethstatsMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return "", nil, err
+	}
+	var command string
+	json.Unmarshal(msg.Emit[0], &command)
+	if len(msg.Emit) > 1 {
+		return command, msg.Emit[1], nil
+	}
+	return command, nil, nil
+}
+
+// readUnmasked reads a frame sent by the server
+func (c *wsConn) readUnmasked() (bool, byte, []byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return false, 0, nil, err
+	}
+	size := int(hdr[1] & 0x7f)
+	if size == 126 {
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		size = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, size)
+	_, err := io.ReadFull(c.br, payload)
+	return hdr[0]&0x80 != 0, hdr[0] & 0x0f, payload, err
+}
+
+func (c *ethstatsTestClient) block(num uint64) map[string]interface{} {
+	bl := c.chain[num]
+	var parent common.Hash
+	if num > 0 {
+		parent = c.chain[num-1].hash
+	}
+	return map[string]interface{}{"number": num, "hash": bl.hash, "parentHash": parent, "timestamp": num * 12,
+		"gasUsed": 0, "gasLimit": 30000000, "totalDifficulty": "100", "transactions": []interface{}{}, "uncles": []interface{}{}}
+}
+
+// serve answers history requests until the connection is closed
+func (c *ethstatsTestClient) serve(id string) {
+	for {
+		command, payload, err := c.read()
+		if err != nil {
+			return
+		}
+		if command != "history" {
+			continue
+		}
+		var req struct{ List []uint64 }
+		json.Unmarshal(payload, &req)
+		var history []interface{}
+		for _, num := range req.List {
+			history = append(history, c.block(num))
+		}
+		c.emit("history", map[string]interface{}{"id": id, "history": history})
+	}
+}
+
+func TestEthstats(t *testing.T) {
+	var a, b = make([]*blockInfo, 200), make([]*blockInfo, 200)
+	for i := range a {
+		a[i] = &blockInfo{num: uint64(i), hash: common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("a :%d", i))))}
+		b[i] = a[i]
+		if i >= 150 {
+			b[i] = &blockInfo{num: uint64(i), hash: common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("b :%d", i))))}
+		}
+	}
+	reporter := newEthstatsNode("geth", time.Minute, 3*time.Second, registry)
+	mon, err := NewMonitor([]Node{newTestNode("a", 199, a), reporter}, nil, nil,
+		&Config{ReloadInterval: "1s", Ethstats: ethstatsConfig{Secret: "secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(mon.HandleEthstats))
+	defer srv.Close()
+
+	hello := func(secret string) map[string]interface{} {
+		return map[string]interface{}{"id": "geth", "secret": secret, "info": map[string]interface{}{"node": "Geth/v1.10.0"}}
+	}
+	bad := dialEthstats(t, srv.URL, b)
+	bad.emit("hello", hello("wrong"))
+	if _, _, err := bad.read(); err == nil {
+		t.Error("login with the wrong secret accepted")
+	}
+	c := dialEthstats(t, srv.URL, b)
+	defer c.conn.Close()
+	c.emit("hello", hello("secret"))
+	if command, _, err := c.read(); err != nil || command != "ready" {
+		t.Fatalf("login failed: %v %v", command, err)
+	}
+	c.emit("node-ping", map[string]interface{}{"id": "geth", "clientTime": "now"})
+	if command, _, err := c.read(); err != nil || command != "node-pong" {
+		t.Fatalf("ping not answered: %v %v", command, err)
+	}
+	c.emit("block", map[string]interface{}{"id": "geth", "block": c.block(199)})
+	c.emit("stats", map[string]interface{}{"id": "geth", "stats": map[string]interface{}{"peers": 5, "syncing": false}})
+	go c.serve("geth")
+	for i := 0; i < 100 && reporter.UpdateLatest() != nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if v, _ := reporter.Version(); v != "Geth/v1.10.0" {
+		t.Errorf("wrong version: %v", v)
+	}
+	mon.doChecks()
+	found := false
+	for _, ev := range mon.Report().Events {
+		if ev.Kind == EventSplit && strings.Contains(ev.Message, "block 150") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("split not located via history requests: %+v", mon.Report().Events)
+	}
+	if v, err := reporter.Vitals(); err != nil || v.Peers != 5 {
+		t.Errorf("wrong vitals: %+v %v", v, err)
+	}
+}
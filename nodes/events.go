@@ -0,0 +1,388 @@
+package nodes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Severity is how bad an event is
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityCritical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityCritical:
+		return "critical"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText parses the severity, so archived reports can be decoded
+func (s *Severity) UnmarshalText(text []byte) error {
+	for _, sev := range []Severity{SeverityInfo, SeverityWarning, SeverityCritical} {
+		if string(text) == sev.String() {
+			*s = sev
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown severity %q", text)
+}
+
+// Kinds of events
+const (
+	EventSplit          = "split"
+	EventUnreachable    = "unreachable"
+	EventFinalizedSplit = "finalized-split"
+	EventSafeSplit      = "safe-split"
+	// All nodes in one group diverged from all nodes in another
+	EventGroupSplit = "group-split"
+	// Beacon nodes disagree about finalized or justified checkpoints
+	EventCheckpointMismatch = "checkpoint-mismatch"
+	// A beacon node's head is too far behind the wall clock
+	EventSlotLag = "slot-lag"
+	// Participation is low, or nodes disagree about it
+	EventParticipation = "participation"
+	// A monitored validator missed a duty
+	EventMissedAttestation = "missed-attestation"
+	EventMissedProposal    = "missed-proposal"
+	// A monitored validator's balance decreased over the balance window
+	EventBalanceDecrease = "balance-decrease"
+	// Beacon nodes disagree about the active fork
+	EventForkMismatch = "fork-mismatch"
+	// A beacon node failed to serve the blobs of a block
+	EventBlobUnavailable = "blob-unavailable"
+	// A MEV-boost relay could not be reached
+	EventRelayUnreachable = "relay-unreachable"
+	// A beacon node and its execution node drifted apart
+	EventPairDrift = "pair-drift"
+	// A monitored validator keeps missing sync committee contributions
+	EventSyncCommittee = "sync-committee"
+	// A slashing was included in a block, or a monitored validator was slashed
+	EventSlashing = "slashing"
+	// A node's head number went backwards, due to a deep reorg or a resync
+	EventHeadRegression = "head-regression"
+	// A node's head hasn't advanced for too long
+	EventHeadStall = "head-stall"
+	// No node's head has advanced for too long
+	EventChainHalt = "chain-halt"
+	// A node's head block is much older than the wall clock
+	EventStaleHead = "stale-head"
+	// A node follows a chain with less weight than another node's
+	EventLightChain = "light-chain"
+	// Nodes report different weights for the same block
+	EventWeightMismatch = "weight-mismatch"
+	// The share of recent blocks with uncles is above the max
+	EventUncleRate = "uncle-rate"
+	// Nodes agreeing on a block disagree about the state
+	EventStateMismatch = "state-mismatch"
+	// Nodes on the same chain return different logs for the same query
+	EventLogMismatch = "log-mismatch"
+	// A node rejected a block as invalid
+	EventBadBlock = "bad-block"
+	// A node failed to accept a canary transaction
+	EventCanaryRejected = "canary-rejected"
+	// A canary transaction wasn't included in time
+	EventCanaryStuck = "canary-stuck"
+	// A txpool grew faster than allowed
+	EventTxpoolGrowth = "txpool-growth"
+	// A txpool is empty while those of the other nodes are not
+	EventTxpoolEmpty = "txpool-empty"
+	// The nodes on different sides of a split share no peers
+	EventDisjointPeers = "disjoint-peers"
+	// Diverged nodes were connected to each other
+	EventAutoPeer = "auto-peer"
+	// Several configured endpoints are the same node
+	EventDuplicateNode = "duplicate-node"
+	// A rollup node could not be reached
+	EventRollupUnreachable = "rollup-unreachable"
+	// A rollup node's unsafe head is behind that of the sequencer
+	EventRollupLag = "rollup-lag"
+	// Rollup nodes disagree about the L2 chain
+	EventRollupDivergence = "rollup-divergence"
+	// A federated monitor stopped pushing its reports
+	EventOriginStale = "origin-stale"
+	// This instance became the leader, or a standby, in active/passive mode
+	EventLeaderChange = "leader-change"
+	// A node's provider rate limits the monitor
+	EventRateLimited = "rate-limited"
+)
+
+// Event is something noteworthy found during a check cycle
+type Event struct {
+	Time     time.Time
+	Kind     string
+	Severity Severity
+	Nodes    []string
+	Message  string
+	// Muted is set if no alert was sent due to a maintenance window
+	Muted bool
+	// Block and Hashes identify the blocks the event is about, if any.
+	// HashNodes are the nodes having each of the hashes, in order.
+	Block     uint64        `json:",omitempty"`
+	Hashes    []common.Hash `json:",omitempty"`
+	HashNodes [][]string    `json:",omitempty"`
+}
+
+func (ev *Event) String() string {
+	return fmt.Sprintf("[%v] %v: %v (%v)", ev.Severity, ev.Kind, ev.Message,
+		strings.Join(ev.Nodes, ", "))
+}
+
+// Notifier is implemented by the destinations that alerts are sent to
+type Notifier interface {
+	Notify(ev *Event) error
+}
+
+// recoveryNotifier is implemented by notifiers which also report when an
+// alerted condition is over
+type recoveryNotifier interface {
+	Recovered(ev *Event) error
+}
+
+// fingerprint identifies an ongoing condition, regardless of the details in
+// the message
+func (ev *Event) fingerprint() string {
+	nodes := append([]string{}, ev.Nodes...)
+	sort.Strings(nodes)
+	return ev.Kind + "/" + strings.Join(nodes, ",")
+}
+
+// alertManager forwards events to the configured notifiers. An event which
+// keeps occurring is only forwarded again once the cooldown for its kind has
+// passed.
+type alertManager struct {
+	notifiers []Notifier
+	// minimum severity for an event to be forwarded
+	threshold Severity
+	cooldown  time.Duration
+	cooldowns map[string]time.Duration // per event kind
+	// fingerprints of the ongoing events which were notified about
+	active map[string]*activeAlert
+	seen   map[string]bool // fingerprints seen in the current cycle
+	// resolveAfter is how long an alert must be gone, besides for more than
+	// a cycle, to be resolved. It covers the checks which don't run every
+	// cycle.
+	resolveAfter time.Duration
+
+	mutes    []*muteWindow
+	muteLock sync.Mutex
+	// passive is set while standing by in active/passive mode. Events are
+	// tracked as usual, so that the leader's alerts aren't repeated on
+	// takeover, but nothing is sent.
+	passive bool
+}
+
+func newAlertManager(conf alertsConfig) (*alertManager, error) {
+	am := &alertManager{
+		threshold: SeverityWarning,
+		cooldown:  30 * time.Minute,
+		cooldowns: make(map[string]time.Duration),
+		active:    make(map[string]*activeAlert),
+		seen:      make(map[string]bool),
+	}
+	if len(conf.Cooldown) > 0 {
+		d, err := time.ParseDuration(conf.Cooldown)
+		if err != nil {
+			return nil, err
+		}
+		am.cooldown = d
+	}
+	for kind, cooldown := range conf.Cooldowns {
+		d, err := time.ParseDuration(cooldown)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cooldown for %v: %v", kind, err)
+		}
+		am.cooldowns[kind] = d
+	}
+	for _, mw := range conf.Maintenance {
+		mw := mw
+		if err := am.addMute(&mw); err != nil {
+			return nil, err
+		}
+	}
+	return am, nil
+}
+
+// activeAlert is an ongoing condition which was alerted on
+type activeAlert struct {
+	sent     *Event // the last event sent
+	lastSeen time.Time
+	// missed is the number of cycles in a row it didn't reoccur in
+	missed int
+}
+
+// coverInterval makes alerts outlast the interval of a check which doesn't
+// run every cycle, so they aren't resolved in between
+func (am *alertManager) coverInterval(d time.Duration) {
+	if d > am.resolveAfter {
+		am.resolveAfter = d
+	}
+}
+
+// hold keeps the ongoing alerts of the kind through the cycle, for checks
+// which didn't run in it
+func (am *alertManager) hold(kind string) {
+	for fp, a := range am.active {
+		if a.sent.Kind == kind {
+			am.seen[fp] = true
+		}
+	}
+}
+
+// endCycle forgets about the events that didn't reoccur for more than a cycle,
+// and for longer than the longest check interval, so they're alerted on right
+// away if they come back. Notifiers supporting it are told about the recovery.
+func (am *alertManager) endCycle(now time.Time) {
+	for fp, a := range am.active {
+		if am.seen[fp] {
+			a.missed = 0
+			continue
+		}
+		if a.missed++; a.missed < 2 || now.Sub(a.lastSeen) <= am.resolveAfter {
+			continue
+		}
+		delete(am.active, fp)
+		if am.passive {
+			continue
+		}
+		for _, n := range am.notifiers {
+			if rn, ok := n.(recoveryNotifier); ok {
+				if err := rn.Recovered(a.sent); err != nil {
+					log.Warn("Failed to send recovery", "kind", a.sent.Kind, "error", err)
+				}
+			}
+		}
+	}
+	am.seen = make(map[string]bool)
+}
+
+// suppressed returns whether the event is a duplicate of one already sent
+// within the cooldown period.
+func (am *alertManager) suppressed(ev *Event) bool {
+	fp := ev.fingerprint()
+	cooldown, ok := am.cooldowns[ev.Kind]
+	if !ok {
+		cooldown = am.cooldown
+	}
+	if a, ok := am.active[fp]; ok && ev.Time.Sub(a.sent.Time) < cooldown {
+		return true
+	}
+	am.active[fp] = &activeAlert{sent: ev, lastSeen: ev.Time}
+	return false
+}
+
+func (am *alertManager) dispatch(ev *Event) {
+	// An ongoing alert is kept while muted or below the threshold, so it
+	// isn't resolved by the mute
+	fp := ev.fingerprint()
+	am.seen[fp] = true
+	if a := am.active[fp]; a != nil {
+		a.lastSeen = ev.Time
+	}
+	if ev.Severity < am.threshold {
+		return
+	}
+	if am.muted(ev) {
+		ev.Muted = true
+		log.Debug("Alert muted by maintenance window", "kind", ev.Kind, "nodes", ev.Nodes)
+		return
+	}
+	if am.suppressed(ev) {
+		log.Debug("Suppressing duplicate alert", "kind", ev.Kind, "nodes", ev.Nodes)
+		return
+	}
+	if am.passive {
+		return
+	}
+	for _, n := range am.notifiers {
+		if err := n.Notify(ev); err != nil {
+			log.Warn("Failed to send alert", "kind", ev.Kind, "error", err)
+		}
+	}
+}
+
+// emit records an event in the current cycle, and alerts about it
+func (mon *NodeMonitor) emit(kind string, sev Severity, nodes []string, format string, args ...interface{}) {
+	mon.raise(&Event{
+		Time:     time.Now(),
+		Kind:     kind,
+		Severity: sev,
+		Nodes:    nodes,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// emitBlock is like emit, for events about a block on which the nodes have
+// the given hashes: either one each, or all the same one
+func (mon *NodeMonitor) emitBlock(kind string, sev Severity, nodes []string, block uint64, hashes []common.Hash, format string, args ...interface{}) {
+	var hashNodes [][]string
+	switch {
+	case len(hashes) == 1:
+		hashNodes = [][]string{nodes}
+	case len(hashes) == len(nodes):
+		for _, name := range nodes {
+			hashNodes = append(hashNodes, []string{name})
+		}
+	}
+	mon.emitGroups(kind, sev, hashNodes, nodes, block, hashes, format, args...)
+}
+
+// splitEvent returns the split event of groups of nodes which each have one of
+// the hashes, to be raised once the comparisons are done
+func splitEvent(groups [][]string, block uint64, hashes []common.Hash, format string, args ...interface{}) *Event {
+	var nodes []string
+	for _, g := range groups {
+		nodes = append(nodes, g...)
+	}
+	return groupEvent(EventSplit, SeverityWarning, groups, nodes, block, hashes, format, args...)
+}
+
+func (mon *NodeMonitor) emitGroups(kind string, sev Severity, groups [][]string, nodes []string, block uint64, hashes []common.Hash, format string, args ...interface{}) {
+	mon.raise(groupEvent(kind, sev, groups, nodes, block, hashes, format, args...))
+}
+
+func groupEvent(kind string, sev Severity, groups [][]string, nodes []string, block uint64, hashes []common.Hash, format string, args ...interface{}) *Event {
+	return &Event{
+		Time:      time.Now(),
+		Kind:      kind,
+		Severity:  sev,
+		Nodes:     nodes,
+		Message:   fmt.Sprintf(format, args...),
+		Block:     block,
+		Hashes:    hashes,
+		HashNodes: groups,
+	}
+}
+
+func (mon *NodeMonitor) raise(ev *Event) {
+	kind, nodes := ev.Kind, ev.Nodes
+	switch ev.Severity {
+	case SeverityCritical:
+		log.Error("Critical event", "kind", kind, "nodes", nodes, "msg", ev.Message)
+	case SeverityWarning:
+		log.Warn("Event", "kind", kind, "nodes", nodes, "msg", ev.Message)
+	default:
+		log.Info("Event", "kind", kind, "nodes", nodes, "msg", ev.Message)
+	}
+	mon.events = append(mon.events, ev)
+	mon.alerts.dispatch(ev)
+}
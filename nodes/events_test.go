@@ -0,0 +1,366 @@
+package nodes
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingNotifier keeps all events it's notified about
+type recordingNotifier struct {
+	events []*Event
+}
+
+func (n *recordingNotifier) Notify(ev *Event) error {
+	n.events = append(n.events, ev)
+	return nil
+}
+
+func TestAlertDeduplication(t *testing.T) {
+	am, err := newAlertManager(alertsConfig{Cooldown: "10m", Cooldowns: map[string]string{EventSplit: "1m"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := new(recordingNotifier)
+	am.notifiers = []Notifier{rec}
+
+	start := time.Unix(1600000000, 0)
+	cycle := func(offset time.Duration, events ...*Event) {
+		for _, ev := range events {
+			ev.Time = start.Add(offset)
+			am.dispatch(ev)
+		}
+		am.endCycle(start.Add(offset))
+	}
+	unreachable := func() *Event {
+		return &Event{Kind: EventUnreachable, Severity: SeverityWarning, Nodes: []string{"a"}}
+	}
+	split := func(msg string) *Event {
+		return &Event{Kind: EventSplit, Severity: SeverityWarning, Nodes: []string{"b", "a"}, Message: msg}
+	}
+	cycle(0, unreachable(), split("at 1"))
+	cycle(10*time.Second, unreachable(), split("at 2"))
+	if len(rec.events) != 2 {
+		t.Fatalf("expected ongoing events to be suppressed, got %d alerts", len(rec.events))
+	}
+	// The split cooldown is shorter
+	cycle(2*time.Minute, unreachable(), split("at 3"))
+	if len(rec.events) != 3 || rec.events[2].Message != "at 3" {
+		t.Fatalf("expected split to be alerted again after the cooldown, got %v", rec.events)
+	}
+	// Missing a single cycle doesn't resolve the outage
+	cycle(3 * time.Minute)
+	cycle(4*time.Minute, unreachable())
+	if len(rec.events) != 3 {
+		t.Fatalf("expected outage to be ongoing, got %v", rec.events)
+	}
+	// Once resolved, the outage is alerted on right away when it comes back
+	cycle(5 * time.Minute)
+	cycle(6 * time.Minute)
+	cycle(7*time.Minute, unreachable())
+	if len(rec.events) != 4 || rec.events[3].Kind != EventUnreachable {
+		t.Fatalf("expected recurring outage to be alerted, got %v", rec.events)
+	}
+	// Below the threshold, nothing is sent
+	cycle(8*time.Minute, &Event{Kind: EventSlotLag, Severity: SeverityInfo})
+	if len(rec.events) != 4 {
+		t.Errorf("expected info event to be dropped")
+	}
+	if _, err := newAlertManager(alertsConfig{Cooldowns: map[string]string{EventSplit: "soon"}}); err == nil {
+		t.Errorf("expected error for invalid cooldown")
+	}
+}
+
+// recoveryRecorder keeps the events it's told are resolved
+type recoveryRecorder struct {
+	recordingNotifier
+	recovered []*Event
+}
+
+func (n *recoveryRecorder) Recovered(ev *Event) error {
+	n.recovered = append(n.recovered, ev)
+	return nil
+}
+
+func TestAlertResolution(t *testing.T) {
+	am, _ := newAlertManager(alertsConfig{})
+	rec := new(recoveryRecorder)
+	am.notifiers = []Notifier{rec}
+	am.coverInterval(10 * time.Minute)
+
+	start := time.Unix(1600000000, 0)
+	cycle := func(offset time.Duration, events ...*Event) {
+		for _, ev := range events {
+			ev.Time = start.Add(offset)
+			am.dispatch(ev)
+		}
+		am.endCycle(start.Add(offset))
+	}
+	unreachable := func() *Event {
+		return &Event{Kind: EventUnreachable, Severity: SeverityWarning, Nodes: []string{"a"}}
+	}
+	cycle(0, unreachable())
+	// A maintenance window starting during the outage doesn't resolve it
+	am.addMute(&muteWindow{Start: start, End: start.Add(time.Hour)})
+	for i := 1; i <= 3; i++ {
+		cycle(time.Duration(i)*time.Minute, unreachable())
+	}
+	if len(rec.events) != 1 || len(rec.recovered) != 0 {
+		t.Fatalf("expected muted outage to stay ongoing, got %v sent and %v resolved", rec.events, rec.recovered)
+	}
+	// Nor does a check which runs less often than every cycle
+	for i := 4; i <= 10; i++ {
+		cycle(time.Duration(i) * time.Minute)
+	}
+	if len(rec.recovered) != 0 {
+		t.Fatalf("expected outage to be ongoing within the check interval, got %v", rec.recovered)
+	}
+	cycle(14 * time.Minute)
+	if len(rec.recovered) != 1 {
+		t.Fatalf("expected outage to be resolved, got %v", rec.recovered)
+	}
+	// A muted condition was never notified about, so it's not resolved either
+	cycle(15*time.Minute, &Event{Kind: EventUnreachable, Severity: SeverityWarning, Nodes: []string{"b"}})
+	cycle(30 * time.Minute)
+	cycle(45 * time.Minute)
+	if len(rec.events) != 1 || len(rec.recovered) != 1 {
+		t.Errorf("unexpected alerts for muted condition: %v sent, %v resolved", rec.events, rec.recovered)
+	}
+}
+
+func TestMaintenanceWindows(t *testing.T) {
+	now := time.Now()
+	am, err := newAlertManager(alertsConfig{Maintenance: []muteWindow{
+		{Node: "a", Start: now.Add(-time.Hour), End: now.Add(time.Hour)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := new(recordingNotifier)
+	am.notifiers = []Notifier{rec}
+	mon := &NodeMonitor{alerts: am}
+
+	send := func(node string) *Event {
+		ev := &Event{Time: time.Now(), Kind: EventUnreachable, Severity: SeverityCritical, Nodes: []string{node}}
+		am.dispatch(ev)
+		am.endCycle(time.Now())
+		return ev
+	}
+	if ev := send("a"); !ev.Muted || len(rec.events) != 0 {
+		t.Fatalf("expected alert for muted node to be dropped")
+	}
+	if ev := send("b"); ev.Muted || len(rec.events) != 1 {
+		t.Fatalf("expected alert for other node to be sent")
+	}
+	// Mute everything via the API
+	body := fmt.Sprintf(`{"reason": "upgrade", "end": %q}`, now.Add(time.Hour).Format(time.RFC3339))
+	w := httptest.NewRecorder()
+	mon.HandleMute(w, httptest.NewRequest("POST", "/api/mute", strings.NewReader(body)))
+	var windows []*muteWindow
+	if err := json.NewDecoder(w.Body).Decode(&windows); err != nil || len(windows) != 2 {
+		t.Fatalf("expected 2 windows, got %v (%v)", windows, err)
+	}
+	if ev := send("c"); !ev.Muted {
+		t.Errorf("expected alert to be muted globally")
+	}
+	w = httptest.NewRecorder()
+	mon.HandleMute(w, httptest.NewRequest("DELETE", "/api/mute", nil))
+	if ev := send("c"); ev.Muted || len(rec.events) != 2 {
+		t.Errorf("expected alert to be sent after unmuting")
+	}
+	w = httptest.NewRecorder()
+	mon.HandleMute(w, httptest.NewRequest("POST", "/api/mute", strings.NewReader(`{"node": "a"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected window without end to be rejected, got %d", w.Code)
+	}
+}
+
+func TestHeadRegression(t *testing.T) {
+	chain := makeChain("a", 100, nil)
+	node := newTestNode("a", 99, chain)
+	mon, _ := NewMonitor([]Node{node}, nil, nil, &Config{ReloadInterval: "1s"})
+	regressions := func() []*Event {
+		var evs []*Event
+		for _, ev := range mon.Report().Events {
+			if ev.Kind == EventHeadRegression {
+				evs = append(evs, ev)
+			}
+		}
+		return evs
+	}
+	if evs := regressions(); len(evs) != 0 {
+		t.Fatalf("unexpected regression: %v", evs[0].Message)
+	}
+	// Resyncing from scratch
+	node.head = 10
+	mon.doChecks()
+	evs := regressions()
+	if len(evs) != 1 || evs[0].Block != 10 || evs[0].Message != "Head went backwards from 99 to 10" {
+		t.Fatalf("unexpected events: %v", evs)
+	}
+	// Advancing again is fine
+	node.head = 11
+	mon.doChecks()
+	if evs := regressions(); len(evs) != 0 {
+		t.Errorf("unexpected regression: %v", evs[0].Message)
+	}
+}
+
+func TestHeadStalls(t *testing.T) {
+	chain := makeChain("a", 100, nil)
+	a, b := newTestNode("a", 50, chain), newTestNode("b", 50, chain)
+	conf := &Config{ReloadInterval: "1s", Stall: stallConfig{Node: "1m", Chain: "5m"}}
+	mon, err := NewMonitor([]Node{a, b}, nil, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	check := func(after time.Duration) map[string][]string {
+		mon.events = nil
+		mon.checkHeads([]Node{a, b}, now.Add(after))
+		kinds := make(map[string][]string)
+		for _, ev := range mon.events {
+			kinds[ev.Kind] = append(kinds[ev.Kind], ev.Nodes...)
+		}
+		return kinds
+	}
+	// b keeps advancing, a is stuck
+	for i := 1; i <= 3; i++ {
+		b.head++
+		kinds := check(time.Duration(i) * 30 * time.Second)
+		if i >= 2 && (len(kinds[EventHeadStall]) != 1 || kinds[EventHeadStall][0] != a.Name()) {
+			t.Errorf("%d: expected a to stall, got %v", i, kinds)
+		}
+		if len(kinds[EventChainHalt]) > 0 {
+			t.Errorf("%d: unexpected chain halt", i)
+		}
+	}
+	// Nothing advances anymore
+	kinds := check(7 * time.Minute)
+	if len(kinds[EventHeadStall]) != 2 || len(kinds[EventChainHalt]) != 2 {
+		t.Errorf("expected both nodes stalled and the chain halted, got %v", kinds)
+	}
+	if _, err := newHeadTracker(stallConfig{Node: "soon"}); err == nil {
+		t.Error("expected error for invalid timeout")
+	}
+}
+
+func TestStaleHeads(t *testing.T) {
+	now := time.Now()
+	chain := makeChain("a", 10, nil)
+	for i, bl := range chain {
+		bl.time = uint64(now.Add(time.Duration(i-9) * 12 * time.Second).Unix())
+	}
+	fresh, stale := newTestNode("fresh", 9, chain), newTestNode("stale", 2, chain)
+	conf := &Config{ReloadInterval: "1s", Stall: stallConfig{HeadAge: "1m"}}
+	mon, err := NewMonitor([]Node{fresh, stale}, nil, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for _, ev := range mon.Report().Events {
+		if ev.Kind == EventStaleHead {
+			found = append(found, ev.Nodes...)
+		}
+	}
+	if len(found) != 1 || found[0] != stale.Name() {
+		t.Errorf("expected only the stale node to be reported, got %v", found)
+	}
+}
+
+func TestBlockTimes(t *testing.T) {
+	chain := makeChain("a", 30, nil)
+	var ts uint64 = 1000
+	for i, bl := range chain {
+		bl.time = ts
+		if i == 20 {
+			ts += 60
+		} else {
+			ts += 12
+		}
+	}
+	var (
+		node = newTestNode("a", 0, chain)
+		bt   = new(blockTimes)
+	)
+	if st := bt.stats(); st != nil {
+		t.Errorf("expected no stats before blocks are seen, got %+v", st)
+	}
+	// Jumping back doesn't count as a block
+	for _, head := range []int{0, 5, 20, 4, 20, 21} {
+		node.head = head
+		bt.observe([]Node{node})
+	}
+	st := bt.stats()
+	if st == nil {
+		t.Fatal("expected stats")
+	}
+	if st.Samples != 21 {
+		t.Errorf("expected 21 samples, got %d", st.Samples)
+	}
+	if want := 300.0 / 21; math.Abs(st.Average-want) > 1e-9 {
+		t.Errorf("expected average %v, got %v", want, st.Average)
+	}
+	if st.Median != 12 || st.P95 != 12 {
+		t.Errorf("expected median and p95 of 12s, got %v and %v", st.Median, st.P95)
+	}
+	// Only the window is kept
+	long := makeChain("b", 2*blockTimeWindow, nil)
+	for i, bl := range long {
+		bl.time = uint64(1000 + 12*i)
+	}
+	node = newTestNode("b", 0, long)
+	bt = new(blockTimes)
+	bt.observe([]Node{node})
+	node.head = len(long) - 1
+	bt.observe([]Node{node})
+	if st := bt.stats(); st.Samples != blockTimeWindow || st.Average != 12 {
+		t.Errorf("expected %d samples of 12s, got %+v", blockTimeWindow, st)
+	}
+}
+
+func TestFeeTracker(t *testing.T) {
+	chain := makeChain("a", 10, nil)
+	for i, bl := range chain {
+		bl.gasLimit = 1000
+		bl.gasUsed = uint64(100 * i)
+		bl.baseFee = big.NewInt(int64(1000 + 100*i))
+	}
+	var (
+		node = newTestNode("a", 0, chain)
+		ft   = new(feeTracker)
+	)
+	// A head seen again is only sampled once
+	for _, head := range []int{2, 2, 4, 3, 6} {
+		node.head = head
+		ft.observe([]Node{node})
+	}
+	fj := ft.report()
+	if fj == nil {
+		t.Fatal("expected fee report")
+	}
+	if len(fj.Recent) != 3 {
+		t.Fatalf("expected 3 samples, got %d", len(fj.Recent))
+	}
+	if fj.BaseFee != 1600 || fj.GasUsedRatio != 0.6 {
+		t.Errorf("wrong latest values: %+v", fj)
+	}
+	if fj.BaseFeeChange != 100*(1600.0-1200)/1200 {
+		t.Errorf("wrong base fee change: %v", fj.BaseFeeChange)
+	}
+	if math.Abs(fj.AvgGasUsedRatio-0.4) > 1e-9 {
+		t.Errorf("wrong average gas usage: %v", fj.AvgGasUsedRatio)
+	}
+	// Blocks without gas info aren't sampled
+	ft = new(feeTracker)
+	ft.observe([]Node{newTestNode("b", 5, makeChain("b", 10, nil))})
+	if ft.report() != nil {
+		t.Error("expected no samples for blocks without gas info")
+	}
+}
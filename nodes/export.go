@@ -0,0 +1,105 @@
+package nodes
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Exporter is implemented by backends which receive the report after every
+// check cycle.
+type Exporter interface {
+	Export(r *Report) error
+}
+
+// artifactStore is implemented by the stores which keep a copy of the files
+// written by the monitor, such as the split artifacts
+type artifactStore interface {
+	put(key string, data []byte)
+}
+
+// artifactStores puts the files into all of the stores
+type artifactStores []artifactStore
+
+func (s artifactStores) put(key string, data []byte) {
+	for _, store := range s {
+		store.put(key, data)
+	}
+}
+
+// influxExporter pushes per-node and per-chain data to InfluxDB, using the
+// v2 write API and line protocol.
+type influxExporter struct {
+	client      *http.Client
+	writeUrl    string
+	token       string
+	measurement string
+}
+
+func newInfluxExporter(conf influxConfig) *influxExporter {
+	q := url.Values{}
+	q.Set("org", conf.Org)
+	q.Set("bucket", conf.Bucket)
+	q.Set("precision", "s")
+	measurement := conf.Measurement
+	if len(measurement) == 0 {
+		measurement = "nodemonitor"
+	}
+	return &influxExporter{
+		client:      &http.Client{Timeout: 10 * time.Second},
+		writeUrl:    fmt.Sprintf("%v/api/v2/write?%v", strings.TrimSuffix(conf.Url, "/"), q.Encode()),
+		token:       conf.Token,
+		measurement: measurement,
+	}
+}
+
+// lines returns the report in influx line protocol
+func (e *influxExporter) lines(r *Report, now time.Time) []byte {
+	var buf bytes.Buffer
+	ts := now.Unix()
+	for _, c := range r.Cols {
+		var extra strings.Builder
+		if c.Health != nil {
+			fmt.Fprintf(&extra, ",health=%di", c.Health.Score)
+		}
+		for _, name := range sortedMetrics(c.Metrics) {
+			fmt.Fprintf(&extra, ",metric_%v=%v", influxEscape(name), c.Metrics[name])
+		}
+		fmt.Fprintf(&buf, "%v_node,node=%v%v head=%di,lag=%di,status=%di,latency_ms=%di%v %d\n",
+			e.measurement, influxEscape(c.Name), influxTags(withNetwork(c.Labels, r.Network)), c.Head, c.Lag, c.Status,
+			c.Latency.Milliseconds(), extra.String(), ts)
+	}
+	fmt.Fprintf(&buf, "%v_chain%v split_depth=%di %d\n", e.measurement,
+		influxTags(withNetwork(nil, r.Network)), r.SplitDepth, ts)
+	return buf.Bytes()
+}
+
+func (e *influxExporter) Export(r *Report) error {
+	req, err := http.NewRequest("POST", e.writeUrl, bytes.NewReader(e.lines(r, time.Now())))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if len(e.token) > 0 {
+		req.Header.Set("Authorization", "Token "+e.token)
+	}
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("influx write failed: %v: %s", res.Status, body)
+	}
+	return nil
+}
+
+// influxEscape escapes tag keys and values as required by the line protocol
+func influxEscape(s string) string {
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(s)
+}
@@ -0,0 +1,290 @@
+package nodes
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+func TestInfluxExport(t *testing.T) {
+	r := NewReport(nil)
+	r.Cols = []*clientJson{
+		{Name: "geth eu", Head: 100, Latency: 20 * time.Millisecond},
+		{Name: "besu", Head: 98, Lag: 2, Status: NodeStatusUnreachable,
+			Labels: map[string]string{"region": "us", "client": "besu"}},
+	}
+	r.SplitDepth = 3
+
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if have := req.Header.Get("Authorization"); have != "Token secret" {
+			t.Errorf("wrong auth header: %q", have)
+		}
+		if have := req.URL.Query().Get("bucket"); have != "monitoring" {
+			t.Errorf("wrong bucket: %q", have)
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		got = string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	exp := newInfluxExporter(influxConfig{Url: srv.URL, Token: "secret", Org: "org", Bucket: "monitoring"})
+	if err := exp.Export(r); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "nodemonitor_chain split_depth=3i") {
+		t.Errorf("unexpected body: %v", got)
+	}
+	lines := string(exp.lines(r, time.Unix(1600000000, 0)))
+	wantLines := `nodemonitor_node,node=geth\ eu head=100i,lag=0i,status=0i,latency_ms=20i 1600000000
+nodemonitor_node,node=besu,client=besu,region=us head=98i,lag=2i,status=1i,latency_ms=0i 1600000000
+nodemonitor_chain split_depth=3i 1600000000
+`
+	if lines != wantLines {
+		t.Errorf("got\n%v\nwant\n%v", lines, wantLines)
+	}
+}
+
+func TestReportRendering(t *testing.T) {
+	r := NewReport([]int{10, 11})
+	r.Cols = []*clientJson{
+		{Name: "geth", Version: "Geth/v1.9.22", Head: 11},
+		{Name: "besu", Head: 11, Status: NodeStatusUnreachable},
+	}
+	r.Rows[10] = []string{"0xaaaa", "0xaaaa"}
+	r.Rows[11] = []string{"0xbbbb", "0xcccc"}
+	r.SplitDepth = 1
+	r.Events = []*Event{{Kind: EventSplit, Severity: SeverityCritical, Nodes: []string{"geth", "besu"}, Message: "<split>"}}
+	r.BlockTime = &blockTimeJson{Average: 12.25, Median: 12, P95: 24, Samples: 100}
+	r.Fees = &feeJson{BaseFee: 1500, BaseFeeChange: -25, GasUsedRatio: 0.5, AvgGasUsedRatio: 0.45, Recent: make([]feeSample, 4)}
+
+	data, err := r.render("csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "number,geth,besu\nversion,Geth/v1.9.22,\nstatus,ok,unreachable\nhead,11,11\nlag,0,0\nfinalized,0,0\n10,0xaaaa,0xaaaa\n11,0xbbbb,0xcccc\n"
+	if string(data) != want {
+		t.Errorf("unexpected csv:\n%s", data)
+	}
+
+	data, err = r.render("html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(data)
+	for _, want := range []string{
+		"split, 1 blocks deep",
+		`<td class="unreachable">unreachable</td>`,
+		`<td class="diff" title="0xcccc">0xcccc</td>`,
+		"&lt;split&gt;",
+		"last 100 blocks: average 12.2s, median 12.0s, p95 24.0s",
+		"Base fee 1500 wei (-25.0% over the last 4 heads), gas used 50% (average 45%)",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("html report missing %q", want)
+		}
+	}
+	if _, err := r.render("xml"); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
+
+// protoFields splits a protobuf message into its length-delimited fields, and
+// the raw values of the others, by field number
+func protoFields(t *testing.T, data []byte) map[int][][]byte {
+	t.Helper()
+	fields := make(map[int][][]byte)
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		data = data[n:]
+		var val []byte
+		switch key & 7 {
+		case 0:
+			_, n := binary.Uvarint(data)
+			val, data = data[:n], data[n:]
+		case 1:
+			val, data = data[:8], data[8:]
+		case 2:
+			l, n := binary.Uvarint(data)
+			val, data = data[n:n+int(l)], data[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields[int(key>>3)] = append(fields[int(key>>3)], val)
+	}
+	return fields
+}
+
+func TestRemoteWriteExport(t *testing.T) {
+	r := NewReport(nil)
+	r.Cols = []*clientJson{
+		{Name: "geth", Head: 100, Labels: map[string]string{"region": "eu", "client-name": "geth"}},
+	}
+	r.SplitDepth = 3
+
+	var series []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Content-Encoding") != "snappy" || req.Header.Get("X-Scope-OrgID") != "nodes" {
+			t.Errorf("wrong headers: %v", req.Header)
+		}
+		if user, pass, _ := req.BasicAuth(); user != "metrics" || pass != "secret" {
+			t.Errorf("wrong auth: %v %v", user, pass)
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		data, err := snappy.Decode(nil, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ts := range protoFields(t, data)[1] {
+			fields := protoFields(t, ts)
+			var labels []string
+			for _, l := range fields[1] {
+				lf := protoFields(t, l)
+				labels = append(labels, fmt.Sprintf("%s=%s", lf[1][0], lf[2][0]))
+			}
+			sample := protoFields(t, fields[2][0])
+			value := math.Float64frombits(binary.LittleEndian.Uint64(sample[1][0]))
+			series = append(series, fmt.Sprintf("%v %v", strings.Join(labels, ","), value))
+		}
+	}))
+	defer srv.Close()
+
+	exp := newRemoteWriteExporter(remoteWriteConfig{Url: srv.URL, Username: "metrics", Password: "secret",
+		Headers: map[string]string{"X-Scope-OrgID": "nodes"}})
+	if err := exp.Export(r); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"__name__=nodemonitor_node_head,client_name=geth,job=nodemonitor,node=geth,region=eu 100",
+		"__name__=nodemonitor_node_lag,client_name=geth,job=nodemonitor,node=geth,region=eu 0",
+		"__name__=nodemonitor_node_status,client_name=geth,job=nodemonitor,node=geth,region=eu 0",
+		"__name__=nodemonitor_node_latency_seconds,client_name=geth,job=nodemonitor,node=geth,region=eu 0",
+		"__name__=nodemonitor_chain_split_depth,job=nodemonitor 3",
+	}
+	if have := strings.Join(series, "\n"); have != strings.Join(want, "\n") {
+		t.Errorf("got\n%v\nwant\n%v", have, strings.Join(want, "\n"))
+	}
+}
+
+func TestStatsdExport(t *testing.T) {
+	r := NewReport(nil)
+	r.Cols = []*clientJson{
+		{Name: "geth eu", Head: 100, Latency: 20 * time.Millisecond, Labels: map[string]string{"region": "eu"}},
+	}
+	r.SplitDepth = 3
+	r.Events = []*Event{{Kind: EventSplit}, {Kind: EventUnreachable}, {Kind: EventSplit}}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, tags := range []bool{true, false} {
+		exp, err := newStatsdExporter(statsdConfig{Address: conn.LocalAddr().String(), Tags: tags})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := exp.Export(r); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, statsdMaxPacket)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := `nodemonitor.node.head:100|g|#node:geth_eu,region:eu
+nodemonitor.node.lag:0|g|#node:geth_eu,region:eu
+nodemonitor.node.status:0|g|#node:geth_eu,region:eu
+nodemonitor.node.latency:20|ms|#node:geth_eu,region:eu
+nodemonitor.chain.split_depth:3|g
+nodemonitor.events:2|c|#kind:split
+nodemonitor.events:1|c|#kind:unreachable`
+		if !tags {
+			want = `nodemonitor.node.geth_eu.head:100|g
+nodemonitor.node.geth_eu.lag:0|g
+nodemonitor.node.geth_eu.status:0|g
+nodemonitor.node.geth_eu.latency:20|ms
+nodemonitor.chain.split_depth:3|g
+nodemonitor.events.split:2|c
+nodemonitor.events.unreachable:1|c`
+		}
+		if have := string(buf[:n]); have != want {
+			t.Errorf("tags %v: got\n%v\nwant\n%v", tags, have, want)
+		}
+	}
+}
+
+func TestNodeMetricsScrape(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `# HELP go_goroutines Number of goroutines
+# TYPE go_goroutines gauge
+go_goroutines 42
+process_resident_memory_bytes 1.5e+09 1600000000000
+node_filesystem_avail_bytes{device="sda1",mountpoint="/"} 1000
+node_filesystem_avail_bytes{device="sdb1",mountpoint="/data"} 2000
+node_filesystem_avail_bytes{device="sdb2",mountpoint="/data"} 500
+eth_db_chaindata_disk_size 3e+11
+`)
+	}))
+	defer srv.Close()
+
+	conf := scrapeConfig{Series: map[string]string{
+		"goroutines": "go_goroutines",
+		"memory":     "process_resident_memory_bytes",
+		"data_free":  `node_filesystem_avail_bytes{mountpoint="/data"}`,
+	}}
+	clients := []ClientInfo{
+		{Name: "geth", MetricsUrl: srv.URL, MetricsSeries: map[string]string{"chaindata": "eth_db_chaindata_disk_size"}},
+		{Name: "besu"},
+	}
+	s, err := newMetricsScraper(conf, clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s.scrapeAll(now)
+	r := NewReport(nil)
+	r.Cols = []*clientJson{{Name: "geth"}, {Name: "besu"}}
+	r.setNodeMetrics(s)
+	want := map[string]float64{"goroutines": 42, "memory": 1.5e9, "data_free": 2500, "chaindata": 3e11}
+	if have := r.Cols[0].Metrics; fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("wrong metrics: %v, want %v", have, want)
+	}
+	if r.Cols[1].Metrics != nil {
+		t.Errorf("unexpected metrics: %v", r.Cols[1].Metrics)
+	}
+	lines := string(newInfluxExporter(influxConfig{}).lines(r, time.Unix(1600000000, 0)))
+	if !strings.Contains(lines, "latency_ms=0i,metric_chaindata=3e+11,metric_data_free=2500,metric_goroutines=42,metric_memory=1.5e+09 ") {
+		t.Errorf("metrics missing from influx lines:\n%v", lines)
+	}
+	// Not scraped again within the interval
+	srv.Close()
+	s.scrapeAll(now.Add(time.Second))
+	if _, ok := s.values["geth"]; !ok {
+		t.Error("values dropped within the interval")
+	}
+	s.scrapeAll(now.Add(time.Minute))
+	if _, ok := s.values["geth"]; ok {
+		t.Error("values kept after a failed scrape")
+	}
+	if _, err := newMetricsScraper(scrapeConfig{}, clients[:1]); err != nil {
+		t.Errorf("node series alone should do: %v", err)
+	}
+	if _, err := newMetricsScraper(scrapeConfig{}, []ClientInfo{{Name: "geth", MetricsUrl: srv.URL}}); err == nil {
+		t.Error("expected error without series")
+	}
+	if _, err := parseSelector(`disk{mountpoint="/}`); err == nil {
+		t.Error("expected error for unterminated label")
+	}
+}
@@ -0,0 +1,383 @@
+package nodes
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// federationMaxReport is the max size of a report pushed by an origin
+const federationMaxReport = 16 * 1024 * 1024
+
+type federationConfig struct {
+	// Upstream is the federation endpoint of a central monitor, e.g.
+	// https://central.example.org/api/federation, which every report is
+	// pushed to
+	Upstream string
+	// Token is the bearer token sent to the upstream monitor
+	Token string
+	// Origins are the remote monitors whose reports the central monitor
+	// accepts
+	Origins []federationOrigin
+	// MaxAge is how long the report of an origin is part of the merged view
+	// after it was received. Defaults to 5m.
+	MaxAge string
+}
+
+type federationOrigin struct {
+	// Name is the origin the reports are attributed to, e.g. eu-west
+	Name string
+	// Token is the bearer token the origin authenticates with
+	Token string
+	// PublicKey, if set, is the hex-encoded key the reports must be signed
+	// with
+	PublicKey string
+}
+
+// federationPusher pushes the reports to a central monitor
+type federationPusher struct {
+	url    string
+	token  string
+	client *http.Client
+	signer *reportSigner // nil if signing is disabled
+}
+
+// newFederationPusher returns nil if there's no upstream monitor
+func newFederationPusher(conf federationConfig) (*federationPusher, error) {
+	if len(conf.Upstream) == 0 {
+		return nil, nil
+	}
+	if _, err := url.ParseRequestURI(conf.Upstream); err != nil {
+		return nil, fmt.Errorf("invalid federation upstream: %v", err)
+	}
+	return &federationPusher{
+		url:    conf.Upstream,
+		token:  conf.Token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Export pushes the report, signed if a signing key is configured
+func (p *federationPusher) Export(r *Report) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", p.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(p.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	if p.signer != nil {
+		sig := p.signer.sign(data)
+		req.Header.Set("X-Monitor-Identity", sig.Identity)
+		req.Header.Set("X-Monitor-Key", sig.PublicKey)
+		req.Header.Set("X-Signature", sig.Signature)
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("federation push failed: %v: %s", res.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// originReport is the latest report of an origin
+type originReport struct {
+	report   *Report
+	received time.Time
+}
+
+// federation collects the reports pushed by the origins
+type federation struct {
+	origins []federationOrigin
+	maxAge  time.Duration
+	local   string // name of the local monitor in the view
+
+	mu      sync.Mutex
+	reports map[string]*originReport // by origin
+}
+
+// newFederation returns nil if no origins are configured. The local monitor
+// is named by its identity in the view.
+func newFederation(conf federationConfig, identity string) (*federation, error) {
+	if len(conf.Origins) == 0 {
+		return nil, nil
+	}
+	f := &federation{
+		origins: conf.Origins,
+		maxAge:  5 * time.Minute,
+		local:   identity,
+		reports: make(map[string]*originReport),
+	}
+	if len(f.local) == 0 {
+		f.local = "local"
+	}
+	if len(conf.MaxAge) > 0 {
+		d, err := time.ParseDuration(conf.MaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("invalid federation max age: %v", err)
+		}
+		f.maxAge = d
+	}
+	names, tokens := make(map[string]bool), make(map[string]bool)
+	for _, o := range conf.Origins {
+		if len(o.Name) == 0 || len(o.Token) == 0 {
+			return nil, errors.New("federation origin without name or token")
+		}
+		if names[o.Name] || tokens[o.Token] {
+			return nil, fmt.Errorf("duplicate federation origin %v", o.Name)
+		}
+		if o.Name == f.local {
+			return nil, fmt.Errorf("federation origin %v has the name of the local monitor", o.Name)
+		}
+		names[o.Name], tokens[o.Token] = true, true
+	}
+	return f, nil
+}
+
+// origin returns the origin authenticating with the bearer token, or nil
+func (f *federation) origin(r *http.Request) *federationOrigin {
+	have := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	for i, o := range f.origins {
+		if subtle.ConstantTimeCompare([]byte(have), []byte(o.Token)) == 1 {
+			return &f.origins[i]
+		}
+	}
+	return nil
+}
+
+// receive stores the report pushed by the origin
+func (f *federation) receive(o *federationOrigin, data []byte, sig *signatureJson, now time.Time) error {
+	if len(o.PublicKey) > 0 {
+		if _, err := verifySignature(data, sig, o.PublicKey); err != nil {
+			return err
+		}
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return fmt.Errorf("invalid report: %v", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reports[o.Name] = &originReport{report: &r, received: now}
+	return nil
+}
+
+// stale returns the origins whose last report is older than the max age, or
+// which never reported
+func (f *federation) stale(now time.Time) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for _, o := range f.origins {
+		if or := f.reports[o.Name]; or == nil || now.Sub(or.received) > f.maxAge {
+			names = append(names, o.Name)
+		}
+	}
+	return names
+}
+
+// originJson summarizes the last report of an origin
+type originJson struct {
+	Origin   string
+	Monitor  string `json:",omitempty"`
+	Network  string `json:",omitempty"`
+	Received time.Time
+	// Stale is set if the report is older than the max age, in which case
+	// its nodes aren't part of the merged view
+	Stale       bool `json:",omitempty"`
+	Nodes       int
+	Unreachable int
+	SplitDepth  int64
+	Events      int
+}
+
+// federatedNodeJson is a node as reported by an origin
+type federatedNodeJson struct {
+	Origin  string
+	Name    string
+	Network string `json:",omitempty"`
+	Status  int
+	Head    uint64
+	Hash    common.Hash `json:",omitempty"`
+	// Lag is the number of blocks behind the highest head of the network,
+	// across all origins
+	Lag uint64
+}
+
+// headDisagreementJson is a block number at which nodes report different
+// head hashes
+type headDisagreementJson struct {
+	Network string `json:",omitempty"`
+	Number  uint64
+	// Nodes are the nodes, as origin/name, by the hash of their head
+	Nodes map[common.Hash][]string
+}
+
+// federatedViewJson is the merged view of the reports of all origins
+type federatedViewJson struct {
+	Generated     time.Time
+	Origins       []*originJson
+	Nodes         []*federatedNodeJson
+	Disagreements []*headDisagreementJson `json:",omitempty"`
+}
+
+// view merges the last reports of the origins, and the local one if given,
+// attributing every node to its origin
+func (f *federation) view(local *Report, localName string, now time.Time) *federatedViewJson {
+	reports := make(map[string]*originReport)
+	var names []string
+	f.mu.Lock()
+	for name, or := range f.reports {
+		reports[name] = or
+		names = append(names, name)
+	}
+	f.mu.Unlock()
+	sort.Strings(names)
+	if local != nil {
+		reports[localName] = &originReport{report: local, received: local.Generated}
+		names = append([]string{localName}, names...)
+	}
+	v := &federatedViewJson{Generated: now}
+	highest := make(map[string]uint64) // by network
+	for _, name := range names {
+		or := reports[name]
+		r := or.report
+		oj := &originJson{
+			Origin:     name,
+			Monitor:    r.Monitor,
+			Network:    r.Network,
+			Received:   or.received,
+			Stale:      name != localName && now.Sub(or.received) > f.maxAge,
+			Nodes:      len(r.Cols),
+			SplitDepth: r.SplitDepth,
+			Events:     len(r.Events),
+		}
+		v.Origins = append(v.Origins, oj)
+		for _, c := range r.Cols {
+			if c.Status != NodeStatusOK {
+				oj.Unreachable++
+			}
+			if oj.Stale {
+				continue
+			}
+			fn := &federatedNodeJson{Origin: name, Name: c.Name, Network: r.Network, Status: c.Status, Head: c.Head}
+			if c.HeadBlock != nil {
+				fn.Hash = c.HeadBlock.Hash
+			}
+			v.Nodes = append(v.Nodes, fn)
+			if c.Status == NodeStatusOK && c.Head > highest[r.Network] {
+				highest[r.Network] = c.Head
+			}
+		}
+	}
+	type height struct {
+		network string
+		number  uint64
+	}
+	heads := make(map[height]map[common.Hash][]string)
+	var heights []height
+	for _, fn := range v.Nodes {
+		if fn.Status != NodeStatusOK {
+			continue
+		}
+		fn.Lag = highest[fn.Network] - fn.Head
+		if fn.Hash == (common.Hash{}) {
+			continue
+		}
+		h := height{fn.Network, fn.Head}
+		if heads[h] == nil {
+			heads[h] = make(map[common.Hash][]string)
+			heights = append(heights, h)
+		}
+		heads[h][fn.Hash] = append(heads[h][fn.Hash], fn.Origin+"/"+fn.Name)
+	}
+	for _, h := range heights {
+		if len(heads[h]) > 1 {
+			v.Disagreements = append(v.Disagreements, &headDisagreementJson{Network: h.network, Number: h.number, Nodes: heads[h]})
+		}
+	}
+	sort.Slice(v.Disagreements, func(i, j int) bool {
+		a, b := v.Disagreements[i], v.Disagreements[j]
+		if a.Network != b.Network {
+			return a.Network < b.Network
+		}
+		return a.Number > b.Number
+	})
+	return v
+}
+
+// checkFederation warns about origins which stopped reporting
+func (mon *NodeMonitor) checkFederation() {
+	if mon.federation == nil {
+		return
+	}
+	for _, name := range mon.federation.stale(time.Now()) {
+		mon.emit(EventOriginStale, SeverityWarning, []string{name},
+			"No report received from the origin within %v", mon.federation.maxAge)
+	}
+}
+
+// HandleFederation serves the federation API: origins POST their reports to
+// /api/federation, authenticated by their token, and GET returns the merged
+// view of the reports of all origins and this monitor.
+func (mon *NodeMonitor) HandleFederation(w http.ResponseWriter, r *http.Request) {
+	f := mon.federation
+	if f == nil {
+		http.Error(w, "federation not enabled", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "POST":
+		o := f.origin(r)
+		if o == nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, federationMaxReport))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sig := &signatureJson{
+			Identity:  r.Header.Get("X-Monitor-Identity"),
+			PublicKey: r.Header.Get("X-Monitor-Key"),
+			Signature: r.Header.Get("X-Signature"),
+		}
+		if err := f.receive(o, data, sig, time.Now()); err != nil {
+			log.Warn("Rejected federated report", "origin", o.Name, "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case "GET":
+		local := mon.Report()
+		if local != nil && len(local.Cols) == 0 {
+			local = nil
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(f.view(local, f.local, time.Now()))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,108 @@
+package nodes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestFederation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodemonitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	signer, err := newReportSigner(signingConfig{KeyFile: filepath.Join(dir, "signing.key")}, "eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mon, err := NewMonitor(nil, nil, nil, &Config{ReloadInterval: "1s", Identity: "central"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mon.federation, err = newFederation(federationConfig{Origins: []federationOrigin{
+		{Name: "eu", Token: "eu-token", PublicKey: signer.publicKey()},
+		{Name: "us", Token: "us-token"},
+		{Name: "asia", Token: "asia-token"},
+	}}, "central")
+	if err != nil {
+		t.Fatal(err)
+	}
+	central := httptest.NewServer(http.HandlerFunc(mon.HandleFederation))
+	defer central.Close()
+
+	push := func(token string, signer *reportSigner, cols ...*clientJson) error {
+		p, err := newFederationPusher(federationConfig{Upstream: central.URL, Token: token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.signer = signer
+		return p.Export(&Report{Cols: cols, Monitor: "remote"})
+	}
+	node := func(name string, head uint64, hash byte) *clientJson {
+		return &clientJson{Name: name, Status: NodeStatusOK, Head: head, HeadBlock: &headBlockJson{Hash: common.Hash{hash}}}
+	}
+	if err := push("wrong", nil, node("geth", 100, 1)); err == nil {
+		t.Error("report accepted with wrong token")
+	}
+	if err := push("eu-token", nil, node("geth", 100, 1)); err == nil {
+		t.Error("unsigned report accepted from origin with a key")
+	}
+	if err := push("eu-token", signer, node("geth", 100, 1), node("besu", 98, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := push("us-token", nil, node("geth", 100, 3)); err != nil {
+		t.Fatal(err)
+	}
+	mon.lastReport = &Report{Cols: []*clientJson{node("nethermind", 101, 4)}}
+
+	res, err := http.Get(central.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var v federatedViewJson
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Origins) != 3 || v.Origins[0].Origin != "central" || v.Origins[1].Origin != "eu" || v.Origins[2].Monitor != "remote" {
+		t.Fatalf("wrong origins: %+v", v.Origins)
+	}
+	if len(v.Nodes) != 4 || v.Nodes[1].Origin != "eu" || v.Nodes[1].Lag != 1 || v.Nodes[2].Lag != 3 {
+		t.Fatalf("wrong nodes: %+v", v.Nodes)
+	}
+	if len(v.Disagreements) != 1 || v.Disagreements[0].Number != 100 || len(v.Disagreements[0].Nodes) != 2 {
+		t.Errorf("wrong disagreements: %+v", v.Disagreements)
+	}
+	if have := mon.federation.stale(time.Now()); len(have) != 1 || have[0] != "asia" {
+		t.Errorf("wrong stale origins: %v", have)
+	}
+	// Reports past the max age are left out of the merged view
+	v = *mon.federation.view(nil, "central", time.Now().Add(10*time.Minute))
+	if len(v.Nodes) != 0 || !v.Origins[0].Stale {
+		t.Errorf("stale reports in merged view: %+v", v)
+	}
+}
+
+func TestFederationLocalName(t *testing.T) {
+	origins := []federationOrigin{{Name: "eu", Token: "eu-token"}, {Name: "central", Token: "central-token"}}
+	// An origin named like the local monitor would replace it in the view
+	if _, err := newFederation(federationConfig{Origins: origins}, "central"); err == nil {
+		t.Error("origin with the name of the local monitor accepted")
+	}
+	if _, err := newFederation(federationConfig{Origins: origins}, "eu-central"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// Without an identity, the local monitor is named "local"
+	local := []federationOrigin{{Name: "local", Token: "token"}}
+	if _, err := newFederation(federationConfig{Origins: local}, ""); err == nil {
+		t.Error("origin named local accepted without an identity")
+	}
+}
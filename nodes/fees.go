@@ -0,0 +1,77 @@
+package nodes
+
+// feeWindow is the number of head blocks the fee trends are computed over
+const feeWindow = 64
+
+// feeSample is the base fee and gas usage of a head block
+type feeSample struct {
+	Number uint64
+	// BaseFee is the base fee per gas in wei, zero before london
+	BaseFee uint64
+	// GasUsedRatio is the share of the gas limit used by the block
+	GasUsedRatio float64
+}
+
+// feeJson holds the fee trends over the recent head blocks
+type feeJson struct {
+	BaseFee      uint64
+	GasUsedRatio float64
+	// BaseFeeChange is the change of the base fee over the window, in percent
+	BaseFeeChange float64
+	// AvgGasUsedRatio is the average gas usage over the window
+	AvgGasUsedRatio float64
+	Recent          []feeSample // oldest first
+}
+
+// feeTracker records the base fee and gas usage of each new head block
+type feeTracker struct {
+	samples []feeSample
+}
+
+// observe samples the block at the highest head of the nodes, if it's new
+func (ft *feeTracker) observe(active []Node) {
+	head := headBlock(active)
+	if head == nil || head.gasLimit == 0 {
+		return
+	}
+	if n := len(ft.samples); n > 0 && head.num <= ft.samples[n-1].Number {
+		return
+	}
+	s := feeSample{
+		Number:       head.num,
+		GasUsedRatio: float64(head.gasUsed) / float64(head.gasLimit),
+	}
+	if head.baseFee != nil && head.baseFee.IsUint64() {
+		s.BaseFee = head.baseFee.Uint64()
+	}
+	ft.samples = append(ft.samples, s)
+	if len(ft.samples) > feeWindow {
+		ft.samples = append([]feeSample{}, ft.samples[len(ft.samples)-feeWindow:]...)
+	}
+}
+
+// report returns the trends over the window, or nil if no head was sampled
+// yet.
+func (ft *feeTracker) report() *feeJson {
+	if len(ft.samples) == 0 {
+		return nil
+	}
+	var (
+		first = ft.samples[0]
+		last  = ft.samples[len(ft.samples)-1]
+		used  float64
+	)
+	for _, s := range ft.samples {
+		used += s.GasUsedRatio
+	}
+	fj := &feeJson{
+		BaseFee:         last.BaseFee,
+		GasUsedRatio:    last.GasUsedRatio,
+		AvgGasUsedRatio: used / float64(len(ft.samples)),
+		Recent:          append([]feeSample{}, ft.samples...),
+	}
+	if first.BaseFee > 0 {
+		fj.BaseFeeChange = 100 * (float64(last.BaseFee) - float64(first.BaseFee)) / float64(first.BaseFee)
+	}
+	return fj
+}
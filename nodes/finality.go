@@ -0,0 +1,136 @@
+package nodes
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Block tags which, post-merge, refer to the latest safe and finalized blocks
+const (
+	TagSafe      = "safe"
+	TagFinalized = "finalized"
+)
+
+// taggedNode is implemented by nodes which can report the blocks for the
+// 'safe' and 'finalized' block tags.
+type taggedNode interface {
+	Node
+	// UpdateTagged fetches the blocks for the given tags
+	UpdateTagged(tags ...string) error
+	// Tagged returns the last fetched block for a tag, or nil if unknown
+	Tagged(tag string) *blockInfo
+}
+
+func (node *RPCNode) UpdateTagged(tags ...string) error {
+	for _, tag := range tags {
+		bl, err := node.fetchTagged(tag)
+		if err != nil {
+			// Pre-merge nodes don't know about these tags
+			delete(node.tagged, tag)
+			return err
+		}
+		node.tagged[tag] = bl
+	}
+	return nil
+}
+
+func (node *RPCNode) Tagged(tag string) *blockInfo {
+	return node.tagged[tag]
+}
+
+func (node *RPCNode) fetchTagged(tag string) (*blockInfo, error) {
+	node.throttle.Take()
+	// The header type we have can't be trusted to hash post-merge headers
+	// correctly, so use the hash reported by the node
+	var head *struct {
+		Number *hexutil.Big
+		Hash   common.Hash
+	}
+	err := node.rpcCli.CallContext(context.Background(), &head, "eth_getBlockByNumber", tag, false)
+	if err != nil || head == nil || head.Number == nil {
+		return nil, wrapNodeError(node.name, tag, err)
+	}
+	return &blockInfo{
+		num:  (*big.Int)(head.Number).Uint64(),
+		hash: head.Hash,
+	}, nil
+}
+
+// finalizedNum returns the lowest of the finalized blocks last fetched from the
+// nodes, i.e. the one all of them finalized. It returns false if none of them
+// reported one.
+func finalizedNum(nodes []Node) (uint64, bool) {
+	var (
+		lowest uint64
+		found  bool
+	)
+	for _, n := range nodes {
+		tn, ok := n.(taggedNode)
+		if !ok {
+			continue
+		}
+		if bl := tn.Tagged(TagFinalized); bl != nil && (!found || bl.num < lowest) {
+			lowest, found = bl.num, true
+		}
+	}
+	return lowest, found
+}
+
+// checkTagged cross-checks the safe and finalized blocks of the given nodes
+// pairwise. Nodes can't legitimately disagree about these, so any divergence
+// is reported as an event of its own, a finalized one being critical.
+func (mon *NodeMonitor) checkTagged(nodes []Node) {
+	var tagged []taggedNode
+	for _, n := range nodes {
+		if tn, ok := n.(taggedNode); ok {
+			if err := tn.UpdateTagged(TagFinalized, TagSafe); err != nil {
+				continue
+			}
+			tagged = append(tagged, tn)
+		}
+	}
+	for _, tag := range []string{TagFinalized, TagSafe} {
+		kind, sev := EventFinalizedSplit, SeverityCritical
+		if tag == TagSafe {
+			kind, sev = EventSafeSplit, SeverityWarning
+		}
+		for i := 0; i < len(tagged); i++ {
+			for j := i + 1; j < len(tagged); j++ {
+				a, b := tagged[i], tagged[j]
+				num, ok := taggedConflict(a, b, tag)
+				if !ok {
+					mon.emitBlock(kind, sev, []string{a.Name(), b.Name()}, num,
+						[]common.Hash{a.HashAt(num, false), b.HashAt(num, false)},
+						"Nodes disagree about %v block %d", tag, num)
+				}
+			}
+		}
+	}
+}
+
+// taggedConflict checks whether the tagged blocks of two nodes are
+// consistent. The node with the lower tagged block is checked against the
+// canonical chain of the other. It returns the number checked, and false if
+// they conflict.
+func taggedConflict(a, b taggedNode, tag string) (uint64, bool) {
+	ta, tb := a.Tagged(tag), b.Tagged(tag)
+	if ta == nil || tb == nil {
+		return 0, true
+	}
+	if ta.num == tb.num {
+		return ta.num, ta.hash == tb.hash
+	}
+	// Make 'a' the one further ahead
+	if ta.num < tb.num {
+		a, ta, tb = b, tb, ta
+	}
+	have := a.HashAt(tb.num, false)
+	if have == (common.Hash{}) {
+		// Can't tell, the node doesn't have the block
+		return tb.num, true
+	}
+	return tb.num, have == tb.hash
+}
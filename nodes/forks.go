@@ -0,0 +1,104 @@
+package nodes
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// fork is the fork a beacon node considers active at its head
+type fork struct {
+	PreviousVersion string `json:"previous_version"`
+	CurrentVersion  string `json:"current_version"`
+	Epoch           uint64 `json:"epoch,string"`
+}
+
+// updateFork fetches the active fork and the fork digest advertised in the
+// node's ENR. The ENR only changes at forks, so it's fetched once, and again
+// after reconnecting or when the active fork changed.
+func (node *BeaconNode) updateFork() error {
+	var f fork
+	if err := node.get("/eth/v1/beacon/states/head/fork", &f); err != nil {
+		return err
+	}
+	if node.fork != nil && node.fork.CurrentVersion != f.CurrentVersion {
+		node.forkDigest = ""
+	}
+	node.fork = &f
+	if len(node.forkDigest) > 0 {
+		return nil
+	}
+	var identity struct {
+		ENR string `json:"enr"`
+	}
+	if err := node.get("/eth/v1/node/identity", &identity); err != nil {
+		return err
+	}
+	digest, err := enrForkDigest(identity.ENR)
+	if err != nil {
+		return err
+	}
+	node.forkDigest = digest
+	return nil
+}
+
+// enrForkDigest extracts the fork digest from the 'eth2' entry of a textual
+// ENR. The entry is the ssz-encoded ENRForkID, which starts with the digest.
+func enrForkDigest(enr string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(enr, "enr:"))
+	if err != nil {
+		return "", err
+	}
+	// The record is [signature, seq, k, v, ...]
+	elems, _, err := rlp.SplitList(data)
+	if err != nil {
+		return "", err
+	}
+	for i := 0; len(elems) > 0; i++ {
+		var content []byte
+		if content, elems, err = rlp.SplitString(elems); err != nil {
+			return "", err
+		}
+		if i < 2 || i%2 != 0 || string(content) != "eth2" {
+			continue
+		}
+		if content, _, err = rlp.SplitString(elems); err != nil {
+			return "", err
+		}
+		if len(content) < 4 {
+			return "", errors.New("invalid eth2 entry")
+		}
+		return hexutil.Encode(content[:4]), nil
+	}
+	return "", errors.New("no eth2 entry in enr")
+}
+
+// checkForks cross-checks the active fork of the beacon nodes. Nodes where the
+// fork can't be determined are skipped.
+func (mon *NodeMonitor) checkForks(nodes []*BeaconNode) {
+	var known []*BeaconNode
+	for _, node := range nodes {
+		if err := node.updateFork(); err != nil {
+			log.Warn("Failed to fetch fork", "node", node.name, "error", err)
+			continue
+		}
+		known = append(known, node)
+	}
+	for i := 0; i < len(known); i++ {
+		for j := i + 1; j < len(known); j++ {
+			a, b := known[i], known[j]
+			if a.fork.CurrentVersion != b.fork.CurrentVersion {
+				mon.emit(EventForkMismatch, SeverityCritical, []string{a.name, b.name},
+					"Nodes are on different forks: %v (epoch %d) vs %v (epoch %d)",
+					a.fork.CurrentVersion, a.fork.Epoch, b.fork.CurrentVersion, b.fork.Epoch)
+			} else if a.forkDigest != b.forkDigest {
+				mon.emit(EventForkMismatch, SeverityCritical, []string{a.name, b.name},
+					"Nodes advertise different fork digests: %v vs %v", a.forkDigest, b.forkDigest)
+			}
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package nodes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Grafana targets. The per-node ones are followed by a colon and the node
+// name.
+const (
+	targetSplitDepth = "split_depth"
+	targetLag        = "lag"
+	targetStatus     = "status"
+	targetHead       = "head"
+)
+
+// grafanaQuery is the body of a query by the SimpleJSON datasource
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	Targets []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+	MaxDataPoints int `json:"maxDataPoints"`
+}
+
+// grafanaSeries is a time series as expected by the SimpleJSON datasource,
+// with [value, unix millis] datapoints
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// grafanaPoint is a datapoint as served to the Infinity datasource
+type grafanaPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// grafanaTargets lists the available targets, for the nodes in the last
+// report
+func (mon *NodeMonitor) grafanaTargets() []string {
+	targets := []string{targetSplitDepth}
+	if r := mon.Report(); r != nil {
+		for _, kind := range []string{targetLag, targetStatus, targetHead} {
+			for _, c := range r.Cols {
+				targets = append(targets, kind+":"+c.Name)
+			}
+		}
+	}
+	return targets
+}
+
+// targetValue picks the value of the target out of the report
+func targetValue(r *Report, target string) (float64, bool) {
+	if target == targetSplitDepth {
+		return float64(r.SplitDepth), true
+	}
+	kind, name := target, ""
+	if i := strings.IndexByte(target, ':'); i >= 0 {
+		kind, name = target[:i], target[i+1:]
+	}
+	for _, c := range r.Cols {
+		if c.Name != name {
+			continue
+		}
+		switch kind {
+		case targetLag:
+			return float64(c.Lag), true
+		case targetStatus:
+			return float64(c.Status), true
+		case targetHead:
+			return float64(c.Head), true
+		}
+	}
+	return 0, false
+}
+
+// series returns the values of the targets in the archived reports within
+// the time range. Reports are skipped evenly if there are more than max of
+// them, unless max is zero.
+func (mon *NodeMonitor) series(targets []string, from, to time.Time, max int) ([][]grafanaPoint, error) {
+	if mon.backend == nil || !mon.archive {
+		return nil, errors.New("report archiving not enabled")
+	}
+	reports, err := mon.backend.reportsBetween(from, to)
+	if err != nil {
+		return nil, err
+	}
+	step := 1
+	if max > 0 && len(reports) > max {
+		step = (len(reports) + max - 1) / max
+	}
+	points := make([][]grafanaPoint, len(targets))
+	for i := 0; i < len(reports); i += step {
+		r, err := decodeReport(reports[i].Report, reports[i].Time)
+		if err != nil {
+			continue
+		}
+		for j, target := range targets {
+			if v, ok := targetValue(r, target); ok {
+				points[j] = append(points[j], grafanaPoint{reports[i].Time, v})
+			}
+		}
+	}
+	return points, nil
+}
+
+// HandleGrafana serves the endpoints of the Grafana SimpleJSON datasource
+// under /api/grafana/: the connection test, /search and /query. The series
+// are taken from the archived reports. For the Infinity datasource,
+// /series?target=<target>&from=<time>&to=<time> serves a single series as
+// a flat list.
+func (mon *NodeMonitor) HandleGrafana(w http.ResponseWriter, r *http.Request) {
+	var res interface{}
+	switch strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/grafana"), "/") {
+	case "":
+		w.WriteHeader(http.StatusOK)
+		return
+	case "search":
+		res = mon.grafanaTargets()
+	case "query":
+		var q grafanaQuery
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			http.Error(w, "invalid query: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var targets []string
+		for _, t := range q.Targets {
+			targets = append(targets, t.Target)
+		}
+		points, err := mon.series(targets, q.Range.From, q.Range.To, q.MaxDataPoints)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		series := make([]*grafanaSeries, len(targets))
+		for i, target := range targets {
+			series[i] = &grafanaSeries{Target: target, Datapoints: [][2]float64{}}
+			for _, p := range points[i] {
+				ms := float64(p.Time.UnixNano() / int64(time.Millisecond))
+				series[i].Datapoints = append(series[i].Datapoints, [2]float64{p.Value, ms})
+			}
+		}
+		res = series
+	case "series":
+		target := r.URL.Query().Get("target")
+		if len(target) == 0 {
+			http.Error(w, "missing target", http.StatusBadRequest)
+			return
+		}
+		var (
+			to  = time.Now()
+			err error
+		)
+		if s := r.URL.Query().Get("to"); len(s) > 0 {
+			if to, err = parseTime(s); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		from := to.Add(-time.Hour)
+		if s := r.URL.Query().Get("from"); len(s) > 0 {
+			if from, err = parseTime(s); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		points, err := mon.series([]string{target}, from, to, 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		res = points[0]
+		if points[0] == nil {
+			res = []grafanaPoint{}
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,68 @@
+package nodes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGrafana(t *testing.T) {
+	db := newMemoryDB(t)
+	base := time.Unix(1600000000, 0)
+	for i := 0; i < 10; i++ {
+		r := &Report{
+			Cols:       []*clientJson{{Name: "geth", Lag: uint64(i)}, {Name: "besu", Status: NodeStatusUnreachable}},
+			SplitDepth: int64(i % 3),
+		}
+		data, _ := json.Marshal(r)
+		db.addReport(base.Add(time.Duration(i)*time.Minute), data)
+	}
+	mon := &NodeMonitor{backend: db, archive: true}
+	mon.lastReport = &Report{Cols: []*clientJson{{Name: "geth"}, {Name: "besu"}}}
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mon.HandleGrafana(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	if rec := do("GET", "/api/grafana/", ""); rec.Code != http.StatusOK {
+		t.Errorf("connection test failed: %d", rec.Code)
+	}
+	var targets []string
+	json.NewDecoder(do("POST", "/api/grafana/search", "{}").Body).Decode(&targets)
+	if have := strings.Join(targets, ","); have != "split_depth,lag:geth,lag:besu,status:geth,status:besu,head:geth,head:besu" {
+		t.Errorf("wrong targets: %v", have)
+	}
+	query := fmt.Sprintf(`{"range": {"from": %q, "to": %q}, "targets": [{"target": "lag:geth"}, {"target": "split_depth"}, {"target": "lag:nethermind"}], "maxDataPoints": 3}`,
+		base.Add(2*time.Minute).Format(time.RFC3339), base.Add(7*time.Minute).Format(time.RFC3339))
+	var series []*grafanaSeries
+	if err := json.NewDecoder(do("POST", "/api/grafana/query", query).Body).Decode(&series); err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 3 {
+		t.Fatalf("expected 3 series, have %d", len(series))
+	}
+	// Six reports in range, every other one is skipped
+	want := [][2]float64{{2, 1600000120000}, {4, 1600000240000}, {6, 1600000360000}}
+	if have := series[0].Datapoints; fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("wrong lag series: %v", have)
+	}
+	if have := series[1].Datapoints; len(have) != 3 || have[0][0] != 2 || have[1][0] != 1 {
+		t.Errorf("wrong split depth series: %v", have)
+	}
+	if have := series[2].Datapoints; len(have) != 0 {
+		t.Errorf("expected no datapoints for unknown node: %v", have)
+	}
+	var points []grafanaPoint
+	path := fmt.Sprintf("/api/grafana/series?target=status:besu&to=%d", base.Add(3*time.Minute).Unix())
+	if err := json.NewDecoder(do("GET", path, "").Body).Decode(&points); err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 4 || points[3].Value != NodeStatusUnreachable || !points[3].Time.Equal(base.Add(3*time.Minute)) {
+		t.Errorf("wrong series: %v", points)
+	}
+}
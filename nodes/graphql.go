@@ -0,0 +1,777 @@
+package nodes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The GraphQL endpoint supports the subset of the language needed to query
+// the monitoring data: a single query operation with variables, aliases,
+// arguments, fragments and nested selections. Directives aren't supported.
+// Queries are checked against the schema, in graphqlschema.go, before they're
+// run.
+
+// gqlField is a field in a selection set. Fragments within the selection
+// have no name, but the name of the spread fragment, or the selection of the
+// inline one.
+type gqlField struct {
+	alias string
+	name  string
+	args  map[string]interface{}
+	sel   []*gqlField
+
+	spread string
+	on     string // type condition of fragments
+}
+
+// gqlVar is a reference to a variable, within the arguments
+type gqlVar string
+
+// gqlOperation is a parsed query
+type gqlOperation struct {
+	// defaults are the default values of the declared variables
+	defaults  map[string]interface{}
+	sel       []*gqlField
+	fragments map[string]*gqlField
+}
+
+// gqlParser parses queries. Commas are insignificant in GraphQL, and are
+// skipped along with whitespace and comments.
+type gqlParser struct {
+	src string
+	pos int
+}
+
+// parseGraphQL parses a document of a query along with the fragments it
+// spreads
+func parseGraphQL(src string) (*gqlOperation, error) {
+	var (
+		p      = &gqlParser{src: src}
+		op     = &gqlOperation{defaults: make(map[string]interface{}), fragments: make(map[string]*gqlField)}
+		parsed bool
+	)
+	for p.peek() != 0 {
+		kind := "query"
+		if p.peek() != '{' {
+			var err error
+			if kind, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case kind == "fragment":
+			if err := p.fragment(op); err != nil {
+				return nil, err
+			}
+			continue
+		case kind != "query":
+			return nil, fmt.Errorf("unsupported operation %q, only queries are supported", kind)
+		case parsed:
+			return nil, p.errorf("unexpected input after the query, only one operation is supported")
+		}
+		if c := p.peek(); c != '(' && c != '{' {
+			if _, err := p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if p.peek() == '(' {
+			if err := p.variables(op); err != nil {
+				return nil, err
+			}
+		}
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		op.sel, parsed = sel, true
+	}
+	if !parsed {
+		return nil, p.errorf("missing query")
+	}
+	return op, nil
+}
+
+// fragment parses a fragment definition
+func (p *gqlParser) fragment(op *gqlOperation) error {
+	name, err := p.name()
+	if err != nil {
+		return err
+	}
+	if on, err := p.name(); err != nil || on != "on" {
+		return p.errorf("expected type condition of fragment %v", name)
+	}
+	frag := new(gqlField)
+	if frag.on, err = p.name(); err != nil {
+		return err
+	}
+	if frag.sel, err = p.selection(); err != nil {
+		return err
+	}
+	if op.fragments[name] != nil {
+		return fmt.Errorf("fragment %v is defined twice", name)
+	}
+	op.fragments[name] = frag
+	return nil
+}
+
+func (p *gqlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("syntax error at %d: %v", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *gqlParser) skip() {
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case ' ', '\t', '\n', '\r', ',':
+			p.pos++
+		case '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// peek returns the next significant character, or 0 at the end
+func (p *gqlParser) peek() byte {
+	p.skip()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *gqlParser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *gqlParser) name() (string, error) {
+	p.skip()
+	start := p.pos
+	for ; p.pos < len(p.src); p.pos++ {
+		c := p.src[p.pos]
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || p.pos > start && c >= '0' && c <= '9') {
+			break
+		}
+	}
+	if p.pos == start {
+		return "", p.errorf("expected name")
+	}
+	return p.src[start:p.pos], nil
+}
+
+// variables parses the variable definitions, keeping their defaults. The
+// types aren't checked.
+func (p *gqlParser) variables(op *gqlOperation) error {
+	p.pos++
+	for p.peek() != ')' {
+		if err := p.expect('$'); err != nil {
+			return err
+		}
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		if err := p.expect(':'); err != nil {
+			return err
+		}
+		if err := p.skipType(); err != nil {
+			return err
+		}
+		if p.peek() == '=' {
+			p.pos++
+			if op.defaults[name], err = p.value(); err != nil {
+				return err
+			}
+		}
+	}
+	p.pos++
+	return nil
+}
+
+func (p *gqlParser) skipType() error {
+	if p.peek() == '[' {
+		p.pos++
+		if err := p.skipType(); err != nil {
+			return err
+		}
+		if err := p.expect(']'); err != nil {
+			return err
+		}
+	} else if _, err := p.name(); err != nil {
+		return err
+	}
+	if p.peek() == '!' {
+		p.pos++
+	}
+	return nil
+}
+
+func (p *gqlParser) selection() ([]*gqlField, error) {
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+	var fields []*gqlField
+	for {
+		switch p.peek() {
+		case '}':
+			p.pos++
+			if len(fields) == 0 {
+				return nil, p.errorf("empty selection")
+			}
+			return fields, nil
+		case '.':
+			f, err := p.spread()
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, f)
+			continue
+		case '@':
+			return nil, p.errorf("directives are not supported")
+		case 0:
+			return nil, p.errorf("unexpected end of query")
+		}
+		f, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+}
+
+// spread parses a fragment spread, or an inline fragment
+func (p *gqlParser) spread() (*gqlField, error) {
+	if !strings.HasPrefix(p.src[p.pos:], "...") {
+		return nil, p.errorf("expected \"...\"")
+	}
+	p.pos += 3
+	f := new(gqlField)
+	if p.peek() == '{' {
+		sel, err := p.selection()
+		f.sel = sel
+		return f, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if name != "on" {
+		f.spread = name
+		return f, nil
+	}
+	if f.on, err = p.name(); err != nil {
+		return nil, err
+	}
+	f.sel, err = p.selection()
+	return f, err
+}
+
+func (p *gqlParser) field() (*gqlField, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	f := &gqlField{alias: name, name: name}
+	if p.peek() == ':' {
+		p.pos++
+		if f.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek() == '(' {
+		p.pos++
+		f.args = make(map[string]interface{})
+		for p.peek() != ')' {
+			arg, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(':'); err != nil {
+				return nil, err
+			}
+			if f.args[arg], err = p.value(); err != nil {
+				return nil, err
+			}
+		}
+		p.pos++
+	}
+	if p.peek() == '{' {
+		if f.sel, err = p.selection(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// value parses a value. Numbers are parsed as float64 and objects as maps,
+// like the variables decoded from json.
+func (p *gqlParser) value() (interface{}, error) {
+	switch c := p.peek(); {
+	case c == '$':
+		p.pos++
+		name, err := p.name()
+		return gqlVar(name), err
+	case c == '"':
+		start := p.pos
+		for p.pos++; p.pos < len(p.src) && p.src[p.pos] != '"'; p.pos++ {
+			if p.src[p.pos] == '\\' {
+				p.pos++
+			}
+		}
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated string")
+		}
+		p.pos++
+		var s string
+		if err := json.Unmarshal([]byte(p.src[start:p.pos]), &s); err != nil {
+			return nil, p.errorf("invalid string: %v", err)
+		}
+		return s, nil
+	case c == '[':
+		p.pos++
+		list := []interface{}{}
+		for p.peek() != ']' {
+			if p.peek() == 0 {
+				return nil, p.errorf("unterminated list")
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		p.pos++
+		return list, nil
+	case c == '{':
+		p.pos++
+		obj := make(map[string]interface{})
+		for p.peek() != '}' {
+			key, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(':'); err != nil {
+				return nil, err
+			}
+			if obj[key], err = p.value(); err != nil {
+				return nil, err
+			}
+		}
+		p.pos++
+		return obj, nil
+	case c == '-' || c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos++; p.pos < len(p.src) && strings.IndexByte("0123456789.eE+-", p.src[p.pos]) >= 0; p.pos++ {
+		}
+		f, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", p.src[start:p.pos])
+		}
+		return f, nil
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	// Enum values are passed on as strings
+	return name, nil
+}
+
+// gqlObject is a result object, whose fields keep the order of the query
+type gqlObject []gqlEntry
+
+type gqlEntry struct {
+	key   string
+	value interface{}
+}
+
+func (o gqlObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, e := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(e.key)
+		buf.Write(key)
+		buf.WriteByte(':')
+		val, err := json.Marshal(e.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// gqlArgs are the arguments of a top-level field, with the variables
+// substituted
+type gqlArgs map[string]interface{}
+
+func (a gqlArgs) str(name string) string {
+	switch v := a[name].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// num returns the numeric argument, or def if it's not given
+func (a gqlArgs) num(name string, def uint64) (uint64, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return def, nil
+	case float64:
+		if v >= 0 && v == float64(uint64(v)) {
+			return uint64(v), nil
+		}
+	}
+	return 0, fmt.Errorf("argument %v must be a non-negative integer", name)
+}
+
+// timeRange returns the range given by the 'from' and 'to' arguments, either
+// in RFC3339 format or as unix seconds. It defaults to everything until now.
+func (a gqlArgs) timeRange() (from, to time.Time, err error) {
+	from, to = time.Unix(0, 0), time.Now()
+	if s := a.str("from"); len(s) > 0 {
+		if from, err = parseTime(s); err != nil {
+			return from, to, err
+		}
+	}
+	if s := a.str("to"); len(s) > 0 {
+		to, err = parseTime(s)
+	}
+	return from, to, err
+}
+
+// page applies the 'skip' and 'first' arguments to a list of n items, and
+// returns the range of them to return
+func (a gqlArgs) page(n int) (int, int, error) {
+	skip, err := a.num("skip", 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	first, err := a.num("first", uint64(n))
+	if err != nil {
+		return 0, 0, err
+	}
+	start := n
+	if skip < uint64(n) {
+		start = int(skip)
+	}
+	end := n
+	if first < uint64(n-start) {
+		end = start + int(first)
+	}
+	return start, end, nil
+}
+
+// gqlResolvers returns the top-level fields, by name
+func (mon *NodeMonitor) gqlResolvers() map[string]func(gqlArgs) (interface{}, error) {
+	return map[string]func(gqlArgs) (interface{}, error){
+		"nodes":         mon.gqlNodes,
+		"reports":       mon.gqlReports,
+		"statusHistory": mon.gqlStatusHistory,
+		"splits":        mon.gqlSplits,
+		"headers":       mon.gqlHeaders,
+	}
+}
+
+// gqlNodes lists the nodes as of the last report, optionally only the one
+// with the given name
+func (mon *NodeMonitor) gqlNodes(args gqlArgs) (interface{}, error) {
+	r := mon.Report()
+	if r == nil {
+		return []*clientJson{}, nil
+	}
+	name := args.str("name")
+	var nodes []*clientJson
+	for _, c := range r.Cols {
+		if len(name) == 0 || c.Name == name {
+			nodes = append(nodes, c)
+		}
+	}
+	start, end, err := args.page(len(nodes))
+	return nodes[start:end], err
+}
+
+func (mon *NodeMonitor) archivedReports(args gqlArgs) ([]*archivedReport, error) {
+	if mon.backend == nil || !mon.archive {
+		return nil, errors.New("report archiving not enabled")
+	}
+	from, to, err := args.timeRange()
+	if err != nil {
+		return nil, err
+	}
+	return mon.backend.reportsBetween(from, to)
+}
+
+// gqlReports lists the archived reports within the time range
+func (mon *NodeMonitor) gqlReports(args gqlArgs) (interface{}, error) {
+	reports, err := mon.archivedReports(args)
+	if err != nil {
+		return nil, err
+	}
+	start, end, err := args.page(len(reports))
+	return reports[start:end], err
+}
+
+// statusJson is the status of a node in an archived report
+type statusJson struct {
+	Time   time.Time
+	Status int
+	Head   uint64
+	Lag    uint64
+	// Minority is set if the node disagreed with the quorum head
+	Minority bool
+}
+
+// gqlStatusHistory lists the status of the node in the archived reports
+// within the time range
+func (mon *NodeMonitor) gqlStatusHistory(args gqlArgs) (interface{}, error) {
+	name := args.str("node")
+	if len(name) == 0 {
+		return nil, errors.New("missing argument node")
+	}
+	reports, err := mon.archivedReports(args)
+	if err != nil {
+		return nil, err
+	}
+	var history []*statusJson
+	for _, ar := range reports {
+		r, err := decodeReport(ar.Report, ar.Time)
+		if err != nil {
+			continue
+		}
+		for _, c := range r.Cols {
+			if c.Name == name {
+				history = append(history, &statusJson{ar.Time, c.Status, c.Head, c.Lag, c.Minority})
+			}
+		}
+	}
+	start, end, err := args.page(len(history))
+	return history[start:end], err
+}
+
+// gqlSplits lists the splits found in the archived reports within the time
+// range. A split is reported each cycle while it lasts, so only its first
+// occurrence is listed.
+func (mon *NodeMonitor) gqlSplits(args gqlArgs) (interface{}, error) {
+	reports, err := mon.archivedReports(args)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		splits []*Event
+		seen   = make(map[string]bool)
+	)
+	for _, ar := range reports {
+		r, err := decodeReport(ar.Report, ar.Time)
+		if err != nil {
+			continue
+		}
+		for _, ev := range r.Events {
+			if ev.Kind != EventSplit {
+				continue
+			}
+			key := fmt.Sprintf("%d %v %x", ev.Block, ev.Nodes, ev.Hashes)
+			if !seen[key] {
+				seen[key] = true
+				splits = append(splits, ev)
+			}
+		}
+	}
+	start, end, err := args.page(len(splits))
+	return splits[start:end], err
+}
+
+// gqlHeaders lists the stored headers, optionally only those at the given
+// number, or within the 'minNumber' and 'maxNumber' range
+func (mon *NodeMonitor) gqlHeaders(args gqlArgs) (interface{}, error) {
+	if mon.backend == nil {
+		return nil, errors.New("no block database")
+	}
+	min, err := args.num("minNumber", 0)
+	if err != nil {
+		return nil, err
+	}
+	max, err := args.num("maxNumber", ^uint64(0))
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := args["number"]; ok {
+		if min, err = args.num("number", 0); err != nil {
+			return nil, err
+		}
+		max = min
+	}
+	all, err := mon.backend.Headers()
+	if err != nil {
+		return nil, err
+	}
+	var headers []HeaderInfo
+	for _, h := range all {
+		if h.Number >= min && h.Number <= max {
+			headers = append(headers, h)
+		}
+	}
+	start, end, err := args.page(len(headers))
+	return headers[start:end], err
+}
+
+// substitute replaces the variables within the value
+func substitute(v interface{}, vars map[string]interface{}) interface{} {
+	switch v := v.(type) {
+	case gqlVar:
+		return vars[string(v)]
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i := range v {
+			list[i] = substitute(v[i], vars)
+		}
+		return list
+	case map[string]interface{}:
+		obj := make(map[string]interface{})
+		for k := range v {
+			obj[k] = substitute(v[k], vars)
+		}
+		return obj
+	}
+	return v
+}
+
+// executeGraphQL runs the query, and returns the data along with the errors
+// of the fields which failed. Failed fields are null in the data.
+func (mon *NodeMonitor) executeGraphQL(query string, vars map[string]interface{}) (gqlObject, []string) {
+	op, err := parseGraphQL(query)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+	sel, err := graphQLSchema.validate(op, op.sel, graphQLSchema.query, nil)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+	all := make(map[string]interface{})
+	for k, v := range op.defaults {
+		all[k] = v
+	}
+	for k, v := range vars {
+		all[k] = v
+	}
+	var (
+		data      gqlObject
+		errs      []string
+		resolvers = mon.gqlResolvers()
+	)
+	for _, f := range sel {
+		val, err := mon.resolveGraphQL(resolvers, f, all)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", f.alias, err))
+		}
+		data = append(data, gqlEntry{f.alias, val})
+	}
+	return data, errs
+}
+
+func (mon *NodeMonitor) resolveGraphQL(resolvers map[string]func(gqlArgs) (interface{}, error), f *gqlField, vars map[string]interface{}) (interface{}, error) {
+	args := make(gqlArgs)
+	for k, v := range f.args {
+		args[k] = substitute(v, vars)
+	}
+	switch f.name {
+	case "__typename":
+		return graphQLSchema.query.name, nil
+	case "__schema", "__type":
+		return graphQLSchema.introspect(f, args), nil
+	}
+	val, err := resolvers[f.name](args)
+	if err != nil {
+		return nil, err
+	}
+	// Turn the result into generic maps and lists to select from
+	data, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	typ := graphQLSchema.named(graphQLSchema.query.field(f.name).typ)
+	return graphQLSchema.project(generic, f.sel, typ), nil
+}
+
+// graphQLMaxRequest is the max size of a posted query
+const graphQLMaxRequest = 1024 * 1024
+
+type gqlRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type gqlError struct {
+	Message string `json:"message"`
+}
+
+type gqlResponse struct {
+	Data   interface{} `json:"data"`
+	Errors []gqlError  `json:"errors,omitempty"`
+}
+
+// HandleGraphQL serves GraphQL queries over the nodes, archived reports,
+// splits and stored headers, either as a POST with a json body, or as a GET
+// with the 'query' and json 'variables' parameters.
+func (mon *NodeMonitor) HandleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req gqlRequest
+	switch r.Method {
+	case "GET":
+		req.Query = r.URL.Query().Get("query")
+		if vars := r.URL.Query().Get("variables"); len(vars) > 0 {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				http.Error(w, "invalid variables: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	case "POST":
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, graphQLMaxRequest)).Decode(&req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	data, errs := mon.executeGraphQL(req.Query, req.Variables)
+	res := new(gqlResponse)
+	if data != nil {
+		res.Data = data
+	}
+	for _, err := range errs {
+		res.Errors = append(res.Errors, gqlError{err})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,247 @@
+package nodes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGraphQLParse(t *testing.T) {
+	op, err := parseGraphQL(`query Heads($node: String!, $first: Int = 2) {
+		# comments and commas are ignored
+		h: statusHistory(node: $node, first: $first, to: "2020-09-13T12:26:40Z") { time, head }
+		nodes { name labels { client } }
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.defaults["first"] != 2.0 {
+		t.Errorf("wrong default: %v", op.defaults)
+	}
+	if len(op.sel) != 2 {
+		t.Fatalf("expected 2 fields, have %d", len(op.sel))
+	}
+	f := op.sel[0]
+	if f.alias != "h" || f.name != "statusHistory" || f.args["node"] != gqlVar("node") || f.args["to"] != "2020-09-13T12:26:40Z" || len(f.sel) != 2 {
+		t.Errorf("wrong field: %+v", f)
+	}
+	if sel := op.sel[1].sel; len(sel) != 2 || sel[1].name != "labels" || sel[1].sel[0].name != "client" {
+		t.Errorf("wrong nested selection: %+v", sel)
+	}
+	op, err = parseGraphQL(`{ nodes { ...fields ... on Node { head } } } fragment fields on Node { name }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sel := op.sel[0].sel; len(sel) != 2 || sel[0].spread != "fields" || sel[1].on != "Node" || len(sel[1].sel) != 1 {
+		t.Errorf("wrong fragments: %+v", sel)
+	}
+	if frag := op.fragments["fields"]; frag == nil || frag.on != "Node" || frag.sel[0].name != "name" {
+		t.Errorf("wrong fragment definition: %+v", frag)
+	}
+	for _, q := range []string{
+		`{ nodes `,
+		`mutation { nodes }`,
+		`{ nodes { ...fields } } fragment fields { name }`,
+		`{ nodes(name: "unterminated) }`,
+		`{ nodes } { nodes }`,
+	} {
+		if _, err := parseGraphQL(q); err == nil {
+			t.Errorf("expected %q to be rejected", q)
+		}
+	}
+}
+
+func TestGraphQL(t *testing.T) {
+	db := newMemoryDB(t)
+	base := time.Unix(1600000000, 0)
+	for i := 0; i < 4; i++ {
+		r := &Report{
+			Cols: []*clientJson{{Name: "geth", Head: uint64(100 + i)}, {Name: "besu", Head: 100}},
+			Events: []*Event{
+				{Kind: EventSplit, Nodes: []string{"geth", "besu"}, Block: 99},
+			},
+		}
+		data, _ := json.Marshal(r)
+		db.addReport(base.Add(time.Duration(i)*time.Minute), data)
+	}
+	mon := &NodeMonitor{backend: db, archive: true}
+	mon.lastReport = &Report{Cols: []*clientJson{{Name: "geth", Head: 103}, {Name: "besu", Head: 100}}}
+
+	query := func(q string, vars map[string]interface{}) string {
+		t.Helper()
+		body, _ := json.Marshal(&gqlRequest{Query: q, Variables: vars})
+		rec := httptest.NewRecorder()
+		mon.HandleGraphQL(rec, httptest.NewRequest("POST", "/api/graphql", strings.NewReader(string(body))))
+		return strings.TrimSpace(rec.Body.String())
+	}
+	for i, tt := range []struct {
+		query string
+		vars  map[string]interface{}
+		want  string
+	}{
+		{
+			`{ nodes(name: "besu") { head name } }`, nil,
+			`{"data":{"nodes":[{"head":100,"name":"besu"}]}}`,
+		},
+		{
+			`query ($n: String) { statusHistory(node: $n, skip: 1, first: 2) { head } }`,
+			map[string]interface{}{"n": "geth"},
+			`{"data":{"statusHistory":[{"head":101},{"head":102}]}}`,
+		},
+		{
+			fmt.Sprintf(`{ a: statusHistory(node: "geth", from: %d) { head } }`, base.Add(3*time.Minute).Unix()), nil,
+			`{"data":{"a":[{"head":103}]}}`,
+		},
+		{
+			`{ splits { block nodes } }`, nil,
+			`{"data":{"splits":[{"block":99,"nodes":["geth","besu"]}]}}`,
+		},
+		{
+			`{ nodes { ...n } } fragment n on Node { name ... on Node { head } }`, nil,
+			`{"data":{"nodes":[{"name":"geth","head":103},{"name":"besu","head":100}]}}`,
+		},
+		{
+			`{ __typename nodes(first: 1) { __typename headBlock { hash } } }`, nil,
+			`{"data":{"__typename":"Query","nodes":[{"__typename":"Node","headBlock":null}]}}`,
+		},
+		{
+			`{ nodes { head { number } } }`, nil,
+			`{"data":null,"errors":[{"message":"field head of type Int! has no subfields"}]}`,
+		},
+		{
+			`{ nodes { headBlock } }`, nil,
+			`{"data":null,"errors":[{"message":"field headBlock of type HeadBlock needs a selection of subfields"}]}`,
+		},
+		{
+			`{ unknown }`, nil,
+			`{"data":null,"errors":[{"message":"unknown field unknown on type Query"}]}`,
+		},
+		{
+			`{ nodes(node: "geth") { name } }`, nil,
+			`{"data":null,"errors":[{"message":"unknown argument node of field nodes"}]}`,
+		},
+		{
+			`{ statusHistory { head } }`, nil,
+			`{"data":null,"errors":[{"message":"missing argument node of field statusHistory"}]}`,
+		},
+		{
+			`{ nodes { ...h } } fragment h on Header { number }`, nil,
+			`{"data":null,"errors":[{"message":"fragment on Header can't be spread within Node"}]}`,
+		},
+		{
+			`{ nodes { ...a } } fragment a on Node { ...b } fragment b on Node { ...a }`, nil,
+			`{"data":null,"errors":[{"message":"fragment a spreads itself"}]}`,
+		},
+		{
+			`{ nodes`, nil,
+			`{"data":null,"errors":[{"message":"syntax error at 7: unexpected end of query"}]}`,
+		},
+	} {
+		if have := query(tt.query, tt.vars); have != tt.want {
+			t.Errorf("test %d: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
+
+func TestGraphQLIntrospection(t *testing.T) {
+	mon := new(NodeMonitor)
+	run := func(q string) string {
+		t.Helper()
+		data, errs := mon.executeGraphQL(q, nil)
+		if len(errs) > 0 {
+			t.Fatalf("query failed: %v", errs)
+		}
+		out, _ := json.Marshal(data)
+		return string(out)
+	}
+	have := run(`{ __type(name: "Status") { kind name fields { name type { kind name ofType { name } } } } }`)
+	want := `{"__type":{"kind":"OBJECT","name":"Status","fields":[` +
+		`{"name":"time","type":{"kind":"NON_NULL","name":null,"ofType":{"name":"Time"}}},` +
+		`{"name":"status","type":{"kind":"NON_NULL","name":null,"ofType":{"name":"Int"}}},` +
+		`{"name":"head","type":{"kind":"NON_NULL","name":null,"ofType":{"name":"Int"}}},` +
+		`{"name":"lag","type":{"kind":"NON_NULL","name":null,"ofType":{"name":"Int"}}},` +
+		`{"name":"minority","type":{"kind":"NON_NULL","name":null,"ofType":{"name":"Boolean"}}}]}}`
+	if have != want {
+		t.Errorf("wrong type\nhave %v\nwant %v", have, want)
+	}
+	if have := run(`{ __type(name: "Unknown") { name } }`); have != `{"__type":null}` {
+		t.Errorf("wrong unknown type: %v", have)
+	}
+	// The query sent by GraphiQL and the like to discover the schema
+	var res struct {
+		Schema struct {
+			QueryType struct{ Name string }
+			Types     []struct {
+				Kind   string
+				Name   string
+				Fields []struct {
+					Name string
+					Args []struct{ Name string }
+				}
+			}
+		} `json:"__schema"`
+	}
+	if err := json.Unmarshal([]byte(run(introspectionQuery)), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Schema.QueryType.Name != "Query" {
+		t.Errorf("wrong query type: %v", res.Schema.QueryType.Name)
+	}
+	fields := make(map[string][]string)
+	for _, typ := range res.Schema.Types {
+		for _, f := range typ.Fields {
+			fields[typ.Name] = append(fields[typ.Name], f.Name)
+			if typ.Name == "Query" && f.Name == "statusHistory" && len(f.Args) != 5 {
+				t.Errorf("wrong statusHistory arguments: %v", f.Args)
+			}
+		}
+	}
+	if have := strings.Join(fields["Query"], " "); have != "nodes reports statusHistory splits headers" {
+		t.Errorf("wrong query fields: %v", have)
+	}
+	if have := strings.Join(fields["Header"], " "); have != "hash number parent time" {
+		t.Errorf("wrong header fields: %v", have)
+	}
+}
+
+func TestGraphQLRequestLimit(t *testing.T) {
+	mon := new(NodeMonitor)
+	body := `{"query": "{ nodes { name } }", "variables": {"pad": "` + strings.Repeat("x", graphQLMaxRequest) + `"}}`
+	rec := httptest.NewRecorder()
+	mon.HandleGraphQL(rec, httptest.NewRequest("POST", "/api/graphql", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected oversized request to be rejected, have status %d", rec.Code)
+	}
+}
+
+const introspectionQuery = `query IntrospectionQuery {
+	__schema {
+		queryType { name }
+		mutationType { name }
+		subscriptionType { name }
+		types { ...FullType }
+		directives { name description locations args { ...InputValue } }
+	}
+}
+fragment FullType on __Type {
+	kind name description
+	fields(includeDeprecated: true) {
+		name description
+		args { ...InputValue }
+		type { ...TypeRef }
+		isDeprecated deprecationReason
+	}
+	inputFields { ...InputValue }
+	interfaces { ...TypeRef }
+	enumValues(includeDeprecated: true) { name description isDeprecated deprecationReason }
+	possibleTypes { ...TypeRef }
+}
+fragment InputValue on __InputValue { name description type { ...TypeRef } defaultValue }
+fragment TypeRef on __Type {
+	kind name
+	ofType { kind name ofType { kind name ofType { kind name ofType { kind name } } } }
+}`
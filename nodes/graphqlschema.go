@@ -0,0 +1,539 @@
+package nodes
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// The schema of the GraphQL endpoint. The top-level fields are declared here,
+// the object types below them are derived from the json of the report types,
+// so they can't drift apart. Queries are validated against it before they're
+// run, and it's served through the standard introspection fields, so tools
+// can discover what to query.
+
+// gqlArgDef is an argument of a field or directive
+type gqlArgDef struct {
+	name string
+	typ  string // in SDL notation, e.g. "[Node!]!"
+	desc string
+}
+
+// gqlFieldDef is a field of an object type
+type gqlFieldDef struct {
+	name string
+	typ  string
+	args []gqlArgDef
+	desc string
+}
+
+func (f *gqlFieldDef) arg(name string) *gqlArgDef {
+	for i := range f.args {
+		if f.args[i].name == name {
+			return &f.args[i]
+		}
+	}
+	return nil
+}
+
+// gqlTypeDef is a named type: an object, a scalar or an enum
+type gqlTypeDef struct {
+	kind   string
+	name   string
+	desc   string
+	fields []*gqlFieldDef
+	values []string // of enums
+}
+
+func (t *gqlTypeDef) field(name string) *gqlFieldDef {
+	for _, f := range t.fields {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+var (
+	gqlPageArgs = []gqlArgDef{
+		{"first", "Int", "The max number of items to return"},
+		{"skip", "Int", "The number of items to skip"},
+	}
+	gqlTimeArgs = []gqlArgDef{
+		{"from", "Time", "The start of the time range, the epoch by default"},
+		{"to", "Time", "The end of the time range, now by default"},
+	}
+)
+
+// gqlQueryFields are the top-level fields, which are served by the resolvers
+var gqlQueryFields = []*gqlFieldDef{
+	{
+		name: "nodes", typ: "[Node!]!",
+		args: append([]gqlArgDef{{"name", "String", "Only the node with the name"}}, gqlPageArgs...),
+		desc: "The nodes as of the last report",
+	},
+	{
+		name: "reports", typ: "[ArchivedReport!]!",
+		args: append(gqlTimeArgs, gqlPageArgs...),
+		desc: "The archived reports within the time range",
+	},
+	{
+		name: "statusHistory", typ: "[Status!]!",
+		args: append(append([]gqlArgDef{{"node", "String!", "The name of the node"}}, gqlTimeArgs...), gqlPageArgs...),
+		desc: "The status of the node in the archived reports within the time range",
+	},
+	{
+		name: "splits", typ: "[Event!]!",
+		args: append(gqlTimeArgs, gqlPageArgs...),
+		desc: "The first occurrence of each split in the archived reports within the time range",
+	},
+	{
+		name: "headers", typ: "[Header!]!",
+		args: append([]gqlArgDef{
+			{"number", "Int", "Only the headers at the number"},
+			{"minNumber", "Int", "The lowest number"},
+			{"maxNumber", "Int", "The highest number"},
+		}, gqlPageArgs...),
+		desc: "The stored headers, ordered by number",
+	},
+}
+
+// gqlMetaFields are the introspection fields of the query type, which aren't
+// listed among its fields
+var gqlMetaFields = []*gqlFieldDef{
+	{name: "__schema", typ: "__Schema!"},
+	{name: "__type", typ: "__Type", args: []gqlArgDef{{name: "name", typ: "String!"}}},
+}
+
+// gqlObjectNames are the names of the report types in the schema. Other
+// structs are named after their Go type, without the 'Json' suffix.
+var gqlObjectNames = map[reflect.Type]string{
+	reflect.TypeOf(clientJson{}):     "Node",
+	reflect.TypeOf(archivedReport{}): "ArchivedReport",
+	reflect.TypeOf(statusJson{}):     "Status",
+	reflect.TypeOf(HeaderInfo{}):     "Header",
+}
+
+var gqlScalarDescs = map[string]string{
+	"JSON":     "Free-form json",
+	"Time":     "A time in RFC3339 format. Arguments also take unix seconds.",
+	"Hash":     "A 32-byte hash in hex",
+	"Address":  "A 20-byte address in hex",
+	"Severity": "The severity of an event: info, warning or critical",
+}
+
+var gqlIntrospectionTypes = []*gqlTypeDef{
+	{kind: "OBJECT", name: "__Schema", fields: []*gqlFieldDef{
+		{name: "description", typ: "String"},
+		{name: "types", typ: "[__Type!]!"},
+		{name: "queryType", typ: "__Type!"},
+		{name: "mutationType", typ: "__Type"},
+		{name: "subscriptionType", typ: "__Type"},
+		{name: "directives", typ: "[__Directive!]!"},
+	}},
+	{kind: "OBJECT", name: "__Type", fields: []*gqlFieldDef{
+		{name: "kind", typ: "__TypeKind!"},
+		{name: "name", typ: "String"},
+		{name: "description", typ: "String"},
+		{name: "specifiedByURL", typ: "String"},
+		{name: "fields", typ: "[__Field!]", args: []gqlArgDef{{name: "includeDeprecated", typ: "Boolean"}}},
+		{name: "interfaces", typ: "[__Type!]"},
+		{name: "possibleTypes", typ: "[__Type!]"},
+		{name: "enumValues", typ: "[__EnumValue!]", args: []gqlArgDef{{name: "includeDeprecated", typ: "Boolean"}}},
+		{name: "inputFields", typ: "[__InputValue!]", args: []gqlArgDef{{name: "includeDeprecated", typ: "Boolean"}}},
+		{name: "ofType", typ: "__Type"},
+	}},
+	{kind: "OBJECT", name: "__Field", fields: []*gqlFieldDef{
+		{name: "name", typ: "String!"},
+		{name: "description", typ: "String"},
+		{name: "args", typ: "[__InputValue!]!", args: []gqlArgDef{{name: "includeDeprecated", typ: "Boolean"}}},
+		{name: "type", typ: "__Type!"},
+		{name: "isDeprecated", typ: "Boolean!"},
+		{name: "deprecationReason", typ: "String"},
+	}},
+	{kind: "OBJECT", name: "__InputValue", fields: []*gqlFieldDef{
+		{name: "name", typ: "String!"},
+		{name: "description", typ: "String"},
+		{name: "type", typ: "__Type!"},
+		{name: "defaultValue", typ: "String"},
+		{name: "isDeprecated", typ: "Boolean!"},
+		{name: "deprecationReason", typ: "String"},
+	}},
+	{kind: "OBJECT", name: "__EnumValue", fields: []*gqlFieldDef{
+		{name: "name", typ: "String!"},
+		{name: "description", typ: "String"},
+		{name: "isDeprecated", typ: "Boolean!"},
+		{name: "deprecationReason", typ: "String"},
+	}},
+	{kind: "OBJECT", name: "__Directive", fields: []*gqlFieldDef{
+		{name: "name", typ: "String!"},
+		{name: "description", typ: "String"},
+		{name: "locations", typ: "[__DirectiveLocation!]!"},
+		{name: "args", typ: "[__InputValue!]!", args: []gqlArgDef{{name: "includeDeprecated", typ: "Boolean"}}},
+		{name: "isRepeatable", typ: "Boolean!"},
+	}},
+	{kind: "ENUM", name: "__TypeKind", values: []string{
+		"SCALAR", "OBJECT", "INTERFACE", "UNION", "ENUM", "INPUT_OBJECT", "LIST", "NON_NULL",
+	}},
+	{kind: "ENUM", name: "__DirectiveLocation", values: []string{
+		"QUERY", "MUTATION", "SUBSCRIPTION", "FIELD", "FRAGMENT_DEFINITION", "FRAGMENT_SPREAD",
+		"INLINE_FRAGMENT", "VARIABLE_DEFINITION", "SCHEMA", "SCALAR", "OBJECT", "FIELD_DEFINITION",
+		"ARGUMENT_DEFINITION", "INTERFACE", "UNION", "ENUM", "ENUM_VALUE", "INPUT_OBJECT",
+		"INPUT_FIELD_DEFINITION",
+	}},
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+)
+
+// gqlSchema holds the named types, by name
+type gqlSchema struct {
+	types map[string]*gqlTypeDef
+	query *gqlTypeDef
+}
+
+var graphQLSchema = newGqlSchema()
+
+func newGqlSchema() *gqlSchema {
+	s := &gqlSchema{
+		types: make(map[string]*gqlTypeDef),
+		query: &gqlTypeDef{kind: "OBJECT", name: "Query", fields: gqlQueryFields},
+	}
+	s.types["Query"] = s.query
+	for _, name := range []string{"String", "Int", "Float", "Boolean", "Time"} {
+		s.scalar(name)
+	}
+	s.object(reflect.TypeOf(clientJson{}))
+	s.object(reflect.TypeOf(archivedReport{}))
+	s.object(reflect.TypeOf(statusJson{}))
+	s.object(reflect.TypeOf(Event{}))
+	s.object(reflect.TypeOf(HeaderInfo{}))
+	for _, t := range gqlIntrospectionTypes {
+		s.types[t.name] = t
+	}
+	return s
+}
+
+// named returns the named type within the type reference
+func (s *gqlSchema) named(typ string) *gqlTypeDef {
+	return s.types[strings.Trim(typ, "[]!")]
+}
+
+func (s *gqlSchema) scalar(name string) string {
+	if s.types[name] == nil {
+		s.types[name] = &gqlTypeDef{kind: "SCALAR", name: name, desc: gqlScalarDescs[name]}
+	}
+	return name
+}
+
+// typeOf returns the type of the json values of the Go type, adding the
+// objects to the schema as they're encountered. Values which are never null
+// in the json are non-null.
+func (s *gqlSchema) typeOf(t reflect.Type) string {
+	switch {
+	case t.Kind() == reflect.Ptr:
+		return strings.TrimSuffix(s.typeOf(t.Elem()), "!")
+	case t == rawMessageType:
+		return s.scalar("JSON")
+	case t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType),
+		reflect.PtrTo(t).Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType):
+		return s.scalar(t.Name()) + "!"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "Boolean!"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "Int!"
+	case reflect.Float32, reflect.Float64:
+		return "Float!"
+	case reflect.String:
+		return "String!"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "String"
+		}
+		return "[" + s.typeOf(t.Elem()) + "]"
+	case reflect.Struct:
+		return s.object(t) + "!"
+	}
+	return s.scalar("JSON")
+}
+
+func (s *gqlSchema) object(t reflect.Type) string {
+	name, ok := gqlObjectNames[t]
+	if !ok {
+		name = strings.TrimSuffix(t.Name(), "Json")
+		name = strings.ToUpper(name[:1]) + name[1:]
+	}
+	if s.types[name] == nil {
+		typ := &gqlTypeDef{kind: "OBJECT", name: name}
+		s.types[name] = typ
+		typ.fields = s.structFields(t)
+	}
+	return name
+}
+
+// structFields returns the fields of the struct as they're encoded to json,
+// starting in lower case
+func (s *gqlSchema) structFields(t reflect.Type) []*gqlFieldDef {
+	var fields []*gqlFieldDef
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			name, opts = tag[:i], tag[i:]
+		}
+		if sf.Anonymous && len(name) == 0 && sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, s.structFields(sf.Type)...)
+			continue
+		}
+		if len(sf.PkgPath) > 0 {
+			continue
+		}
+		if len(name) == 0 {
+			name = sf.Name
+		}
+		typ := s.typeOf(sf.Type)
+		if strings.Contains(opts, ",string") {
+			typ = "String!"
+		}
+		if strings.Contains(opts, ",omitempty") {
+			typ = strings.TrimSuffix(typ, "!")
+		}
+		fields = append(fields, &gqlFieldDef{name: strings.ToLower(name[:1]) + name[1:], typ: typ})
+	}
+	return fields
+}
+
+// field returns the definition of the field of the type, including the
+// introspection fields of the query type
+func (s *gqlSchema) field(typ *gqlTypeDef, name string) *gqlFieldDef {
+	if f := typ.field(name); f != nil || typ != s.query {
+		return f
+	}
+	for _, f := range gqlMetaFields {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// validate checks the selection against the fields of the type, and returns
+// it with the fragments expanded. Spread are the fragments being expanded,
+// to catch cycles.
+func (s *gqlSchema) validate(op *gqlOperation, sel []*gqlField, typ *gqlTypeDef, spread []string) ([]*gqlField, error) {
+	var fields []*gqlField
+	for _, f := range sel {
+		if len(f.name) == 0 {
+			frag, within := f, spread
+			if len(f.spread) > 0 {
+				for _, name := range spread {
+					if name == f.spread {
+						return nil, fmt.Errorf("fragment %v spreads itself", name)
+					}
+				}
+				if frag = op.fragments[f.spread]; frag == nil {
+					return nil, fmt.Errorf("unknown fragment %v", f.spread)
+				}
+				within = append(append([]string{}, spread...), f.spread)
+			}
+			if len(frag.on) > 0 && frag.on != typ.name {
+				if s.types[frag.on] == nil {
+					return nil, fmt.Errorf("unknown type %v", frag.on)
+				}
+				return nil, fmt.Errorf("fragment on %v can't be spread within %v", frag.on, typ.name)
+			}
+			expanded, err := s.validate(op, frag.sel, typ, within)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, expanded...)
+			continue
+		}
+		if f.name == "__typename" {
+			if len(f.sel) > 0 || len(f.args) > 0 {
+				return nil, fmt.Errorf("field __typename takes no arguments or subfields")
+			}
+			fields = append(fields, f)
+			continue
+		}
+		def := s.field(typ, f.name)
+		if def == nil {
+			return nil, fmt.Errorf("unknown field %v on type %v", f.name, typ.name)
+		}
+		for name := range f.args {
+			if def.arg(name) == nil {
+				return nil, fmt.Errorf("unknown argument %v of field %v", name, f.name)
+			}
+		}
+		for _, arg := range def.args {
+			if _, ok := f.args[arg.name]; !ok && strings.HasSuffix(arg.typ, "!") {
+				return nil, fmt.Errorf("missing argument %v of field %v", arg.name, f.name)
+			}
+		}
+		ft := s.named(def.typ)
+		switch {
+		case ft.kind == "OBJECT" && len(f.sel) == 0:
+			return nil, fmt.Errorf("field %v of type %v needs a selection of subfields", f.name, def.typ)
+		case ft.kind != "OBJECT" && len(f.sel) > 0:
+			return nil, fmt.Errorf("field %v of type %v has no subfields", f.name, def.typ)
+		}
+		sub, err := s.validate(op, f.sel, ft, spread)
+		if err != nil {
+			return nil, err
+		}
+		field := *f
+		field.sel = sub
+		fields = append(fields, &field)
+	}
+	return fields, nil
+}
+
+// gqlLazy is a value which is only computed if it's selected, as the
+// introspection types refer to each other
+type gqlLazy func() interface{}
+
+// project picks the selected fields out of the value, which is json decoded
+// into generic maps and lists, or built from the schema for introspection.
+// Fields are matched by name, ignoring the case of the first letter. The
+// selection must be validated against the type.
+func (s *gqlSchema) project(v interface{}, sel []*gqlField, typ *gqlTypeDef) interface{} {
+	switch v := v.(type) {
+	case gqlLazy:
+		return s.project(v(), sel, typ)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i := range v {
+			list[i] = s.project(v[i], sel, typ)
+		}
+		return list
+	case map[string]interface{}:
+		if typ.kind != "OBJECT" {
+			return v
+		}
+		var obj gqlObject
+		for _, f := range sel {
+			if f.name == "__typename" {
+				obj = append(obj, gqlEntry{f.alias, typ.name})
+				continue
+			}
+			def := s.field(typ, f.name)
+			obj = append(obj, gqlEntry{f.alias, s.project(lookupField(v, f.name), f.sel, s.named(def.typ))})
+		}
+		return obj
+	}
+	return v
+}
+
+func lookupField(obj map[string]interface{}, name string) interface{} {
+	if v, ok := obj[name]; ok {
+		return v
+	}
+	var keys []string
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if len(k) > 0 && strings.ToLower(k[:1])+k[1:] == name {
+			return obj[k]
+		}
+	}
+	return nil
+}
+
+// introspect resolves the __schema and __type fields
+func (s *gqlSchema) introspect(f *gqlField, args gqlArgs) interface{} {
+	if f.name == "__type" {
+		typ := s.types[args.str("name")]
+		if typ == nil {
+			return nil
+		}
+		return s.project(s.typeJson(typ), f.sel, s.types["__Type"])
+	}
+	var (
+		names []string
+		types []interface{}
+	)
+	for name := range s.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		types = append(types, s.typeJson(s.types[name]))
+	}
+	schema := map[string]interface{}{
+		"types":      types,
+		"queryType":  s.typeJson(s.query),
+		"directives": []interface{}{},
+	}
+	return s.project(schema, f.sel, s.types["__Schema"])
+}
+
+// typeJson returns the type as a __Type object
+func (s *gqlSchema) typeJson(t *gqlTypeDef) map[string]interface{} {
+	obj := map[string]interface{}{"kind": t.kind, "name": t.name}
+	if len(t.desc) > 0 {
+		obj["description"] = t.desc
+	}
+	switch t.kind {
+	case "OBJECT":
+		obj["interfaces"] = []interface{}{}
+		obj["fields"] = gqlLazy(func() interface{} {
+			fields := []interface{}{}
+			for _, f := range t.fields {
+				fields = append(fields, s.fieldJson(f))
+			}
+			return fields
+		})
+	case "ENUM":
+		values := []interface{}{}
+		for _, v := range t.values {
+			values = append(values, map[string]interface{}{"name": v, "isDeprecated": false})
+		}
+		obj["enumValues"] = values
+	}
+	return obj
+}
+
+func (s *gqlSchema) fieldJson(f *gqlFieldDef) map[string]interface{} {
+	args := []interface{}{}
+	for _, arg := range f.args {
+		a := map[string]interface{}{"name": arg.name, "type": s.typeRef(arg.typ), "isDeprecated": false}
+		if len(arg.desc) > 0 {
+			a["description"] = arg.desc
+		}
+		args = append(args, a)
+	}
+	obj := map[string]interface{}{"name": f.name, "args": args, "type": s.typeRef(f.typ), "isDeprecated": false}
+	if len(f.desc) > 0 {
+		obj["description"] = f.desc
+	}
+	return obj
+}
+
+// typeRef returns the __Type object of the type reference, with the
+// wrapping lists and non-nulls
+func (s *gqlSchema) typeRef(typ string) gqlLazy {
+	return func() interface{} {
+		switch {
+		case strings.HasSuffix(typ, "!"):
+			return map[string]interface{}{"kind": "NON_NULL", "ofType": s.typeRef(typ[:len(typ)-1])}
+		case strings.HasPrefix(typ, "["):
+			return map[string]interface{}{"kind": "LIST", "ofType": s.typeRef(typ[1 : len(typ)-1])}
+		}
+		return s.typeJson(s.types[typ])
+	}
+}
@@ -0,0 +1,271 @@
+package nodes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+type leaderConfig struct {
+	// LeaseFile is where the lease of the leader is kept, on storage shared
+	// by the monitor instances. Setting it enables active/passive mode: only
+	// the instance holding the lease writes reports, exports and alerts. The
+	// storage must support exclusive file creation, and the clocks of the
+	// hosts must be in sync, as the expiry is checked against the local time.
+	LeaseFile string
+	// Lease is how long the lease is valid without being renewed, after which
+	// a standby takes over. Defaults to 1m.
+	Lease string
+}
+
+// leaseJson is the content of the lease file
+type leaseJson struct {
+	Holder  string
+	Expires time.Time
+}
+
+// leaderElector elects one of several monitor instances as the leader, via a
+// lease which the leader renews, and standbys take once it expires
+type leaderElector struct {
+	path   string
+	holder string
+	lease  time.Duration
+
+	mu      sync.Mutex
+	leading bool
+	current string    // holder of the lease as last seen
+	expires time.Time // expiry of the lease as last taken or renewed
+}
+
+// newLeaderElector returns nil if active/passive mode is disabled
+func newLeaderElector(conf leaderConfig, identity string) (*leaderElector, error) {
+	if len(conf.LeaseFile) == 0 {
+		return nil, nil
+	}
+	le := &leaderElector{path: conf.LeaseFile, holder: identity, lease: time.Minute}
+	if len(conf.Lease) > 0 {
+		d, err := time.ParseDuration(conf.Lease)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lease: %v", err)
+		}
+		if d <= 0 {
+			return nil, errors.New("lease must be positive")
+		}
+		le.lease = d
+	}
+	// The instances need to tell each other apart
+	host, _ := os.Hostname()
+	if len(le.holder) == 0 {
+		le.holder = host
+	}
+	le.holder = fmt.Sprintf("%v/%d", le.holder, os.Getpid())
+	return le, nil
+}
+
+// read returns the current lease, nil if there is none
+func (le *leaderElector) read() (*leaseJson, error) {
+	data, err := ioutil.ReadFile(le.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var l leaseJson
+	if err := json.Unmarshal(data, &l); err != nil {
+		// A torn or garbled lease is as good as none
+		log.Warn("Invalid lease file", "file", le.path, "error", err)
+		return nil, nil
+	}
+	return &l, nil
+}
+
+// lock takes the lock guarding the lease, by creating the lock file next to
+// it exclusively, so only one instance at a time reads and updates the lease.
+// A lock left behind by a crashed instance is broken once it's older than the
+// lease. It returns false if another instance holds the lock.
+func (le *leaderElector) lock() (bool, error) {
+	path := le.path + ".lock"
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) {
+		info, serr := os.Stat(path)
+		if serr != nil || time.Since(info.ModTime()) < le.lease {
+			return false, nil
+		}
+		if !breakLock(path, info) {
+			return false, nil
+		}
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) {
+			return false, nil
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	fmt.Fprintln(f, le.holder)
+	return true, f.Close()
+}
+
+// breakLock removes the stale lock. Other instances may see it as stale at
+// the same time, and one of them may break it and take a fresh lock before
+// this one gets to it, so the lock isn't removed in place: it's moved aside
+// to a unique name, which only succeeds for one instance, and checked to be
+// the stale one. A fresh lock moved aside is put back.
+func breakLock(path string, stale os.FileInfo) bool {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".stale-")
+	if err != nil {
+		log.Warn("Failed to break stale lease lock", "file", path, "error", err)
+		return false
+	}
+	tmp.Close()
+	aside := tmp.Name()
+	defer os.Remove(aside)
+	if err := os.Rename(path, aside); err != nil {
+		// Moved aside by another instance already
+		return false
+	}
+	// The inode of a removed lock may be reused by a fresh one, so the time
+	// is compared as well
+	if moved, err := os.Stat(aside); err == nil && os.SameFile(stale, moved) && moved.ModTime().Equal(stale.ModTime()) {
+		log.Warn("Broke stale lease lock", "file", path, "age", time.Since(stale.ModTime()))
+		return true
+	}
+	if err := os.Link(aside, path); err != nil {
+		log.Warn("Failed to restore lease lock", "file", path, "error", err)
+	}
+	return false
+}
+
+func (le *leaderElector) unlock() {
+	if err := os.Remove(le.path + ".lock"); err != nil {
+		log.Warn("Failed to remove lease lock", "file", le.path, "error", err)
+	}
+}
+
+// write replaces the lease atomically
+func (le *leaderElector) write(l *leaseJson) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(le.path), ".lease-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), le.path)
+}
+
+// elect renews the lease if this instance holds it, or takes it if it
+// expired, and returns whether this instance is the leader. If another
+// instance is updating the lease right now, it's left as it is, and this
+// instance keeps leading until its lease expires.
+func (le *leaderElector) elect(now time.Time) bool {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	locked, err := le.lock()
+	if err != nil {
+		log.Warn("Failed to lock lease", "file", le.path, "error", err)
+	}
+	if !locked {
+		le.leading = le.leading && now.Before(le.expires)
+		return le.leading
+	}
+	defer le.unlock()
+	l, err := le.read()
+	if err != nil {
+		// Without knowing who leads, it's safer not to
+		log.Warn("Failed to read lease", "file", le.path, "error", err)
+		le.leading = false
+		return false
+	}
+	if l != nil && l.Holder != le.holder && now.Before(l.Expires) {
+		le.leading, le.current = false, l.Holder
+		return false
+	}
+	expires := now.Add(le.lease)
+	if err := le.write(&leaseJson{Holder: le.holder, Expires: expires}); err != nil {
+		log.Warn("Failed to write lease", "file", le.path, "error", err)
+		le.leading = false
+		return false
+	}
+	le.leading, le.current, le.expires = true, le.holder, expires
+	return true
+}
+
+// release gives up the lease, so that a standby takes over right away
+func (le *leaderElector) release() {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	le.leading = false
+	if locked, _ := le.lock(); !locked {
+		// It expires in time either way
+		return
+	}
+	defer le.unlock()
+	if l, err := le.read(); err == nil && l != nil && l.Holder == le.holder {
+		if err := os.Remove(le.path); err != nil {
+			log.Warn("Failed to release lease", "file", le.path, "error", err)
+		}
+	}
+}
+
+// state returns whether this instance leads, and the holder of the lease
+func (le *leaderElector) state() (bool, string) {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	return le.leading, le.current
+}
+
+// renewLease renews the lease, or tries to take it, well within its
+// duration until the monitor is stopped
+func (mon *NodeMonitor) renewLease() {
+	defer mon.wg.Done()
+	defer mon.leader.release()
+	for {
+		select {
+		case <-mon.quitCh:
+			return
+		case <-time.After(mon.leader.lease / 3):
+			mon.leader.elect(time.Now())
+		}
+	}
+}
+
+// checkLeadership returns whether this instance is the leader, and raises an
+// event when that changed since the last cycle
+func (mon *NodeMonitor) checkLeadership() bool {
+	if mon.leader == nil {
+		return true
+	}
+	leading, holder := mon.leader.state()
+	if leading != mon.wasLeading {
+		if leading {
+			mon.emit(EventLeaderChange, SeverityInfo, nil, "Became the leader as %v", mon.leader.holder)
+		} else {
+			mon.emit(EventLeaderChange, SeverityInfo, nil, "Standing by, %v is the leader", holder)
+		}
+		mon.wasLeading = leading
+	}
+	var gauge int64
+	if leading {
+		gauge = 1
+	}
+	metrics.GetOrRegisterGauge("leader", mon.registry).Update(gauge)
+	return leading
+}
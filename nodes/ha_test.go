@@ -0,0 +1,194 @@
+package nodes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLeaderElection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodemonitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := leaderConfig{LeaseFile: filepath.Join(dir, "lease"), Lease: "30s"}
+	a, err := newLeaderElector(conf, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := newLeaderElector(conf, "b")
+
+	now := time.Now()
+	if !a.elect(now) {
+		t.Fatal("first instance not elected")
+	}
+	if b.elect(now.Add(time.Second)) {
+		t.Fatal("standby took a valid lease")
+	}
+	if _, holder := b.state(); holder != a.holder {
+		t.Errorf("wrong leader seen by standby: %v", holder)
+	}
+	// Renewals keep the lease
+	if !a.elect(now.Add(20*time.Second)) || b.elect(now.Add(40*time.Second)) {
+		t.Fatal("renewed lease lost")
+	}
+	// The standby takes over once the leader stops renewing
+	if !b.elect(now.Add(time.Minute)) || a.elect(now.Add(time.Minute)) {
+		t.Fatal("standby didn't take over expired lease")
+	}
+	// and gives it up right away when stopped
+	b.release()
+	if !a.elect(now.Add(61 * time.Second)) {
+		t.Error("released lease not taken")
+	}
+	// While another instance updates the lease, it's left alone: the leader
+	// keeps leading until its lease expires, and the standby stands by
+	lock := conf.LeaseFile + ".lock"
+	if err := ioutil.WriteFile(lock, []byte("c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !a.elect(now.Add(80*time.Second)) || b.elect(now.Add(100*time.Second)) {
+		t.Error("lease changed while locked")
+	}
+	if a.elect(now.Add(100 * time.Second)) {
+		t.Error("expired lease kept while locked")
+	}
+	// A lock left behind by a crashed instance is broken
+	old := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(lock, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if !a.elect(now.Add(100 * time.Second)) {
+		t.Error("stale lock not broken")
+	}
+}
+
+func TestStandbyAlerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodemonitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := leaderConfig{LeaseFile: filepath.Join(dir, "lease")}
+	leader, _ := newLeaderElector(conf, "leader")
+	leader.elect(time.Now())
+
+	mon, err := NewMonitor([]Node{&brokenNode{"broken"}}, nil, nil, &Config{ReloadInterval: "1s", Leader: conf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := new(recordingNotifier)
+	mon.alerts.notifiers = []Notifier{rec}
+	mon.doChecks()
+	if leading, _ := mon.leader.state(); leading {
+		t.Fatal("standby elected while the lease is held")
+	}
+	if len(mon.Report().Events) == 0 || len(rec.events) != 0 {
+		t.Fatalf("standby sent alerts: %d events, %d alerts", len(mon.Report().Events), len(rec.events))
+	}
+	// Alerts already sent by the leader aren't repeated on takeover
+	leader.release()
+	mon.leader.elect(time.Now())
+	mon.doChecks()
+	if len(rec.events) != 0 {
+		t.Errorf("ongoing events alerted on takeover: %v", rec.events)
+	}
+	found := false
+	for _, ev := range mon.Report().Events {
+		found = found || ev.Kind == EventLeaderChange
+	}
+	if !found {
+		t.Error("takeover not reported")
+	}
+}
+
+func TestStaleLockRace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodemonitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := leaderConfig{LeaseFile: filepath.Join(dir, "lease"), Lease: "30s"}
+	lock := conf.LeaseFile + ".lock"
+	stale := func() os.FileInfo {
+		t.Helper()
+		if err := ioutil.WriteFile(lock, []byte("crashed"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		old := time.Now().Add(-time.Minute)
+		if err := os.Chtimes(lock, old, old); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(lock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+	// An instance sees the stale lock, but another one breaks it and takes
+	// a fresh lock before the first one gets to breaking it
+	info := stale()
+	b, _ := newLeaderElector(conf, "b")
+	if ok, err := b.lock(); !ok || err != nil {
+		t.Fatalf("stale lock not broken: %v", err)
+	}
+	if breakLock(lock, info) {
+		t.Error("fresh lock broken")
+	}
+	if data, _ := ioutil.ReadFile(lock); strings.TrimSpace(string(data)) != b.holder {
+		t.Errorf("fresh lock lost, have %q", data)
+	}
+	os.Remove(lock)
+
+	for round := 0; round < 20; round++ {
+		// A lock left behind by a crashed instance, seen by several standbys
+		// at once: only one of them may break it and take the lock
+		stale()
+		var (
+			start  = make(chan struct{})
+			wg     sync.WaitGroup
+			mu     sync.Mutex
+			locked []string
+		)
+		for i := 0; i < 8; i++ {
+			le, err := newLeaderElector(conf, fmt.Sprintf("standby-%d", i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				ok, err := le.lock()
+				if err != nil {
+					t.Error(err)
+				}
+				if ok {
+					mu.Lock()
+					locked = append(locked, le.holder)
+					mu.Unlock()
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+		if len(locked) != 1 {
+			t.Fatalf("round %d: lock taken by %v", round, locked)
+		}
+		data, _ := ioutil.ReadFile(lock)
+		if have := strings.TrimSpace(string(data)); have != locked[0] {
+			t.Fatalf("round %d: lock of %v held by %v", round, have, locked[0])
+		}
+		os.Remove(lock)
+	}
+	// The locks moved aside are cleaned up
+	if files, _ := filepath.Glob(lock + ".stale-*"); len(files) != 0 {
+		t.Errorf("leftover files: %v", files)
+	}
+}
@@ -0,0 +1,47 @@
+package nodes
+
+import "github.com/ethereum/go-ethereum/common"
+
+// headGroup is a set of nodes with the same head block. They share the whole
+// chain, so comparing one of them against another node is as good as
+// comparing all of them.
+type headGroup []Node
+
+func (g headGroup) names() []string {
+	var names []string
+	for _, node := range g {
+		names = append(names, node.Name())
+	}
+	return names
+}
+
+// headID identifies a head block. The rest of the block info differs across
+// nodes, e.g. when it was received.
+type headID struct {
+	num  uint64
+	hash common.Hash
+}
+
+// headGroups buckets the nodes by head block, keeping the order in which the
+// nodes are given. Nodes whose head block is unknown are left on their own.
+func headGroups(nodes []Node) []headGroup {
+	var (
+		groups []headGroup
+		index  = make(map[headID]int)
+	)
+	for _, node := range nodes {
+		head := node.BlockAt(node.HeadNum(), false)
+		if head == nil {
+			groups = append(groups, headGroup{node})
+			continue
+		}
+		key := headID{head.num, head.hash}
+		if i, ok := index[key]; ok {
+			groups[i] = append(groups[i], node)
+			continue
+		}
+		index[key] = len(groups)
+		groups = append(groups, headGroup{node})
+	}
+	return groups
+}
@@ -0,0 +1,213 @@
+package nodes
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// headPrefix is the key prefix for recorded heads, followed by the node name,
+// a zero byte and the big-endian unix time (in nanoseconds) of the sample.
+// The value is the big-endian head number, followed by the head hash.
+var headPrefix = []byte("head-")
+
+// headNodePrefix is the key prefix for the recorded heads of the node
+func headNodePrefix(node string) []byte {
+	return append(append(append([]byte{}, headPrefix...), node...), 0)
+}
+
+func headKey(node string, t time.Time) []byte {
+	var nanos [8]byte
+	binary.BigEndian.PutUint64(nanos[:], uint64(t.UnixNano()))
+	return append(headNodePrefix(node), nanos[:]...)
+}
+
+// defaultRetention is how long the recorded heads and the height index are
+// kept by default
+const defaultRetention = 30 * 24 * time.Hour
+
+// parseRetention parses the history retention, which defaults to 30 days
+func parseRetention(s string) (time.Duration, error) {
+	if len(s) == 0 {
+		return defaultRetention, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err == nil && d <= 0 {
+		err = errors.New("must be positive")
+	}
+	return d, err
+}
+
+// headRecord is the head of a node at some point in time
+type headRecord struct {
+	Time   time.Time
+	Number uint64
+	Hash   common.Hash
+}
+
+func (db *blockDB) addHead(node string, t time.Time, num uint64, hash common.Hash) error {
+	val := make([]byte, 8+common.HashLength)
+	binary.BigEndian.PutUint64(val, num)
+	copy(val[8:], hash[:])
+	return db.db.Put(headKey(node, t), val, nil)
+}
+
+// headsBetween returns the heads of the node recorded within the given time
+// range, oldest first
+func (db *blockDB) headsBetween(node string, from, to time.Time) ([]headRecord, error) {
+	prefix := headNodePrefix(node)
+	it := db.db.NewIterator(&util.Range{
+		Start: headKey(node, from),
+		Limit: util.BytesPrefix(prefix).Limit,
+	}, nil)
+	defer it.Release()
+
+	var samples []headRecord
+	for it.Next() {
+		key, val := it.Key(), it.Value()
+		if len(key) != len(prefix)+8 || len(val) != 8+common.HashLength {
+			continue
+		}
+		t := time.Unix(0, int64(binary.BigEndian.Uint64(key[len(prefix):])))
+		if t.After(to) {
+			break
+		}
+		samples = append(samples, headRecord{
+			Time:   t,
+			Number: binary.BigEndian.Uint64(val),
+			Hash:   common.BytesToHash(val[8:]),
+		})
+	}
+	return samples, it.Error()
+}
+
+// pruneHeads deletes the heads recorded before the cutoff, and returns how
+// many were deleted
+func (db *blockDB) pruneHeads(cutoff time.Time) (int, error) {
+	return db.deleteWhere(headPrefix, func(key, val []byte) bool {
+		if len(key) < len(headPrefix)+9 {
+			return false
+		}
+		nanos := binary.BigEndian.Uint64(key[len(key)-8:])
+		return int64(nanos) < cutoff.UnixNano()
+	})
+}
+
+// deleteWhere deletes the entries under the prefix for which the predicate
+// holds, and returns how many were deleted
+func (db *blockDB) deleteWhere(prefix []byte, pred func(key, val []byte) bool) (int, error) {
+	it := db.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer it.Release()
+
+	batch := new(leveldb.Batch)
+	for it.Next() {
+		if pred(it.Key(), it.Value()) {
+			batch.Delete(append([]byte{}, it.Key()...))
+		}
+	}
+	if err := it.Error(); err != nil {
+		return 0, err
+	}
+	return batch.Len(), db.db.Write(batch, nil)
+}
+
+// pruneHistory deletes the recorded heads and the height index entries older
+// than the retention, a minute after starting and daily after that, until
+// the monitor stops
+func (mon *NodeMonitor) pruneHistory() {
+	defer mon.wg.Done()
+	prune := time.After(time.Minute)
+	for {
+		select {
+		case <-mon.quitCh:
+			return
+		case now := <-prune:
+			cutoff := now.Add(-mon.retention)
+			if deleted, err := mon.backend.pruneHeads(cutoff); err != nil {
+				log.Warn("Failed to prune recorded heads", "error", err)
+			} else if deleted > 0 {
+				log.Info("Pruned recorded heads", "deleted", deleted)
+			}
+			if deleted, err := mon.backend.pruneHeights(cutoff); err != nil {
+				log.Warn("Failed to prune height index", "error", err)
+			} else if deleted > 0 {
+				log.Info("Pruned height index", "deleted", deleted)
+			}
+			prune = time.After(24 * time.Hour)
+		}
+	}
+}
+
+// headStore is where the heads are recorded, the block database outside of
+// tests
+type headStore interface {
+	addHead(node string, t time.Time, num uint64, hash common.Hash) error
+}
+
+// storeHeads stores the current head of each node, if enabled
+func (mon *NodeMonitor) storeHeads(nodes []Node, now time.Time) {
+	if !mon.recordHeads || mon.backend == nil {
+		return
+	}
+	recordHeads(mon.backend, nodes, now)
+}
+
+// recordHeads stores the current head of each node. Failing to store the
+// head of one node doesn't keep those of the others from being stored.
+func recordHeads(store headStore, nodes []Node, now time.Time) {
+	for _, node := range nodes {
+		num := node.HeadNum()
+		if err := store.addHead(node.Name(), now, num, node.HashAt(num, false)); err != nil {
+			log.Warn("Failed to record head", "node", node.Name(), "error", err)
+		}
+	}
+}
+
+// HandleHeads serves the recorded heads of a node, given by the 'node' query
+// parameter. The 'from' and 'to' parameters limit the time range, in RFC3339
+// format or as unix seconds, and default to the last hour.
+func (mon *NodeMonitor) HandleHeads(w http.ResponseWriter, r *http.Request) {
+	if mon.backend == nil || !mon.recordHeads {
+		http.Error(w, "head recording not enabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	node := q.Get("node")
+	if len(node) == 0 {
+		http.Error(w, "missing node", http.StatusBadRequest)
+		return
+	}
+	to := time.Now()
+	if s := q.Get("to"); len(s) > 0 {
+		var err error
+		if to, err = parseTime(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	from := to.Add(-time.Hour)
+	if s := q.Get("from"); len(s) > 0 {
+		var err error
+		if from, err = parseTime(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	samples, err := mon.backend.headsBetween(node, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if samples == nil {
+		samples = []headRecord{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(samples)
+}
@@ -0,0 +1,112 @@
+package nodes
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type stallConfig struct {
+	// Node is how long a node's head may stay the same before it's reported
+	// as stalled, e.g. "2m". Disabled if empty.
+	Node string
+	// Chain is how long the highest head of all nodes may stay the same
+	// before the chain is reported as halted. Disabled if empty.
+	Chain string
+	// HeadAge is how far a node's head block timestamp may lag the wall
+	// clock, which catches nodes serving an old chain. Disabled if empty.
+	HeadAge string
+}
+
+// headTracker remembers the head of each node across cycles, and when it
+// last advanced
+type headTracker struct {
+	heads    map[string]uint64
+	advanced map[string]time.Time
+	// highest head of any node, and when it last advanced
+	highest      uint64
+	highestSince time.Time
+
+	nodeStall, chainHalt, headAge time.Duration
+}
+
+func newHeadTracker(conf stallConfig) (*headTracker, error) {
+	t := &headTracker{
+		heads:    make(map[string]uint64),
+		advanced: make(map[string]time.Time),
+	}
+	var err error
+	if len(conf.Node) > 0 {
+		if t.nodeStall, err = time.ParseDuration(conf.Node); err != nil {
+			return nil, fmt.Errorf("invalid node stall timeout: %v", err)
+		}
+	}
+	if len(conf.Chain) > 0 {
+		if t.chainHalt, err = time.ParseDuration(conf.Chain); err != nil {
+			return nil, fmt.Errorf("invalid chain halt timeout: %v", err)
+		}
+	}
+	if len(conf.HeadAge) > 0 {
+		if t.headAge, err = time.ParseDuration(conf.HeadAge); err != nil {
+			return nil, fmt.Errorf("invalid head age: %v", err)
+		}
+	}
+	return t, nil
+}
+
+func (t *headTracker) forget(name string) {
+	delete(t.heads, name)
+	delete(t.advanced, name)
+}
+
+// checkHeads compares the heads of the nodes to those of the previous cycle.
+// A head going backwards is a deep reorg or a resync, rather than business as
+// usual, so it's reported on its own. Heads which haven't advanced for too
+// long are reported as stalled, and if no node advances, the chain as halted.
+// Heads with old timestamps are reported as stale, even if they advance.
+func (mon *NodeMonitor) checkHeads(nodes []Node, now time.Time) {
+	t := mon.heads
+	var highest uint64
+	for _, node := range nodes {
+		name, num := node.Name(), node.HeadNum()
+		prev, ok := t.heads[name]
+		if ok && num < prev {
+			mon.emitBlock(EventHeadRegression, SeverityWarning, []string{name}, num, nil,
+				"Head went backwards from %d to %d", prev, num)
+		}
+		if !ok || num > prev {
+			t.advanced[name] = now
+		}
+		t.heads[name] = num
+		if stalled := now.Sub(t.advanced[name]); t.nodeStall > 0 && stalled >= t.nodeStall {
+			mon.emitBlock(EventHeadStall, SeverityWarning, []string{name}, num, nil,
+				"Head stuck at %d for %v", num, stalled.Round(time.Second))
+		}
+		if t.headAge > 0 {
+			if bl := node.BlockAt(num, false); bl != nil && bl.time > 0 {
+				if age := now.Sub(time.Unix(int64(bl.time), 0)); age >= t.headAge {
+					mon.emitBlock(EventStaleHead, SeverityWarning, []string{name}, num, []common.Hash{bl.hash},
+						"Head block %d is %v old", num, age.Round(time.Second))
+				}
+			}
+		}
+		if num > highest {
+			highest = num
+		}
+	}
+	if len(nodes) == 0 {
+		return
+	}
+	if highest > t.highest || t.highestSince.IsZero() {
+		t.highest, t.highestSince = highest, now
+	}
+	if halted := now.Sub(t.highestSince); t.chainHalt > 0 && halted >= t.chainHalt {
+		var names []string
+		for _, node := range nodes {
+			names = append(names, node.Name())
+		}
+		mon.emitBlock(EventChainHalt, SeverityCritical, names, t.highest, nil,
+			"No node advanced past block %d for %v", t.highest, halted.Round(time.Second))
+	}
+}
@@ -0,0 +1,82 @@
+package nodes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// healthReport is the self-reported health of the monitor, as served on the
+// /healthz and /readyz endpoints.
+type healthReport struct {
+	Running    bool
+	LastCycle  time.Time
+	DBWritable bool
+	Error      string `json:",omitempty"`
+	// Leader is whether this instance leads, in active/passive mode
+	Leader *bool `json:",omitempty"`
+	// Cycle summarizes the last check cycle
+	Cycle *cycleJson `json:",omitempty"`
+}
+
+func (mon *NodeMonitor) setRunning(running bool) {
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+	mon.running = running
+}
+
+// markCycle records that a check cycle completed successfully
+func (mon *NodeMonitor) markCycle() {
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+	mon.lastCycle = time.Now()
+}
+
+func (mon *NodeMonitor) health() *healthReport {
+	mon.mu.Lock()
+	h := &healthReport{
+		Running:    mon.running,
+		LastCycle:  mon.lastCycle,
+		DBWritable: true,
+		Cycle:      mon.cycleStats,
+	}
+	mon.mu.Unlock()
+	if mon.leader != nil {
+		leading, _ := mon.leader.state()
+		h.Leader = &leading
+	}
+	if mon.backend != nil {
+		if err := mon.backend.writable(); err != nil {
+			h.DBWritable = false
+			h.Error = err.Error()
+		}
+	}
+	return h
+}
+
+// HandleHealthz serves the liveness probe: it fails if the check loop is not
+// running.
+func (mon *NodeMonitor) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	h := mon.health()
+	writeHealth(w, h, h.Running)
+}
+
+// HandleReadyz serves the readiness probe: in addition to the loop running,
+// it requires that a check cycle completed recently and that the backend
+// database is writable.
+func (mon *NodeMonitor) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	h := mon.health()
+	reload, _ := mon.intervals()
+	// Allow a few missed cycles before declaring ourselves unready, a cycle
+	// against slow nodes may well take longer than the reload interval.
+	recent := time.Since(h.LastCycle) < 3*reload+time.Minute
+	writeHealth(w, h, h.Running && h.DBWritable && recent)
+}
+
+func writeHealth(w http.ResponseWriter, h *healthReport, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(h)
+}
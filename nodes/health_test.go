@@ -0,0 +1,33 @@
+package nodes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	mon, _ := NewMonitor([]Node{&brokenNode{"broken"}}, nil, nil, &Config{ReloadInterval: "1s"})
+
+	check := func(handler http.HandlerFunc, want int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != want {
+			t.Errorf("got status %d, want %d: %s", rec.Code, want, rec.Body.String())
+		}
+	}
+	// Not started yet
+	check(mon.HandleHealthz, http.StatusServiceUnavailable)
+	check(mon.HandleReadyz, http.StatusServiceUnavailable)
+
+	mon.Start()
+	// Give the loop a moment to spin up
+	time.Sleep(10 * time.Millisecond)
+	check(mon.HandleHealthz, http.StatusOK)
+	check(mon.HandleReadyz, http.StatusOK)
+
+	mon.Stop()
+	check(mon.HandleHealthz, http.StatusServiceUnavailable)
+}
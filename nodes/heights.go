@@ -0,0 +1,109 @@
+package nodes
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// heightPrefix is the key prefix for the height index, followed by the
+// big-endian block number, the block hash and the name of the node which
+// reported it. The value is the big-endian unix time the node last reported
+// it, by which the index is pruned.
+var heightPrefix = []byte("height-")
+
+func heightKey(num uint64) []byte {
+	key := make([]byte, len(heightPrefix)+8)
+	copy(key, heightPrefix)
+	binary.BigEndian.PutUint64(key[len(heightPrefix):], num)
+	return key
+}
+
+// addHeight records that the node reported the block at the given height
+func (db *blockDB) addHeight(num uint64, hash common.Hash, node string, t time.Time) error {
+	key := append(append(heightKey(num), hash[:]...), node...)
+	var val [8]byte
+	binary.BigEndian.PutUint64(val[:], uint64(t.Unix()))
+	return db.db.Put(key, val[:], nil)
+}
+
+// pruneHeights deletes the blocks last reported before the cutoff from the
+// index, and returns how many entries were deleted. Entries from before the
+// time was recorded are deleted too.
+func (db *blockDB) pruneHeights(cutoff time.Time) (int, error) {
+	return db.deleteWhere(heightPrefix, func(key, val []byte) bool {
+		return len(val) != 8 || int64(binary.BigEndian.Uint64(val)) < cutoff.Unix()
+	})
+}
+
+// heightVariant is a block seen at some height, along with the nodes which
+// reported it
+type heightVariant struct {
+	HeaderInfo
+	Nodes []string
+}
+
+// hashesAt returns every block seen at the given height, ordered by hash
+func (db *blockDB) hashesAt(num uint64) ([]*heightVariant, error) {
+	prefix := heightKey(num)
+	it := db.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer it.Release()
+
+	var (
+		variants []*heightVariant
+		byHash   = make(map[common.Hash]*heightVariant)
+	)
+	for it.Next() {
+		key := it.Key()[len(prefix):]
+		if len(key) < common.HashLength {
+			continue
+		}
+		hash := common.BytesToHash(key[:common.HashLength])
+		v, ok := byHash[hash]
+		if !ok {
+			v = &heightVariant{HeaderInfo: HeaderInfo{Hash: hash, Number: num}}
+			if h := db.get(hash); h != nil {
+				v.Parent, v.Time = h.ParentHash, h.Time
+			}
+			byHash[hash] = v
+			variants = append(variants, v)
+		}
+		v.Nodes = append(v.Nodes, string(key[common.HashLength:]))
+	}
+	// Keys are ordered, so the variants and their nodes are too
+	return variants, it.Error()
+}
+
+// HandleHeight serves GET /api/height/{n}, listing every block seen at the
+// given height, and which nodes reported it.
+func (mon *NodeMonitor) HandleHeight(w http.ResponseWriter, r *http.Request) {
+	if mon.backend == nil {
+		http.Error(w, "no block database", http.StatusNotFound)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	num, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/api/height/"), 0, 64)
+	if err != nil {
+		http.Error(w, "invalid block number", http.StatusBadRequest)
+		return
+	}
+	variants, err := mon.backend.hashesAt(num)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if variants == nil {
+		variants = []*heightVariant{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(variants)
+}
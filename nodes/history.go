@@ -0,0 +1,113 @@
+package nodes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// reportPrefix is the key prefix for archived reports, followed by the
+// big-endian unix time (in nanoseconds) of the report.
+var reportPrefix = []byte("report-")
+
+var errNoReport = errors.New("no report archived at or before the given time")
+
+func reportKey(t time.Time) []byte {
+	key := make([]byte, len(reportPrefix)+8)
+	copy(key, reportPrefix)
+	binary.BigEndian.PutUint64(key[len(reportPrefix):], uint64(t.UnixNano()))
+	return key
+}
+
+// addReport archives the serialized report, generated at the given time.
+func (db *blockDB) addReport(t time.Time, data []byte) error {
+	return db.db.Put(reportKey(t), data, nil)
+}
+
+// reportAt returns the most recent report archived at or before the given
+// time, along with the time it was generated.
+func (db *blockDB) reportAt(t time.Time) (time.Time, []byte, error) {
+	it := db.db.NewIterator(util.BytesPrefix(reportPrefix), nil)
+	defer it.Release()
+
+	key := reportKey(t)
+	var found bool
+	if it.Seek(key) {
+		// Seek lands on the first key >= the one we want. Unless it's an
+		// exact hit, the one we're after is the one before.
+		found = bytes.Equal(it.Key(), key) || it.Prev()
+	} else {
+		found = it.Last()
+	}
+	if !found {
+		return time.Time{}, nil, errNoReport
+	}
+	nanos := binary.BigEndian.Uint64(it.Key()[len(reportPrefix):])
+	return time.Unix(0, int64(nanos)), common.CopyBytes(it.Value()), nil
+}
+
+// reportsBetween returns the reports archived within the given time range,
+// oldest first
+func (db *blockDB) reportsBetween(from, to time.Time) ([]*archivedReport, error) {
+	it := db.db.NewIterator(&util.Range{
+		Start: reportKey(from),
+		Limit: util.BytesPrefix(reportPrefix).Limit,
+	}, nil)
+	defer it.Release()
+
+	var reports []*archivedReport
+	for it.Next() {
+		nanos := binary.BigEndian.Uint64(it.Key()[len(reportPrefix):])
+		when := time.Unix(0, int64(nanos))
+		if when.After(to) {
+			break
+		}
+		reports = append(reports, &archivedReport{when, common.CopyBytes(it.Value())})
+	}
+	return reports, it.Error()
+}
+
+type archivedReport struct {
+	Time   time.Time
+	Report json.RawMessage
+}
+
+// HandleReports serves archived reports. The 'time' query parameter selects
+// the report as of the given time, either in RFC3339 format or as unix
+// seconds. If omitted, the latest report is returned.
+func (mon *NodeMonitor) HandleReports(w http.ResponseWriter, r *http.Request) {
+	if mon.backend == nil || !mon.archive {
+		http.Error(w, "report archiving not enabled", http.StatusNotFound)
+		return
+	}
+	t := time.Now()
+	if q := r.URL.Query().Get("time"); len(q) > 0 {
+		var err error
+		if t, err = parseTime(q); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	when, data, err := mon.backend.reportAt(t)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&archivedReport{when, data})
+}
+
+// parseTime parses either an RFC3339 timestamp or unix seconds.
+func parseTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,169 @@
+package nodes
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type httpConfig struct {
+	// BasePath serves the dashboard and API under a path prefix, e.g.
+	// "/monitor", for reverse proxies which don't strip it
+	BasePath string
+	// CORSOrigins are the origins allowed to make cross-origin requests, or
+	// "*" for any
+	CORSOrigins []string
+	// TrustedProxies are the addresses or CIDR ranges of the reverse proxies
+	// whose X-Forwarded-* headers are trusted
+	TrustedProxies []string
+}
+
+func (c *httpConfig) enabled() bool {
+	return len(c.BasePath) > 0 || len(c.CORSOrigins) > 0 || len(c.TrustedProxies) > 0
+}
+
+// corsHeaders are the request headers allowed in cross-origin requests, and
+// exposeHeaders the response headers readable by the scripts
+const (
+	corsHeaders   = "Authorization, Content-Type"
+	exposeHeaders = "X-Monitor-Identity, X-Monitor-Key, X-Signature"
+)
+
+// httpFront handles the concerns of being served behind reverse proxies and
+// to other origins, in front of the routes of the networks
+type httpFront struct {
+	basePath string
+	origins  map[string]bool
+	anyOrig  bool
+	proxies  []*net.IPNet
+	next     http.Handler
+}
+
+// NewHTTPHandler wraps the handler of the server with the base path, CORS
+// and forwarded header handling. Without any configured, the handler is
+// returned as is.
+func NewHTTPHandler(conf httpConfig, next http.Handler) (http.Handler, error) {
+	if !conf.enabled() {
+		return next, nil
+	}
+	f := &httpFront{next: next, origins: make(map[string]bool)}
+	if len(conf.BasePath) > 0 {
+		if !strings.HasPrefix(conf.BasePath, "/") {
+			return nil, fmt.Errorf("base path %q must start with /", conf.BasePath)
+		}
+		f.basePath = strings.TrimSuffix(conf.BasePath, "/")
+	}
+	for _, o := range conf.CORSOrigins {
+		if o == "*" {
+			f.anyOrig = true
+			continue
+		}
+		f.origins[strings.TrimSuffix(o, "/")] = true
+	}
+	for _, p := range conf.TrustedProxies {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", p, err)
+		}
+		f.proxies = append(f.proxies, ipnet)
+	}
+	return f, nil
+}
+
+// trusted checks whether the request comes from a trusted proxy
+func (f *httpFront) trusted(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, p := range f.proxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwarded applies the X-Forwarded-* headers set by a trusted proxy to the
+// request, and returns the path prefix stripped by the proxy, if any
+func (f *httpFront) forwarded(r *http.Request) string {
+	if len(f.proxies) == 0 || !f.trusted(r) {
+		return ""
+	}
+	// The left-most address is the client, as seen by the first proxy
+	if fwd := r.Header.Get("X-Forwarded-For"); len(fwd) > 0 {
+		client := strings.TrimSpace(strings.Split(fwd, ",")[0])
+		if ip := net.ParseIP(client); ip != nil {
+			r.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+		}
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		r.URL.Scheme = proto
+	}
+	if host := r.Header.Get("X-Forwarded-Host"); len(host) > 0 {
+		r.Host = host
+	}
+	return strings.TrimSuffix(r.Header.Get("X-Forwarded-Prefix"), "/")
+}
+
+// cors sets the CORS headers for allowed origins, and returns true if the
+// request was a preflight request, which is answered right away
+func (f *httpFront) cors(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 || (!f.anyOrig && !f.origins[origin]) {
+		return false
+	}
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	if f.origins[origin] {
+		// Credentials are only allowed for explicitly listed origins
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Credentials", "true")
+	} else {
+		h.Set("Access-Control-Allow-Origin", "*")
+	}
+	h.Set("Access-Control-Expose-Headers", exposeHeaders)
+	if r.Method != "OPTIONS" || len(r.Header.Get("Access-Control-Request-Method")) == 0 {
+		return false
+	}
+	h.Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	h.Set("Access-Control-Allow-Headers", corsHeaders)
+	h.Set("Access-Control-Max-Age", "600")
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+func (f *httpFront) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	prefix := f.forwarded(r)
+	if len(f.origins) > 0 || f.anyOrig {
+		if f.cors(w, r) {
+			return
+		}
+	}
+	if len(f.basePath) > 0 {
+		switch {
+		case r.URL.Path == f.basePath:
+			// Relative links of the dashboard need the trailing slash
+			http.Redirect(w, r, prefix+f.basePath+"/", http.StatusMovedPermanently)
+			return
+		case !strings.HasPrefix(r.URL.Path, f.basePath+"/"):
+			http.NotFound(w, r)
+			return
+		}
+		http.StripPrefix(f.basePath, f.next).ServeHTTP(w, r)
+		return
+	}
+	f.next.ServeHTTP(w, r)
+}
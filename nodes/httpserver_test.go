@@ -0,0 +1,72 @@
+package nodes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPFront(t *testing.T) {
+	var seen *http.Request
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+	})
+	h, err := NewHTTPHandler(httpConfig{
+		BasePath:       "/monitor/",
+		CORSOrigins:    []string{"https://grafana.example.com"},
+		TrustedProxies: []string{"10.0.0.0/8"},
+	}, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		seen = nil
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	// The base path is stripped, other paths aren't served
+	if serve(httptest.NewRequest("GET", "/monitor/api/reports", nil)); seen == nil || seen.URL.Path != "/api/reports" {
+		t.Fatalf("base path not stripped: %+v", seen)
+	}
+	if rec := serve(httptest.NewRequest("GET", "/api/reports", nil)); rec.Code != http.StatusNotFound || seen != nil {
+		t.Errorf("path outside the base path served: %d", rec.Code)
+	}
+	// Forwarded headers are only trusted from the proxies
+	req := httptest.NewRequest("GET", "/monitor", nil)
+	req.RemoteAddr = "10.1.2.3:4567"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.1.2.3")
+	req.Header.Set("X-Forwarded-Prefix", "/ops/")
+	if rec := serve(req); rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/ops/monitor/" {
+		t.Errorf("wrong redirect: %d %v", rec.Code, rec.Header().Get("Location"))
+	}
+	req = httptest.NewRequest("GET", "/monitor/", nil)
+	req.RemoteAddr = "10.1.2.3:4567"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	if serve(req); seen == nil || seen.RemoteAddr != "203.0.113.7:0" || seen.URL.Scheme != "https" {
+		t.Errorf("forwarded headers not applied: %+v", seen)
+	}
+	req = httptest.NewRequest("GET", "/monitor/", nil)
+	req.RemoteAddr = "192.0.2.1:4567"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	if serve(req); seen == nil || seen.RemoteAddr != "192.0.2.1:4567" {
+		t.Errorf("forwarded headers of untrusted peer applied: %+v", seen)
+	}
+	// Preflight requests of allowed origins are answered right away
+	req = httptest.NewRequest("OPTIONS", "/monitor/api/mute", nil)
+	req.Header.Set("Origin", "https://grafana.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rec := serve(req)
+	if rec.Code != http.StatusNoContent || seen != nil || rec.Header().Get("Access-Control-Allow-Origin") != "https://grafana.example.com" {
+		t.Errorf("preflight not answered: %d %v", rec.Code, rec.Header())
+	}
+	req = httptest.NewRequest("GET", "/monitor/api/reports", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	if rec := serve(req); rec.Header().Get("Access-Control-Allow-Origin") != "" || seen == nil {
+		t.Errorf("other origin allowed: %v", rec.Header())
+	}
+	if _, err := NewHTTPHandler(httpConfig{BasePath: "monitor"}, next); err == nil {
+		t.Error("relative base path accepted")
+	}
+}
@@ -0,0 +1,163 @@
+package nodes
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// identityNode is implemented by execution and beacon nodes which can tell
+// their p2p identity
+type identityNode interface {
+	Name() string
+	Identity() (*identityJson, error)
+}
+
+// identityJson is the p2p identity of a node
+type identityJson struct {
+	// ID is the node id of execution nodes, and the peer id of beacon nodes
+	ID    string
+	Enode string `json:",omitempty"`
+	ENR   string `json:",omitempty"`
+	// DiscoveryPort and ListenerPort are those of execution nodes
+	DiscoveryPort int `json:",omitempty"`
+	ListenerPort  int `json:",omitempty"`
+	// Addresses are the p2p and discovery multiaddrs of beacon nodes
+	Addresses []string `json:",omitempty"`
+}
+
+// Identity fetches the identity via admin_nodeInfo
+func (node *RPCNode) Identity() (*identityJson, error) {
+	node.throttle.Take()
+	var info struct {
+		ID    string `json:"id"`
+		Enode string `json:"enode"`
+		ENR   string `json:"enr"`
+		Ports struct {
+			Discovery int `json:"discovery"`
+			Listener  int `json:"listener"`
+		} `json:"ports"`
+	}
+	if err := node.rpcCli.CallContext(context.Background(), &info, "admin_nodeInfo"); err != nil {
+		return nil, wrapNodeError(node.name, "identity", err)
+	}
+	return &identityJson{
+		ID:            info.ID,
+		Enode:         info.Enode,
+		ENR:           info.ENR,
+		DiscoveryPort: info.Ports.Discovery,
+		ListenerPort:  info.Ports.Listener,
+	}, nil
+}
+
+// Identity fetches the identity via /eth/v1/node/identity
+func (node *BeaconNode) Identity() (*identityJson, error) {
+	var res struct {
+		PeerID             string   `json:"peer_id"`
+		ENR                string   `json:"enr"`
+		P2PAddresses       []string `json:"p2p_addresses"`
+		DiscoveryAddresses []string `json:"discovery_addresses"`
+	}
+	if err := node.get("/eth/v1/node/identity", &res); err != nil {
+		return nil, err
+	}
+	return &identityJson{
+		ID:        res.PeerID,
+		ENR:       res.ENR,
+		Addresses: append(res.P2PAddresses, res.DiscoveryAddresses...),
+	}, nil
+}
+
+// identities are the identities of the nodes, by name, as captured once they
+// were first reachable
+type identities struct {
+	known       map[string]*identityJson
+	unsupported map[string]bool
+}
+
+func newIdentities() *identities {
+	return &identities{
+		known:       make(map[string]*identityJson),
+		unsupported: make(map[string]bool),
+	}
+}
+
+func (ids *identities) forget(name string) {
+	delete(ids.known, name)
+	delete(ids.unsupported, name)
+}
+
+// capture fetches the identities of the nodes which aren't known yet
+func (ids *identities) capture(nodes []identityNode) {
+	for _, node := range nodes {
+		if ids.unsupported[node.Name()] || ids.known[node.Name()] != nil {
+			continue
+		}
+		id, err := node.Identity()
+		if err != nil {
+			if isMethodNotFound(err) || errors.Is(err, errNotFound) {
+				log.Info("Node doesn't tell its identity", "node", node.Name())
+				ids.unsupported[node.Name()] = true
+			} else {
+				log.Debug("Failed to fetch node identity", "node", node.Name(), "error", err)
+			}
+			continue
+		}
+		log.Info("Node identity", "node", node.Name(), "id", id.ID, "enode", id.Enode, "enr", id.ENR)
+		ids.known[node.Name()] = id
+	}
+}
+
+// duplicates returns the groups of nodes with the same identity, which are
+// the same node behind different endpoints
+func (ids *identities) duplicates() [][]string {
+	byID := make(map[string][]string)
+	for name, id := range ids.known {
+		if len(id.ID) > 0 {
+			byID[id.ID] = append(byID[id.ID], name)
+		}
+	}
+	var dups [][]string
+	for _, names := range byID {
+		if len(names) > 1 {
+			sort.Strings(names)
+			dups = append(dups, names)
+		}
+	}
+	sort.Slice(dups, func(i, j int) bool { return dups[i][0] < dups[j][0] })
+	return dups
+}
+
+// checkIdentities captures the identities of the reachable nodes, and warns
+// about endpoints which turn out to be the same node
+func (mon *NodeMonitor) checkIdentities(nodes []Node) {
+	var list []identityNode
+	for _, n := range nodes {
+		if node, ok := n.(identityNode); ok {
+			list = append(list, node)
+		}
+	}
+	for _, node := range mon.beacons {
+		if node.Status() == NodeStatusOK {
+			list = append(list, node)
+		}
+	}
+	mon.identities.capture(list)
+	for _, names := range mon.identities.duplicates() {
+		mon.emit(EventDuplicateNode, SeverityWarning, names,
+			"Endpoints %v are the same node", strings.Join(names, ", "))
+	}
+}
+
+// setIdentities adds the identities to the nodes of the report
+func (r *Report) setIdentities(ids *identities) {
+	for _, c := range r.Cols {
+		c.Identity = ids.known[c.Name]
+	}
+	for _, b := range r.Beacons {
+		b.Identity = ids.known[b.Name]
+	}
+}
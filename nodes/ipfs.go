@@ -0,0 +1,135 @@
+package nodes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+type ipfsConfig struct {
+	// Api is the url of the RPC API of an IPFS node, e.g. http://127.0.0.1:5001.
+	// Pinning is disabled if empty.
+	Api string
+}
+
+// ipfsJson links the report to the content pinned to IPFS
+type ipfsJson struct {
+	// Previous is the CID of the previous report, so that the reports form a
+	// chain which can't be altered after the fact
+	Previous string `json:",omitempty"`
+	// Files are the CIDs of the split artifacts and digests, by path
+	Files map[string]string `json:",omitempty"`
+}
+
+// ipfsPinner adds and pins the reports and artifacts to an IPFS node, in
+// the background
+type ipfsPinner struct {
+	url    string
+	client *http.Client
+	queue  chan *bucketObject
+
+	mu    sync.Mutex
+	last  string            // CID of the last report pinned
+	files map[string]string // CIDs of the artifacts pinned
+}
+
+func newIpfsPinner(conf ipfsConfig) *ipfsPinner {
+	if len(conf.Api) == 0 {
+		return nil
+	}
+	return &ipfsPinner{
+		url:    strings.TrimSuffix(conf.Api, "/") + "/api/v0/add?pin=true&cid-version=1",
+		client: &http.Client{Timeout: time.Minute},
+		queue:  make(chan *bucketObject, bucketQueue),
+		files:  make(map[string]string),
+	}
+}
+
+// put queues the file for pinning. Reports are told apart from the other
+// files by their key.
+func (p *ipfsPinner) put(key string, data []byte) {
+	select {
+	case p.queue <- &bucketObject{key, data}:
+	default:
+		log.Warn("IPFS queue full, dropping file", "key", key)
+	}
+}
+
+// add adds and pins the file, and returns its CID
+func (p *ipfsPinner) add(name string, data []byte) (string, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		return "", err
+	}
+	fw.Write(data)
+	mw.Close()
+	res, err := p.client.Post(p.url, mw.FormDataContentType(), &body)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(res.Body)
+		return "", fmt.Errorf("ipfs add failed: %v: %s", res.Status, msg)
+	}
+	var added struct {
+		Hash string
+	}
+	if err := json.NewDecoder(res.Body).Decode(&added); err != nil {
+		return "", err
+	}
+	return added.Hash, nil
+}
+
+// pin adds the file, and records its CID
+func (p *ipfsPinner) pin(obj *bucketObject) error {
+	cid, err := p.add(path.Base(obj.key), obj.data)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if strings.HasPrefix(obj.key, "reports/") {
+		p.last = cid
+	} else {
+		p.files[obj.key] = cid
+	}
+	return nil
+}
+
+// report returns the CIDs pinned so far
+func (p *ipfsPinner) report() *ipfsJson {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	files := make(map[string]string, len(p.files))
+	for k, v := range p.files {
+		files[k] = v
+	}
+	return &ipfsJson{Previous: p.last, Files: files}
+}
+
+// pinFiles pins the queued files until the monitor stops
+func (mon *NodeMonitor) pinFiles() {
+	defer mon.wg.Done()
+	for {
+		select {
+		case <-mon.quitCh:
+			return
+		case obj := <-mon.ipfs.queue:
+			if err := mon.ipfs.pin(obj); err != nil {
+				log.Warn("Failed to pin to IPFS", "key", obj.key, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package nodes
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type kafkaConfig struct {
+	// RestProxy is the url of the Kafka REST proxy, e.g. http://localhost:8082
+	RestProxy string
+	Topic     string
+	// Format is "json" (default) or "avro". Avro needs a schema registry
+	// behind the proxy.
+	Format   string
+	Username string
+	Password string
+}
+
+// streamAvroSchema is the schema of the stream messages
+const streamAvroSchema = `{"type": "record", "name": "MonitoringEvent", "namespace": "nodemonitor", "fields": [
+	{"name": "type", "type": "string"},
+	{"name": "time", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+	{"name": "node", "type": "string"},
+	{"name": "head", "type": "long"},
+	{"name": "status", "type": "string"},
+	{"name": "kind", "type": "string"},
+	{"name": "severity", "type": "string"},
+	{"name": "nodes", "type": {"type": "array", "items": "string"}},
+	{"name": "message", "type": "string"},
+	{"name": "block", "type": "long"}
+]}`
+
+// kafkaPublisher produces the stream messages to a Kafka topic, via the REST
+// proxy. Messages about a node are keyed by its name, so they stay in order,
+// and events by their kind.
+type kafkaPublisher struct {
+	url    string
+	format string
+	header http.Header
+}
+
+func newKafkaPublisher(conf kafkaConfig) (*kafkaPublisher, error) {
+	if len(conf.RestProxy) == 0 {
+		return nil, nil
+	}
+	if len(conf.Topic) == 0 {
+		return nil, errors.New("kafka needs a topic")
+	}
+	format := conf.Format
+	if len(format) == 0 {
+		format = "json"
+	}
+	if format != "json" && format != "avro" {
+		return nil, fmt.Errorf("invalid kafka format %q, available: [json, avro]", format)
+	}
+	header := make(http.Header)
+	header.Set("Accept", "application/vnd.kafka.v2+json")
+	header.Set("Content-Type", fmt.Sprintf("application/vnd.kafka.%v.v2+json", format))
+	if len(conf.Username) > 0 {
+		auth := base64.StdEncoding.EncodeToString([]byte(conf.Username + ":" + conf.Password))
+		header.Set("Authorization", "Basic "+auth)
+	}
+	return &kafkaPublisher{
+		url:    fmt.Sprintf("%v/topics/%v", strings.TrimSuffix(conf.RestProxy, "/"), url.PathEscape(conf.Topic)),
+		format: format,
+		header: header,
+	}, nil
+}
+
+type kafkaRecord struct {
+	Key   string         `json:"key"`
+	Value *streamMessage `json:"value"`
+}
+
+type kafkaRecords struct {
+	KeySchema   string        `json:"key_schema,omitempty"`
+	ValueSchema string        `json:"value_schema,omitempty"`
+	Records     []kafkaRecord `json:"records"`
+}
+
+func (p *kafkaPublisher) publish(msgs []*streamMessage) error {
+	body := &kafkaRecords{}
+	for _, msg := range msgs {
+		key := msg.Node
+		if msg.Type == streamEvent {
+			key = msg.Kind
+		}
+		body.Records = append(body.Records, kafkaRecord{Key: key, Value: msg})
+	}
+	if p.format == "avro" {
+		body.KeySchema, body.ValueSchema = `"string"`, streamAvroSchema
+	}
+	return postJSON("POST", p.url, body, p.header)
+}
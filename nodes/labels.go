@@ -0,0 +1,117 @@
+package nodes
+
+import (
+	"sort"
+	"strings"
+)
+
+// nodeLabels maps node names to the labels configured for them
+type nodeLabels map[string]map[string]string
+
+func newNodeLabels(clients []ClientInfo) nodeLabels {
+	labels := make(nodeLabels)
+	for _, c := range clients {
+		if len(c.Labels) > 0 {
+			labels[c.Name] = c.Labels
+		}
+	}
+	return labels
+}
+
+// setLabels attaches the configured labels to the nodes in the report
+func (r *Report) setLabels(labels nodeLabels) {
+	for _, c := range r.Cols {
+		c.Labels = labels[c.Name]
+	}
+	for _, b := range r.Beacons {
+		b.Labels = labels[b.Name]
+	}
+}
+
+// influxTags returns the labels as line protocol tags, ordered by key
+func influxTags(labels map[string]string) string {
+	var keys []string
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var tags strings.Builder
+	for _, k := range keys {
+		tags.WriteString("," + influxEscape(k) + "=" + influxEscape(labels[k]))
+	}
+	return tags.String()
+}
+
+// withNetwork returns the labels with the network added, if it's named and not
+// labelled otherwise already
+func withNetwork(labels map[string]string, network string) map[string]string {
+	if _, ok := labels["network"]; ok || len(network) == 0 {
+		return labels
+	}
+	res := map[string]string{"network": network}
+	for k, v := range labels {
+		res[k] = v
+	}
+	return res
+}
+
+// groupSplitJson is a split between all nodes of one group and all nodes of
+// another, as grouped by a label
+type groupSplitJson struct {
+	Label  string
+	Groups [2]string
+	// Block is the first block the groups disagree on
+	Block int
+}
+
+// splitSet holds the split blocks found between pairs of nodes
+type splitSet map[[2]string]int
+
+func (s splitSet) add(a, b string, block int) {
+	s[[2]string{a, b}] = block
+}
+
+func (s splitSet) get(a, b string) (int, bool) {
+	if block, ok := s[[2]string{a, b}]; ok {
+		return block, true
+	}
+	block, ok := s[[2]string{b, a}]
+	return block, ok
+}
+
+// groupSplits aggregates the pairwise splits between the active nodes per
+// group, and returns the pairs of groups where every node of one diverged
+// from every node of the other. Nodes without the label are not grouped.
+func (labels nodeLabels) groupSplits(label string, active []Node, splits splitSet) []*groupSplitJson {
+	groups := make(map[string][]string)
+	for _, node := range active {
+		if value, ok := labels[node.Name()][label]; ok {
+			groups[value] = append(groups[value], node.Name())
+		}
+	}
+	var names []string
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var res []*groupSplitJson
+	for i := 0; i < len(names); i++ {
+		for j := i + 1; j < len(names); j++ {
+			first, all := -1, true
+			for _, a := range groups[names[i]] {
+				for _, b := range groups[names[j]] {
+					block, ok := splits.get(a, b)
+					all = all && ok
+					if ok && (first < 0 || block < first) {
+						first = block
+					}
+				}
+			}
+			if all {
+				res = append(res, &groupSplitJson{Label: label, Groups: [2]string{names[i], names[j]}, Block: first})
+			}
+		}
+	}
+	return res
+}
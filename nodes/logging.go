@@ -0,0 +1,40 @@
+package nodes
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// SetupLogging configures the root logger according to the config. By default,
+// logs are written in terminal format to stderr, at info level.
+func SetupLogging(conf *Config) error {
+	lvl := log.LvlInfo
+	if len(conf.Logging.Level) > 0 {
+		var err error
+		if lvl, err = log.LvlFromString(conf.Logging.Level); err != nil {
+			return err
+		}
+	}
+	var format log.Format
+	switch conf.Logging.Format {
+	case "", "terminal":
+		format = log.TerminalFormat(false)
+	case "json":
+		format = log.JSONFormat()
+	case "logfmt":
+		format = log.LogfmtFormat()
+	default:
+		return fmt.Errorf("invalid log format %q, available: [terminal, json, logfmt]", conf.Logging.Format)
+	}
+	handler := log.StreamHandler(os.Stderr, format)
+	if len(conf.Logging.File) > 0 {
+		var err error
+		if handler, err = log.FileHandler(conf.Logging.File, format); err != nil {
+			return err
+		}
+	}
+	log.Root().SetHandler(log.LvlFilterHandler(lvl, handler))
+	return nil
+}
@@ -0,0 +1,74 @@
+package nodes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+func TestSetupLogging(t *testing.T) {
+	defer log.Root().SetHandler(log.Root().GetHandler())
+	dir, err := ioutil.TempDir("", "logging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// logTo sets up logging into a fresh file, logs a message at every level
+	// and returns the lines written
+	logTo := func(name, format, level string) []string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := SetupLogging(&Config{Logging: loggingConfig{Format: format, Level: level, File: path}}); err != nil {
+			t.Fatal(err)
+		}
+		log.Debug("debug message", "node", "geth")
+		log.Info("info message", "node", "geth")
+		log.Warn("warn message", "node", "geth")
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+	// json, at info level by default
+	lines := logTo("json.log", "json", "")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, have %d: %v", len(lines), lines)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("invalid json line %q: %v", lines[0], err)
+	}
+	if entry["msg"] != "info message" || entry["lvl"] != "info" || entry["node"] != "geth" {
+		t.Errorf("wrong json entry: %v", entry)
+	}
+	// logfmt, at debug level
+	lines = logTo("logfmt.log", "logfmt", "debug")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, have %d: %v", len(lines), lines)
+	}
+	for _, want := range []string{"lvl=dbug", `msg="debug message"`, "node=geth"} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("logfmt line %q lacks %q", lines[0], want)
+		}
+	}
+	// Only warnings and worse
+	lines = logTo("warn.log", "", "warn")
+	if len(lines) != 1 || !strings.Contains(lines[0], "warn message") {
+		t.Errorf("wrong lines at warn level: %v", lines)
+	}
+	for _, conf := range []loggingConfig{
+		{Format: "xml"},
+		{Level: "loud"},
+	} {
+		if err := SetupLogging(&Config{Logging: conf}); err == nil {
+			t.Errorf("expected %+v to be rejected", conf)
+		}
+	}
+}
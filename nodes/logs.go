@@ -0,0 +1,207 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+type logQueryConfig struct {
+	// Addresses the logs are filtered by, any if empty
+	Addresses []string
+	// Topics filter the logs by position, as in eth_getLogs. An empty
+	// position matches any topic.
+	Topics [][]string
+	// Blocks is the number of blocks queried, up to the lowest head. The
+	// query is run again once the head advanced that many blocks. Defaults
+	// to 16.
+	Blocks uint64
+}
+
+// logQuery is an eth_getLogs filter, whose results all nodes on the same
+// chain must agree on
+type logQuery struct {
+	addresses []common.Address
+	topics    [][]common.Hash
+	blocks    uint64
+	// last is the last block of the previously queried range
+	last uint64
+}
+
+func newLogQueries(confs []logQueryConfig) ([]*logQuery, error) {
+	var queries []*logQuery
+	for i, conf := range confs {
+		q := &logQuery{blocks: conf.Blocks}
+		if q.blocks == 0 {
+			q.blocks = 16
+		}
+		for _, addr := range conf.Addresses {
+			if !common.IsHexAddress(addr) {
+				return nil, fmt.Errorf("log check %d: invalid address %q", i, addr)
+			}
+			q.addresses = append(q.addresses, common.HexToAddress(addr))
+		}
+		for _, position := range conf.Topics {
+			var topics []common.Hash
+			for _, topic := range position {
+				b, err := hexutil.Decode(topic)
+				if err != nil || len(b) != common.HashLength {
+					return nil, fmt.Errorf("log check %d: invalid topic %q", i, topic)
+				}
+				topics = append(topics, common.BytesToHash(b))
+			}
+			q.topics = append(q.topics, topics)
+		}
+		queries = append(queries, q)
+	}
+	return queries, nil
+}
+
+// due returns the block range to query if the head advanced far enough
+// since the last query
+func (q *logQuery) due(head uint64) (from, to uint64, ok bool) {
+	if q.last != 0 && head < q.last+q.blocks {
+		return 0, 0, false
+	}
+	if head+1 > q.blocks {
+		from = head + 1 - q.blocks
+	}
+	return from, head, true
+}
+
+// filter returns the query as the eth_getLogs filter object
+func (q *logQuery) filter(from, to uint64) map[string]interface{} {
+	filter := map[string]interface{}{
+		"fromBlock": hexutil.EncodeUint64(from),
+		"toBlock":   hexutil.EncodeUint64(to),
+	}
+	if len(q.addresses) > 0 {
+		filter["address"] = q.addresses
+	}
+	if len(q.topics) > 0 {
+		var topics []interface{}
+		for _, position := range q.topics {
+			if len(position) == 0 {
+				topics = append(topics, nil)
+			} else {
+				topics = append(topics, position)
+			}
+		}
+		filter["topics"] = topics
+	}
+	return filter
+}
+
+// logNode is implemented by nodes which can serve log queries
+type logNode interface {
+	Node
+	// Logs returns the logs matching the query within the block range
+	Logs(q *logQuery, from, to uint64) ([]types.Log, error)
+}
+
+func (node *RPCNode) Logs(q *logQuery, from, to uint64) ([]types.Log, error) {
+	node.throttle.Take()
+	var logs []types.Log
+	err := node.rpcCli.CallContext(context.Background(), &logs, "eth_getLogs", q.filter(from, to))
+	return logs, wrapNodeError(node.name, "logs", err)
+}
+
+// logsEqual compares two logs field by field
+func logsEqual(a, b *types.Log) bool {
+	if a.Address != b.Address || a.BlockNumber != b.BlockNumber || a.TxHash != b.TxHash ||
+		a.TxIndex != b.TxIndex || a.BlockHash != b.BlockHash || a.Index != b.Index ||
+		a.Removed != b.Removed || string(a.Data) != string(b.Data) || len(a.Topics) != len(b.Topics) {
+		return false
+	}
+	for i := range a.Topics {
+		if a.Topics[i] != b.Topics[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// firstLogDiff returns the index of the first log at which the lists
+// differ, or -1 if they're the same
+func firstLogDiff(a, b []types.Log) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if !logsEqual(&a[i], &b[i]) {
+			return i
+		}
+	}
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return len(a)
+		}
+		return len(b)
+	}
+	return -1
+}
+
+// checkLogs runs the log queries which are due over the recent blocks, and
+// compares the results of nodes on the same chain. Log indexing bugs often
+// differ between clients, even when they agree on every block. Nodes which
+// fail to answer are skipped.
+func (mon *NodeMonitor) checkLogs(active []Node) {
+	if len(mon.logQueries) == 0 {
+		return
+	}
+	var nodes []Node
+	for _, n := range active {
+		if _, ok := n.(logNode); ok {
+			nodes = append(nodes, n)
+		}
+	}
+	lowest, groups := blockGroups(nodes)
+	if len(groups) == 0 {
+		return
+	}
+	for _, q := range mon.logQueries {
+		from, to, ok := q.due(lowest)
+		if !ok {
+			// Mismatches found earlier stand until the query runs again
+			mon.alerts.hold(EventLogMismatch)
+			continue
+		}
+		q.last = to
+		for _, group := range groups {
+			var (
+				names   []string
+				results [][]types.Log
+			)
+			for _, node := range group {
+				logs, err := node.(logNode).Logs(q, from, to)
+				if err != nil {
+					log.Debug("Log query failed", "node", node.Name(), "error", err)
+					continue
+				}
+				names = append(names, node.Name())
+				results = append(results, logs)
+			}
+			if len(results) < 2 {
+				continue
+			}
+			diff := -1
+			for _, logs := range results[1:] {
+				if d := firstLogDiff(results[0], logs); d >= 0 && (diff < 0 || d < diff) {
+					diff = d
+				}
+			}
+			if diff < 0 {
+				continue
+			}
+			var counts []string
+			for i, name := range names {
+				counts = append(counts, fmt.Sprintf("%v=%d", name, len(results[i])))
+			}
+			mon.emitBlock(EventLogMismatch, SeverityWarning, names, to, nil,
+				"Logs of blocks %d-%d differ from log %d on, number of logs: %v",
+				from, to, diff, strings.Join(counts, ", "))
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package nodes
+
+import (
+	"container/list"
+	"sync"
+)
+
+// hashCacheSize is the number of blocks remembered per node. Split searches
+// and pairwise checks keep asking for the same numbers, within and across
+// cycles, so most lookups are served from the cache.
+var hashCacheSize = 8192
+
+// blockCache is a least-recently-used cache of the blocks of a node, by number.
+// It's safe for concurrent use, since a node may be in several pairs being
+// compared at once.
+type blockCache struct {
+	mu    sync.Mutex
+	size  int
+	items map[uint64]*list.Element
+	order *list.List // most recently used first
+}
+
+func newBlockCache(size int) *blockCache {
+	return &blockCache{
+		size:  size,
+		items: make(map[uint64]*list.Element),
+		order: list.New(),
+	}
+}
+
+func (c *blockCache) get(num uint64) (*blockInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[num]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*blockInfo), true
+}
+
+func (c *blockCache) add(bl *blockInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[bl.num]; ok {
+		el.Value = bl
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[bl.num] = c.order.PushFront(bl)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*blockInfo).num)
+	}
+}
+
+func (c *blockCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
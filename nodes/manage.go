@@ -0,0 +1,255 @@
+package nodes
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/naoina/toml"
+)
+
+// SetConfigFile sets the file that changes to the monitored nodes made via the
+// API are persisted to.
+func (mon *NodeMonitor) SetConfigFile(path string) {
+	mon.confLock.Lock()
+	defer mon.confLock.Unlock()
+	mon.confPath = path
+}
+
+// persistConfig writes the config back to the config file, if any. The caller
+// must hold confLock.
+func (mon *NodeMonitor) persistConfig() error {
+	if len(mon.confPath) == 0 {
+		return nil
+	}
+	data, err := toml.Marshal(mon.conf)
+	if err != nil {
+		return err
+	}
+	tmp := mon.confPath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, mon.confPath)
+}
+
+// schedule queues a change to the monitored nodes, to be applied by the check
+// loop at the start of the next cycle.
+func (mon *NodeMonitor) schedule(change func()) {
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+	mon.pending = append(mon.pending, change)
+}
+
+// applyPending applies the queued changes to the monitored nodes
+func (mon *NodeMonitor) applyPending() {
+	mon.mu.Lock()
+	pending := mon.pending
+	mon.pending = nil
+	mon.mu.Unlock()
+	for _, change := range pending {
+		change()
+	}
+}
+
+// AddClient starts monitoring the described node, and persists it to the
+// config.
+func (mon *NodeMonitor) AddClient(c ClientInfo) error {
+	mon.confLock.Lock()
+	defer mon.confLock.Unlock()
+	if len(c.Name) == 0 {
+		return errors.New("missing name")
+	}
+	for _, existing := range mon.conf.Clients {
+		if existing.Name == c.Name {
+			return fmt.Errorf("node %v already exists", c.Name)
+		}
+	}
+	if _, err := c.options(); err != nil {
+		return err
+	}
+	node, beacon, err := NewClient(&c, mon.conf, mon.backend)
+	if err != nil {
+		return err
+	}
+	// Checked before persisting, added by the loop
+	if _, err := newMetricsScraper(mon.conf.Scrape, []ClientInfo{c}); err != nil {
+		return err
+	}
+	mon.conf.Clients = append(mon.conf.Clients, c)
+	if err := mon.persistConfig(); err != nil {
+		mon.conf.Clients = mon.conf.Clients[:len(mon.conf.Clients)-1]
+		return err
+	}
+	mon.schedule(func() {
+		if beacon != nil {
+			mon.beacons = append(mon.beacons, beacon)
+		} else {
+			mon.nodes = append(mon.nodes, node)
+			mon.startWatcher(node)
+			if mon.collector != nil {
+				mon.collector.add(node)
+			}
+			if mon.ethstats != nil {
+				mon.ethstats.add(node)
+			}
+		}
+		if len(c.Labels) > 0 {
+			mon.labels[c.Name] = c.Labels
+		}
+		if c.Reference {
+			mon.references[c.Name] = true
+		}
+		if opts, _ := c.options(); opts != nil {
+			mon.opts[c.Name] = opts
+			mon.alerts.coverInterval(opts.interval)
+		}
+		if mon.scraper == nil {
+			mon.scraper, _ = newMetricsScraper(mon.conf.Scrape, []ClientInfo{c})
+		} else {
+			mon.scraper.add(c)
+		}
+		log.Info("Node added", "name", c.Name, "kind", c.Kind)
+	})
+	return nil
+}
+
+// RemoveClient stops monitoring the named node, and removes it from the
+// config.
+func (mon *NodeMonitor) RemoveClient(name string) error {
+	mon.confLock.Lock()
+	defer mon.confLock.Unlock()
+	var (
+		clients []ClientInfo
+		found   bool
+	)
+	for _, c := range mon.conf.Clients {
+		if c.Name == name {
+			found = true
+		} else {
+			clients = append(clients, c)
+		}
+	}
+	if !found {
+		return fmt.Errorf("node %v not found", name)
+	}
+	old := mon.conf.Clients
+	mon.conf.Clients = clients
+	if err := mon.persistConfig(); err != nil {
+		mon.conf.Clients = old
+		return err
+	}
+	mon.schedule(func() {
+		mon.stopWatcher(name)
+		var nodes []Node
+		for _, node := range mon.nodes {
+			if node.Name() != name {
+				nodes = append(nodes, node)
+			}
+		}
+		var beacons []*BeaconNode
+		for _, node := range mon.beacons {
+			if node.Name() != name {
+				beacons = append(beacons, node)
+			}
+		}
+		var pairs []*clientPair
+		for _, pair := range mon.pairs {
+			if pair.beacon.Name() != name && pair.exec.Name() != name {
+				pairs = append(pairs, pair)
+			}
+		}
+		mon.nodes, mon.beacons, mon.pairs = nodes, beacons, pairs
+		delete(mon.labels, name)
+		delete(mon.references, name)
+		delete(mon.opts, name)
+		mon.ancestors.forget(name)
+		mon.heads.forget(name)
+		mon.identities.forget(name)
+		if mon.scraper != nil {
+			mon.scraper.forget(name)
+		}
+		if mon.scorer != nil {
+			mon.scorer.forget(name)
+		}
+		if mon.badBlocks != nil {
+			mon.badBlocks.forget(name)
+		}
+		if mon.splitTraces != nil {
+			mon.splitTraces.forget(name)
+		}
+		if mon.rawBlocks != nil {
+			mon.rawBlocks.forget(name)
+		}
+		if mon.txpools != nil {
+			mon.txpools.forget(name)
+		}
+		if mon.peers != nil {
+			mon.peers.forget(name)
+		}
+		if mon.autoPeers != nil {
+			mon.autoPeers.forget(name)
+		}
+		if mon.collector != nil {
+			mon.collector.forget(name)
+		}
+		if mon.ethstats != nil {
+			mon.ethstats.forget(name)
+		}
+		log.Info("Node removed", "name", name)
+	})
+	return nil
+}
+
+// authorized checks the bearer token of a request against the admin token,
+// unless the request was authenticated as an admin by APIAuth. Without a
+// configured token or API users, the endpoints are disabled.
+func (mon *NodeMonitor) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if apiRole(r) == RoleAdmin {
+		return true
+	}
+	token := mon.conf.AdminToken
+	if len(token) == 0 {
+		http.Error(w, "admin API disabled", http.StatusForbidden)
+		return false
+	}
+	have := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(have), []byte(token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// HandleNodes serves the node management API: POST /api/nodes adds a node
+// described by a json client config, and DELETE /api/nodes/{name} removes
+// one. Changes take effect in the next check cycle.
+func (mon *NodeMonitor) HandleNodes(w http.ResponseWriter, r *http.Request) {
+	if !mon.authorized(w, r) {
+		return
+	}
+	var err error
+	switch name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/nodes"), "/"); {
+	case r.Method == "POST" && len(name) == 0:
+		var c ClientInfo
+		if err = json.NewDecoder(r.Body).Decode(&c); err == nil {
+			err = mon.AddClient(c)
+		}
+	case r.Method == "DELETE" && len(name) > 0:
+		err = mon.RemoveClient(name)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
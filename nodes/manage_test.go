@@ -0,0 +1,120 @@
+package nodes
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/naoina/toml"
+)
+
+func TestManageNodes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodemonitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.toml")
+
+	chain := makeChain("a", 10, nil)
+	a := newTestNode("a", 9, chain)
+	cp := checkpoint{Epoch: 10, Root: [32]byte{0x10}}
+	_, beacon, closeBeacon := newFakeBeacon(t, 360, cp, cp)
+	defer closeBeacon()
+
+	conf := &Config{ReloadInterval: "1s", AdminToken: "secret", Clients: []ClientInfo{{Name: a.Name(), Kind: "rpc"}}}
+	mon, _ := NewMonitor([]Node{a}, nil, nil, conf)
+	mon.SetConfigFile(path)
+
+	request := func(method, path, token, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mon.HandleNodes(w, req)
+		return w.Code
+	}
+	if code := request("POST", "/api/nodes", "wrong", `{}`); code != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized, got %d", code)
+	}
+	body := `{"name": "lighthouse", "kind": "beacon", "url": "` + beacon.url + `"}`
+	if code := request("POST", "/api/nodes", "secret", body); code != http.StatusAccepted {
+		t.Fatalf("failed to add node: %d", code)
+	}
+	if code := request("POST", "/api/nodes", "secret", body); code != http.StatusBadRequest {
+		t.Errorf("expected duplicate node to be rejected, got %d", code)
+	}
+	if code := request("DELETE", "/api/nodes/"+a.Name(), "secret", ""); code != http.StatusAccepted {
+		t.Fatalf("failed to remove node: %d", code)
+	}
+	if code := request("DELETE", "/api/nodes/missing", "secret", ""); code != http.StatusBadRequest {
+		t.Errorf("expected missing node to be rejected, got %d", code)
+	}
+	// The changes are applied in the next cycle
+	mon.doChecks()
+	r := mon.Report()
+	if len(r.Cols) != 0 || len(r.Beacons) != 1 || r.Beacons[0].Name != "lighthouse" {
+		t.Errorf("unexpected nodes after changes: %d clients, %d beacons", len(r.Cols), len(r.Beacons))
+	}
+	// And persisted to the config file
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var persisted Config
+	if err := toml.NewDecoder(f).Decode(&persisted); err != nil {
+		t.Fatal(err)
+	}
+	if len(persisted.Clients) != 1 || persisted.Clients[0].Name != "lighthouse" || persisted.Clients[0].Url != beacon.url {
+		t.Errorf("unexpected persisted clients: %+v", persisted.Clients)
+	}
+}
+
+func TestManageNodesAPIAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodemonitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cp := checkpoint{Epoch: 10, Root: [32]byte{0x10}}
+	_, beacon, closeBeacon := newFakeBeacon(t, 360, cp, cp)
+	defer closeBeacon()
+
+	// The legacy admin token differs from the tokens of the API users
+	conf := &Config{ReloadInterval: "1s", AdminToken: "legacy"}
+	mon, _ := NewMonitor(nil, nil, nil, conf)
+	mon.SetConfigFile(filepath.Join(dir, "config.toml"))
+	auth, err := NewAPIAuth(apiAuthConfig{Users: []apiUser{
+		{Name: "viewer", Password: "pw"},
+		{Name: "admin", Password: "pw", Role: RoleAdmin},
+		{Name: "ops", Token: "secret", Role: RoleAdmin},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := auth.Wrap(http.HandlerFunc(mon.HandleNodes))
+	request := func(method, path, body string, setAuth func(r *http.Request)) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		setAuth(req)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	body := `{"name": "lighthouse", "kind": "beacon", "url": "` + beacon.url + `"}`
+	if code := request("POST", "/api/nodes", body, func(r *http.Request) { r.SetBasicAuth("viewer", "pw") }); code != http.StatusForbidden {
+		t.Errorf("expected reader to be forbidden, got %d", code)
+	}
+	if code := request("POST", "/api/nodes", body, func(r *http.Request) { r.SetBasicAuth("admin", "pw") }); code != http.StatusAccepted {
+		t.Errorf("failed to add node as basic auth admin: %d", code)
+	}
+	if code := request("DELETE", "/api/nodes/lighthouse", "", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }); code != http.StatusAccepted {
+		t.Errorf("failed to remove node as token admin: %d", code)
+	}
+}
@@ -0,0 +1,85 @@
+package nodes
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type matrixConfig struct {
+	// Homeserver is the base url of the homeserver, e.g. https://matrix.org
+	Homeserver  string
+	AccessToken string
+	// RoomID is the internal id of the room (!abc:matrix.org), which the
+	// user of the access token must have joined
+	RoomID string
+	// Kinds are the event kinds to send. Defaults to splits and unreachable
+	// nodes.
+	Kinds []string
+}
+
+// matrixNotifier sends alerts, and their recoveries, as messages to a Matrix
+// room
+type matrixNotifier struct {
+	sendUrl string // endpoint, without the transaction id
+	header  http.Header
+	kinds   kindFilter
+	txn     uint64
+}
+
+func newMatrixNotifier(conf matrixConfig) (*matrixNotifier, error) {
+	if len(conf.Homeserver) == 0 {
+		return nil, nil
+	}
+	if len(conf.AccessToken) == 0 || len(conf.RoomID) == 0 {
+		return nil, errors.New("matrix needs an access_token and a room_id")
+	}
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer "+conf.AccessToken)
+	return &matrixNotifier{
+		sendUrl: fmt.Sprintf("%v/_matrix/client/v3/rooms/%v/send/m.room.message",
+			strings.TrimSuffix(conf.Homeserver, "/"), url.PathEscape(conf.RoomID)),
+		header: header,
+		kinds:  newKindFilter(conf.Kinds, outageKinds...),
+	}, nil
+}
+
+// send posts a message to the room. Each message needs a unique transaction
+// id, which the homeserver uses to deduplicate retries.
+func (n *matrixNotifier) send(text, formatted string) error {
+	txn := fmt.Sprintf("nodemonitor.%d.%d", time.Now().UnixNano(), atomic.AddUint64(&n.txn, 1))
+	return postJSON("PUT", n.sendUrl+"/"+txn, map[string]string{
+		"msgtype":        "m.text",
+		"body":           text,
+		"format":         "org.matrix.custom.html",
+		"formatted_body": formatted,
+	}, n.header)
+}
+
+func (n *matrixNotifier) Notify(ev *Event) error {
+	if !n.kinds.match(ev) {
+		return nil
+	}
+	nodes := strings.Join(ev.Nodes, ", ")
+	return n.send(
+		fmt.Sprintf("[%v] %v: %v (%v)", ev.Severity, ev.Kind, ev.Message, nodes),
+		fmt.Sprintf("<b>[%v] %v</b>: %v<br>Nodes: %v", ev.Severity, html.EscapeString(ev.Kind),
+			html.EscapeString(ev.Message), html.EscapeString(nodes)),
+	)
+}
+
+func (n *matrixNotifier) Recovered(ev *Event) error {
+	if !n.kinds.match(ev) {
+		return nil
+	}
+	nodes := strings.Join(ev.Nodes, ", ")
+	return n.send(
+		fmt.Sprintf("[resolved] %v (%v)", ev.Kind, nodes),
+		fmt.Sprintf("<b>[resolved] %v</b><br>Nodes: %v", html.EscapeString(ev.Kind), html.EscapeString(nodes)),
+	)
+}
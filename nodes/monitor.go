@@ -2,34 +2,150 @@ package nodes
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/syndtr/goleveldb/leveldb"
-	"github.com/syndtr/goleveldb/leveldb/errors"
+	leveldbErrors "github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 )
 
 // NodeMonitor monitors a set of nodes, and performs checks on them
 type NodeMonitor struct {
 	nodes          []Node
+	beacons        []*BeaconNode
+	beaconConf     beaconConfig
+	slots          *slotTracker
+	validators     *validatorTracker
+	balances       *balanceHistory
+	slashings      *slashingWatcher
+	blobs          *blobTracker
+	scanner        *blockScanner
+	pairs          []*clientPair
+	relays         []*relay
+	labels         nodeLabels
+	groupLabel     string
+	references     map[string]bool
+	opts           nodeOptionSet
+	maxSplitDepth  uint64
+	ancestors      *ancestorSet
+	heads          *headTracker
+	blockTimes     *blockTimes
+	fees           *feeTracker
+	uncles         *uncleTracker // nil if disabled
+	stateProbes    []*stateProbe
+	logQueries     []*logQuery
+	badBlocks      *badBlockCollector // nil if disabled
+	splitTraces    *splitTracer       // nil if disabled
+	rawBlocks      *rawBlockStore     // nil if disabled
+	canary         *canaryTracker     // nil if disabled
+	txpools        *txpoolTracker     // nil if disabled
+	peers          *peerTracker       // nil if disabled
+	autoPeers      *autoPeerer        // nil if disabled
+	identities     *identities
+	scraper        *metricsScraper // nil if no node has a metrics url
+	scorer         *healthScorer   // nil if disabled
+	rollups        *rollupMonitor  // nil if no rollup nodes are configured
+	federation     *federation     // nil if no origins are configured
+	collector      *collector      // nil if no agents are configured
+	leader         *leaderElector  // nil unless in active/passive mode
+	ethstats       *ethstatsServer // nil if no ethstats secret is configured
+	rpcProxy       *rpcProxy       // nil if disabled
+	// wasLeading is whether this instance led in the last cycle
+	wasLeading bool
+	// compareWorkers is the number of node pairs compared concurrently
+	compareWorkers int
 	quitCh         chan struct{}
+	checkCh        chan struct{} // triggers an immediate check cycle
+	headCh         chan struct{} // triggers a check cycle after the settle delay
 	backend        *blockDB
 	wg             sync.WaitGroup
 	reloadInterval time.Duration
+	// headPoll is how often the heads of nodes are polled for changes.
+	// Zero disables polling.
+	headPoll time.Duration
+	// tuner derives the intervals from the block time, if either is "auto".
+	// The intervals are then updated under mu.
+	tuner *intervalTuner
+	// hash of the last written report
+	lastReportHash common.Hash
+	// whether to archive reports into the backend
+	archive bool
+	// whether to record the heads of the nodes into the backend
+	recordHeads bool
+	// retention is how long the recorded heads and height index are kept
+	retention time.Duration
+	// formats besides json that the report is written in
+	reportFormats []string
+	// outDir is the directory the reports and artifacts are written to
+	outDir string
+	// registry holds the internal metrics of the network
+	registry metrics.Registry
+	// exporters receive the report after each cycle
+	exporters []Exporter
+	tracer    *tracer
+	alerts    *alertManager
+	digest    *digester // nil if digests are disabled
+	// bucket uploads the reports and artifacts, nil if disabled
+	bucket *bucketArchiver
+	// ipfs pins the reports and artifacts, nil if disabled
+	ipfs *ipfsPinner
+	// signer signs the reports and API responses, nil if disabled
+	signer *reportSigner
+	// digestSenders deliver the digests, e.g. by email
+	digestSenders []digestSender
+	// events raised during the current cycle
+	events []*Event
+
+	// conf is the config the monitor was created with, which is updated and
+	// persisted to confPath when nodes are added or removed at runtime
+	conf     *Config
+	confPath string
+	confLock sync.Mutex
+
+	// mu guards the fields below, which are read from outside the check loop
+	mu          sync.Mutex
+	running     bool
+	lastCycle   time.Time
+	cycleStats  *cycleJson // summary of the last cycle, completed or not
+	lastReport  *Report
+	agreed      *agreedHead     // served by the rpc proxy, nil if no quorum
+	checkpoint  *checkpointJson // served as checkpoint provider, nil if no quorum
+	splits      int             // number of splits found during the last cycle
+	unreachable []string        // names of unreachable nodes during the last cycle
+	pending     []func()        // changes to the monitored nodes, applied by the loop
+	// stop channels of the head subscriptions, by node name
+	watchers map[string]chan struct{}
+	polled   []headPoller // nodes to poll for head changes
 }
 
+var (
+	// ErrSplitDetected is returned by Verdict if the nodes disagree about the chain
+	ErrSplitDetected = errors.New("split detected")
+	// ErrNodesUnreachable is returned by Verdict if any node could not be reached
+	ErrNodesUnreachable = errors.New("unreachable nodes")
+)
+
 // NewMonitor creates a new NodeMonitor
-func NewMonitor(nodes []Node, db *blockDB, reload time.Duration) (*NodeMonitor, error) {
+func NewMonitor(nodes []Node, beacons []*BeaconNode, db *blockDB, conf *Config) (*NodeMonitor, error) {
+	reload, autoReload, err := parseInterval(conf.ReloadInterval)
+	if err != nil {
+		return nil, err
+	}
 	// Do initial healthcheck
 	for _, node := range nodes {
 		v, err := node.Version()
@@ -41,20 +157,338 @@ func NewMonitor(nodes []Node, db *blockDB, reload time.Duration) (*NodeMonitor,
 		}
 		log.Info("RPCNode OK", "version", v)
 	}
+	for _, node := range beacons {
+		v, err := node.Version()
+		if err != nil {
+			node.SetStatus(NodeStatusUnreachable)
+			log.Error("Error checking version", "error", err)
+		} else {
+			node.SetStatus(NodeStatusOK)
+		}
+		log.Info("BeaconNode OK", "version", v)
+	}
 	if reload == 0 {
 		reload = 10 * time.Second
 	}
+	headPoll, autoHeadPoll, err := parseInterval(conf.HeadPollInterval)
+	if err != nil {
+		return nil, err
+	}
+	retention, err := parseRetention(conf.HistoryRetention)
+	if err != nil {
+		return nil, err
+	}
+	heads, err := newHeadTracker(conf.Stall)
+	if err != nil {
+		return nil, err
+	}
+	stateProbes, err := newStateProbes(conf.StateChecks)
+	if err != nil {
+		return nil, err
+	}
+	logQueries, err := newLogQueries(conf.LogChecks)
+	if err != nil {
+		return nil, err
+	}
+	badBlocks, err := newBadBlockCollector(conf.BadBlockInterval)
+	if err != nil {
+		return nil, err
+	}
+	canary, err := newCanaryTracker(conf.Canary)
+	if err != nil {
+		return nil, err
+	}
+	txpools, err := newTxpoolTracker(conf.Txpool)
+	if err != nil {
+		return nil, err
+	}
+	autoPeers, err := newAutoPeerer(conf.AutoPeer)
+	if err != nil {
+		return nil, err
+	}
+	scraper, err := newMetricsScraper(conf.Scrape, conf.Clients)
+	if err != nil {
+		return nil, err
+	}
+	scorer, err := newHealthScorer(conf.HealthScore)
+	if err != nil {
+		return nil, err
+	}
+	rollups, err := newRollupMonitor(conf.Rollup)
+	if err != nil {
+		return nil, err
+	}
+	federation, err := newFederation(conf.Federation, conf.Identity)
+	if err != nil {
+		return nil, err
+	}
+	pusher, err := newFederationPusher(conf.Federation)
+	if err != nil {
+		return nil, err
+	}
+	collector, err := newCollector(conf.Collector)
+	if err != nil {
+		return nil, err
+	}
+	leader, err := newLeaderElector(conf.Leader, conf.Identity)
+	if err != nil {
+		return nil, err
+	}
+	ethstats, err := newEthstatsServer(conf.Ethstats)
+	if err != nil {
+		return nil, err
+	}
+	rpcProxy, err := newRPCProxy(conf.RPCProxy, len(nodes))
+	if err != nil {
+		return nil, err
+	}
+	compareWorkers := conf.CompareWorkers
+	if compareWorkers == 0 {
+		compareWorkers = 8
+	}
+	var tuner *intervalTuner
+	if autoReload || autoHeadPoll {
+		tuner = &intervalTuner{reload: autoReload, headPoll: autoHeadPoll}
+	}
+	if autoHeadPoll {
+		// Until the block time is known
+		headPoll = 2 * time.Second
+	}
+	groupLabel := conf.SplitGroupLabel
+	if len(groupLabel) == 0 {
+		groupLabel = "client"
+	}
+	pairs, err := resolvePairs(conf.Clients, nodes, beacons)
+	if err != nil {
+		return nil, err
+	}
+	alerts, err := newAlertManager(conf.Alerts)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkReportFormats(conf.ReportFormats); err != nil {
+		return nil, err
+	}
+	digest, err := newDigester(conf.Digest)
+	if err != nil {
+		return nil, err
+	}
+	email, err := newEmailNotifier(conf.Email)
+	if err != nil {
+		return nil, err
+	}
+	telegram, err := newTelegramNotifier(conf.Telegram)
+	if err != nil {
+		return nil, err
+	}
+	matrix, err := newMatrixNotifier(conf.Matrix)
+	if err != nil {
+		return nil, err
+	}
+	annotator, err := newGrafanaAnnotator(conf.Grafana)
+	if err != nil {
+		return nil, err
+	}
+	statsd, err := newStatsdExporter(conf.Statsd)
+	if err != nil {
+		return nil, err
+	}
+	publishers, err := newPublishers(conf)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := newBucketArchiver(conf.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := newReportSigner(conf.Signing, conf.Identity)
+	if err != nil {
+		return nil, err
+	}
 	nm := &NodeMonitor{
 		nodes:          nodes,
+		beacons:        beacons,
+		beaconConf:     conf.Beacon,
+		slots:          newSlotTracker(conf.Beacon.MissedSlotWindow),
+		validators:     newValidatorTracker(conf.Beacon.Validators, conf.Beacon.MaxSyncMisses),
+		balances:       newBalanceHistory(db, conf.Beacon.BalanceWindow),
+		slashings:      new(slashingWatcher),
+		blobs:          new(blobTracker),
+		scanner:        new(blockScanner),
+		pairs:          pairs,
+		labels:         newNodeLabels(conf.Clients),
+		groupLabel:     groupLabel,
+		references:     make(map[string]bool),
+		opts:           newNodeOptionSet(conf.Clients),
+		maxSplitDepth:  conf.MaxSplitDepth,
+		ancestors:      newAncestorSet(),
+		heads:          heads,
+		blockTimes:     new(blockTimes),
+		fees:           new(feeTracker),
+		uncles:         newUncleTracker(conf.UncleWindow, conf.MaxUncleRate),
+		stateProbes:    stateProbes,
+		logQueries:     logQueries,
+		badBlocks:      badBlocks,
+		splitTraces:    newSplitTracer(conf.SplitTracer),
+		rawBlocks:      newRawBlockStore(conf.RawBlocks),
+		canary:         canary,
+		txpools:        txpools,
+		peers:          newPeerTracker(conf.PeerOverlap),
+		autoPeers:      autoPeers,
+		identities:     newIdentities(),
+		scraper:        scraper,
+		scorer:         scorer,
+		rollups:        rollups,
+		federation:     federation,
+		collector:      collector,
+		leader:         leader,
+		ethstats:       ethstats,
+		rpcProxy:       rpcProxy,
+		compareWorkers: compareWorkers,
 		quitCh:         make(chan struct{}),
+		checkCh:        make(chan struct{}, 1),
+		headCh:         make(chan struct{}, 1),
+		watchers:       make(map[string]chan struct{}),
 		backend:        db,
 		reloadInterval: reload,
+		headPoll:       headPoll,
+		tuner:          tuner,
+		archive:        conf.ArchiveReports,
+		recordHeads:    conf.RecordHeads,
+		retention:      retention,
+		reportFormats:  conf.ReportFormats,
+		outDir:         conf.OutputDir(),
+		registry:       conf.metricsRegistry(),
+		tracer:         newTracer(conf.Tracing),
+		alerts:         alerts,
+		digest:         digest,
+		bucket:         bucket,
+		ipfs:           newIpfsPinner(conf.IPFS),
+		signer:         signer,
+		conf:           conf,
+	}
+	for _, c := range conf.Clients {
+		if c.Reference {
+			nm.references[c.Name] = true
+		}
+	}
+	for _, node := range nodes {
+		if collector != nil {
+			collector.add(node)
+		}
+		if ethstats != nil {
+			ethstats.add(node)
+		}
+	}
+	// Alerts of checks which don't run every cycle mustn't resolve in between
+	for _, opts := range nm.opts {
+		alerts.coverInterval(opts.interval)
+	}
+	if badBlocks != nil {
+		alerts.coverInterval(badBlocks.interval)
+	}
+	// Every network writes to its own directory
+	if nm.badBlocks != nil {
+		nm.badBlocks.dir = filepath.Join(nm.outDir, "badblocks")
+	}
+	if nm.splitTraces != nil {
+		nm.splitTraces.dir = filepath.Join(nm.outDir, "traces")
+	}
+	if nm.rawBlocks != nil {
+		nm.rawBlocks.dir = filepath.Join(nm.outDir, "hashes")
+	}
+	if digest != nil && len(conf.Digest.Dir) == 0 {
+		digest.dir = filepath.Join(nm.outDir, "digests")
+	}
+	for _, rc := range conf.Relays {
+		nm.relays = append(nm.relays, newRelay(rc))
+	}
+	if email != nil {
+		alerts.notifiers = append(alerts.notifiers, email)
+		nm.digestSenders = append(nm.digestSenders, email)
+	}
+	if telegram != nil {
+		alerts.notifiers = append(alerts.notifiers, telegram)
+	}
+	if discord := newDiscordNotifier(conf.Discord, conf.Namespace()); discord != nil {
+		alerts.notifiers = append(alerts.notifiers, discord)
+	}
+	if matrix != nil {
+		alerts.notifiers = append(alerts.notifiers, matrix)
+	}
+	if annotator != nil {
+		alerts.notifiers = append(alerts.notifiers, annotator)
+	}
+	if len(conf.Influx.Url) > 0 {
+		nm.exporters = append(nm.exporters, newInfluxExporter(conf.Influx))
+	}
+	if len(conf.RemoteWrite.Url) > 0 {
+		nm.exporters = append(nm.exporters, newRemoteWriteExporter(conf.RemoteWrite))
+	}
+	if statsd != nil {
+		nm.exporters = append(nm.exporters, statsd)
+	}
+	for _, pub := range publishers {
+		nm.exporters = append(nm.exporters, newEventStream(pub))
+	}
+	if pusher != nil {
+		pusher.signer = signer
+		nm.exporters = append(nm.exporters, pusher)
+	}
+	var copies artifactStores
+	if bucket != nil {
+		copies = append(copies, bucket)
+	}
+	if nm.ipfs != nil {
+		copies = append(copies, nm.ipfs)
+	}
+	if nm.badBlocks != nil {
+		nm.badBlocks.copies = copies
+	}
+	if nm.splitTraces != nil {
+		nm.splitTraces.copies = copies
+	}
+	if nm.rawBlocks != nil {
+		nm.rawBlocks.copies = copies
+	}
+	if nm.digest != nil {
+		nm.digest.copies = copies
+	}
+	if leader != nil {
+		leader.elect(time.Now())
 	}
 	nm.doChecks()
 	return nm, nil
 }
 
 func (mon *NodeMonitor) Start() {
+	for _, node := range mon.nodes {
+		mon.startWatcher(node)
+	}
+	if mon.headPoll > 0 {
+		mon.wg.Add(1)
+		go mon.pollHeads()
+	}
+	if mon.bucket != nil {
+		mon.wg.Add(1)
+		go mon.uploadObjects()
+	}
+	if mon.backend != nil {
+		mon.wg.Add(1)
+		go mon.pruneHistory()
+	}
+	if mon.ipfs != nil {
+		mon.wg.Add(1)
+		go mon.pinFiles()
+	}
+	if mon.leader != nil {
+		mon.wg.Add(1)
+		go mon.renewLease()
+	}
+	if mon.splitTraces != nil {
+		mon.wg.Add(1)
+		go mon.traceSplits()
+	}
 	mon.wg.Add(1)
 	go mon.loop()
 }
@@ -64,24 +498,96 @@ func (mon *NodeMonitor) Stop() {
 	mon.wg.Wait()
 }
 
+// Report returns the report produced by the last check cycle.
+func (mon *NodeMonitor) Report() *Report {
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+	return mon.lastReport
+}
+
+// Verdict returns nil if the last check cycle found all nodes reachable and in
+// agreement, ErrSplitDetected if any nodes disagreed about the chain, and
+// otherwise ErrNodesUnreachable if any node could not be reached.
+func (mon *NodeMonitor) Verdict() error {
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+	if mon.splits > 0 {
+		return fmt.Errorf("%w: %d diverging node pairs", ErrSplitDetected, mon.splits)
+	}
+	if len(mon.unreachable) > 0 {
+		return fmt.Errorf("%w: %v", ErrNodesUnreachable, strings.Join(mon.unreachable, ", "))
+	}
+	return nil
+}
+
 func (mon *NodeMonitor) loop() {
 	defer mon.wg.Done()
+	mon.setRunning(true)
+	defer mon.setRunning(false)
+	// Cycles are triggered by head changes, with the reload interval as a
+	// fallback if no heads change
+	var settle <-chan time.Time
 	for {
 		select {
 		case <-mon.quitCh:
 			return
 		case <-time.After(mon.reloadInterval):
 			mon.doChecks()
+		case <-mon.checkCh:
+			mon.doChecks()
+		case <-mon.headCh:
+			if settle == nil {
+				settle = time.After(settleDelay)
+			}
+		case <-settle:
+			settle = nil
+			mon.doChecks()
 		}
 	}
 }
 
+// CheckNow triggers a check cycle right away, instead of waiting for the next
+// tick. It returns without waiting for the cycle to complete, and does nothing
+// if a triggered cycle is already pending.
+func (mon *NodeMonitor) CheckNow() {
+	select {
+	case mon.checkCh <- struct{}{}:
+	default:
+	}
+}
+
+// HandleCheck triggers an immediate check cycle on POST requests
+func (mon *NodeMonitor) HandleCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	mon.CheckNow()
+	w.WriteHeader(http.StatusAccepted)
+}
+
 func (mon *NodeMonitor) doChecks() {
+	stats := &cycleJson{Started: time.Now()}
+	cycle := mon.tracer.startSpan(nil, "doChecks")
+	defer func() {
+		mon.recordCycle(stats)
+		cycle.finish()
+		mon.tracer.flush()
+	}()
+	mon.events = nil
+	mon.applyPending()
+	// A standby does all the checks, to be ready to take over, but leaves
+	// the output to the leader
+	leading := mon.checkLeadership()
+	mon.alerts.passive = !leading
 
 	// splitSize is the max amount of blocks in any chain not accepted by all nodes.
 	// If one node is simply 'behind' that does not count, since it has yet
 	// to accept the canon chain
 	var splitSize int64
+	// splitTooDeep is set if any split is deeper than the search bound, in
+	// which case splitSize is a lower bound
+	var splitTooDeep bool
 	// We want to cross-check all 'latest' numbers. So if we have
 	// node 1: x,
 	// node 2: y,
@@ -94,12 +600,31 @@ func (mon *NodeMonitor) doChecks() {
 
 	var heads = make(map[uint64]bool)
 	var activeNodes []Node
+	var unreachable []string
+	var throttled int
+	var splits int
+	var splitPairs = make(splitSet)
+	// the first diverging blocks of the splits, on either side
+	var splitPoints []splitPoint
+	var latencies = make(map[string]time.Duration)
 	for _, node := range mon.nodes {
-		err := node.UpdateLatest()
+		start := time.Now()
+		var err error
+		// Nodes with an interval of their own keep their head in between
+		if mon.opts.due(node, start) {
+			sp := mon.tracer.startSpan(cycle, "updateLatest", "node", node.Name())
+			err = node.UpdateLatest()
+			sp.finish()
+			latencies[node.Name()] = time.Since(start)
+			mon.opts.updated(node, start)
+		}
 		v, _ := node.Version()
 		if err != nil {
-			log.Error("Error getting latest", "node", v, "error", err)
-			node.SetStatus(NodeStatusUnreachable)
+			if mon.nodeFailed(node, "getting latest", err) {
+				unreachable = append(unreachable, node.Name())
+			} else {
+				throttled++
+			}
 		} else {
 			activeNodes = append(activeNodes, node)
 			node.SetStatus(NodeStatusOK)
@@ -109,9 +634,54 @@ func (mon *NodeMonitor) doChecks() {
 		}
 	}
 
-	// Pair-wise, figure out the splitblocks (if any)
-	forPairs(activeNodes,
+	mon.checkHeads(activeNodes, time.Now())
+	mon.storeHeads(activeNodes, time.Now())
+	mon.blockTimes.observe(activeNodes)
+	mon.fees.observe(activeNodes)
+
+	// Pair-wise, figure out the splitblocks (if any). Nodes on the same head
+	// are compared as one group, represented by its first node. If there are
+	// reference nodes, the others are only compared against the reference
+	// chain.
+	members := make(map[string]headGroup)
+	group := func(rep Node) headGroup {
+		if g, ok := members[rep.Name()]; ok {
+			return g
+		}
+		return headGroup{rep}
+	}
+	compare := func(nodes []Node, fn func(a, b Node)) {
+		var reps []Node
+		for _, g := range headGroups(nodes) {
+			members[g[0].Name()] = g
+			reps = append(reps, g[0])
+		}
+		forPairs(reps, fn)
+	}
+	refs, others := mon.splitReferences(activeNodes)
+	if len(refs) > 0 {
+		compare = func(_ []Node, fn func(a, b Node)) {
+			forPairs(refs, fn)
+			for _, node := range others {
+				fn(refs[0], node)
+			}
+		}
+	}
+	// The pairs are compared concurrently, so the results are aggregated
+	// under a lock. The split events are raised after the comparisons, as
+	// raising them sends the alerts.
+	var (
+		pairList    [][2]Node
+		pairLock    sync.Mutex
+		splitEvents []*Event
+	)
+	compare(activeNodes, func(a, b Node) {
+		pairList = append(pairList, [2]Node{a, b})
+	})
+	runPairs(pairList, mon.compareWorkers,
 		func(a, b Node) {
+			sp := mon.tracer.startSpan(cycle, "compare", "x", a.Name(), "y", b.Name())
+			defer sp.finish()
 			highest := a.HeadNum()
 			if b.HeadNum() < highest {
 				highest = b.HeadNum()
@@ -130,15 +700,106 @@ func (mon *NodeMonitor) doChecks() {
 				return
 			}
 			if ha.hash == hb.hash {
+				mon.ancestors.set(a, b, highest)
+				return
+			}
+			// They appear to have diverged, and so has every node on the
+			// same head as either of them
+			ga, gb := group(a), group(b)
+			groups := [][]string{ga.names(), gb.names()}
+			pairLock.Lock()
+			splits += len(ga) * len(gb)
+			pairLock.Unlock()
+			addSplit := func(block int) {
+				for _, x := range ga {
+					for _, y := range gb {
+						splitPairs.add(x.Name(), y.Name(), block)
+					}
+				}
+			}
+			if mon.opts.skips(checkSplitSearch, a, b) {
+				// Without searching, all we know is that they disagree here
+				pairLock.Lock()
+				defer pairLock.Unlock()
+				if splitSize < 1 {
+					splitSize = 1
+				}
+				addSplit(int(highest))
+				splitEvents = append(splitEvents, splitEvent(groups, highest,
+					[]common.Hash{ha.hash, hb.hash}, "Split found at or below block %d", highest))
 				return
 			}
-			// They appear to have diverged
-			split := findSplit(int(highest), a, b)
+			var from int
+			if mon.maxSplitDepth > 0 && highest > mon.maxSplitDepth {
+				from = int(highest - mon.maxSplitDepth)
+			}
+			// Pruned nodes are only asked for blocks in their retained range
+			sa, sb := mon.opts.retained(a), mon.opts.retained(b)
+			if anc, ok := mon.ancestors.get(sa, sb, highest); ok && int(anc) >= from {
+				// Resume from the last block they agreed on
+				from = int(anc) + 1
+			} else if from > 0 && disagreeAt(sa, sb, uint64(from)) {
+				// They already disagree at the bound, don't search any deeper
+				hashes := []common.Hash{a.HashAt(uint64(from), false), b.HashAt(uint64(from), false)}
+				pairLock.Lock()
+				defer pairLock.Unlock()
+				if splitSize < int64(mon.maxSplitDepth) {
+					splitSize = int64(mon.maxSplitDepth)
+				}
+				splitTooDeep = true
+				addSplit(from)
+				splitEvents = append(splitEvents, splitEvent(groups, uint64(from), hashes,
+					"Split deeper than %d blocks, before block %d", mon.maxSplitDepth, from))
+				return
+			}
+			searchSpan := mon.tracer.startSpan(sp, "findSplit", "x", a.Name(), "y", b.Name())
+			split, unbounded, err := findSplit(from, int(highest), sa, sb)
+			if err == nil && unbounded {
+				// Search the blocks below on nodes which have them, archive
+				// nodes standing in for the pruned ones
+				xa := mon.archiveFor(a, uint64(split), activeNodes)
+				xb := mon.archiveFor(b, uint64(split), activeNodes)
+				if xa != nil && xb != nil {
+					sa, sb = xa, xb
+					split, unbounded, err = findSplit(from, split, sa, sb)
+				}
+			}
+			searchSpan.finish()
+			if err != nil {
+				// The depth is unknown, all we know is that they disagree here
+				log.Warn("Split search failed", "x", a.Name(), "y", b.Name(), "kind", ErrorKindOf(err), "error", err)
+				pairLock.Lock()
+				defer pairLock.Unlock()
+				if splitSize < 1 {
+					splitSize = 1
+				}
+				addSplit(int(highest))
+				splitEvents = append(splitEvents, splitEvent(groups, highest, []common.Hash{ha.hash, hb.hash},
+					"Split found at or below block %d, search failed: %v", highest, err))
+				return
+			}
+			if split > 0 && !unbounded {
+				mon.ancestors.set(a, b, uint64(split-1))
+			}
+			hashes := []common.Hash{sa.HashAt(uint64(split), false), sb.HashAt(uint64(split), false)}
+			pairLock.Lock()
+			defer pairLock.Unlock()
 			splitLength := int64(int(highest) - split)
 			if splitSize < splitLength {
 				splitSize = splitLength
 			}
-			log.Info("Split found", "x", a.Name(), "y", b.Name(), "num", split)
+			addSplit(split)
+			if unbounded {
+				// The blocks below are unavailable, e.g. pruned
+				splitTooDeep = true
+				splitEvents = append(splitEvents, splitEvent(groups, uint64(split), hashes,
+					"Split at least %d blocks deep, at or before block %d", splitLength, split))
+			} else {
+				splitEvents = append(splitEvents, splitEvent(groups, uint64(split), hashes,
+					"Split found at block %d", split))
+				splitPoints = append(splitPoints, splitPoint{a, uint64(split), hashes[0]},
+					splitPoint{b, uint64(split), hashes[1]})
+			}
 			// Point of interest, add split-block and split-block-minus-one to heads
 			heads[uint64(split)] = true
 			if split > 0 {
@@ -146,7 +807,53 @@ func (mon *NodeMonitor) doChecks() {
 			}
 		},
 	)
-	metrics.GetOrRegisterGauge("chain/split", registry).Update(int64(splitSize))
+	for _, ev := range splitEvents {
+		mon.raise(ev)
+	}
+	metrics.GetOrRegisterGauge("chain/split", mon.registry).Update(int64(splitSize))
+	if mon.splitTraces != nil {
+		mon.splitTraces.trace(splitPoints)
+	}
+	if mon.rawBlocks != nil {
+		mon.rawBlocks.storeSplits(splitPoints)
+	}
+	q := quorum(activeNodes)
+	if q != nil {
+		metrics.GetOrRegisterGaugeFloat64("chain/quorum", mon.registry).Update(q.Percent)
+	}
+	if mon.rpcProxy != nil {
+		agreed := mon.rpcProxy.agree(activeNodes, q)
+		mon.mu.Lock()
+		mon.agreed = agreed
+		mon.mu.Unlock()
+	}
+	groupSplits := mon.labels.groupSplits(mon.groupLabel, activeNodes, splitPairs)
+	for _, gs := range groupSplits {
+		mon.emit(EventGroupSplit, SeverityWarning, gs.Groups[:],
+			"All %v=%v nodes diverged from all %v=%v nodes at block %d",
+			gs.Label, gs.Groups[0], gs.Label, gs.Groups[1], gs.Block)
+	}
+	mon.checkTagged(mon.opts.enabled(checkTaggedBlocks, activeNodes))
+	mon.checkWeights(activeNodes)
+	mon.checkUncles(activeNodes)
+	mon.checkState(mon.opts.enabled(checkStateProbes, activeNodes))
+	mon.checkLogs(mon.opts.enabled(checkLogQueries, activeNodes))
+	mon.checkBadBlocks(mon.opts.enabled(checkBadBlocks, activeNodes))
+	mon.checkCanary(mon.opts.enabled(checkCanaries, activeNodes))
+	mon.checkTxpools(activeNodes)
+	peers := mon.checkPeers(activeNodes, splitPairs)
+	mon.autoPeer(activeNodes, splitPairs)
+	beaconsDown, beaconsThrottled := mon.checkBeacons()
+	unreachable = append(unreachable, beaconsDown...)
+	throttled += beaconsThrottled
+	mon.checkIdentities(activeNodes)
+	mon.checkNodeMetrics()
+	mon.checkVitals(activeNodes)
+	pairs := mon.checkPairs(activeNodes)
+	relays := mon.checkRelays()
+	rollups := mon.checkRollups()
+	mon.checkFederation()
+
 	var headList []int
 	for k, _ := range heads {
 		headList = append(headList, int(k))
@@ -157,10 +864,98 @@ func (mon *NodeMonitor) doChecks() {
 	for _, node := range mon.nodes {
 		r.AddToReport(node)
 	}
+	for _, node := range mon.beacons {
+		r.Beacons = append(r.Beacons, newBeaconJson(node))
+	}
+	if len(mon.beacons) > 0 {
+		r.MissedSlots = mon.slots.missedSlots()
+		r.MissedSlotRate = mon.slots.rate()
+		r.Validators = mon.validators.report(mon.balances)
+		r.Slashings = mon.slashings.found
+		r.Blobs = mon.blobs.recent
+		r.Pairs = pairs
+	}
+	r.Monitor = mon.conf.Identity
+	r.Network = mon.conf.Network
+	r.setLabels(mon.labels)
+	r.setIdentities(mon.identities)
+	if mon.scraper != nil {
+		r.setNodeMetrics(mon.scraper)
+	}
+	if mon.txpools != nil {
+		r.setTxpools(mon.txpools)
+	}
+	r.Relays = relays
+	r.Rollups = rollups
+	r.PeerOverlap = peers
+	r.SplitDepth = splitSize
+	r.SplitTooDeep = splitTooDeep
+	r.GroupSplits = groupSplits
+	r.Quorum = q
+	r.BlockTime = mon.blockTimes.stats()
+	if st := r.BlockTime; st != nil {
+		metrics.GetOrRegisterGaugeFloat64("chain/blocktime/avg", mon.registry).Update(st.Average)
+		metrics.GetOrRegisterGaugeFloat64("chain/blocktime/median", mon.registry).Update(st.Median)
+		metrics.GetOrRegisterGaugeFloat64("chain/blocktime/p95", mon.registry).Update(st.P95)
+	}
+	r.Fees = mon.fees.report()
+	if fj := r.Fees; fj != nil {
+		metrics.GetOrRegisterGauge("chain/basefee", mon.registry).Update(int64(fj.BaseFee))
+		metrics.GetOrRegisterGaugeFloat64("chain/gasused", mon.registry).Update(fj.GasUsedRatio)
+	}
+	if mon.badBlocks != nil {
+		r.BadBlocks = mon.badBlocks.report()
+	}
+	if mon.splitTraces != nil {
+		r.Traces = mon.splitTraces.report()
+	}
+	if mon.canary != nil {
+		r.Canaries = mon.canary.report()
+	}
+	if mon.uncles != nil {
+		r.UncleRate = mon.uncles.rate()
+	}
+	r.setMinority(q)
+	r.setAgreement(refs, others, splitPairs)
+	r.Events = mon.events
+	mon.alerts.endCycle(time.Now())
+	r.fillStats(latencies)
+	if mon.scorer != nil {
+		r.setScores(mon.scorer, mon.registry)
+	}
+	if leading {
+		for _, exp := range mon.exporters {
+			if err := exp.Export(r); err != nil {
+				log.Warn("Failed to export report", "error", err)
+			}
+		}
+		mon.updateDigest(r)
+	}
+	mon.tuneIntervals(r)
+	mon.mu.Lock()
+	r.Generated = time.Now()
+	mon.lastReport = r
+	mon.polled = pollTargets(mon.nodes)
+	mon.splits = splits
+	mon.unreachable = unreachable
+	mon.mu.Unlock()
+	stats.NodesFailed, stats.NodesThrottled = len(unreachable), throttled
+	stats.NodesOK = len(mon.nodes) + len(mon.beacons) - stats.NodesFailed - throttled
 
-	jsd, err := json.MarshalIndent(r, "", "  ")
+	// The generation and event times change every cycle, so they're left out
+	// when checking whether anything changed
+	unstamped := *r
+	unstamped.Generated = time.Time{}
+	unstamped.Events = make([]*Event, len(r.Events))
+	for i, ev := range r.Events {
+		cpy := *ev
+		cpy.Time = time.Time{}
+		unstamped.Events[i] = &cpy
+	}
+	jsd, err := json.MarshalIndent(&unstamped, "", "  ")
 	if err != nil {
 		log.Warn("Json marshall fail", "error", err)
+		stats.failWrite(err)
 		return
 	}
 	if mon.backend == nil {
@@ -168,12 +963,77 @@ func (mon *NodeMonitor) doChecks() {
 		// Just print and return
 		r.Print()
 		fmt.Println(string(jsd))
+		mon.markCycle()
+		return
+	}
+	if !leading {
+		log.Debug("Standing by, skipping report write")
+		mon.markCycle()
+		return
+	}
+	// Skip the write (and the hashes) if nothing changed since last time
+	reportHash := crypto.Keccak256Hash(jsd)
+	if reportHash == mon.lastReportHash {
+		log.Debug("Report unchanged, skipping write")
+		mon.markCycle()
+		return
+	}
+	// The CIDs are added after the comparison too, as the previous report
+	// changes with every report written
+	out := *r
+	if mon.ipfs != nil {
+		out.IPFS = mon.ipfs.report()
+	}
+	if jsd, err = json.MarshalIndent(&out, "", "  "); err != nil {
+		log.Warn("Json marshall fail", "error", err)
+		stats.failWrite(err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Join(mon.outDir, "hashes"), 0755); err != nil {
+		log.Warn("Failed to create report directory", "error", err)
+		stats.failWrite(err)
 		return
 	}
-	if err := ioutil.WriteFile("www/data.json", jsd, 0777); err != nil {
+	if err := ioutil.WriteFile(filepath.Join(mon.outDir, reportFiles["json"]), jsd, 0777); err != nil {
 		log.Warn("Failed to write file", "error", err)
+		stats.failWrite(err)
 		return
 	}
+	stats.Written = true
+	if mon.signer != nil {
+		sig, _ := json.MarshalIndent(mon.signer.sign(jsd), "", "  ")
+		if err := ioutil.WriteFile(filepath.Join(mon.outDir, reportFiles["json"])+".sig", sig, 0644); err != nil {
+			log.Warn("Failed to write report signature", "error", err)
+			stats.failWrite(err)
+		}
+	}
+	for _, format := range mon.reportFormats {
+		if format == "json" {
+			continue
+		}
+		data, err := r.render(format)
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(mon.outDir, reportFiles[format]), data, 0777)
+		}
+		if err != nil {
+			log.Warn("Failed to write report", "format", format, "error", err)
+			stats.failWrite(err)
+		}
+	}
+	mon.lastReportHash = reportHash
+	if mon.archive {
+		if err := mon.backend.addReport(time.Now(), jsd); err != nil {
+			log.Warn("Failed to archive report", "error", err)
+			stats.failWrite(err)
+		}
+	}
+	if mon.bucket != nil {
+		mon.bucket.put(reportObject(time.Now()), jsd)
+	}
+	if mon.ipfs != nil {
+		mon.ipfs.put(reportObject(time.Now()), jsd)
+	}
+	mon.markCycle()
 	// And now provide relevant hashes
 	for _, hash := range r.Hashes {
 		hdr := mon.backend.get(hash)
@@ -181,7 +1041,7 @@ func (mon *NodeMonitor) doChecks() {
 			log.Warn("Missing header", "hash", hash)
 			continue
 		}
-		fname := fmt.Sprintf("www/hashes/0x%x.json", hash)
+		fname := filepath.Join(mon.outDir, "hashes", fmt.Sprintf("0x%x.json", hash))
 		// only write it if it isn't already there
 		if _, err := os.Stat(fname); os.IsNotExist(err) {
 			data, err := json.MarshalIndent(hdr, "", " ")
@@ -206,11 +1066,82 @@ func (mon *NodeMonitor) doChecks() {
 //
 //  Search uses binary search to find and return the smallest index i
 //  in [0, n) at which f(i) is true
-func findSplit(num int, a Node, b Node) int {
-	splitBlock := sort.Search(num, func(i int) bool {
-		return a.HashAt(uint64(i), false) != b.HashAt(uint64(i), false)
+//
+// The search starts at block 'from', below which the nodes are assumed to agree.
+//
+// Blocks which either node doesn't have, e.g. because it's pruned, are treated
+// as agreed on. If the block right below the split is such a block, the split
+// may well be deeper, and the returned bool is set: the split block is then
+// only the lowest diverging block that could be found. Failed requests abort
+// the search, as they'd make it look shallower than it is.
+func findSplit(from, num int, a Node, b Node) (int, bool, error) {
+	// Nodes supporting batching can probe many blocks per round instead
+	if ba, ok := a.(batchNode); ok {
+		if bb, ok := b.(batchNode); ok {
+			return findSplitBatched(from, num, ba, bb)
+		}
+	}
+	var (
+		missing = make(map[int]bool)
+		failed  error
+	)
+	splitBlock := from + sort.Search(num-from, func(i int) bool {
+		if failed != nil {
+			return true
+		}
+		ha, err := lookupHash(a, uint64(from+i))
+		if err != nil {
+			failed = err
+			return true
+		}
+		hb, err := lookupHash(b, uint64(from+i))
+		if err != nil {
+			failed = err
+			return true
+		}
+		if ha == (common.Hash{}) || hb == (common.Hash{}) {
+			missing[from+i] = true
+			return false
+		}
+		return ha != hb
 	})
-	return splitBlock
+	if failed != nil {
+		return 0, false, failed
+	}
+	return splitBlock, missing[splitBlock-1], nil
+}
+
+// disagreeAt returns whether both nodes have a block at the number, and they
+// differ
+func disagreeAt(a, b Node, num uint64) bool {
+	ha, hb := a.HashAt(num, false), b.HashAt(num, false)
+	return ha != (common.Hash{}) && hb != (common.Hash{}) && ha != hb
+}
+
+// runPairs calls 'fn(a, b)' for each of the pairs, on up to 'workers'
+// goroutines at a time
+func runPairs(pairs [][2]Node, workers int, fn func(a, b Node)) {
+	if workers < 1 {
+		workers = 1
+	}
+	var (
+		wg    sync.WaitGroup
+		queue = make(chan [2]Node)
+	)
+	for i := 0; i < workers && i < len(pairs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pair := range queue {
+				fn(pair[0], pair[1])
+			}
+		}()
+	}
+	for _, pair := range pairs {
+		queue <- pair
+	}
+	close(queue)
+	wg.Wait()
 }
 
 // calls 'fn(a, b)' once for each pair in the given list of 'elems'
@@ -226,21 +1157,31 @@ type blockDB struct {
 	db *leveldb.DB
 }
 
+// NewBlockDB opens the block database in the default location
 func NewBlockDB() (*blockDB, error) {
-	file := "blockDB"
+	return OpenBlockDB("blockDB")
+}
+
+// OpenBlockDB opens the block database at the given path, creating it if
+// needed.
+func OpenBlockDB(file string) (*blockDB, error) {
 	db, err := leveldb.OpenFile(file, &opt.Options{
 		// defaults:
 		//BlockCacheCapacity:     8  * opt.MiB,
 		//WriteBuffer:            4 * opt.MiB,
 	})
-	if _, corrupted := err.(*errors.ErrCorrupted); corrupted {
+	if _, corrupted := err.(*leveldbErrors.ErrCorrupted); corrupted {
 		db, err = leveldb.RecoverFile(file, nil)
 	}
 	if err != nil {
 		return nil, err
 	}
 	return &blockDB{db}, nil
+}
 
+// Close closes the underlying database
+func (db *blockDB) Close() error {
+	return db.db.Close()
 }
 
 func (db *blockDB) add(key common.Hash, h *types.Header) {
@@ -255,6 +1196,16 @@ func (db *blockDB) add(key common.Hash, h *types.Header) {
 	db.db.Put(k, data, nil)
 }
 
+// writable checks that the database accepts writes, by storing and removing
+// a probe key.
+func (db *blockDB) writable() error {
+	probe := []byte("health-probe")
+	if err := db.db.Put(probe, []byte{1}, nil); err != nil {
+		return err
+	}
+	return db.db.Delete(probe, nil)
+}
+
 func (db *blockDB) get(key common.Hash) *types.Header {
 	data, err := db.db.Get(key[:], nil)
 	if err != nil {
@@ -1,15 +1,25 @@
 package nodes
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
 )
 
 type testNode struct {
@@ -149,6 +159,1452 @@ func TestMonitor(t *testing.T) {
 	nodes = append(nodes, &brokenNode{"broken-b"})
 	nodes = append(nodes, &brokenNode{"broken-c"})
 
-	mon, _ := NewMonitor(nodes, nil, time.Second)
+	mon, _ := NewMonitor(nodes, nil, nil, &Config{ReloadInterval: "1s"})
 	mon.doChecks()
+	if err := mon.Verdict(); !errors.Is(err, ErrSplitDetected) {
+		t.Errorf("expected split, got %v", err)
+	}
+}
+
+func TestVerdict(t *testing.T) {
+	var chain = make([]*blockInfo, 100)
+	for i := 0; i < len(chain); i++ {
+		chain[i] = &blockInfo{
+			num:  uint64(i),
+			hash: common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("a :%d", i)))),
+		}
+	}
+	nodes := []Node{
+		newTestNode("node-a", 99, chain),
+		newTestNode("node-b", 97, chain),
+	}
+	mon, _ := NewMonitor(nodes, nil, nil, &Config{ReloadInterval: "1s"})
+	if err := mon.Verdict(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	mon, _ = NewMonitor(append(nodes, &brokenNode{"broken"}), nil, nil, &Config{ReloadInterval: "1s"})
+	if err := mon.Verdict(); !errors.Is(err, ErrNodesUnreachable) {
+		t.Errorf("expected unreachable nodes, got %v", err)
+	}
+}
+
+// taggedTestNode is a testNode which also reports safe/finalized blocks
+type taggedTestNode struct {
+	*testNode
+	tags map[string]int
+}
+
+func (t *taggedTestNode) UpdateTagged(tags ...string) error { return nil }
+
+func (t *taggedTestNode) Tagged(tag string) *blockInfo {
+	if num, ok := t.tags[tag]; ok {
+		return t.chain[num]
+	}
+	return nil
+}
+
+func makeChain(prefix string, length int, parent []*blockInfo) []*blockInfo {
+	var chain = make([]*blockInfo, length)
+	copy(chain, parent)
+	for i := len(parent); i < length; i++ {
+		chain[i] = &blockInfo{
+			num:  uint64(i),
+			hash: common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("%v :%d", prefix, i)))),
+		}
+	}
+	return chain
+}
+
+func TestTaggedCheck(t *testing.T) {
+	a := makeChain("a", 100, nil)
+	b := makeChain("b", 100, a[:50])
+
+	nodeA := &taggedTestNode{newTestNode("a", 99, a), map[string]int{TagFinalized: 80, TagSafe: 90}}
+	// Same chain, but lagging
+	nodeA2 := &taggedTestNode{newTestNode("a2", 95, a), map[string]int{TagFinalized: 70, TagSafe: 85}}
+	// Diverged at 50, but finalized before that
+	nodeB := &taggedTestNode{newTestNode("b", 99, b), map[string]int{TagFinalized: 40, TagSafe: 60}}
+	// Diverged at 50, and finalized after that
+	nodeB2 := &taggedTestNode{newTestNode("b2", 99, b), map[string]int{TagFinalized: 60, TagSafe: 60}}
+
+	if _, ok := taggedConflict(nodeA, nodeA2, TagFinalized); !ok {
+		t.Errorf("a, a2: unexpected finalized conflict")
+	}
+	if _, ok := taggedConflict(nodeA, nodeB, TagFinalized); !ok {
+		t.Errorf("a, b: unexpected finalized conflict")
+	}
+	if _, ok := taggedConflict(nodeA, nodeB, TagSafe); ok {
+		t.Errorf("a, b: expected safe conflict")
+	}
+	if num, ok := taggedConflict(nodeB2, nodeA, TagFinalized); ok || num != 60 {
+		t.Errorf("b2, a: expected finalized conflict at 60, got %d", num)
+	}
+
+	mon, _ := NewMonitor([]Node{nodeA, nodeB2}, nil, nil, &Config{ReloadInterval: "1s"})
+	var finalized int
+	for _, ev := range mon.Report().Events {
+		if ev.Kind == EventFinalizedSplit {
+			finalized++
+			if ev.Severity != SeverityCritical {
+				t.Errorf("expected critical severity, got %v", ev.Severity)
+			}
+		}
+	}
+	if finalized != 1 {
+		t.Errorf("expected one finalized split event, got %d", finalized)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	chain := makeChain("a", 10, nil)
+	a, b := newTestNode("a", 9, chain), newTestNode("b", 9, chain)
+	conf := &Config{ReloadInterval: "1s", Clients: []ClientInfo{
+		{Name: a.Name(), Labels: map[string]string{"client": "geth"}},
+	}}
+	mon, _ := NewMonitor([]Node{a, b}, nil, nil, conf)
+	r := mon.Report()
+	if have := r.Cols[0].Labels["client"]; have != "geth" {
+		t.Errorf("wrong label: %q", have)
+	}
+	if r.Cols[1].Labels != nil {
+		t.Errorf("unexpected labels: %v", r.Cols[1].Labels)
+	}
+}
+
+func TestGroupSplits(t *testing.T) {
+	a := makeChain("a", 20, nil)
+	b := makeChain("b", 20, a[:10])
+	var (
+		nodes  []Node
+		labels []ClientInfo
+	)
+	for i, client := range []string{"geth", "geth", "nethermind", "nethermind", "besu"} {
+		chain := a
+		if client == "nethermind" {
+			chain = b
+		}
+		node := newTestNode(fmt.Sprintf("%v%d", client, i), 19, chain)
+		nodes = append(nodes, node)
+		labels = append(labels, ClientInfo{Name: node.Name(), Labels: map[string]string{"client": client}})
+	}
+	mon, _ := NewMonitor(nodes, nil, nil, &Config{ReloadInterval: "1s", Clients: labels})
+	r := mon.Report()
+	want := []groupSplitJson{
+		{Label: "client", Groups: [2]string{"besu", "nethermind"}, Block: 10},
+		{Label: "client", Groups: [2]string{"geth", "nethermind"}, Block: 10},
+	}
+	if len(r.GroupSplits) != len(want) {
+		t.Fatalf("wrong group splits: %v", r.GroupSplits)
+	}
+	for i, gs := range r.GroupSplits {
+		if *gs != want[i] {
+			t.Errorf("group split %d: have %+v, want %+v", i, *gs, want[i])
+		}
+	}
+}
+
+func TestQuorum(t *testing.T) {
+	a := makeChain("a", 20, nil)
+	b := makeChain("b", 20, a[:10])
+	nodes := []Node{newTestNode("a1", 19, a), newTestNode("a2", 15, a), newTestNode("b1", 19, b)}
+	mon, _ := NewMonitor(nodes, nil, nil, &Config{ReloadInterval: "1s"})
+	r := mon.Report()
+	q := r.Quorum
+	if q.Number != 19 || q.Hash != a[19].hash {
+		t.Errorf("wrong quorum head: %d %x", q.Number, q.Hash)
+	}
+	if fmt.Sprint(q.Minority) != "[TestNode(b1)]" || len(q.Agreeing) != 2 {
+		t.Errorf("wrong quorum: %+v", q)
+	}
+	if q.Percent < 66 || q.Percent > 67 {
+		t.Errorf("wrong quorum percentage: %v", q.Percent)
+	}
+	for _, c := range r.Cols {
+		if c.Minority != (c.Name == "TestNode(b1)") {
+			t.Errorf("wrong minority flag for %v", c.Name)
+		}
+	}
+}
+
+func TestReferenceNodes(t *testing.T) {
+	a := makeChain("a", 20, nil)
+	b := makeChain("b", 20, a[:10])
+	ref, good := newTestNode("ref", 19, a), newTestNode("good", 18, a)
+	bad1, bad2 := newTestNode("bad1", 19, b), newTestNode("bad2", 19, b)
+	conf := &Config{ReloadInterval: "1s", Clients: []ClientInfo{{Name: ref.Name(), Reference: true}}}
+	mon, _ := NewMonitor([]Node{bad1, ref, good, bad2}, nil, nil, conf)
+	r := mon.Report()
+	want := []string{"diverging", "reference", "agreeing", "diverging"}
+	for i, c := range r.Cols {
+		if c.Agreement != want[i] {
+			t.Errorf("%v: have %q, want %q", c.Name, c.Agreement, want[i])
+		}
+	}
+	// The two diverging nodes aren't compared against each other
+	var splits int
+	for _, ev := range r.Events {
+		if ev.Kind == EventSplit {
+			splits++
+		}
+	}
+	if splits != 2 {
+		t.Errorf("expected 2 splits, got %d", splits)
+	}
+}
+
+// countingNode counts the head updates, and reports itself as reachable
+type countingNode struct {
+	*testNode
+	updates int
+}
+
+func (c *countingNode) Status() int { return NodeStatusOK }
+
+func (c *countingNode) UpdateLatest() error {
+	c.updates++
+	return nil
+}
+
+func TestNodeOptions(t *testing.T) {
+	a := makeChain("a", 20, nil)
+	b := makeChain("b", 20, a[:10])
+	slow := &countingNode{testNode: newTestNode("slow", 19, b)}
+	fast := &countingNode{testNode: newTestNode("fast", 19, a)}
+	conf := &Config{ReloadInterval: "1s", Clients: []ClientInfo{
+		{Name: slow.Name(), Interval: "1h", Skip: []string{checkSplitSearch}},
+	}}
+	mon, err := NewMonitor([]Node{slow, fast}, nil, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mon.doChecks()
+	if slow.updates != 1 || fast.updates != 2 {
+		t.Errorf("updates: slow %d, fast %d, want 1 and 2", slow.updates, fast.updates)
+	}
+	// The split is reported where it was seen, not searched for
+	var found bool
+	for _, ev := range mon.Report().Events {
+		if ev.Kind == EventSplit {
+			found = true
+			if ev.Block != 19 {
+				t.Errorf("split reported at %d, want 19", ev.Block)
+			}
+		}
+	}
+	if !found {
+		t.Error("split not reported")
+	}
+
+	for _, c := range []ClientInfo{
+		{Name: "a", Interval: "soon"},
+		{Name: "a", Skip: []string{"everything"}},
+	} {
+		if _, err := c.options(); err == nil {
+			t.Errorf("expected error for %+v", c)
+		}
+	}
+}
+
+// batchTestNode serves hashes in batches, counting the requests
+type batchTestNode struct {
+	*testNode
+	requests int
+}
+
+func (b *batchTestNode) HashesAt(nums []uint64) ([]common.Hash, error) {
+	b.requests++
+	hashes := make([]common.Hash, len(nums))
+	for i, num := range nums {
+		hashes[i] = b.HashAt(num, false)
+	}
+	return hashes, nil
+}
+
+func TestBatchedSplitSearch(t *testing.T) {
+	a := makeChain("a", 5000, nil)
+	for _, split := range []int{0, 1, 17, 1000, 4321, 4999, 5000} {
+		b := makeChain("b", 5000, a[:split])
+		plainA, plainB := newTestNode("a", 4999, a), newTestNode("b", 4999, b)
+		batchA := &batchTestNode{testNode: plainA}
+		batchB := &batchTestNode{testNode: plainB}
+		want, _, _ := findSplit(0, 4999, plainA, plainB)
+		if split < 4999 && want != split {
+			t.Fatalf("plain search found %d, want %d", want, split)
+		}
+		if have, _, _ := findSplit(0, 4999, batchA, batchB); have != want {
+			t.Errorf("split %d: batched search found %d, want %d", split, have, want)
+		}
+		// Four rounds of 16 probes cover 5000 blocks
+		if batchA.requests > 4 {
+			t.Errorf("split %d: %d requests", split, batchA.requests)
+		}
+	}
+}
+
+func TestMaxSplitDepth(t *testing.T) {
+	a := makeChain("a", 300, nil)
+	b := makeChain("b", 300, a[:100])
+	for _, tc := range []struct {
+		maxDepth uint64
+		block    uint64
+		tooDeep  bool
+	}{
+		{0, 100, false},
+		{250, 100, false},
+		{150, 149, true},
+	} {
+		conf := &Config{ReloadInterval: "1s", MaxSplitDepth: tc.maxDepth}
+		mon, _ := NewMonitor([]Node{newTestNode("a", 299, a), newTestNode("b", 299, b)}, nil, nil, conf)
+		r := mon.Report()
+		if r.SplitTooDeep != tc.tooDeep {
+			t.Errorf("max %d: too deep %v, want %v", tc.maxDepth, r.SplitTooDeep, tc.tooDeep)
+		}
+		for _, ev := range r.Events {
+			if ev.Kind == EventSplit && ev.Block != tc.block {
+				t.Errorf("max %d: split at %d, want %d", tc.maxDepth, ev.Block, tc.block)
+			}
+		}
+	}
+}
+
+// probeCountingNode records the lowest block looked up
+type probeCountingNode struct {
+	*testNode
+	lowest uint64
+}
+
+func (p *probeCountingNode) HashAt(num uint64, force bool) common.Hash {
+	if num < p.lowest {
+		p.lowest = num
+	}
+	return p.testNode.HashAt(num, force)
+}
+
+func TestCommonAncestorCache(t *testing.T) {
+	a := makeChain("a", 2000, nil)
+	b := makeChain("b", 2000, a[:1500])
+	nodeA := &probeCountingNode{testNode: newTestNode("a", 1400, a)}
+	nodeB := &probeCountingNode{testNode: newTestNode("b", 1401, b)}
+	mon, _ := NewMonitor([]Node{nodeA, nodeB}, nil, nil, &Config{ReloadInterval: "1s"})
+	// They agree at 1400, which is remembered
+	if anc, ok := mon.ancestors.get(nodeA, nodeB, 1402); !ok || anc != 1400 {
+		t.Fatalf("ancestor %d (%v), want 1400", anc, ok)
+	}
+	nodeA.head, nodeB.head = 1999, 1999
+	nodeA.lowest = nodeA.HeadNum()
+	mon.doChecks()
+	for _, ev := range mon.Report().Events {
+		if ev.Kind == EventSplit && ev.Block != 1500 {
+			t.Errorf("split found at %d, want 1500", ev.Block)
+		}
+	}
+	// The search only covered the blocks from the ancestor up
+	if nodeA.lowest != 1400 {
+		t.Errorf("lowest block looked up %d, want 1400", nodeA.lowest)
+	}
+	if anc, _ := mon.ancestors.get(nodeA, nodeB, 1999); anc != 1499 {
+		t.Errorf("ancestor %d, want 1499", anc)
+	}
+	mon.ancestors.forget("TestNode(a)")
+	if len(mon.ancestors.blocks) != 0 {
+		t.Error("ancestors not forgotten")
+	}
+}
+
+func TestBlockCache(t *testing.T) {
+	chain := makeChain("a", 10, nil)
+	c := newBlockCache(3)
+	for _, bl := range chain[:3] {
+		c.add(bl)
+	}
+	// Touch the oldest, so the next add evicts block 1 instead
+	if bl, ok := c.get(0); !ok || bl != chain[0] {
+		t.Fatal("block 0 missing")
+	}
+	c.add(chain[3])
+	if _, ok := c.get(1); ok {
+		t.Error("block 1 not evicted")
+	}
+	for _, num := range []uint64{0, 2, 3} {
+		if _, ok := c.get(num); !ok {
+			t.Errorf("block %d missing", num)
+		}
+	}
+	// Replacing a block, e.g. after a reorg, doesn't grow the cache
+	c.add(&blockInfo{num: 3})
+	if bl, _ := c.get(3); c.len() != 3 || bl.hash != (common.Hash{}) {
+		t.Errorf("block not replaced, len %d", c.len())
+	}
+}
+
+func TestHeadGroups(t *testing.T) {
+	a := makeChain("a", 100, nil)
+	b := makeChain("b", 100, a[:50])
+	var nodes []Node
+	for i := 0; i < 5; i++ {
+		nodes = append(nodes, newTestNode(fmt.Sprintf("a%d", i), 99, a))
+	}
+	for i := 0; i < 3; i++ {
+		nodes = append(nodes, newTestNode(fmt.Sprintf("b%d", i), 99, b))
+	}
+	// Same chain as the a's, but a block behind
+	nodes = append(nodes, newTestNode("lagging", 98, a))
+	// Same blocks as the a's, as fetched by another node
+	var fetched []*blockInfo
+	for i, bl := range a {
+		cpy := *bl
+		cpy.td, cpy.received = big.NewInt(int64(i)), time.Now()
+		fetched = append(fetched, &cpy)
+	}
+	nodes = append(nodes, newTestNode("fetched", 99, fetched))
+	groups := headGroups(nodes)
+	if len(groups) != 3 || len(groups[0]) != 6 || len(groups[1]) != 3 || len(groups[2]) != 1 {
+		t.Fatalf("wrong groups: %v", groups)
+	}
+	mon, _ := NewMonitor(nodes, nil, nil, &Config{ReloadInterval: "1s"})
+	// Only the representatives are compared, but the split still covers
+	// every diverging pair
+	var events int
+	for _, ev := range mon.Report().Events {
+		if ev.Kind == EventSplit {
+			events++
+			if ev.Block != 50 {
+				t.Errorf("split at %d, want 50", ev.Block)
+			}
+			if len(ev.HashNodes) != 2 || len(ev.HashNodes[0])+len(ev.HashNodes[1]) != len(ev.Nodes) || ev.Hashes[0] == ev.Hashes[1] {
+				t.Errorf("wrong hash groups %v for nodes %v", ev.HashNodes, ev.Nodes)
+			}
+		}
+	}
+	if events != 2 {
+		t.Errorf("expected 2 split events, got %d", events)
+	}
+	if mon.splits != 3*7 {
+		t.Errorf("expected %d diverging pairs, got %d", 3*7, mon.splits)
+	}
+}
+
+func TestRunPairs(t *testing.T) {
+	var nodes []Node
+	for i := 0; i < 10; i++ {
+		nodes = append(nodes, newTestNode(fmt.Sprint(i), 0, nil))
+	}
+	var pairs [][2]Node
+	forPairs(nodes, func(a, b Node) { pairs = append(pairs, [2]Node{a, b}) })
+	var (
+		mu            sync.Mutex
+		seen          = make(map[[2]string]bool)
+		running, peak int
+	)
+	runPairs(pairs, 3, func(a, b Node) {
+		mu.Lock()
+		seen[[2]string{a.Name(), b.Name()}] = true
+		if running++; running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+	if len(seen) != 45 {
+		t.Errorf("compared %d pairs, want 45", len(seen))
+	}
+	if peak > 3 {
+		t.Errorf("%d pairs compared at once, want at most 3", peak)
+	}
+}
+
+// prunedNode can't serve the blocks below its horizon
+type prunedNode struct {
+	*testNode
+	horizon uint64
+}
+
+func (p *prunedNode) BlockAt(num uint64, force bool) *blockInfo {
+	if num < p.horizon {
+		return nil
+	}
+	return p.testNode.BlockAt(num, force)
+}
+
+func (p *prunedNode) HashAt(num uint64, force bool) common.Hash {
+	if bl := p.BlockAt(num, force); bl != nil {
+		return bl.hash
+	}
+	return common.Hash{}
+}
+
+// batchPrunedNode is a prunedNode which supports batching
+type batchPrunedNode struct {
+	*prunedNode
+}
+
+func (b *batchPrunedNode) HashesAt(nums []uint64) ([]common.Hash, error) {
+	hashes := make([]common.Hash, len(nums))
+	for i, num := range nums {
+		hashes[i] = b.HashAt(num, false)
+	}
+	return hashes, nil
+}
+
+func TestPrunedSplitSearch(t *testing.T) {
+	a := makeChain("a", 1000, nil)
+	for _, tc := range []struct {
+		split, want int
+		unbounded   bool
+	}{
+		{100, 500, true},
+		{700, 700, false},
+		// Block 499 is unavailable, so it can't tell
+		{500, 500, true},
+		{501, 501, false},
+	} {
+		b := makeChain("b", 1000, a[:tc.split])
+		full := newTestNode("full", 999, a)
+		pruned := &prunedNode{newTestNode("pruned", 999, b), 500}
+		for _, nodes := range [][2]Node{
+			{full, pruned},
+			{&batchTestNode{testNode: full}, &batchPrunedNode{pruned}},
+		} {
+			split, unbounded, err := findSplit(0, 999, nodes[0], nodes[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if split != tc.want || unbounded != tc.unbounded {
+				t.Errorf("split %d: found %d (unbounded %v), want %d (%v)",
+					tc.split, split, unbounded, tc.want, tc.unbounded)
+			}
+		}
+	}
+}
+
+// flakyNode fails requests for the blocks below some number, rather than
+// not having them
+type flakyNode struct {
+	*testNode
+	below uint64
+}
+
+func (f *flakyNode) LookupHash(num uint64) (common.Hash, error) {
+	if num < f.below {
+		return common.Hash{}, errors.New("request timed out")
+	}
+	return f.HashAt(num, false), nil
+}
+
+func (f *flakyNode) HashesAt(nums []uint64) ([]common.Hash, error) {
+	hashes := make([]common.Hash, len(nums))
+	for i, num := range nums {
+		hash, err := f.LookupHash(num)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = hash
+	}
+	return hashes, nil
+}
+
+func TestFailedSplitSearch(t *testing.T) {
+	a := makeChain("a", 1000, nil)
+	b := makeChain("b", 1000, a[:100])
+	full := newTestNode("full", 999, a)
+	flaky := &flakyNode{newTestNode("flaky", 999, b), 500}
+	// Failed requests must not be taken as agreement, making the split look
+	// like it's at 500
+	for _, nodes := range [][2]Node{
+		{full, flaky},
+		{&batchTestNode{testNode: full}, flaky},
+	} {
+		if split, _, err := findSplit(0, 999, nodes[0], nodes[1]); err == nil {
+			t.Errorf("expected error, found split at %d", split)
+		}
+	}
+}
+
+func TestArchiveNodes(t *testing.T) {
+	a := makeChain("a", 1000, nil)
+	b := makeChain("b", 1000, a[:100])
+	// limited only retains the last 500 blocks, the archive node on the same
+	// chain has all of them
+	full := newTestNode("full", 999, a)
+	limited := &probeCountingNode{testNode: newTestNode("limited", 999, b), lowest: 999}
+	archive := newTestNode("archive", 999, b)
+	conf := &Config{ReloadInterval: "1s", Clients: []ClientInfo{
+		{Name: limited.Name(), HistoryBlocks: 500},
+		{Name: archive.Name(), Archive: true},
+	}}
+	mon, _ := NewMonitor([]Node{full, limited, archive}, nil, nil, conf)
+	r := mon.Report()
+	var found bool
+	for _, ev := range r.Events {
+		if ev.Kind == EventSplit {
+			found = true
+			if ev.Block != 100 {
+				t.Errorf("split at %d, want 100", ev.Block)
+			}
+		}
+	}
+	if !found || r.SplitTooDeep {
+		t.Errorf("split found: %v, too deep: %v", found, r.SplitTooDeep)
+	}
+	if limited.lowest < 500 {
+		t.Errorf("block %d requested outside the retained range", limited.lowest)
+	}
+	// Without an archive node, the split is reported as a lower bound
+	mon, _ = NewMonitor([]Node{full, limited}, nil, nil, conf)
+	if r := mon.Report(); !r.SplitTooDeep {
+		t.Error("expected the split to be reported as too deep")
+	}
+
+	if _, err := (&ClientInfo{Archive: true, HistoryBlocks: 10}).options(); err == nil {
+		t.Error("expected error for archive node with limited history")
+	}
+}
+
+func TestTotalDifficulty(t *testing.T) {
+	a := makeChain("a", 100, nil)
+	b := makeChain("b", 100, a[:50])
+	for i := 50; i < 100; i++ {
+		a[i].td = big.NewInt(int64(10 * i))
+		b[i].td = big.NewInt(int64(500 + 5*(i-50)))
+	}
+	// c is on chain a, but reports a different total difficulty at its head
+	c := append([]*blockInfo{}, a...)
+	c[80] = &blockInfo{num: 80, hash: a[80].hash, td: big.NewInt(801)}
+
+	// b is ahead, but on the lighter chain
+	nodes := []Node{newTestNode("a", 90, a), newTestNode("b", 95, b), newTestNode("c", 80, c)}
+	mon, err := NewMonitor(nodes, nil, nil, &Config{ReloadInterval: "1s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string][]string)
+	for _, ev := range mon.Report().Events {
+		if ev.Kind == EventLightChain || ev.Kind == EventWeightMismatch {
+			found[ev.Kind] = append(found[ev.Kind], ev.Nodes...)
+		}
+	}
+	if have := fmt.Sprint(found[EventLightChain]); have != "[TestNode(b)]" {
+		t.Errorf("wrong nodes on a lighter chain: %v", have)
+	}
+	if have := fmt.Sprint(found[EventWeightMismatch]); have != "[TestNode(c) TestNode(a)]" {
+		t.Errorf("wrong weight mismatch: %v", have)
+	}
+}
+
+func TestUncleRate(t *testing.T) {
+	chain := makeChain("a", 120, nil)
+	for _, bl := range chain[95:] {
+		bl.uncles = true
+	}
+	node := newTestNode("a", 99, chain)
+	conf := &Config{ReloadInterval: "1s", UncleWindow: 10, MaxUncleRate: 0.2}
+	mon, err := NewMonitor([]Node{node}, nil, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := mon.Report()
+	if r.UncleRate != 0.5 {
+		t.Errorf("expected uncle rate 0.5, got %v", r.UncleRate)
+	}
+	var found bool
+	for _, ev := range r.Events {
+		found = found || ev.Kind == EventUncleRate
+	}
+	if !found {
+		t.Error("expected uncle rate event")
+	}
+	// Blocks with uncles fall out of the window
+	for _, bl := range chain[100:] {
+		bl.uncles = false
+	}
+	node.head = 107
+	mon.uncles.update([]Node{node})
+	if rate := mon.uncles.rate(); rate != 0.2 {
+		t.Errorf("expected uncle rate 0.2, got %v", rate)
+	}
+	if newUncleTracker(10, 0) != nil {
+		t.Error("expected no tracker if disabled")
+	}
+}
+
+// stateTestNode serves the state probes from a fixed map
+type stateTestNode struct {
+	*testNode
+	state map[string]string // probe -> result
+}
+
+func (n *stateTestNode) StateAt(p *stateProbe, num uint64) (string, error) {
+	res, ok := n.state[p.String()]
+	if !ok {
+		return "", errors.New("missing state")
+	}
+	return res, nil
+}
+
+func TestStateChecks(t *testing.T) {
+	var (
+		a       = makeChain("a", 100, nil)
+		b       = makeChain("b", 100, a[:90])
+		balance = "balance of 0x00000000000000000000000000000000000000aa"
+		storage = "storage slot 0000000000000000000000000000000000000000000000000000000000000002 of 0x00000000000000000000000000000000000000bb"
+	)
+	nodes := []Node{
+		&stateTestNode{newTestNode("a", 95, a), map[string]string{balance: "0x1", storage: "0x5"}},
+		&stateTestNode{newTestNode("b", 99, a), map[string]string{balance: "0x1", storage: "0x6"}},
+		// c is on another chain, so its state isn't compared
+		&stateTestNode{newTestNode("c", 97, b), map[string]string{balance: "0x2", storage: "0x7"}},
+		// d can't serve the balance
+		&stateTestNode{newTestNode("d", 99, a), map[string]string{storage: "0x5"}},
+	}
+	conf := &Config{ReloadInterval: "1s", StateChecks: []stateProbeConfig{
+		{Kind: "balance", Address: "0x00000000000000000000000000000000000000aa"},
+		{Kind: "storage", Address: "0x00000000000000000000000000000000000000bb", Slot: "2"},
+	}}
+	mon, err := NewMonitor(nodes, nil, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []*Event
+	for _, ev := range mon.Report().Events {
+		if ev.Kind == EventStateMismatch {
+			found = append(found, ev)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected 1 state mismatch, got %d", len(found))
+	}
+	if ev := found[0]; ev.Block != 95 || len(ev.Nodes) != 3 || !strings.Contains(ev.Message, "storage slot") {
+		t.Errorf("wrong state mismatch: %+v", ev)
+	}
+
+	for _, probes := range [][]stateProbeConfig{
+		{{Kind: "code", Address: "0x00000000000000000000000000000000000000aa"}},
+		{{Kind: "storage", Address: "0x00000000000000000000000000000000000000aa", Slot: "nope"}},
+		{{Kind: "call", Address: "0x00000000000000000000000000000000000000aa", Data: "0xz"}},
+	} {
+		if _, err := newStateProbes(probes); err == nil {
+			t.Errorf("expected error for %+v", probes)
+		}
+	}
+}
+
+// logTestNode serves the logs of a fixed list
+type logTestNode struct {
+	*testNode
+	logs    []types.Log
+	queries int
+}
+
+func (n *logTestNode) Logs(q *logQuery, from, to uint64) ([]types.Log, error) {
+	n.queries++
+	var logs []types.Log
+	for _, l := range n.logs {
+		if l.BlockNumber >= from && l.BlockNumber <= to {
+			logs = append(logs, l)
+		}
+	}
+	return logs, nil
+}
+
+func TestLogChecks(t *testing.T) {
+	var (
+		chain = makeChain("a", 100, nil)
+		logs  = []types.Log{
+			{BlockNumber: 50, Index: 0},
+			{BlockNumber: 85, Index: 0},
+			{BlockNumber: 90, Index: 0},
+			{BlockNumber: 90, Index: 1},
+		}
+		// b indexes the logs of block 90 differently
+		bad = append(append([]types.Log{}, logs[:2]...), types.Log{BlockNumber: 90, Index: 1}, types.Log{BlockNumber: 90, Index: 2})
+		a   = &logTestNode{testNode: newTestNode("a", 95, chain), logs: logs}
+		b   = &logTestNode{testNode: newTestNode("b", 97, chain), logs: bad}
+		c   = &logTestNode{testNode: newTestNode("c", 99, chain), logs: logs}
+	)
+	conf := &Config{ReloadInterval: "1s", LogChecks: []logQueryConfig{{}}}
+	mon, err := NewMonitor([]Node{a, b, c}, nil, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []*Event
+	for _, ev := range mon.Report().Events {
+		if ev.Kind == EventLogMismatch {
+			found = append(found, ev)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected 1 log mismatch, got %d", len(found))
+	}
+	if want := "Logs of blocks 80-95 differ from log 1 on, number of logs: TestNode(a)=3, TestNode(b)=3, TestNode(c)=3"; found[0].Message != want {
+		t.Errorf("wrong message: %v", found[0].Message)
+	}
+	// The query isn't repeated until the head advanced
+	queries := a.queries
+	mon.doChecks()
+	if a.queries != queries {
+		t.Errorf("expected no new query, got %d", a.queries-queries)
+	}
+	if _, err := newLogQueries([]logQueryConfig{{Topics: [][]string{{"0x1234"}}}}); err == nil {
+		t.Error("expected error for a short topic")
+	}
+}
+
+// badBlockTestNode reports a fixed list of bad blocks
+type badBlockTestNode struct {
+	*testNode
+	bad []badBlock
+}
+
+func (n *badBlockTestNode) BadBlocks() ([]badBlock, error) {
+	return n.bad, nil
+}
+
+func TestBadBlocks(t *testing.T) {
+	var (
+		chain = makeChain("a", 10, nil)
+		x     = badBlock{Hash: common.Hash{0x0a}, Number: 5, Raw: []byte(`{"hash":"0x0a"}`)}
+		y     = badBlock{Hash: common.Hash{0x0b}, Number: 7, Raw: []byte(`{"hash":"0x0b"}`)}
+		a     = &badBlockTestNode{newTestNode("a", 9, chain), []badBlock{x}}
+		b     = &badBlockTestNode{newTestNode("b", 9, chain), nil}
+		now   = time.Now()
+	)
+	bc, err := newBadBlockCollector("1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, reporters := bc.collect([]Node{a, b}, now)
+	if len(updated) != 1 || updated[0].Hash != x.Hash || fmt.Sprint(reporters) != "[[TestNode(a)]]" {
+		t.Fatalf("wrong bad blocks: %v %v", updated, reporters)
+	}
+	// Not asked again before the interval passed
+	b.bad = []badBlock{x, y}
+	if updated, _ := bc.collect([]Node{a, b}, now.Add(time.Second)); len(updated) != 0 {
+		t.Errorf("expected no collection within the interval")
+	}
+	updated, reporters = bc.collect([]Node{a, b}, now.Add(time.Minute))
+	if len(updated) != 2 || fmt.Sprint(reporters) != "[[TestNode(b)] [TestNode(b)]]" {
+		t.Fatalf("wrong bad blocks: %v %v", updated, reporters)
+	}
+	report := bc.report()
+	if len(report) != 2 || report[0].Hash != y.Hash || len(report[1].Nodes) != 2 || report[1].Block != nil {
+		t.Errorf("wrong report: %+v", report)
+	}
+
+	dir, err := ioutil.TempDir("", "badblocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bc.dir = dir
+	if err := bc.write(bc.found[x.Hash]); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("0x%x.json", x.Hash)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"hash": "0x0a"`) {
+		t.Errorf("stored bad block lacks the raw entry:\n%s", data)
+	}
+	// Blocks below the finalized one are forgotten, and not collected again
+	bc.prune(6)
+	if report := bc.report(); len(report) != 1 || report[0].Hash != y.Hash {
+		t.Errorf("wrong report after pruning: %+v", report)
+	}
+	if updated, _ := bc.collect([]Node{a, b}, now.Add(2*time.Minute)); len(updated) != 0 {
+		t.Errorf("pruned bad block collected again: %v", updated)
+	}
+	if _, err := newBadBlockCollector("often"); err == nil {
+		t.Error("expected error for invalid interval")
+	}
+}
+
+// canaryTestNode has a txpool, and includes the transactions in the block
+// set by the test
+type canaryTestNode struct {
+	*testNode
+	pool   map[common.Hash]uint64
+	reject bool
+}
+
+func (n *canaryTestNode) SendRawTransaction(raw []byte) error {
+	if n.reject {
+		return errors.New("nonce too low")
+	}
+	n.pool[crypto.Keccak256Hash(raw)] = 0
+	return nil
+}
+
+func (n *canaryTestNode) TransactionBlock(hash common.Hash) (bool, uint64, error) {
+	block, ok := n.pool[hash]
+	return ok, block, nil
+}
+
+func (n *canaryTestNode) TxParams(from common.Address) (*txParams, error) {
+	return nil, errors.New("no key")
+}
+
+func TestCanaries(t *testing.T) {
+	var (
+		chain = makeChain("a", 10, nil)
+		a     = &canaryTestNode{newTestNode("a", 9, chain), make(map[common.Hash]uint64), false}
+		b     = &canaryTestNode{newTestNode("b", 9, chain), make(map[common.Hash]uint64), true}
+		nodes = []canaryNode{a, b}
+		now   = time.Now()
+	)
+	c, err := newCanaryTracker(canaryConfig{Interval: "1m", Timeout: "2m", RawTxs: []string{"0x01"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.raw = [][]byte{{1}, {2}, {3}}
+	first := c.send(nodes, now)
+	if first == nil || first.Node != "TestNode(a)" || len(first.Error) > 0 || first.Hash != crypto.Keccak256Hash([]byte{1}) {
+		t.Fatalf("wrong canary: %+v", first)
+	}
+	// Not sent again while one is in flight
+	if cj := c.send(nodes, now.Add(time.Minute)); cj != nil {
+		t.Fatalf("unexpected canary: %+v", cj)
+	}
+	// Propagated to b, then included
+	b.pool[first.Hash] = 0
+	if cj := c.follow(nodes, now.Add(10*time.Second)); cj != nil {
+		t.Fatalf("canary finished early: %+v", cj)
+	}
+	a.pool[first.Hash], b.pool[first.Hash] = 9, 9
+	if cj := c.follow(nodes, now.Add(20*time.Second)); cj != first || cj.Block != 9 || cj.Included != 20 || cj.Seen["TestNode(b)"] != 10 || cj.Stuck {
+		t.Fatalf("wrong included canary: %+v", cj)
+	}
+	// Node b takes its turn, and rejects it
+	second := c.send(nodes, now.Add(time.Minute))
+	if second == nil || second.Node != "TestNode(b)" || second.Error != "nonce too low" || c.current != nil {
+		t.Fatalf("wrong rejected canary: %+v", second)
+	}
+	// The third one is never included
+	third := c.send(nodes, now.Add(2*time.Minute))
+	if third == nil || len(third.Error) > 0 {
+		t.Fatalf("wrong canary: %+v", third)
+	}
+	if cj := c.follow(nodes, now.Add(4*time.Minute)); cj != third || !cj.Stuck {
+		t.Fatalf("expected stuck canary: %+v", cj)
+	}
+	// Nothing left to send without a key
+	if cj := c.send(nodes, now.Add(5*time.Minute)); cj != nil {
+		t.Fatalf("unexpected canary: %+v", cj)
+	}
+	report := c.report()
+	if len(report) != 3 || report[0].Hash != third.Hash || !report[0].Stuck || report[2].Block != 9 {
+		t.Errorf("wrong report: %+v", report)
+	}
+	if _, err := newCanaryTracker(canaryConfig{Interval: "1m"}); err == nil {
+		t.Error("expected error without a key or raw txs")
+	}
+}
+
+// rpcError is an error returned by a node over json-rpc
+type rpcError struct {
+	code    int
+	message string
+}
+
+func (e rpcError) Error() string  { return e.message }
+func (e rpcError) ErrorCode() int { return e.code }
+
+// txpoolTestNode reports the txpool size set by the test
+type txpoolTestNode struct {
+	*testNode
+	pool *txpoolJson
+}
+
+func (n *txpoolTestNode) TxpoolStatus() (*txpoolJson, error) {
+	if n.pool == nil {
+		return nil, rpcError{-32601, "the method txpool_status does not exist/is not available"}
+	}
+	return n.pool, nil
+}
+
+func TestTxpools(t *testing.T) {
+	var (
+		chain = makeChain("a", 10, nil)
+		a     = &txpoolTestNode{newTestNode("a", 9, chain), &txpoolJson{Pending: 100, Queued: 20}}
+		b     = &txpoolTestNode{newTestNode("b", 9, chain), &txpoolJson{Pending: 200}}
+		c     = &txpoolTestNode{newTestNode("c", 9, chain), &txpoolJson{}}
+		d     = &txpoolTestNode{newTestNode("d", 9, chain), nil}
+		nodes = []Node{a, b, c, d}
+		now   = time.Now()
+	)
+	tp, err := newTxpoolTracker(txpoolConfig{Enabled: true, MaxGrowth: 3, Window: "10m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.update(nodes, now)
+	if len(tp.current) != 3 || !tp.unsupported["TestNode(d)"] {
+		t.Fatalf("wrong pools: %v %v", tp.current, tp.unsupported)
+	}
+	if empty := tp.empty(); fmt.Sprint(empty) != "[TestNode(c)]" {
+		t.Errorf("wrong empty pools: %v", empty)
+	}
+	// a grows fivefold, b doubles
+	a.pool = &txpoolJson{Pending: 500, Queued: 100}
+	b.pool = &txpoolJson{Pending: 400}
+	tp.update(nodes, now.Add(5*time.Minute))
+	if growth, from := tp.growth("TestNode(a)"); growth != 5 || from != 120 {
+		t.Errorf("wrong growth: %v from %d", growth, from)
+	}
+	if growth, _ := tp.growth("TestNode(b)"); growth != 2 {
+		t.Errorf("wrong growth: %v", growth)
+	}
+	// Growth from an empty pool counts from the min size
+	c.pool = &txpoolJson{Pending: 250}
+	tp.update(nodes, now.Add(6*time.Minute))
+	if growth, _ := tp.growth("TestNode(c)"); growth != 2.5 {
+		t.Errorf("wrong growth: %v", growth)
+	}
+	if empty := tp.empty(); len(empty) != 0 {
+		t.Errorf("wrong empty pools: %v", empty)
+	}
+	// The first samples drop out of the window
+	tp.update(nodes, now.Add(12*time.Minute))
+	if growth, _ := tp.growth("TestNode(a)"); growth != 1 {
+		t.Errorf("wrong growth: %v", growth)
+	}
+	r := &Report{Cols: []*clientJson{{Name: "TestNode(a)"}, {Name: "TestNode(d)"}}}
+	r.setTxpools(tp)
+	if r.Cols[0].Txpool == nil || r.Cols[0].Txpool.Pending != 500 || r.Cols[1].Txpool != nil {
+		t.Errorf("wrong report: %+v %+v", r.Cols[0].Txpool, r.Cols[1].Txpool)
+	}
+	if _, err := newTxpoolTracker(txpoolConfig{Enabled: true, MaxGrowth: 0.5}); err == nil {
+		t.Error("expected error for growth below 1")
+	}
+}
+
+// peerTestNode has a fixed set of peers
+type peerTestNode struct {
+	*testNode
+	peers []string
+}
+
+func (n *peerTestNode) Peers() ([]string, error) {
+	return n.peers, nil
+}
+
+func TestPeerOverlap(t *testing.T) {
+	var (
+		chain = makeChain("a", 10, nil)
+		a     = &peerTestNode{newTestNode("a", 9, chain), []string{"p1", "p2", "p3"}}
+		b     = &peerTestNode{newTestNode("b", 9, chain), []string{"p2", "p3", "p4"}}
+		c     = &peerTestNode{newTestNode("c", 9, chain), []string{"p5"}}
+		d     = newTestNode("d", 9, chain)
+		nodes = []Node{a, b, c, d}
+	)
+	mon, err := NewMonitor(nil, nil, nil, &Config{ReloadInterval: "1s", PeerOverlap: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	splits := make(splitSet)
+	splits.add(a.Name(), c.Name(), 5)
+	splits.add(b.Name(), c.Name(), 5)
+	overlaps := mon.checkPeers(nodes, splits)
+	if len(overlaps) != 3 {
+		t.Fatalf("expected 3 pairs, got %d", len(overlaps))
+	}
+	if po := overlaps[0]; po.Nodes != [2]string{"TestNode(a)", "TestNode(b)"} || po.Shared != 2 || po.Overlap != 0.5 || po.Split {
+		t.Errorf("wrong overlap: %+v", po)
+	}
+	if po := overlaps[1]; po.Nodes[1] != "TestNode(c)" || po.Shared != 0 || !po.Split {
+		t.Errorf("wrong overlap: %+v", po)
+	}
+	if len(mon.events) != 1 || mon.events[0].Kind != EventDisjointPeers || len(mon.events[0].Nodes) != 3 {
+		t.Fatalf("unexpected events: %v", mon.events)
+	}
+	if want := "The split nodes share no peers, while nodes on the same chain share 50% on average"; mon.events[0].Message != want {
+		t.Errorf("wrong message: %v", mon.events[0].Message)
+	}
+	// Nothing to report if the split nodes share peers
+	mon.events = nil
+	c.peers = append(c.peers, "p1")
+	mon.checkPeers(nodes, splits)
+	if len(mon.events) != 0 {
+		t.Errorf("unexpected events: %v", mon.events)
+	}
+}
+
+// peeringTestNode records the peers it's told to add
+type peeringTestNode struct {
+	*testNode
+	added []string
+}
+
+func (n *peeringTestNode) Enode() (string, error) {
+	return "enode://" + n.id + "@127.0.0.1:30303", nil
+}
+
+func (n *peeringTestNode) AddPeer(enode string) (bool, error) {
+	n.added = append(n.added, enode)
+	return true, nil
+}
+
+func TestAutoPeer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autopeer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var (
+		chain = makeChain("a", 10, nil)
+		a     = &peeringTestNode{newTestNode("a", 9, chain), nil}
+		b     = &peeringTestNode{newTestNode("b", 9, chain), nil}
+		c     = newTestNode("c", 9, chain)
+		nodes = []Node{a, b, c}
+		audit = filepath.Join(dir, "audit.log")
+	)
+	mon, err := NewMonitor(nil, nil, nil, &Config{ReloadInterval: "1s", AutoPeer: autoPeerConfig{Enabled: true, AuditLog: audit}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	splits := make(splitSet)
+	splits.add(b.Name(), a.Name(), 5)
+	splits.add(a.Name(), c.Name(), 5)
+	mon.autoPeer(nodes, splits)
+	if fmt.Sprint(a.added) != "[enode://b@127.0.0.1:30303]" || fmt.Sprint(b.added) != "[enode://a@127.0.0.1:30303]" {
+		t.Fatalf("wrong peers added: %v %v", a.added, b.added)
+	}
+	if len(mon.events) != 1 || mon.events[0].Kind != EventAutoPeer || fmt.Sprint(mon.events[0].Nodes) != "[TestNode(a) TestNode(b)]" {
+		t.Fatalf("unexpected events: %v", mon.events)
+	}
+	data, err := ioutil.ReadFile(audit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry autoPeerEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Block != 5 || entry.Added != [2]bool{true, true} || entry.Enodes[1] != "enode://b@127.0.0.1:30303" {
+		t.Errorf("wrong audit entry: %+v", entry)
+	}
+	// Not peered again within the cooldown
+	mon.autoPeer(nodes, splits)
+	if len(a.added) != 1 {
+		t.Errorf("peered again within the cooldown: %v", a.added)
+	}
+}
+
+// vitalsTestNode has a fixed peer count and sync state
+type vitalsTestNode struct {
+	*testNode
+	vitals *nodeVitals
+}
+
+func (n *vitalsTestNode) Vitals() (*nodeVitals, error) {
+	if n.vitals == nil {
+		return nil, rpcError{-32601, "the method net_peerCount does not exist/is not available"}
+	}
+	return n.vitals, nil
+}
+
+func TestHealthScores(t *testing.T) {
+	var (
+		chain = makeChain("a", 10, nil)
+		a     = &vitalsTestNode{newTestNode("a", 9, chain), &nodeVitals{Peers: 20}}
+		b     = &vitalsTestNode{newTestNode("b", 8, chain), &nodeVitals{Peers: 5, Syncing: true}}
+		c     = &vitalsTestNode{newTestNode("c", 9, chain), nil}
+	)
+	hs, err := newHealthScorer(healthScoreConfig{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs.collect([]vitalsNode{a, b, c})
+	if len(hs.vitals) != 2 || !hs.unsupported["TestNode(c)"] {
+		t.Fatalf("wrong vitals: %v %v", hs.vitals, hs.unsupported)
+	}
+	r := &Report{
+		Cols: []*clientJson{
+			{Name: "TestNode(a)"},
+			{Name: "TestNode(b)", Lag: 1, Latency: time.Second},
+			// c wasn't polled this cycle
+			{Name: "TestNode(c)", Lag: 1, Latency: -1},
+			{Name: "TestNode(a)", Status: NodeStatusUnreachable},
+		},
+		Beacons: []*beaconJson{{Name: "beacon", SlotLag: 10}},
+	}
+	// Gauges are no-ops unless metrics are enabled
+	metrics.Enabled = true
+	defer func() { metrics.Enabled = false }()
+	reg := metrics.NewRegistry()
+	r.setScores(hs, reg)
+	// b: status 1, lag 0.8, peers 0.5 and sync 0. The latency is left out
+	// of the report, as it changes on every cycle.
+	for i, want := range []int{100, 57, 90, 0} {
+		if have := r.Cols[i].Health.Score; have != want {
+			t.Errorf("node %d: wrong score %d, want %d", i, have, want)
+		}
+	}
+	if h := r.Cols[1].Health; h.Peers == nil || *h.Peers != 5 || h.Syncing == nil || !*h.Syncing {
+		t.Errorf("wrong vitals in report: %+v", h)
+	}
+	if h := r.Cols[2].Health; h.Peers != nil || h.Syncing != nil {
+		t.Errorf("unexpected vitals in report: %+v", h)
+	}
+	// The beacon is only scored by status and lag
+	if have := r.Beacons[0].Health.Score; have != 50 {
+		t.Errorf("wrong beacon score %d", have)
+	}
+	// The gauges include the latency: 0.5 for b, while c has none
+	if have := metrics.GetOrRegisterGauge("health/TestNode(b)", reg).Value(); have != 56 {
+		t.Errorf("wrong gauge %d", have)
+	}
+	if have := metrics.GetOrRegisterGauge("health/TestNode(c)", reg).Value(); have != 90 {
+		t.Errorf("wrong gauge of unpolled node %d", have)
+	}
+	// Weighing the status double, and leaving out the sync state
+	hs, err = newHealthScorer(healthScoreConfig{Enabled: true, Weights: map[string]float64{"status": 2, "sync": 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs.collect([]vitalsNode{b})
+	if hj, live := hs.score("TestNode(b)", NodeStatusOK, 1, time.Second); hj.Score != 83 || live != 76 {
+		t.Errorf("wrong weighted score %d, live %d", hj.Score, live)
+	}
+	if _, err := newHealthScorer(healthScoreConfig{Enabled: true, Weights: map[string]float64{"disk": 1}}); err == nil {
+		t.Error("expected error for unknown part")
+	}
+}
+
+func TestNetworkMonitor(t *testing.T) {
+	var (
+		chain = makeChain("a", 10, nil)
+		nodes = []Node{newTestNode("a", 9, chain), newTestNode("b", 9, chain)}
+	)
+	top := &Config{Networks: []Config{{Network: "devnet", ReloadInterval: "1s"}}}
+	conf := top.NetworkConfigs()[0]
+	mon, err := NewMonitor(nodes, nil, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registry.Get("devnet/chain/split") == nil || registry.Get("devnet/chain/quorum") == nil {
+		t.Error("network metrics not prefixed")
+	}
+	r := mon.Report()
+	if r.Network != "devnet" {
+		t.Fatalf("wrong network %q", r.Network)
+	}
+	lines := string(newInfluxExporter(influxConfig{}).lines(r, time.Unix(1600000000, 0)))
+	if !strings.Contains(lines, "nodemonitor_node,node=TestNode(a),network=devnet ") ||
+		!strings.Contains(lines, "nodemonitor_chain,network=devnet ") {
+		t.Errorf("network not labelled: %v", lines)
+	}
+	series := newRemoteWriteExporter(remoteWriteConfig{Job: "nodemonitor"}).series(r)
+	if have := fmt.Sprint(series[0].labels); !strings.Contains(have, "{network devnet}") {
+		t.Errorf("network not labelled: %v", have)
+	}
+}
+
+// traceTestNode counts the blocks it's asked to trace
+type traceTestNode struct {
+	*testNode
+	traced map[common.Hash]int
+}
+
+func (n *traceTestNode) TraceBlock(hash common.Hash, tracer string) (json.RawMessage, error) {
+	n.traced[hash]++
+	return json.RawMessage(`[]`), nil
+}
+
+func TestSplitTraces(t *testing.T) {
+	var (
+		a  = makeChain("a", 100, nil)
+		b  = makeChain("b", 100, a[:60])
+		na = &traceTestNode{newTestNode("node a", 90, a), make(map[common.Hash]int)}
+		nb = &traceTestNode{newTestNode("b", 95, b), make(map[common.Hash]int)}
+	)
+	dir, err := ioutil.TempDir("", "traces")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mon, err := NewMonitor([]Node{na, nb}, nil, nil, &Config{ReloadInterval: "1s", SplitTracer: "callTracer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mon.splitTraces.dir = dir
+	mon.doChecks()
+	// The blocks are only queued by the checks, and traced in the background
+	if traces := mon.Report().Traces; len(traces) != 0 || len(mon.splitTraces.queue) != 2 {
+		t.Fatalf("expected 2 queued blocks, got %d, traces %v", len(mon.splitTraces.queue), traces)
+	}
+	for len(mon.splitTraces.queue) > 0 {
+		mon.splitTraces.traceBlock(<-mon.splitTraces.queue)
+	}
+	if na.traced[a[60].hash] != 1 || nb.traced[b[60].hash] != 1 || len(na.traced)+len(nb.traced) != 2 {
+		t.Errorf("expected the diverging block to be traced once on each side: %v %v", na.traced, nb.traced)
+	}
+	// Traced blocks aren't queued again, and show up in the next report
+	mon.doChecks()
+	if len(mon.splitTraces.queue) != 0 {
+		t.Errorf("traced blocks queued again")
+	}
+	traces := mon.Report().Traces
+	if len(traces) != 2 {
+		t.Fatalf("expected 2 traces in the report, got %d", len(traces))
+	}
+	if want := fmt.Sprintf("traces/0x%x-TestNode_node_a_.json", a[60].hash); traces[1].File != want || traces[1].Block != 60 {
+		t.Errorf("wrong trace: %+v, want file %v", traces[1], want)
+	}
+}
+
+// rawTestNode serves made up raw blocks, and counts the requests
+type rawTestNode struct {
+	*testNode
+	fetched map[common.Hash]int
+}
+
+func (n *rawTestNode) RawBlock(hash common.Hash) ([]byte, error) {
+	n.fetched[hash]++
+	return append([]byte("rlp"), hash[:]...), nil
+}
+
+func TestRawBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rawblocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var (
+		a  = makeChain("a", 100, nil)
+		b  = makeChain("b", 100, a[:60])
+		na = &rawTestNode{newTestNode("a", 90, a), make(map[common.Hash]int)}
+		nb = &rawTestNode{newTestNode("b", 60, b), make(map[common.Hash]int)}
+		rs = newRawBlockStore(true)
+	)
+	rs.dir = dir
+	points := []splitPoint{{na, 60, a[60].hash}, {nb, 60, b[60].hash}}
+	rs.storeSplits(points)
+	rs.storeSplits(points)
+	// The common parent is only stored once, and b has no child yet
+	for _, hash := range []common.Hash{a[59].hash, a[60].hash, a[61].hash, b[60].hash} {
+		if na.fetched[hash]+nb.fetched[hash] != 1 {
+			t.Errorf("block %x fetched %d times", hash, na.fetched[hash]+nb.fetched[hash])
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("0x%x.rlp", hash)))
+		if err != nil || string(data) != string(append([]byte("rlp"), hash[:]...)) {
+			t.Errorf("wrong raw block %x stored: %q, %v", hash, data, err)
+		}
+	}
+	if len(na.fetched)+len(nb.fetched) != 4 {
+		t.Errorf("unexpected blocks fetched: %v %v", na.fetched, nb.fetched)
+	}
+	// Blocks below the splits are forgotten, the remaining ones once the
+	// split is gone
+	rs.storeSplits([]splitPoint{{na, 61, a[61].hash}})
+	if _, ok := rs.blocks[a[59].hash]; ok || len(rs.blocks) != 4 {
+		t.Errorf("wrong blocks kept: %v", rs.blocks)
+	}
+	rs.storeSplits(nil)
+	if len(rs.blocks) != 0 {
+		t.Errorf("blocks kept without splits: %v", rs.blocks)
+	}
+}
+
+func TestHeadBlockMetadata(t *testing.T) {
+	node := &RPCNode{name: "geth", chainHistory: newBlockCache(16)}
+	miner := common.HexToAddress("0x95222290dd7278aa3ddd389cc1e1d165cc4bafe5")
+	h := &types.Header{Number: big.NewInt(5), Time: 1600000000, GasUsed: 21000, Coinbase: miner}
+	bl := node.store(h, &headerExtra{Transactions: make([]json.RawMessage, 3)})
+	if bl.txCount != 3 || bl.miner != miner || bl.received.IsZero() {
+		t.Fatalf("unexpected block: %+v", bl)
+	}
+	// Refetching the block keeps the time it was first seen
+	first := time.Unix(1600000001, 0)
+	bl.received = first
+	if again := node.store(h, nil); !again.received.Equal(first) || again.txCount != -1 {
+		t.Errorf("unexpected refetched block: %+v", again)
+	}
+
+	chain := makeChain("a", 10, nil)
+	chain[9].time, chain[9].gasUsed, chain[9].miner, chain[9].txCount, chain[9].received = 1600000000, 21000, miner, 2, first
+	r := NewReport([]int{9})
+	r.AddToReport(&countingNode{testNode: newTestNode("a", 9, chain)})
+	r.AddToReport(newTestNode("down", 9, chain))
+	hb := r.Cols[0].HeadBlock
+	if hb == nil || hb.Hash != chain[9].hash || hb.Time.Unix() != 1600000000 || hb.Miner != miner ||
+		hb.GasUsed != 21000 || hb.TxCount == nil || *hb.TxCount != 2 || !hb.Received.Equal(first) {
+		t.Errorf("unexpected head block: %+v", hb)
+	}
+	if r.Cols[1].HeadBlock != nil {
+		t.Errorf("head block of an unreachable node reported")
+	}
+	html, err := r.render("html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The address may be checksummed
+	if !strings.Contains(strings.ToLower(string(html)), `<td title="0x95222290dd7278aa3ddd389cc1e1d165cc4bafe5">0x95222290dd…</td><td>2</td>`) {
+		t.Errorf("head block missing from html report")
+	}
+}
+
+func TestUnchangedReport(t *testing.T) {
+	var (
+		a  = makeChain("a", 100, nil)
+		b  = makeChain("b", 100, a[:60])
+		na = newTestNode("a", 90, a)
+		nb = newTestNode("b", 95, b)
+	)
+	dir, err := ioutil.TempDir("", "nodemonitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := &Config{ReloadInterval: "1s", HealthScore: healthScoreConfig{Enabled: true}}
+	mon, err := NewMonitor([]Node{na, nb}, nil, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only write reports from here on, to the temp dir
+	mon.backend, mon.outDir = newMemoryDB(t), dir
+	path := filepath.Join(dir, reportFiles["json"])
+	mon.doChecks()
+	r := mon.Report()
+	if len(r.Events) == 0 || r.Cols[0].Health == nil {
+		t.Fatalf("expected a report with events and health scores: %d events", len(r.Events))
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("report not written: %v", err)
+	}
+	// The same cycle again, only the times and latencies differ
+	os.Remove(path)
+	time.Sleep(10 * time.Millisecond)
+	mon.doChecks()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("unchanged report written again: %v", err)
+	}
+	// Until something changes
+	nb.head = 96
+	mon.doChecks()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("changed report not written: %v", err)
+	}
+}
+
+func TestCheckNow(t *testing.T) {
+	mon, _ := NewMonitor([]Node{&brokenNode{"broken"}}, nil, nil, &Config{ReloadInterval: "1h"})
+	mon.Start()
+	defer mon.Stop()
+
+	before := mon.health().LastCycle
+	rec := httptest.NewRecorder()
+	mon.HandleCheck(rec, httptest.NewRequest("POST", "/api/check", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("got status %d", rec.Code)
+	}
+	for i := 0; i < 100; i++ {
+		if mon.health().LastCycle.After(before) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("no check cycle was triggered")
 }
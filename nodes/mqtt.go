@@ -0,0 +1,170 @@
+package nodes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type mqttConfig struct {
+	// Broker is the address of the broker, e.g. tcp://localhost:1883
+	Broker string
+	// Topic is the topic prefix, the messages are published to
+	// <topic>/<type>/<node or event kind>. Defaults to nodemonitor.
+	Topic string
+	// ClientID defaults to nodemonitor
+	ClientID string
+	Username string
+	Password string
+	// Retain makes the broker keep the last message of each topic, so that
+	// new subscribers see the current heads and statuses.
+	Retain bool
+}
+
+// mqttPublisher publishes the stream messages to an MQTT broker, at QoS 0,
+// using MQTT 3.1.1. A connection is made for every batch.
+type mqttPublisher struct {
+	addr string
+	conf mqttConfig
+}
+
+func newMqttPublisher(conf mqttConfig) (*mqttPublisher, error) {
+	if len(conf.Broker) == 0 {
+		return nil, nil
+	}
+	u, err := url.Parse(conf.Broker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mqtt broker: %v", err)
+	}
+	if (u.Scheme != "tcp" && u.Scheme != "mqtt") || len(u.Hostname()) == 0 {
+		return nil, fmt.Errorf("invalid mqtt broker %q, expected tcp://host:port", conf.Broker)
+	}
+	addr := u.Host
+	if len(u.Port()) == 0 {
+		addr = net.JoinHostPort(u.Hostname(), "1883")
+	}
+	if len(conf.Topic) == 0 {
+		conf.Topic = "nodemonitor"
+	}
+	if strings.ContainsAny(conf.Topic, "+#") {
+		return nil, fmt.Errorf("invalid mqtt topic %q, wildcards are not allowed", conf.Topic)
+	}
+	if len(conf.ClientID) == 0 {
+		conf.ClientID = "nodemonitor"
+	}
+	return &mqttPublisher{addr: addr, conf: conf}, nil
+}
+
+// Packet types
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttDisconnect = 14
+)
+
+// mqttString appends a length-prefixed string
+func mqttString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.BigEndian, uint16(len(s)))
+	buf.WriteString(s)
+}
+
+// mqttPacket appends a packet with the given fixed header flags and body
+func mqttPacket(buf *bytes.Buffer, typ, flags byte, body []byte) {
+	buf.WriteByte(typ<<4 | flags)
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		if n /= 128; n > 0 {
+			b |= 0x80
+		}
+		buf.WriteByte(b)
+		if n == 0 {
+			break
+		}
+	}
+	buf.Write(body)
+}
+
+// connectPacket returns the CONNECT packet with the credentials
+func (p *mqttPublisher) connectPacket() []byte {
+	var body, pkt bytes.Buffer
+	mqttString(&body, "MQTT")
+	body.WriteByte(4) // protocol level 3.1.1
+	flags := byte(0x02)
+	if len(p.conf.Username) > 0 {
+		flags |= 0x80
+		if len(p.conf.Password) > 0 {
+			flags |= 0x40
+		}
+	}
+	body.WriteByte(flags)
+	binary.Write(&body, binary.BigEndian, uint16(60)) // keep alive seconds
+	mqttString(&body, p.conf.ClientID)
+	if flags&0x80 != 0 {
+		mqttString(&body, p.conf.Username)
+	}
+	if flags&0x40 != 0 {
+		mqttString(&body, p.conf.Password)
+	}
+	mqttPacket(&pkt, mqttConnect, 0, body.Bytes())
+	return pkt.Bytes()
+}
+
+// topic returns the topic of the message. Node names can't add levels or
+// wildcards to it.
+func (p *mqttPublisher) topic(msg *streamMessage) string {
+	name := msg.Node
+	if msg.Type == streamEvent {
+		name = msg.Kind
+	}
+	name = strings.NewReplacer("+", "_", "#", "_", "/", "_").Replace(name)
+	return fmt.Sprintf("%v/%v/%v", p.conf.Topic, msg.Type, name)
+}
+
+func (p *mqttPublisher) publish(msgs []*streamMessage) error {
+	conn, err := net.DialTimeout("tcp", p.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write(p.connectPacket()); err != nil {
+		return err
+	}
+	var ack [4]byte
+	if _, err := io.ReadFull(conn, ack[:]); err != nil {
+		return err
+	}
+	if ack[0]>>4 != mqttConnack {
+		return errors.New("mqtt: unexpected reply to connect")
+	}
+	if ack[3] != 0 {
+		return fmt.Errorf("mqtt: connection refused, code %d", ack[3])
+	}
+	var buf bytes.Buffer
+	var flags byte
+	if p.conf.Retain {
+		flags = 0x01
+	}
+	for _, msg := range msgs {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		var body bytes.Buffer
+		mqttString(&body, p.topic(msg))
+		body.Write(data)
+		mqttPacket(&buf, mqttPublish, flags, body.Bytes())
+	}
+	mqttPacket(&buf, mqttDisconnect, 0, nil)
+	_, err = conn.Write(buf.Bytes())
+	return err
+}
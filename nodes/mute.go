@@ -0,0 +1,110 @@
+package nodes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// muteWindow silences alerts about a node, or about all nodes if Node is
+// empty, between Start and End. Events are still recorded in the report.
+type muteWindow struct {
+	Node   string
+	Start  time.Time
+	End    time.Time
+	Reason string
+}
+
+func (mw *muteWindow) matches(ev *Event) bool {
+	if ev.Time.Before(mw.Start) || !ev.Time.Before(mw.End) {
+		return false
+	}
+	if len(mw.Node) == 0 {
+		return true
+	}
+	for _, node := range ev.Nodes {
+		if node == mw.Node {
+			return true
+		}
+	}
+	return false
+}
+
+// muted returns whether the event falls into a maintenance window
+func (am *alertManager) muted(ev *Event) bool {
+	am.muteLock.Lock()
+	defer am.muteLock.Unlock()
+	for _, mw := range am.mutes {
+		if mw.matches(ev) {
+			return true
+		}
+	}
+	return false
+}
+
+func (am *alertManager) addMute(mw *muteWindow) error {
+	if mw.Start.IsZero() {
+		mw.Start = time.Now()
+	}
+	if !mw.End.After(mw.Start) {
+		return errors.New("end of maintenance window must be after the start")
+	}
+	am.muteLock.Lock()
+	defer am.muteLock.Unlock()
+	// Drop the windows which are over
+	var mutes []*muteWindow
+	for _, m := range am.mutes {
+		if m.End.After(time.Now()) {
+			mutes = append(mutes, m)
+		}
+	}
+	am.mutes = append(mutes, mw)
+	return nil
+}
+
+// removeMutes removes all windows for the node, or the global ones if node is
+// empty.
+func (am *alertManager) removeMutes(node string) {
+	am.muteLock.Lock()
+	defer am.muteLock.Unlock()
+	var mutes []*muteWindow
+	for _, m := range am.mutes {
+		if m.Node != node {
+			mutes = append(mutes, m)
+		}
+	}
+	am.mutes = mutes
+}
+
+func (am *alertManager) listMutes() []*muteWindow {
+	am.muteLock.Lock()
+	defer am.muteLock.Unlock()
+	return append([]*muteWindow{}, am.mutes...)
+}
+
+// HandleMute manages maintenance windows. GET lists them, POST adds one from
+// a json body with the fields of a muteWindow (Start defaults to now), and
+// DELETE removes the ones for the node given by the 'node' query parameter.
+func (mon *NodeMonitor) HandleMute(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var mw muteWindow
+		if err := json.NewDecoder(r.Body).Decode(&mw); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := mon.alerts.addMute(&mw); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case "DELETE":
+		mon.alerts.removeMutes(r.URL.Query().Get("node"))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mon.alerts.listMutes())
+}
@@ -0,0 +1,115 @@
+package nodes
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type natsConfig struct {
+	// Url is the address of the server, e.g. nats://localhost:4222
+	Url string
+	// Subject is the subject prefix, the message type is appended to it,
+	// e.g. nodemonitor.head. Defaults to nodemonitor.
+	Subject  string
+	Token    string
+	Username string
+	Password string
+}
+
+// natsPublisher publishes the stream messages to a NATS server. The text
+// protocol is simple enough that no client is needed: a connection is made
+// for every batch, which is closed once the server has answered a ping.
+type natsPublisher struct {
+	addr    string
+	subject string
+	connect []byte
+}
+
+func newNatsPublisher(conf natsConfig) (*natsPublisher, error) {
+	if len(conf.Url) == 0 {
+		return nil, nil
+	}
+	u, err := url.Parse(conf.Url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nats url: %v", err)
+	}
+	if u.Scheme != "nats" || len(u.Hostname()) == 0 {
+		return nil, fmt.Errorf("invalid nats url %q, expected nats://host:port", conf.Url)
+	}
+	addr := u.Host
+	if len(u.Port()) == 0 {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	subject := conf.Subject
+	if len(subject) == 0 {
+		subject = "nodemonitor"
+	}
+	if strings.ContainsAny(subject, " \t\r\n*>") {
+		return nil, fmt.Errorf("invalid nats subject %q", subject)
+	}
+	opts := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "nodemonitor",
+		"lang":     "go",
+		"version":  "1.0.0",
+	}
+	if len(conf.Token) > 0 {
+		opts["auth_token"] = conf.Token
+	}
+	if len(conf.Username) > 0 {
+		opts["user"], opts["pass"] = conf.Username, conf.Password
+	}
+	connect, _ := json.Marshal(opts)
+	return &natsPublisher{addr: addr, subject: subject, connect: connect}, nil
+}
+
+func (p *natsPublisher) publish(msgs []*streamMessage) error {
+	conn, err := net.DialTimeout("tcp", p.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+	info, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(info, "INFO") {
+		return fmt.Errorf("unexpected nats greeting: %q", strings.TrimSpace(info))
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "CONNECT %s\r\n", p.connect)
+	for _, msg := range msgs {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "PUB %v.%v %d\r\n%s\r\n", p.subject, msg.Type, len(data), data)
+	}
+	// The pong is only sent once everything before the ping was processed
+	buf.WriteString("PING\r\n")
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("nats: " + strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
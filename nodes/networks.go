@@ -0,0 +1,95 @@
+package nodes
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// networkName is what network names are limited to, as they're part of paths
+// and metric names
+var networkName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// NetworkConfigs returns the configs of the monitored networks: the top level
+// one if it has clients, followed by those under Networks. Each is monitored
+// on its own, by a separate monitor.
+func (c *Config) NetworkConfigs() []*Config {
+	var confs []*Config
+	if len(c.Clients) > 0 {
+		confs = append(confs, c)
+	}
+	for i := range c.Networks {
+		n := &c.Networks[i]
+		n.namespace = n.Network
+		if len(n.Identity) == 0 {
+			n.Identity = c.Identity
+		}
+		// Every network has a lease of its own
+		n.Leader = c.Leader
+		if len(c.Leader.LeaseFile) > 0 {
+			n.Leader.LeaseFile = c.Leader.LeaseFile + "-" + n.namespace
+		}
+		confs = append(confs, n)
+	}
+	return confs
+}
+
+// Namespace is the name the network is kept apart from the others by, which
+// is empty for the top level network
+func (c *Config) Namespace() string {
+	return c.namespace
+}
+
+// DBPath returns the path of the block database of the network, which is the
+// given path for the top level network
+func (c *Config) DBPath(path string) string {
+	if len(c.namespace) == 0 {
+		return path
+	}
+	return path + "-" + c.namespace
+}
+
+// OutputDir is the directory the reports and artifacts of the network are
+// written to
+func (c *Config) OutputDir() string {
+	if len(c.namespace) == 0 {
+		return "www"
+	}
+	return filepath.Join("www", "networks", c.namespace)
+}
+
+// metricsRegistry is the registry of the internal metrics of the network.
+// Those of the top level network are unprefixed.
+func (c *Config) metricsRegistry() metrics.Registry {
+	if len(c.namespace) == 0 {
+		return registry
+	}
+	return metrics.NewPrefixedChildRegistry(registry, c.namespace+"/")
+}
+
+// validateNetworks checks the configs under Networks
+func (c *Config) validateNetworks() error {
+	names := make(map[string]bool)
+	for i := range c.Networks {
+		n := &c.Networks[i]
+		if !networkName.MatchString(n.Network) {
+			return fmt.Errorf("invalid network name %q, only letters, digits, '-' and '_' are allowed", n.Network)
+		}
+		if names[n.Network] {
+			return fmt.Errorf("duplicate network %v", n.Network)
+		}
+		names[n.Network] = true
+		if len(n.Networks) > 0 {
+			return fmt.Errorf("network %v: networks can't be nested", n.Network)
+		}
+		if len(n.ServerAddress) > 0 || n.API.enabled() || n.HTTP.enabled() || n.Pprof || n.Metrics.Enabled || n.Logging != (loggingConfig{}) || n.Leader != (leaderConfig{}) {
+			return fmt.Errorf("network %v: server_address, api, http, pprof, metrics, logging and leader are only configured at the top level", n.Network)
+		}
+		if err := n.Validate(); err != nil {
+			return fmt.Errorf("network %v: %v", n.Network, err)
+		}
+	}
+	return nil
+}
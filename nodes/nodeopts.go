@@ -0,0 +1,125 @@
+package nodes
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Checks which can be disabled per node
+const (
+	// checkSplitSearch is locating the first diverging block, which takes
+	// many requests on deep splits
+	checkSplitSearch = "split_search"
+	// checkTaggedBlocks is cross-checking the safe and finalized blocks
+	checkTaggedBlocks = "tagged"
+	// checkStateProbes is comparing reads of the state
+	checkStateProbes = "state"
+	// checkLogQueries is comparing the results of log queries
+	checkLogQueries = "logs"
+	// checkBadBlocks is collecting the blocks rejected as invalid
+	checkBadBlocks = "bad_blocks"
+	// checkCanaries is sending canary transactions through the node
+	checkCanaries = "canary"
+)
+
+var nodeChecks = []string{checkSplitSearch, checkTaggedBlocks, checkStateProbes, checkLogQueries, checkBadBlocks, checkCanaries}
+
+// nodeOptions are the per-node overrides of the check cycle
+type nodeOptions struct {
+	// interval is the minimum time between head updates, zero to update
+	// every cycle
+	interval time.Duration
+	skip     map[string]bool
+	// archive is set for nodes with the full history, which are used for
+	// deep searches. Other nodes may only retain the last history blocks.
+	archive bool
+	history uint64
+	// lastUpdate is when the head was last updated
+	lastUpdate time.Time
+}
+
+// nodeOptionSet maps node names to their options, for nodes which have any
+type nodeOptionSet map[string]*nodeOptions
+
+// options parses the per-node options of the client, returning nil if there
+// are none
+func (c *ClientInfo) options() (*nodeOptions, error) {
+	if len(c.Interval) == 0 && len(c.Skip) == 0 && !c.Archive && c.HistoryBlocks == 0 {
+		return nil, nil
+	}
+	if c.Archive && c.HistoryBlocks > 0 {
+		return nil, errors.New("archive nodes retain all history, history_blocks can't be set")
+	}
+	opts := &nodeOptions{
+		skip:    make(map[string]bool),
+		archive: c.Archive,
+		history: c.HistoryBlocks,
+	}
+	if len(c.Interval) > 0 {
+		d, err := time.ParseDuration(c.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval: %v", err)
+		}
+		opts.interval = d
+	}
+	for _, check := range c.Skip {
+		known := false
+		for _, name := range nodeChecks {
+			known = known || name == check
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown check %q, available: %v", check, nodeChecks)
+		}
+		opts.skip[check] = true
+	}
+	return opts, nil
+}
+
+func newNodeOptionSet(clients []ClientInfo) nodeOptionSet {
+	set := make(nodeOptionSet)
+	for _, c := range clients {
+		// Errors are caught by Validate
+		if opts, _ := c.options(); opts != nil {
+			set[c.Name] = opts
+		}
+	}
+	return set
+}
+
+// due returns whether the head of the node should be updated in this cycle
+func (set nodeOptionSet) due(node Node, now time.Time) bool {
+	opts := set[node.Name()]
+	if opts == nil || opts.interval == 0 || node.Status() != NodeStatusOK {
+		return true
+	}
+	return now.Sub(opts.lastUpdate) >= opts.interval
+}
+
+// updated records that the head of the node was updated
+func (set nodeOptionSet) updated(node Node, now time.Time) {
+	if opts := set[node.Name()]; opts != nil {
+		opts.lastUpdate = now
+	}
+}
+
+// skips returns whether the check is disabled for any of the nodes
+func (set nodeOptionSet) skips(check string, nodes ...Node) bool {
+	for _, node := range nodes {
+		if opts := set[node.Name()]; opts != nil && opts.skip[check] {
+			return true
+		}
+	}
+	return false
+}
+
+// enabled returns the nodes for which the check is enabled
+func (set nodeOptionSet) enabled(check string, nodes []Node) []Node {
+	var enabled []Node
+	for _, node := range nodes {
+		if !set.skips(check, node) {
+			enabled = append(enabled, node)
+		}
+	}
+	return enabled
+}
@@ -1,14 +1,21 @@
 package nodes
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"go.uber.org/ratelimit"
 	"math/big"
+	"net/http"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
@@ -18,11 +25,30 @@ import (
 const (
 	NodeStatusOK          = 0
 	NodeStatusUnreachable = 1
+	// NodeStatusThrottled is set while the provider of the node rate limits
+	// the monitor
+	NodeStatusThrottled = 2
 )
 
 type blockInfo struct {
 	num  uint64
 	hash common.Hash
+	// time is the block timestamp, zero if unknown
+	time uint64
+	// gas used and limit of the block, zero if unknown
+	gasUsed, gasLimit uint64
+	// baseFee is the base fee per gas, nil before london or if unknown
+	baseFee *big.Int
+	// td is the total difficulty of the chain up to the block, nil if unknown
+	td *big.Int
+	// uncles is set if the block includes uncles
+	uncles bool
+	// miner is the fee recipient
+	miner common.Address
+	// txCount is the number of transactions, -1 if unknown
+	txCount int
+	// received is when the block was first fetched from the node
+	received time.Time
 }
 
 func (bl *blockInfo) TerminalString() string {
@@ -49,21 +75,46 @@ type RPCNode struct {
 	version      string
 	name         string
 	latest       *blockInfo
-	chainHistory map[uint64]*blockInfo
+	chainHistory *blockCache
+	// blocks for the 'safe' and 'finalized' tags
+	tagged map[string]*blockInfo
 	// backend to store hash -> header into
 	db     *blockDB
 	status int
+	// push is set if the node is connected via websocket or ipc, and can
+	// push new heads
+	push bool
 
 	headGauge metrics.Gauge
 	// rate limiting
 	throttle ratelimit.Limiter
 }
 
-func NewRPCNode(name string, url string, db *blockDB, rateLimit int) (*RPCNode, error) {
-	rpcCli, err := rpc.Dial(url)
+// NewRPCNode creates a node reachable at the given url. If client is non-nil,
+// it is used for the http requests. Nodes reached via websocket push their new
+// heads to the monitor.
+func NewRPCNode(name string, url string, db *blockDB, rateLimit int, client *http.Client, reg metrics.Registry) (*RPCNode, error) {
+	rpcCli, err := dialRPC(url, client)
 	if err != nil {
 		return nil, err
 	}
+	node := newRPCNode(name, rpcCli, db, rateLimit, reg)
+	node.push = isWebsocket(url)
+	return node, nil
+}
+
+// NewIPCNode creates a node reachable via the IPC socket at the given path
+func NewIPCNode(name string, path string, db *blockDB, rateLimit int, reg metrics.Registry) (*RPCNode, error) {
+	rpcCli, err := rpc.DialIPC(context.Background(), path)
+	if err != nil {
+		return nil, err
+	}
+	node := newRPCNode(name, rpcCli, db, rateLimit, reg)
+	node.push = true
+	return node, nil
+}
+
+func newRPCNode(name string, rpcCli *rpc.Client, db *blockDB, rateLimit int, reg metrics.Registry) *RPCNode {
 	throttle := ratelimit.NewUnlimited()
 	if rateLimit > 0 {
 		throttle = ratelimit.New(rateLimit)
@@ -75,19 +126,34 @@ func NewRPCNode(name string, url string, db *blockDB, rateLimit int) (*RPCNode,
 		ethCli:       ethCli,
 		name:         name,
 		version:      "n/a",
-		chainHistory: make(map[uint64]*blockInfo),
+		chainHistory: newBlockCache(hashCacheSize),
+		tagged:       make(map[string]*blockInfo),
 		db:           db,
-		headGauge:    metrics.GetOrRegisterGauge(gaugeName, registry),
+		headGauge:    metrics.GetOrRegisterGauge(gaugeName, reg),
 		throttle:     throttle,
-	}, nil
+	}
 }
 
-func NewInfuraNode(name, projectId, endpoint string, db *blockDB, rateLimit int) (*RPCNode, error) {
+// dialRPC connects to the url, using the given http client if non-nil
+func dialRPC(url string, client *http.Client) (*rpc.Client, error) {
+	if isWebsocket(url) {
+		if client != nil {
+			return nil, errors.New("auth, headers, tls and proxy options are not supported for websocket endpoints")
+		}
+		return rpc.DialWebsocket(context.Background(), url, "")
+	}
+	if client != nil {
+		return rpc.DialHTTPWithClient(url, client)
+	}
+	return rpc.Dial(url)
+}
+
+func NewInfuraNode(name, projectId, endpoint string, db *blockDB, rateLimit int, client *http.Client, reg metrics.Registry) (*RPCNode, error) {
 	if len(projectId) == 0 {
 		return nil, errors.New("Missing infura_key")
 	}
 	url := fmt.Sprintf("%v%v", endpoint, projectId)
-	rpcCli, err := rpc.Dial(url)
+	rpcCli, err := dialRPC(url, client)
 	if err != nil {
 		return nil, err
 	}
@@ -102,19 +168,20 @@ func NewInfuraNode(name, projectId, endpoint string, db *blockDB, rateLimit int)
 		ethCli:       ethCli,
 		name:         name,
 		version:      "Infura V3",
-		chainHistory: make(map[uint64]*blockInfo),
+		chainHistory: newBlockCache(hashCacheSize),
+		tagged:       make(map[string]*blockInfo),
 		db:           db,
-		headGauge:    metrics.GetOrRegisterGauge(gaugeName, registry),
+		headGauge:    metrics.GetOrRegisterGauge(gaugeName, reg),
 		throttle:     throttle,
 	}, nil
 }
 
-func NewAlchemyNode(name, apiKey, endpoint string, db *blockDB, rateLimit int) (*RPCNode, error) {
+func NewAlchemyNode(name, apiKey, endpoint string, db *blockDB, rateLimit int, client *http.Client, reg metrics.Registry) (*RPCNode, error) {
 	if len(apiKey) == 0 {
 		return nil, errors.New("Missing alchemy_key")
 	}
 	url := fmt.Sprintf("%v%v", endpoint, apiKey)
-	rpcCli, err := rpc.Dial(url)
+	rpcCli, err := dialRPC(url, client)
 	if err != nil {
 		return nil, err
 	}
@@ -129,9 +196,10 @@ func NewAlchemyNode(name, apiKey, endpoint string, db *blockDB, rateLimit int) (
 		ethCli:       ethCli,
 		name:         name,
 		version:      "Alchemy V2",
-		chainHistory: make(map[uint64]*blockInfo),
+		chainHistory: newBlockCache(hashCacheSize),
+		tagged:       make(map[string]*blockInfo),
 		db:           db,
-		headGauge:    metrics.GetOrRegisterGauge(gaugeName, registry),
+		headGauge:    metrics.GetOrRegisterGauge(gaugeName, reg),
 		throttle:     throttle,
 	}, nil
 }
@@ -154,7 +222,7 @@ func (node *RPCNode) Version() (string, error) {
 			node.version = strings.Join(parts[1:], "/")
 		}
 	}
-	return ver, err
+	return ver, wrapNodeError(node.name, "version", err)
 }
 
 func (node *RPCNode) HeadNum() uint64 {
@@ -171,7 +239,7 @@ func (node *RPCNode) Name() string {
 func (node *RPCNode) UpdateLatest() error {
 	bl, err := node.fetchHeader(nil)
 	if err != nil {
-		return err
+		return wrapNodeError(node.name, "latest", err)
 	}
 	node.latest = bl
 	node.headGauge.Update(int64(bl.num))
@@ -181,24 +249,76 @@ func (node *RPCNode) UpdateLatest() error {
 func (node *RPCNode) fetchHeader(num *big.Int) (*blockInfo, error) {
 	node.throttle.Take()
 	log.Debug("Doing check", "node", node.name, "requested", num)
-	h, err := node.ethCli.HeaderByNumber(context.Background(), num)
-	if err != nil {
+	arg := "latest"
+	if num != nil {
+		arg = hexutil.EncodeBig(num)
+	}
+	// The header type we have predates london, so the base fee is decoded
+	// from the same response on the side, along with the total difficulty
+	op := "header"
+	if num == nil {
+		op = "latest"
+	}
+	var raw json.RawMessage
+	if err := node.rpcCli.CallContext(context.Background(), &raw, "eth_getBlockByNumber", arg, false); err != nil {
 		//log.Error("Blockcheck error", "error", err)
-		return nil, err
+		return nil, wrapNodeError(node.name, op, err)
+	}
+	var (
+		h     *types.Header
+		extra headerExtra
+	)
+	if err := json.Unmarshal(raw, &h); err != nil {
+		return nil, wrapNodeError(node.name, op, err)
 	}
 	if h == nil {
-		return nil, fmt.Errorf("Got nil header for, num %d, node %v", num, node.name)
+		return nil, fmt.Errorf("%w: num %d, node %v", errBlockNotFound, num, node.name)
+	}
+	if err := json.Unmarshal(raw, &extra); err != nil {
+		return nil, wrapNodeError(node.name, op, err)
 	}
+	return node.store(h, &extra), nil
+}
+
+// headerExtra holds the block fields not in the header type
+type headerExtra struct {
+	BaseFee         *hexutil.Big      `json:"baseFeePerGas"`
+	TotalDifficulty *hexutil.Big      `json:"totalDifficulty"`
+	Transactions    []json.RawMessage `json:"transactions"`
+}
+
+// store adds the header to the chain history and the backend. The extra
+// fields are optional.
+func (node *RPCNode) store(h *types.Header, extra *headerExtra) *blockInfo {
 	// Store header to db aswell
 	if node.db != nil {
 		node.db.add(h.Hash(), h)
+		if err := node.db.addHeight(h.Number.Uint64(), h.Hash(), node.name, time.Now()); err != nil {
+			log.Warn("Failed to index header", "number", h.Number, "error", err)
+		}
 	}
 	bl := &blockInfo{
-		num:  h.Number.Uint64(),
-		hash: h.Hash(),
+		num:      h.Number.Uint64(),
+		hash:     h.Hash(),
+		time:     h.Time,
+		gasUsed:  h.GasUsed,
+		gasLimit: h.GasLimit,
+		uncles:   h.UncleHash != types.EmptyUncleHash,
+		miner:    h.Coinbase,
+		txCount:  -1,
+		received: time.Now(),
 	}
-	node.chainHistory[bl.num] = bl
-	return bl, nil
+	if extra != nil {
+		bl.baseFee = (*big.Int)(extra.BaseFee)
+		bl.td = (*big.Int)(extra.TotalDifficulty)
+		bl.txCount = len(extra.Transactions)
+	}
+	// Blocks are refetched, keep the time the block was first seen
+	if prev, ok := node.chainHistory.get(bl.num); ok && prev.hash == bl.hash && !prev.received.IsZero() {
+		bl.received = prev.received
+	}
+	node.chainHistory.add(bl)
+	return bl
 }
 
 func (node *RPCNode) BlockAt(num uint64, force bool) *blockInfo {
@@ -206,11 +326,14 @@ func (node *RPCNode) BlockAt(num uint64, force bool) *blockInfo {
 		return nil // that block is future, don't bother
 	}
 	if !force {
-		if bl, ok := node.chainHistory[num]; ok {
+		if bl, ok := node.chainHistory.get(num); ok {
 			return bl // have it already, don't refetch it
 		}
 	}
-	bl, _ := node.fetchHeader(new(big.Int).SetUint64(num))
+	bl, err := node.fetchHeader(new(big.Int).SetUint64(num))
+	if err != nil && !isBlockNotFound(err) {
+		log.Debug("Failed to fetch block", "node", node.name, "number", num, "kind", ErrorKindOf(err), "error", err)
+	}
 	return bl
 }
 
@@ -225,8 +348,65 @@ type clientJson struct {
 	Version string
 	Name    string
 	Status  int
+	Head    uint64
+	// Lag is the number of blocks behind the highest head
+	Lag       uint64
+	Finalized uint64
+	Safe      uint64
+	// Latency is how long it took to fetch the head. It changes on every
+	// cycle, so it's not part of the serialized report
+	Latency time.Duration `json:"-"`
+	Labels  map[string]string
+	// Minority is set if the node disagrees with the quorum head
+	Minority bool
+	// Agreement is "reference", "agreeing" or "diverging" if there are
+	// reference nodes
+	Agreement string
+	// HeadBlock describes the head block, if its header is known
+	HeadBlock *headBlockJson `json:",omitempty"`
+	// Txpool is the size of the txpool, if it's monitored
+	Txpool *txpoolJson `json:",omitempty"`
+	// Identity is the p2p identity of the node, if it tells it
+	Identity *identityJson `json:",omitempty"`
+	// Metrics are the series scraped from the node's metrics url
+	Metrics map[string]float64 `json:",omitempty"`
+	// Health is the health score of the node, if it's computed
+	Health *healthScoreJson `json:",omitempty"`
+}
+
+// headBlockJson is the metadata of the head block of a node
+type headBlockJson struct {
+	Hash common.Hash
+	// Time is the block timestamp
+	Time time.Time
+	// Miner is the fee recipient
+	Miner   common.Address
+	GasUsed uint64
+	TxCount *int `json:",omitempty"`
+	// Received is when the monitor first saw the block
+	Received time.Time
+}
+
+func newHeadBlockJson(bl *blockInfo) *headBlockJson {
+	hb := &headBlockJson{
+		Hash:     bl.hash,
+		Time:     time.Unix(int64(bl.time), 0).UTC(),
+		Miner:    bl.miner,
+		GasUsed:  bl.gasUsed,
+		Received: bl.received,
+	}
+	if bl.txCount >= 0 {
+		count := bl.txCount
+		hb.TxCount = &count
+	}
+	return hb
 }
 
+// ReportVersion is the version of the report format. It's increased on
+// changes which consumers need to handle, such as renamed or removed fields.
+// Reports from before there were versions are version 0.
+const ReportVersion = 1
+
 // Report represents one 'snapshot' of the state of the nodes, where they are at
 // in a given time.
 type Report struct {
@@ -234,6 +414,53 @@ type Report struct {
 	Rows    map[int][]string
 	Numbers []int
 	Hashes  []common.Hash
+	// SplitDepth is the max amount of blocks in any chain not accepted by all nodes
+	SplitDepth int64
+	// SplitTooDeep is set if a split is deeper than could be searched, either
+	// beyond the max split depth or below the blocks the nodes retain. The
+	// SplitDepth is then a lower bound.
+	SplitTooDeep bool
+	// GroupSplits are splits between entire groups of nodes
+	GroupSplits []*groupSplitJson
+	// Quorum is the head agreed on by the most nodes
+	Quorum  *quorumJson
+	Events  []*Event
+	Beacons []*beaconJson
+	// Missed slots within the tracked window, and the rate of them
+	MissedSlots    []missedSlot
+	MissedSlotRate float64
+	Validators     []*validatorJson
+	Slashings      []*slashingJson
+	Blobs          []*blobJson
+	Pairs          []*pairJson
+	Relays         []*relayJson
+	// BlockTime holds statistics over the recent inter-block times
+	BlockTime *blockTimeJson
+	// Fees holds the base fee and gas usage trends of the head blocks
+	Fees *feeJson
+	// UncleRate is the share of recent blocks which include uncles, if the
+	// uncle rate is tracked
+	UncleRate float64 `json:",omitempty"`
+	// BadBlocks are the blocks which nodes rejected as invalid
+	BadBlocks []*badBlockJson
+	// Traces are the traces of the first diverging blocks of splits
+	Traces []*traceJson
+	// IPFS holds the CIDs of the content pinned to IPFS, if enabled
+	IPFS *ipfsJson `json:",omitempty"`
+	// Version is the ReportVersion the report was generated with
+	Version int
+	// Generated is when the report was generated
+	Generated time.Time
+	// Monitor and Network are the configured names of the monitor and of the
+	// monitored network
+	Monitor string `json:",omitempty"`
+	Network string `json:",omitempty"`
+	// Canaries are the recent canary transactions, newest first
+	Canaries []*canaryJson `json:",omitempty"`
+	// PeerOverlap compares the peers of the nodes, if they're collected
+	PeerOverlap []*peerOverlapJson `json:",omitempty"`
+	// Rollups are the OP-Stack rollup nodes, if any are configured
+	Rollups []*rollupJson `json:",omitempty"`
 }
 
 func NewReport(headList []int) *Report {
@@ -241,7 +468,25 @@ func NewReport(headList []int) *Report {
 		Numbers: headList,
 		Cols:    nil,
 		Rows:    make(map[int][]string),
+		Version: ReportVersion,
+	}
+}
+
+// decodeReport decodes a json report, of the current or an older version.
+// Reports from before versioning get the time they were archived at as the
+// time they were generated.
+func decodeReport(data []byte, archived time.Time) (*Report, error) {
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	if r.Version > ReportVersion {
+		return nil, fmt.Errorf("unsupported report version %d, max %d", r.Version, ReportVersion)
+	}
+	if r.Version == 0 && r.Generated.IsZero() {
+		r.Generated = archived
 	}
+	return &r, nil
 }
 
 func (r *Report) dedup() {
@@ -254,6 +499,10 @@ func (r *Report) dedup() {
 	for k, _ := range hashMap {
 		hashList = append(hashList, k)
 	}
+	// Keep the order stable, so identical reports serialize identically
+	sort.Slice(hashList, func(i, j int) bool {
+		return bytes.Compare(hashList[i][:], hashList[j][:]) < 0
+	})
 	r.Hashes = hashList
 }
 
@@ -284,8 +533,24 @@ func (r *Report) AddToReport(node Node) {
 			Version: v,
 			Name:    node.Name(),
 			Status:  node.Status(),
+			Head:    node.HeadNum(),
 		},
 	)
+	// The head is cached since the last update, unless the node is down
+	if node.Status() == NodeStatusOK {
+		if bl := node.BlockAt(node.HeadNum(), false); bl != nil && bl.time != 0 {
+			r.Cols[len(r.Cols)-1].HeadBlock = newHeadBlockJson(bl)
+		}
+	}
+	if tn, ok := node.(taggedNode); ok {
+		col := r.Cols[len(r.Cols)-1]
+		if bl := tn.Tagged(TagFinalized); bl != nil {
+			col.Finalized = bl.num
+		}
+		if bl := tn.Tagged(TagSafe); bl != nil {
+			col.Safe = bl.num
+		}
+	}
 	for _, num := range r.Numbers {
 		row := r.Rows[num]
 		block := node.BlockAt(uint64(num), false)
@@ -300,6 +565,28 @@ func (r *Report) AddToReport(node Node) {
 	r.dedup()
 }
 
+// fillStats sets the lag of all reachable nodes relative to the highest head,
+// along with the given latencies. Nodes which weren't polled this cycle get a
+// negative latency.
+func (r *Report) fillStats(latencies map[string]time.Duration) {
+	var highest uint64
+	for _, c := range r.Cols {
+		if c.Status == NodeStatusOK && c.Head > highest {
+			highest = c.Head
+		}
+	}
+	for _, c := range r.Cols {
+		if c.Status != NodeStatusOK {
+			continue
+		}
+		c.Lag = highest - c.Head
+		c.Latency = -1
+		if latency, ok := latencies[c.Name]; ok {
+			c.Latency = latency
+		}
+	}
+}
+
 func ReportNode(node Node, nums []int) {
 	v, _ := node.Version()
 	fmt.Printf("## %v\n", v)
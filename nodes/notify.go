@@ -0,0 +1,81 @@
+package nodes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// webhookClient is used by the notifiers posting to chat services
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// postJSON posts the json-encoded body to the url, and fails unless the
+// response is a 2xx
+func postJSON(method, url string, body interface{}, header http.Header) error {
+	return sendJSON(method, url, body, header, nil)
+}
+
+// sendJSON is like postJSON, and decodes the response into result, if given
+func sendJSON(method, url string, body interface{}, header http.Header, result interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if len(req.Header.Get("Content-Type")) == 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("%v: %s", res.Status, msg)
+	}
+	if result != nil {
+		return json.NewDecoder(res.Body).Decode(result)
+	}
+	return nil
+}
+
+// kindFilter selects the events a notifier forwards. If no kinds are given,
+// the defaults are used.
+type kindFilter map[string]bool
+
+func newKindFilter(kinds []string, defaults ...string) kindFilter {
+	if len(kinds) == 0 {
+		kinds = defaults
+	}
+	f := make(kindFilter)
+	for _, kind := range kinds {
+		f[kind] = true
+	}
+	return f
+}
+
+func (f kindFilter) match(ev *Event) bool {
+	return f[ev.Kind]
+}
+
+// splitKinds are the events about the nodes being on different chains, or a
+// node's chain being reorganized
+var splitKinds = []string{
+	EventSplit, EventGroupSplit, EventFinalizedSplit, EventSafeSplit, EventHeadRegression,
+}
+
+// outageKinds are the events about splits, and unreachable or stuck nodes
+var outageKinds = []string{
+	EventSplit, EventGroupSplit, EventFinalizedSplit, EventSafeSplit,
+	EventCheckpointMismatch, EventUnreachable, EventHeadStall, EventChainHalt,
+}
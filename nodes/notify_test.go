@@ -0,0 +1,190 @@
+package nodes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// webhookRecorder serves a fake webhook, recording the decoded bodies
+func webhookRecorder(t *testing.T) (*httptest.Server, *[]map[string]interface{}, *[]*http.Request) {
+	var (
+		bodies []map[string]interface{}
+		reqs   []*http.Request
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("bad body: %v", err)
+		}
+		bodies = append(bodies, body)
+		reqs = append(reqs, r)
+	}))
+	return srv, &bodies, &reqs
+}
+
+func TestTelegramNotifier(t *testing.T) {
+	srv, bodies, reqs := webhookRecorder(t)
+	defer srv.Close()
+
+	n, err := newTelegramNotifier(telegramConfig{Token: "123:abc", ChatID: "-42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.url != "https://api.telegram.org/bot123:abc/sendMessage" {
+		t.Errorf("unexpected url: %v", n.url)
+	}
+	n.url = srv.URL + "/sendMessage"
+	n.Notify(&Event{Kind: EventSlotLag, Severity: SeverityWarning, Nodes: []string{"lighthouse"}})
+	if err := n.Notify(&Event{Kind: EventSplit, Severity: SeverityCritical, Nodes: []string{"geth", "besu"},
+		Message: "Split found at block 100"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*bodies) != 1 {
+		t.Fatalf("expected only the split to be sent, got %d messages", len(*bodies))
+	}
+	body := (*bodies)[0]
+	if body["chat_id"] != "-42" || body["text"] != "🔴 split: Split found at block 100\nNodes: geth, besu" {
+		t.Errorf("unexpected message: %v", body)
+	}
+	if (*reqs)[0].URL.Path != "/sendMessage" {
+		t.Errorf("unexpected path: %v", (*reqs)[0].URL.Path)
+	}
+	if _, err := newTelegramNotifier(telegramConfig{Token: "123:abc"}); err == nil {
+		t.Errorf("expected error for missing chat id")
+	}
+}
+
+func TestDiscordNotifier(t *testing.T) {
+	srv, bodies, _ := webhookRecorder(t)
+	defer srv.Close()
+
+	am, _ := newAlertManager(alertsConfig{})
+	am.notifiers = []Notifier{newDiscordNotifier(discordConfig{Webhook: srv.URL, DashboardUrl: "https://mon.example.com/"}, "goerli")}
+	split := &Event{Time: time.Now(), Kind: EventSplit, Severity: SeverityWarning, Nodes: []string{"geth", "erigon", "besu"},
+		Message: "Split found at block 100", Block: 100, Hashes: []common.Hash{{1}, {2}},
+		HashNodes: [][]string{{"geth", "erigon"}, {"besu"}}}
+	am.dispatch(split)
+	am.endCycle(time.Now())
+	if len(*bodies) != 1 {
+		t.Fatalf("expected one message, got %d", len(*bodies))
+	}
+	data, _ := json.Marshal((*bodies)[0])
+	for _, want := range []string{
+		`"title":"split (warning)"`,
+		`"value":"geth, erigon, besu"`,
+		`"value":"100"`,
+		"[geth, erigon](https://mon.example.com/networks/goerli/hashes/0x0100000000000000000000000000000000000000000000000000000000000000.json)",
+		"[besu](https://mon.example.com/networks/goerli/hashes/0x0200000000000000000000000000000000000000000000000000000000000000.json)",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("message missing %q: %s", want, data)
+		}
+	}
+	// The split is resolved once it's no longer seen for more than a cycle
+	am.endCycle(time.Now())
+	if len(*bodies) != 1 {
+		t.Fatalf("expected no recovery after a single cycle, got %d messages", len(*bodies))
+	}
+	am.endCycle(time.Now())
+	if len(*bodies) != 2 {
+		t.Fatalf("expected recovery message, got %d messages", len(*bodies))
+	}
+	data, _ = json.Marshal((*bodies)[1])
+	if !strings.Contains(string(data), "Resolved: split") {
+		t.Errorf("unexpected recovery: %s", data)
+	}
+	am.endCycle(time.Now())
+	if len(*bodies) != 2 {
+		t.Errorf("expected only one recovery message")
+	}
+}
+
+func TestMatrixNotifier(t *testing.T) {
+	srv, bodies, reqs := webhookRecorder(t)
+	defer srv.Close()
+
+	n, err := newMatrixNotifier(matrixConfig{Homeserver: srv.URL + "/", AccessToken: "secret", RoomID: "!room:example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := &Event{Kind: EventUnreachable, Severity: SeverityWarning, Nodes: []string{"geth"}, Message: "Error getting latest: <timeout>"}
+	n.Notify(ev)
+	n.Notify(ev)
+	n.Recovered(ev)
+	if len(*reqs) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(*reqs))
+	}
+	req := (*reqs)[0]
+	if req.Method != "PUT" || req.Header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("unexpected request: %v %v", req.Method, req.Header)
+	}
+	if !strings.HasPrefix(req.URL.Path, "/_matrix/client/v3/rooms/!room:example.com/send/m.room.message/") {
+		t.Errorf("unexpected path: %v", req.URL.Path)
+	}
+	if (*reqs)[1].URL.Path == req.URL.Path {
+		t.Errorf("transaction ids should be unique")
+	}
+	body := (*bodies)[0]
+	if body["body"] != "[warning] unreachable: Error getting latest: <timeout> (geth)" ||
+		!strings.Contains(body["formatted_body"].(string), "&lt;timeout&gt;") {
+		t.Errorf("unexpected message: %v", body)
+	}
+	if body := (*bodies)[2]; body["body"] != "[resolved] unreachable (geth)" {
+		t.Errorf("unexpected recovery: %v", body)
+	}
+	if _, err := newMatrixNotifier(matrixConfig{Homeserver: srv.URL}); err == nil {
+		t.Errorf("expected error for missing token")
+	}
+}
+
+func TestGrafanaAnnotator(t *testing.T) {
+	var (
+		bodies []map[string]interface{}
+		reqs   []*http.Request
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		reqs = append(reqs, r)
+		w.Write([]byte(`{"id": 7, "message": "Annotation added"}`))
+	}))
+	defer srv.Close()
+
+	n, err := newGrafanaAnnotator(grafanaConfig{Url: srv.URL + "/", Token: "secret", DashboardUID: "nodes", Tags: []string{"mainnet"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := &Event{Time: time.Unix(1600000000, 0), Kind: EventSplit, Severity: SeverityWarning,
+		Nodes: []string{"geth", "besu"}, Message: "Split found at block 100"}
+	n.Notify(&Event{Kind: EventUnreachable, Nodes: []string{"geth"}})
+	n.Notify(ev)
+	n.Notify(ev)
+	n.Recovered(ev)
+	n.Recovered(&Event{Kind: EventUnreachable, Nodes: []string{"geth"}})
+	if len(reqs) != 2 {
+		t.Fatalf("expected an annotation and its end, got %d requests", len(reqs))
+	}
+	if req := reqs[0]; req.Method != "POST" || req.URL.Path != "/api/annotations" || req.Header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("unexpected request: %v %v %v", req.Method, req.URL.Path, req.Header)
+	}
+	body := bodies[0]
+	if body["dashboardUID"] != "nodes" || body["time"] != 1600000000000.0 || body["text"] != "[warning] split: Split found at block 100" {
+		t.Errorf("unexpected annotation: %v", body)
+	}
+	if tags, _ := json.Marshal(body["tags"]); string(tags) != `["mainnet","split","geth","besu"]` {
+		t.Errorf("unexpected tags: %s", tags)
+	}
+	if req := reqs[1]; req.Method != "PATCH" || req.URL.Path != "/api/annotations/7" || bodies[1]["timeEnd"] == nil {
+		t.Errorf("unexpected region end: %v %v %v", req.Method, req.URL.Path, bodies[1])
+	}
+	if _, err := newGrafanaAnnotator(grafanaConfig{Url: srv.URL}); err == nil {
+		t.Errorf("expected error for missing token")
+	}
+}
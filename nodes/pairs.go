@@ -0,0 +1,137 @@
+package nodes
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// clientPair is a beacon node along with the execution node it drives
+type clientPair struct {
+	beacon *BeaconNode
+	exec   Node
+}
+
+// pairJson is the state of a beacon/execution pair in the last cycle
+type pairJson struct {
+	Beacon        string
+	Execution     string
+	PayloadNumber uint64 // execution block in the beacon head
+	PayloadHash   common.Hash
+	ExecutionHead uint64
+	InSync        bool
+}
+
+// resolvePairs looks up the execution nodes that beacon nodes are configured
+// to be paired with.
+func resolvePairs(clients []ClientInfo, nodes []Node, beacons []*BeaconNode) ([]*clientPair, error) {
+	var pairs []*clientPair
+	for _, c := range clients {
+		if c.Kind != "beacon" || len(c.Pair) == 0 {
+			continue
+		}
+		pair := new(clientPair)
+		for _, b := range beacons {
+			if b.Name() == c.Name {
+				pair.beacon = b
+			}
+		}
+		for _, n := range nodes {
+			if n.Name() == c.Pair {
+				pair.exec = n
+			}
+		}
+		if pair.beacon == nil || pair.exec == nil {
+			return nil, fmt.Errorf("invalid pair %v - %v", c.Name, c.Pair)
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, nil
+}
+
+// executionHead returns the execution block of the head beacon block, or nil
+// if it has no execution payload.
+func (node *BeaconNode) executionHead() (*blockInfo, error) {
+	var block struct {
+		Message struct {
+			Body struct {
+				ExecutionPayload *struct {
+					BlockNumber flexUint    `json:"block_number"`
+					BlockHash   common.Hash `json:"block_hash"`
+				} `json:"execution_payload"`
+			} `json:"body"`
+		} `json:"message"`
+	}
+	if err := node.get("/eth/v2/beacon/blocks/head", &block); err != nil {
+		return nil, err
+	}
+	payload := block.Message.Body.ExecutionPayload
+	if payload == nil || payload.BlockHash == (common.Hash{}) {
+		return nil, nil
+	}
+	return &blockInfo{num: uint64(payload.BlockNumber), hash: payload.BlockHash}, nil
+}
+
+// checkPairs verifies that the execution payload in each beacon node's head
+// is on the chain of its paired execution node, and that they're not too far
+// apart. Pairs where either node is down are skipped.
+func (mon *NodeMonitor) checkPairs(active []Node) []*pairJson {
+	var res []*pairJson
+	for _, pair := range mon.pairs {
+		var up bool
+		for _, n := range active {
+			up = up || n == pair.exec
+		}
+		if !up || pair.beacon.Status() != NodeStatusOK {
+			continue
+		}
+		payload, err := pair.beacon.executionHead()
+		if err != nil {
+			log.Warn("Failed to fetch execution payload", "node", pair.beacon.Name(), "error", err)
+			continue
+		}
+		if payload == nil {
+			continue // pre-merge
+		}
+		var (
+			names = []string{pair.beacon.Name(), pair.exec.Name()}
+			head  = pair.exec.HeadNum()
+			pj    = &pairJson{
+				Beacon:        pair.beacon.Name(),
+				Execution:     pair.exec.Name(),
+				PayloadNumber: payload.num,
+				PayloadHash:   payload.hash,
+				ExecutionHead: head,
+				InSync:        true,
+			}
+		)
+		res = append(res, pj)
+		switch {
+		case payload.num > head:
+			if drift := payload.num - head; drift > mon.maxPairDrift() {
+				pj.InSync = false
+				mon.emit(EventPairDrift, SeverityWarning, names,
+					"Execution node is %d blocks behind its beacon node", drift)
+			}
+		case pair.exec.HashAt(payload.num, false) != payload.hash:
+			pj.InSync = false
+			mon.emit(EventPairDrift, SeverityCritical, names,
+				"Execution node doesn't have the beacon head payload %d [%x]", payload.num, payload.hash)
+		default:
+			if drift := head - payload.num; drift > mon.maxPairDrift() {
+				pj.InSync = false
+				mon.emit(EventPairDrift, SeverityWarning, names,
+					"Execution node is %d blocks ahead of its beacon node", drift)
+			}
+		}
+	}
+	return res
+}
+
+func (mon *NodeMonitor) maxPairDrift() uint64 {
+	if mon.beaconConf.MaxPairDrift == 0 {
+		return 2
+	}
+	return mon.beaconConf.MaxPairDrift
+}
@@ -0,0 +1,140 @@
+package nodes
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// flexUint decodes integers that are encoded either as json numbers or as
+// strings, since beacon APIs of different clients don't agree on which.
+type flexUint uint64
+
+func (f *flexUint) UnmarshalJSON(input []byte) error {
+	v, err := strconv.ParseUint(strings.Trim(string(input), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer %s: %v", input, err)
+	}
+	*f = flexUint(v)
+	return nil
+}
+
+// participation is the attestation participation of an epoch
+type participation struct {
+	Epoch uint64
+	// Rate is the fraction of active stake that attested to the correct target
+	Rate float64
+}
+
+// currentEpoch returns the wall clock epoch
+func (node *BeaconNode) currentEpoch() uint64 {
+	if node.slotsPerEpoch == 0 {
+		return 0
+	}
+	return node.wallclockSlot(time.Now()) / node.slotsPerEpoch
+}
+
+// unsupportedEndpoint checks whether the error means the node doesn't serve
+// the endpoint at all, rather than failing to answer
+func unsupportedEndpoint(err error) bool {
+	if errors.Is(err, errNotFound) {
+		return true
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		switch se.code {
+		case 400, 404, 405, 501:
+			return true
+		}
+	}
+	return ErrorKindOf(err) == ErrKindDecode
+}
+
+// updateParticipation fetches the participation of the previous epoch, unless
+// already known. There's no standard API for this, so it uses the global
+// validator inclusion endpoint of lighthouse. Nodes of other clients are
+// detected by the first request, and not asked again.
+func (node *BeaconNode) updateParticipation() error {
+	if node.participationSupport != nil && !*node.participationSupport {
+		return nil
+	}
+	epoch := node.currentEpoch()
+	if epoch == 0 {
+		return nil
+	}
+	epoch--
+	if node.participation != nil && node.participation.Epoch == epoch {
+		return nil
+	}
+	var res struct {
+		ActiveGwei    flexUint `json:"previous_epoch_active_gwei"`
+		AttestingGwei flexUint `json:"previous_epoch_target_attesting_gwei"`
+	}
+	if err := node.get(fmt.Sprintf("/lighthouse/validator_inclusion/%d/global", epoch), &res); err != nil {
+		if node.participationSupport == nil && unsupportedEndpoint(err) {
+			log.Info("Beacon node doesn't serve participation, skipping it", "node", node.name, "version", node.version, "error", err)
+			node.participationSupport = new(bool)
+			return nil
+		}
+		return err
+	}
+	supported := true
+	node.participationSupport = &supported
+	if res.ActiveGwei == 0 {
+		return fmt.Errorf("no active stake in epoch %d", epoch)
+	}
+	node.participation = &participation{
+		Epoch: epoch,
+		Rate:  float64(res.AttestingGwei) / float64(res.ActiveGwei),
+	}
+	return nil
+}
+
+// checkParticipation reports nodes which see a participation rate below the
+// threshold, and nodes which disagree about the participation rate.
+func (mon *NodeMonitor) checkParticipation(nodes []*BeaconNode) {
+	var known []*BeaconNode
+	for _, node := range nodes {
+		if err := node.updateParticipation(); err != nil {
+			if !errors.Is(err, errNotFound) {
+				mon.emit(EventParticipation, SeverityInfo, []string{node.Name()},
+					"Failed to fetch participation: %v", err)
+			}
+			continue
+		}
+		if node.participation == nil {
+			continue
+		}
+		known = append(known, node)
+		if min := mon.beaconConf.MinParticipation; min > 0 && node.participation.Rate < min {
+			mon.emit(EventParticipation, SeverityWarning, []string{node.Name()},
+				"Participation in epoch %d is %.1f%%, below %.1f%%",
+				node.participation.Epoch, 100*node.participation.Rate, 100*min)
+		}
+	}
+	maxSpread := mon.beaconConf.MaxParticipationSpread
+	if maxSpread <= 0 {
+		return
+	}
+	for i := 0; i < len(known); i++ {
+		for j := i + 1; j < len(known); j++ {
+			pa, pb := known[i].participation, known[j].participation
+			if pa.Epoch != pb.Epoch {
+				continue
+			}
+			spread := pa.Rate - pb.Rate
+			if spread < 0 {
+				spread = -spread
+			}
+			if spread > maxSpread {
+				mon.emit(EventParticipation, SeverityWarning, []string{known[i].Name(), known[j].Name()},
+					"Nodes disagree about participation in epoch %d: %.1f%% vs %.1f%%",
+					pa.Epoch, 100*pa.Rate, 100*pb.Rate)
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package nodes
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// peerNode is implemented by nodes which can list their peers
+type peerNode interface {
+	Node
+	// Peers returns the node ids of the peers
+	Peers() ([]string, error)
+}
+
+// Peers fetches the peers via admin_peers
+func (node *RPCNode) Peers() ([]string, error) {
+	node.throttle.Take()
+	var peers []struct {
+		ID string `json:"id"`
+	}
+	if err := node.rpcCli.CallContext(context.Background(), &peers, "admin_peers"); err != nil {
+		return nil, wrapNodeError(node.name, "peers", err)
+	}
+	ids := make([]string, 0, len(peers))
+	for _, p := range peers {
+		ids = append(ids, p.ID)
+	}
+	return ids, nil
+}
+
+// peerOverlapJson is how many peers two nodes have in common
+type peerOverlapJson struct {
+	Nodes [2]string
+	// Peers is the number of peers of either node
+	Peers  [2]int
+	Shared int
+	// Overlap is the share (0-1) of all their peers which they share
+	Overlap float64
+	// Split is set if the nodes are on different chains
+	Split bool `json:",omitempty"`
+}
+
+// peerTracker collects the peers of the nodes with the admin API
+type peerTracker struct {
+	unsupported map[string]bool
+}
+
+// newPeerTracker returns nil if peers aren't collected
+func newPeerTracker(enabled bool) *peerTracker {
+	if !enabled {
+		return nil
+	}
+	return &peerTracker{unsupported: make(map[string]bool)}
+}
+
+func (pt *peerTracker) forget(name string) {
+	delete(pt.unsupported, name)
+}
+
+// collect returns the peers of the nodes which can list them, by node name
+func (pt *peerTracker) collect(nodes []Node) map[string]map[string]bool {
+	peers := make(map[string]map[string]bool)
+	for _, n := range nodes {
+		node, ok := n.(peerNode)
+		if !ok || pt.unsupported[node.Name()] {
+			continue
+		}
+		ids, err := node.Peers()
+		if err != nil {
+			if isMethodNotFound(err) {
+				log.Info("Node doesn't support listing peers", "node", node.Name())
+				pt.unsupported[node.Name()] = true
+			} else {
+				log.Debug("Failed to fetch peers", "node", node.Name(), "error", err)
+			}
+			continue
+		}
+		set := make(map[string]bool)
+		for _, id := range ids {
+			set[id] = true
+		}
+		peers[node.Name()] = set
+	}
+	return peers
+}
+
+// peerOverlaps compares the peers of every pair of nodes
+func peerOverlaps(peers map[string]map[string]bool, splits splitSet) []*peerOverlapJson {
+	var names []string
+	for name := range peers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var list []*peerOverlapJson
+	for i, a := range names {
+		for _, b := range names[i+1:] {
+			po := &peerOverlapJson{
+				Nodes: [2]string{a, b},
+				Peers: [2]int{len(peers[a]), len(peers[b])},
+			}
+			for id := range peers[a] {
+				if peers[b][id] {
+					po.Shared++
+				}
+			}
+			if all := po.Peers[0] + po.Peers[1] - po.Shared; all > 0 {
+				po.Overlap = float64(po.Shared) / float64(all)
+			}
+			_, po.Split = splits.get(a, b)
+			list = append(list, po)
+		}
+	}
+	return list
+}
+
+// checkPeers compares the peers of the nodes. During a split, it's reported
+// whether the nodes on different chains share no peers, while the others do,
+// which points at a network partition rather than a consensus failure.
+func (mon *NodeMonitor) checkPeers(nodes []Node, splits splitSet) []*peerOverlapJson {
+	if mon.peers == nil {
+		return nil
+	}
+	overlaps := peerOverlaps(mon.peers.collect(nodes), splits)
+	var (
+		disjoint       []string
+		splitPairs     int
+		agreeing       int
+		agreeingShared float64
+	)
+	for _, po := range overlaps {
+		if !po.Split {
+			agreeing++
+			agreeingShared += po.Overlap
+			continue
+		}
+		splitPairs++
+		if po.Shared == 0 {
+			disjoint = append(disjoint, po.Nodes[0], po.Nodes[1])
+		}
+	}
+	if splitPairs == 0 || len(disjoint) != 2*splitPairs {
+		return overlaps
+	}
+	if agreeing == 0 {
+		mon.emit(EventDisjointPeers, SeverityInfo, dedupNames(disjoint),
+			"The split nodes share no peers")
+	} else {
+		mon.emit(EventDisjointPeers, SeverityInfo, dedupNames(disjoint),
+			"The split nodes share no peers, while nodes on the same chain share %.0f%% on average",
+			100*agreeingShared/float64(agreeing))
+	}
+	return overlaps
+}
+
+// dedupNames returns the names sorted, without duplicates
+func dedupNames(names []string) []string {
+	sort.Strings(names)
+	var res []string
+	for i, name := range names {
+		if i == 0 || name != names[i-1] {
+			res = append(res, name)
+		}
+	}
+	return res
+}
@@ -0,0 +1,90 @@
+package nodes
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// quorumJson is the head agreed on by the largest subset of nodes
+type quorumJson struct {
+	Number   uint64
+	Hash     common.Hash
+	Agreeing []string
+	Minority []string
+	// Percent is the share of reachable nodes in agreement
+	Percent float64
+}
+
+// quorum groups the active nodes by their block at the lowest common head,
+// and returns the largest group along with the highest head within it. Ties
+// are broken by the highest head.
+func quorum(active []Node) *quorumJson {
+	if len(active) == 0 {
+		return nil
+	}
+	lowest := active[0].HeadNum()
+	for _, node := range active {
+		if node.HeadNum() < lowest {
+			lowest = node.HeadNum()
+		}
+	}
+	groups := make(map[common.Hash][]Node)
+	for _, node := range active {
+		h := node.HashAt(lowest, false)
+		groups[h] = append(groups[h], node)
+	}
+	var (
+		best     []Node
+		bestHead *blockInfo
+	)
+	for _, group := range groups {
+		var head *blockInfo
+		for _, node := range group {
+			if head == nil || node.HeadNum() > head.num {
+				head = &blockInfo{num: node.HeadNum(), hash: node.HashAt(node.HeadNum(), false)}
+			}
+		}
+		switch {
+		case len(group) > len(best):
+		case len(group) == len(best) && head.num > bestHead.num:
+		case len(group) == len(best) && head.num == bestHead.num && bytes.Compare(head.hash[:], bestHead.hash[:]) < 0:
+		default:
+			continue
+		}
+		best, bestHead = group, head
+	}
+	q := &quorumJson{
+		Number:  bestHead.num,
+		Hash:    bestHead.hash,
+		Percent: 100 * float64(len(best)) / float64(len(active)),
+	}
+	agreeing := make(map[Node]bool)
+	for _, node := range best {
+		agreeing[node] = true
+		q.Agreeing = append(q.Agreeing, node.Name())
+	}
+	for _, node := range active {
+		if !agreeing[node] {
+			q.Minority = append(q.Minority, node.Name())
+		}
+	}
+	sort.Strings(q.Agreeing)
+	sort.Strings(q.Minority)
+	return q
+}
+
+// setMinority flags the nodes outside the quorum in the report
+func (r *Report) setMinority(q *quorumJson) {
+	if q == nil {
+		return
+	}
+	minority := make(map[string]bool)
+	for _, name := range q.Minority {
+		minority[name] = true
+	}
+	for _, c := range r.Cols {
+		c.Minority = minority[c.Name]
+	}
+}
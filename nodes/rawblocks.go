@@ -0,0 +1,174 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// rawBlockNode is implemented by nodes which can serve blocks in their
+// consensus encoding
+type rawBlockNode interface {
+	Node
+	// RawBlock returns the RLP-encoded block
+	RawBlock(hash common.Hash) ([]byte, error)
+}
+
+func (node *RPCNode) RawBlock(hash common.Hash) ([]byte, error) {
+	node.throttle.Take()
+	var res hexutil.Bytes
+	err := node.rpcCli.CallContext(context.Background(), &res, "debug_getRawBlock", hash)
+	return res, wrapNodeError(node.name, "rawBlock", err)
+}
+
+// RawBlock returns the SSZ-encoded signed beacon block
+func (node *BeaconNode) RawBlock(root common.Hash) ([]byte, error) {
+	return node.request("GET", fmt.Sprintf("/eth/v2/beacon/blocks/0x%x", root), nil, "application/octet-stream")
+}
+
+// rawBlockStore stores the raw blocks at and around split points next to the
+// headers, so they can be replayed in client test harnesses
+type rawBlockStore struct {
+	dir string
+	// blocks and roots are the execution and beacon blocks stored or failed
+	// to fetch, which aren't fetched again, by block number and epoch
+	blocks map[common.Hash]uint64
+	roots  map[common.Hash]uint64
+	// unsupported are the nodes which can't serve raw blocks
+	unsupported map[string]bool
+	// copies are the stores which get a copy of the blocks
+	copies artifactStores
+}
+
+// newRawBlockStore returns nil if storing raw blocks is disabled
+func newRawBlockStore(enabled bool) *rawBlockStore {
+	if !enabled {
+		return nil
+	}
+	return &rawBlockStore{
+		dir:         "www/hashes",
+		blocks:      make(map[common.Hash]uint64),
+		roots:       make(map[common.Hash]uint64),
+		unsupported: make(map[string]bool),
+	}
+}
+
+func (rs *rawBlockStore) forget(name string) {
+	delete(rs.unsupported, name)
+}
+
+// prune forgets the blocks below the given height
+func prune(done map[common.Hash]uint64, floor uint64) {
+	for hash, height := range done {
+		if height < floor {
+			delete(done, hash)
+		}
+	}
+}
+
+// store fetches the block from the node, and writes it to <hash>.<ext>,
+// unless it's already there. It's marked as done at the given height.
+func (rs *rawBlockStore) store(done map[common.Hash]uint64, height uint64, name string, hash common.Hash, ext string, fetch func(common.Hash) ([]byte, error)) {
+	if _, ok := done[hash]; ok || rs.unsupported[name] {
+		return
+	}
+	fname := filepath.Join(rs.dir, fmt.Sprintf("0x%x.%v", hash, ext))
+	if _, err := os.Stat(fname); err == nil {
+		done[hash] = height
+		return
+	}
+	data, err := fetch(hash)
+	if err != nil {
+		if isMethodNotFound(err) {
+			log.Info("Node doesn't support raw blocks", "node", name)
+			rs.unsupported[name] = true
+		} else {
+			log.Warn("Failed to fetch raw block", "node", name, "hash", hash, "error", err)
+			done[hash] = height
+		}
+		return
+	}
+	done[hash] = height
+	if err := os.MkdirAll(rs.dir, 0755); err != nil {
+		log.Warn("Failed to create block directory", "error", err)
+		return
+	}
+	if err := ioutil.WriteFile(fname, data, 0644); err != nil {
+		log.Warn("Failed to write raw block", "error", err)
+	}
+	rs.copies.put("splits/blocks/"+filepath.Base(fname), data)
+}
+
+// storeSplits stores the RLP of the first diverging block of each split
+// point, along with its parent and child, as seen by that side. Children
+// which aren't there yet are picked up in a later round. The blocks below the
+// splits are forgotten.
+func (rs *rawBlockStore) storeSplits(points []splitPoint) {
+	floor := uint64(math.MaxUint64)
+	for _, p := range points {
+		first := p.block
+		if first > 0 {
+			first--
+		}
+		if first < floor {
+			floor = first
+		}
+	}
+	prune(rs.blocks, floor)
+	for _, p := range points {
+		node, ok := p.node.(rawBlockNode)
+		if !ok {
+			continue
+		}
+		first := p.block
+		if first > 0 {
+			first--
+		}
+		for num := first; num <= p.block+1; num++ {
+			hash := p.hash
+			if num != p.block {
+				hash = node.HashAt(num, false)
+			}
+			if hash == (common.Hash{}) {
+				continue
+			}
+			rs.store(rs.blocks, num, node.Name(), hash, "rlp", node.RawBlock)
+		}
+	}
+}
+
+// storeBeacon stores the SSZ of the blocks of the given checkpoints, as
+// served by the node
+func (rs *rawBlockStore) storeBeacon(node *BeaconNode, cps ...checkpoint) {
+	for _, cp := range cps {
+		if cp.Root != (common.Hash{}) {
+			rs.store(rs.roots, cp.Epoch, node.name, cp.Root, "ssz", node.RawBlock)
+		}
+	}
+}
+
+// pruneBeacon forgets the checkpoint blocks below the epoch finalized by all
+// the nodes
+func (rs *rawBlockStore) pruneBeacon(nodes []*BeaconNode) {
+	if len(nodes) == 0 {
+		return
+	}
+	floor := uint64(math.MaxUint64)
+	for _, node := range nodes {
+		if node.finality == nil {
+			// Can't tell what it finalized
+			return
+		}
+		if epoch := node.finality.Finalized.Epoch; epoch < floor {
+			floor = epoch
+		}
+	}
+	prune(rs.roots, floor)
+}
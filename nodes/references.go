@@ -0,0 +1,34 @@
+package nodes
+
+// splitReferences splits the nodes into the reference nodes and the others
+func (mon *NodeMonitor) splitReferences(nodes []Node) (refs, others []Node) {
+	for _, node := range nodes {
+		if mon.references[node.Name()] {
+			refs = append(refs, node)
+		} else {
+			others = append(others, node)
+		}
+	}
+	return refs, others
+}
+
+// setAgreement classifies the nodes as agreeing with or diverging from the
+// chain of the first reference node
+func (r *Report) setAgreement(refs, others []Node, splits splitSet) {
+	if len(refs) == 0 {
+		return
+	}
+	agreement := make(map[string]string)
+	for _, ref := range refs {
+		agreement[ref.Name()] = "reference"
+	}
+	for _, node := range others {
+		agreement[node.Name()] = "agreeing"
+		if _, diverged := splits.get(refs[0].Name(), node.Name()); diverged {
+			agreement[node.Name()] = "diverging"
+		}
+	}
+	for _, c := range r.Cols {
+		c.Agreement = agreement[c.Name]
+	}
+}
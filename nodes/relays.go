@@ -0,0 +1,100 @@
+package nodes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// relayPayloads is the number of recently delivered payloads fetched per cycle
+const relayPayloads = 10
+
+type relayConfig struct {
+	Name string
+	Url  string
+}
+
+// relay is a MEV-boost relay, checked via the builder and data APIs
+type relay struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+// relayJson is the health of a relay in the last cycle
+type relayJson struct {
+	Name   string
+	Status int
+	// The most recent payload delivered by the relay
+	LastSlot      uint64
+	LastBlockHash common.Hash
+	LastValue     string // in wei
+}
+
+func newRelay(conf relayConfig) *relay {
+	return &relay{
+		name:   conf.Name,
+		url:    strings.TrimSuffix(conf.Url, "/"),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (r *relay) get(path string, result interface{}) error {
+	res, err := r.client.Get(r.url + path)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("request %v failed: %v", path, res.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+// check queries the status of the relay, and the payloads it delivered
+func (r *relay) check() (*relayJson, error) {
+	rj := &relayJson{Name: r.name, Status: NodeStatusUnreachable}
+	if err := r.get("/eth/v1/builder/status", nil); err != nil {
+		return rj, err
+	}
+	var delivered []struct {
+		Slot      flexUint    `json:"slot"`
+		BlockHash common.Hash `json:"block_hash"`
+		Value     string      `json:"value"`
+	}
+	path := fmt.Sprintf("/relay/v1/data/bidtraces/proposer_payload_delivered?limit=%d", relayPayloads)
+	if err := r.get(path, &delivered); err != nil {
+		return rj, err
+	}
+	rj.Status = NodeStatusOK
+	for _, d := range delivered {
+		if uint64(d.Slot) > rj.LastSlot {
+			rj.LastSlot, rj.LastBlockHash, rj.LastValue = uint64(d.Slot), d.BlockHash, d.Value
+		}
+	}
+	return rj, nil
+}
+
+// checkRelays checks the health of all configured relays
+func (mon *NodeMonitor) checkRelays() []*relayJson {
+	var res []*relayJson
+	for _, r := range mon.relays {
+		rj, err := r.check()
+		if err != nil {
+			mon.emit(EventRelayUnreachable, SeverityWarning, []string{r.name},
+				"Error checking relay: %v", err)
+		} else {
+			log.Info("Relay OK", "relay", r.name, "lastslot", rj.LastSlot)
+		}
+		res = append(res, rj)
+	}
+	return res
+}
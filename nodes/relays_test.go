@@ -0,0 +1,48 @@
+package nodes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestRelays(t *testing.T) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/eth/v1/builder/status":
+		case "/relay/v1/data/bidtraces/proposer_payload_delivered":
+			w.Write([]byte(`[
+				{"slot": "101", "block_hash": "0x0200000000000000000000000000000000000000000000000000000000000000", "value": "2000"},
+				{"slot": "100", "block_hash": "0x0100000000000000000000000000000000000000000000000000000000000000", "value": "1000"}
+			]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer good.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+
+	conf := &Config{ReloadInterval: "1s", Relays: []relayConfig{
+		{Name: "good", Url: good.URL + "/"},
+		{Name: "bad", Url: bad.URL},
+	}}
+	mon, _ := NewMonitor(nil, nil, nil, conf)
+	r := mon.Report()
+	if len(r.Relays) != 2 {
+		t.Fatalf("expected 2 relays, got %d", len(r.Relays))
+	}
+	if rj := r.Relays[0]; rj.Status != NodeStatusOK || rj.LastSlot != 101 || rj.LastBlockHash != (common.Hash{2}) || rj.LastValue != "2000" {
+		t.Errorf("unexpected relay status: %+v", rj)
+	}
+	if rj := r.Relays[1]; rj.Status != NodeStatusUnreachable {
+		t.Errorf("expected relay to be unreachable: %+v", rj)
+	}
+	if len(r.Events) != 1 || r.Events[0].Kind != EventRelayUnreachable || r.Events[0].Nodes[0] != "bad" {
+		t.Errorf("unexpected events: %v", r.Events)
+	}
+}
@@ -0,0 +1,182 @@
+package nodes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+type remoteWriteConfig struct {
+	// Url is the remote write endpoint, e.g. http://mimir:9009/api/v1/push
+	Url string
+	// Username and Password are sent as basic auth, Token as a bearer token
+	Username string
+	Password string
+	Token    string
+	// Headers are added to each request, e.g. X-Scope-OrgID for tenants
+	Headers map[string]string
+	// Job is the job label of the series, defaults to nodemonitor
+	Job string
+}
+
+// remoteWriteExporter pushes per-node and per-chain samples to a receiver of
+// the Prometheus remote write protocol, such as Mimir, VictoriaMetrics or
+// Thanos, after every check cycle.
+type remoteWriteExporter struct {
+	client *http.Client
+	conf   remoteWriteConfig
+}
+
+func newRemoteWriteExporter(conf remoteWriteConfig) *remoteWriteExporter {
+	if len(conf.Job) == 0 {
+		conf.Job = "nodemonitor"
+	}
+	return &remoteWriteExporter{
+		client: &http.Client{Timeout: 10 * time.Second},
+		conf:   conf,
+	}
+}
+
+type promLabel struct {
+	name, value string
+}
+
+// promSeries is a single sample of a time series
+type promSeries struct {
+	labels []promLabel
+	value  float64
+}
+
+// series returns the samples of the report. The labels are sorted by name,
+// as required by the protocol.
+func (e *remoteWriteExporter) series(r *Report) []promSeries {
+	var series []promSeries
+	add := func(metric string, value float64, labels map[string]string) {
+		ls := []promLabel{{"__name__", metric}, {"job", e.conf.Job}}
+		for k, v := range labels {
+			ls = append(ls, promLabel{promName(k), v})
+		}
+		sort.Slice(ls, func(i, j int) bool { return ls[i].name < ls[j].name })
+		series = append(series, promSeries{ls, value})
+	}
+	for _, c := range r.Cols {
+		labels := map[string]string{"node": c.Name}
+		for k, v := range c.Labels {
+			if k != "node" && k != "job" {
+				labels[k] = v
+			}
+		}
+		labels = withNetwork(labels, r.Network)
+		add("nodemonitor_node_head", float64(c.Head), labels)
+		add("nodemonitor_node_lag", float64(c.Lag), labels)
+		add("nodemonitor_node_status", float64(c.Status), labels)
+		if c.Latency >= 0 {
+			add("nodemonitor_node_latency_seconds", c.Latency.Seconds(), labels)
+		}
+		if c.Health != nil {
+			add("nodemonitor_node_health", float64(c.Health.Score), labels)
+		}
+		for _, name := range sortedMetrics(c.Metrics) {
+			add("nodemonitor_node_metric_"+promName(name), c.Metrics[name], labels)
+		}
+	}
+	add("nodemonitor_chain_split_depth", float64(r.SplitDepth), withNetwork(nil, r.Network))
+	return series
+}
+
+// promName replaces the characters not allowed in label names
+func promName(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// The protobuf messages of the remote write protocol are simple enough to be
+// encoded by hand:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+
+func protoTag(buf *bytes.Buffer, field, wireType int) {
+	protoVarint(buf, uint64(field<<3|wireType))
+}
+
+func protoVarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+// protoBytes writes a length-delimited field
+func protoBytes(buf *bytes.Buffer, field int, data []byte) {
+	protoTag(buf, field, 2)
+	protoVarint(buf, uint64(len(data)))
+	buf.Write(data)
+}
+
+// encodeWriteRequest encodes the samples as a WriteRequest, all at the given
+// time
+func encodeWriteRequest(series []promSeries, now time.Time) []byte {
+	var req bytes.Buffer
+	ts := now.UnixNano() / int64(time.Millisecond)
+	for _, s := range series {
+		var msg, sub bytes.Buffer
+		for _, l := range s.labels {
+			sub.Reset()
+			protoBytes(&sub, 1, []byte(l.name))
+			protoBytes(&sub, 2, []byte(l.value))
+			protoBytes(&msg, 1, sub.Bytes())
+		}
+		sub.Reset()
+		protoTag(&sub, 1, 1)
+		var val [8]byte
+		binary.LittleEndian.PutUint64(val[:], math.Float64bits(s.value))
+		sub.Write(val[:])
+		protoTag(&sub, 2, 0)
+		protoVarint(&sub, uint64(ts))
+		protoBytes(&msg, 2, sub.Bytes())
+		protoBytes(&req, 1, msg.Bytes())
+	}
+	return req.Bytes()
+}
+
+func (e *remoteWriteExporter) Export(r *Report) error {
+	body := snappy.Encode(nil, encodeWriteRequest(e.series(r), time.Now()))
+	req, err := http.NewRequest("POST", e.conf.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for k, v := range e.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	if len(e.conf.Username) > 0 {
+		req.SetBasicAuth(e.conf.Username, e.conf.Password)
+	} else if len(e.conf.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+e.conf.Token)
+	}
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("remote write failed: %v: %s", res.Status, msg)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package nodes
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"time"
+)
+
+// reportFiles are the files in www/, or the directory of the network, that the
+// report is written to, by format.
+// The json one is always written, since the dashboard is built on it.
+var reportFiles = map[string]string{
+	"json": "data.json",
+	"csv":  "report.csv",
+	"html": "report.html",
+}
+
+// checkReportFormats returns an error if any of the formats is unknown
+func checkReportFormats(formats []string) error {
+	for _, f := range formats {
+		if _, ok := reportFiles[f]; !ok {
+			return fmt.Errorf("unknown report format %q, available: [json, csv, html]", f)
+		}
+	}
+	return nil
+}
+
+// render returns the report in the given format
+func (r *Report) render(format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "csv":
+		err = r.WriteCSV(&buf)
+	case "html":
+		err = r.WriteHTML(&buf, time.Now())
+	default:
+		return nil, fmt.Errorf("unknown report format %q", format)
+	}
+	return buf.Bytes(), err
+}
+
+func statusText(status int) string {
+	switch status {
+	case NodeStatusOK:
+		return "ok"
+	case NodeStatusThrottled:
+		return "throttled"
+	}
+	return "unreachable"
+}
+
+// WriteCSV writes the report as a table with one column per node. The first
+// rows hold the node details, followed by the block hash of each node at
+// every reported number.
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	row := func(first string, field func(c *clientJson) string) {
+		rec := []string{first}
+		for _, c := range r.Cols {
+			rec = append(rec, field(c))
+		}
+		cw.Write(rec)
+	}
+	row("number", func(c *clientJson) string { return c.Name })
+	row("version", func(c *clientJson) string { return c.Version })
+	row("status", func(c *clientJson) string { return statusText(c.Status) })
+	row("head", func(c *clientJson) string { return strconv.FormatUint(c.Head, 10) })
+	row("lag", func(c *clientJson) string { return strconv.FormatUint(c.Lag, 10) })
+	row("finalized", func(c *clientJson) string { return strconv.FormatUint(c.Finalized, 10) })
+	for _, num := range r.Numbers {
+		cw.Write(append([]string{strconv.Itoa(num)}, r.Rows[num]...))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"status": statusText,
+	"percent": func(ratio float64) float64 {
+		return 100 * ratio
+	},
+	"short": func(hash string) string {
+		if len(hash) > 12 {
+			return hash[:12] + "…"
+		}
+		return hash
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Node monitor report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+td.unreachable, td.diff { background: #fdd; }
+td.critical { color: #b00; font-weight: bold; }
+td.warning { color: #a60; }
+</style>
+</head>
+<body>
+<h1>Node monitor report</h1>
+<p>Generated {{.Time.UTC.Format "2006-01-02 15:04:05 MST"}}.
+{{if .Report.SplitDepth}}<strong>The nodes are split, {{if .Report.SplitTooDeep}}more than {{end}}{{.Report.SplitDepth}} blocks deep.</strong>{{else}}No splits detected.{{end}}</p>
+{{with .Report.BlockTime}}<p>Block time over the last {{.Samples}} blocks: average {{printf "%.1f" .Average}}s, median {{printf "%.1f" .Median}}s, p95 {{printf "%.1f" .P95}}s.</p>{{end}}
+{{with .Report.Fees}}<p>Base fee {{.BaseFee}} wei ({{printf "%+.1f" .BaseFeeChange}}% over the last {{len .Recent}} heads), gas used {{printf "%.0f" (percent .GasUsedRatio)}}% (average {{printf "%.0f" (percent .AvgGasUsedRatio)}}%).</p>{{end}}
+
+<h2>Nodes</h2>
+<table>
+<tr><th>Name</th><th>Version</th><th>Status</th><th>Head</th><th>Lag</th><th>Finalized</th><th>Block time</th><th>Fee recipient</th><th>Txs</th><th>Gas used</th><th>Received</th><th>Txpool</th><th>Health</th></tr>
+{{range .Report.Cols}}<tr><td>{{.Name}}</td><td>{{.Version}}</td><td class="{{status .Status}}">{{status .Status}}</td><td>{{.Head}}</td><td>{{.Lag}}</td><td>{{.Finalized}}</td>{{with .HeadBlock}}<td>{{.Time.Format "15:04:05"}}</td><td title="{{.Miner.Hex}}">{{short .Miner.Hex}}</td><td>{{with .TxCount}}{{.}}{{end}}</td><td>{{.GasUsed}}</td><td>{{.Received.UTC.Format "15:04:05.000"}}</td>{{else}}<td></td><td></td><td></td><td></td><td></td>{{end}}<td>{{with .Txpool}}{{.Pending}} / {{.Queued}}{{end}}</td><td>{{with .Health}}{{.Score}}{{end}}</td></tr>
+{{end}}</table>
+
+<h2>Blocks</h2>
+<table>
+<tr><th>Number</th>{{range .Report.Cols}}<th>{{.Name}}</th>{{end}}</tr>
+{{range .Rows}}<tr><td>{{.Number}}</td>{{range .Cells}}<td{{if .Differs}} class="diff"{{end}} title="{{.Hash}}">{{short .Hash}}</td>{{end}}</tr>
+{{end}}</table>
+{{if .Report.Traces}}
+<h2>Traces</h2>
+<table>
+<tr><th>Block</th><th>Node</th><th>Hash</th></tr>
+{{range .Report.Traces}}<tr><td>{{.Block}}</td><td>{{.Node}}</td><td><a href="{{.File}}">{{.Hash.Hex}}</a></td></tr>
+{{end}}</table>
+{{end}}
+{{if .Report.Events}}
+<h2>Events</h2>
+<table>
+<tr><th>Time</th><th>Severity</th><th>Kind</th><th>Nodes</th><th>Message</th></tr>
+{{range .Report.Events}}<tr><td>{{.Time.UTC.Format "15:04:05"}}</td><td class="{{.Severity}}">{{.Severity}}</td><td>{{.Kind}}</td><td>{{range $i, $n := .Nodes}}{{if $i}}, {{end}}{{$n}}{{end}}</td><td>{{.Message}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+type htmlCell struct {
+	Hash    string
+	Differs bool // set if the hash differs from the first one in the row
+}
+
+type htmlRow struct {
+	Number int
+	Cells  []htmlCell
+}
+
+// WriteHTML writes the report as a standalone html page
+func (r *Report) WriteHTML(w io.Writer, now time.Time) error {
+	var rows []htmlRow
+	for _, num := range r.Numbers {
+		var (
+			row   = htmlRow{Number: num}
+			first string
+		)
+		for _, hash := range r.Rows[num] {
+			if len(first) == 0 {
+				first = hash
+			}
+			row.Cells = append(row.Cells, htmlCell{
+				Hash:    hash,
+				Differs: len(hash) > 0 && hash != first,
+			})
+		}
+		rows = append(rows, row)
+	}
+	return htmlReport.Execute(w, struct {
+		Time   time.Time
+		Report *Report
+		Rows   []htmlRow
+	}{now, r, rows})
+}
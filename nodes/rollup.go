@@ -0,0 +1,279 @@
+package nodes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+type rollupConfig struct {
+	// MaxLag is how many L2 blocks the unsafe head of a replica may be behind
+	// that of the sequencer, or the highest one if there's no sequencer.
+	// Defaults to 10.
+	MaxLag uint64
+	// Nodes are the OP-Stack rollup nodes (op-node) to check
+	Nodes []rollupNodeConfig
+}
+
+type rollupNodeConfig struct {
+	Name string
+	Url  string
+	// Sequencer marks the node of the sequencer, which the replicas are
+	// checked against
+	Sequencer bool
+}
+
+// l2BlockRef is a block as referred to by rollup nodes
+type l2BlockRef struct {
+	Hash   common.Hash `json:"hash"`
+	Number uint64      `json:"number"`
+}
+
+// rollupSyncStatus is the sync status of a rollup node, as returned by
+// optimism_syncStatus
+type rollupSyncStatus struct {
+	CurrentL1   l2BlockRef `json:"current_l1"`
+	UnsafeL2    l2BlockRef `json:"unsafe_l2"`
+	SafeL2      l2BlockRef `json:"safe_l2"`
+	FinalizedL2 l2BlockRef `json:"finalized_l2"`
+}
+
+// rollupOutput is the output of an L2 block, as returned by
+// optimism_outputAtBlock
+type rollupOutput struct {
+	OutputRoot common.Hash `json:"outputRoot"`
+	BlockRef   l2BlockRef  `json:"blockRef"`
+}
+
+// rollupNode is an OP-Stack rollup node
+type rollupNode interface {
+	Name() string
+	SyncStatus() (*rollupSyncStatus, error)
+	// OutputAt returns the output of the L2 block with the number
+	OutputAt(num uint64) (*rollupOutput, error)
+}
+
+// opNode is a rollup node queried via the optimism namespace
+type opNode struct {
+	name   string
+	rpcCli *rpc.Client
+}
+
+func (node *opNode) Name() string {
+	return node.name
+}
+
+func (node *opNode) SyncStatus() (*rollupSyncStatus, error) {
+	var st rollupSyncStatus
+	if err := node.rpcCli.CallContext(context.Background(), &st, "optimism_syncStatus"); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func (node *opNode) OutputAt(num uint64) (*rollupOutput, error) {
+	var out rollupOutput
+	if err := node.rpcCli.CallContext(context.Background(), &out, "optimism_outputAtBlock", hexutil.Uint64(num)); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// rollupJson is the state of a rollup node in the last cycle
+type rollupJson struct {
+	Name      string
+	Status    int
+	Sequencer bool `json:",omitempty"`
+	// Unsafe, Safe and Finalized are the L2 heads of the node
+	Unsafe    l2BlockRef
+	Safe      l2BlockRef
+	Finalized l2BlockRef
+	// L1 is the L1 block the node derived the L2 chain up to
+	L1 uint64
+	// Lag is the number of blocks the unsafe head is behind that of the
+	// sequencer, or the highest one if there's no sequencer
+	Lag uint64
+	// Diverged is set if the node disagrees with the sequencer, or with the
+	// most other nodes, about the output at the highest common block
+	Diverged bool `json:",omitempty"`
+}
+
+// rollupMonitor checks rollup nodes against each other and the sequencer
+type rollupMonitor struct {
+	maxLag    uint64
+	nodes     []rollupNode
+	sequencer string // the name of the sequencer node, if any
+}
+
+// newRollupMonitor returns nil if no rollup nodes are configured
+func newRollupMonitor(conf rollupConfig) (*rollupMonitor, error) {
+	if len(conf.Nodes) == 0 {
+		return nil, nil
+	}
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	rm := &rollupMonitor{maxLag: conf.MaxLag}
+	if rm.maxLag == 0 {
+		rm.maxLag = 10
+	}
+	for _, nc := range conf.Nodes {
+		if nc.Sequencer {
+			rm.sequencer = nc.Name
+		}
+		rpcCli, err := dialRPC(nc.Url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("rollup node %v: %v", nc.Name, err)
+		}
+		rm.nodes = append(rm.nodes, &opNode{name: nc.Name, rpcCli: rpcCli})
+	}
+	return rm, nil
+}
+
+// validate checks the config without dialing the nodes
+func (conf *rollupConfig) validate() error {
+	names := make(map[string]bool)
+	sequencers := 0
+	for _, nc := range conf.Nodes {
+		if len(nc.Name) == 0 || len(nc.Url) == 0 {
+			return errors.New("rollup node without name or url")
+		}
+		if names[nc.Name] {
+			return fmt.Errorf("duplicate rollup node %v", nc.Name)
+		}
+		names[nc.Name] = true
+		if nc.Sequencer {
+			sequencers++
+		}
+	}
+	if sequencers > 1 {
+		return errors.New("only one rollup node can be the sequencer")
+	}
+	return nil
+}
+
+// outputKey identifies the output of a block on one chain
+type outputKey struct {
+	hash common.Hash
+	root common.Hash
+}
+
+// checkRollups fetches the heads of the rollup nodes, measures them against
+// the sequencer, and compares their outputs at the highest block they all have
+func (mon *NodeMonitor) checkRollups() []*rollupJson {
+	rm := mon.rollups
+	if rm == nil {
+		return nil
+	}
+	var (
+		res    []*rollupJson
+		active []rollupNode
+		ref    uint64 // the unsafe head the others are measured against
+		refSeq bool
+	)
+	for _, node := range rm.nodes {
+		rj := &rollupJson{Name: node.Name(), Status: NodeStatusUnreachable, Sequencer: node.Name() == rm.sequencer}
+		res = append(res, rj)
+		st, err := node.SyncStatus()
+		if err != nil {
+			mon.emit(EventRollupUnreachable, SeverityWarning, []string{node.Name()},
+				"Error checking rollup node: %v", err)
+			continue
+		}
+		rj.Status = NodeStatusOK
+		rj.Unsafe, rj.Safe, rj.Finalized, rj.L1 = st.UnsafeL2, st.SafeL2, st.FinalizedL2, st.CurrentL1.Number
+		active = append(active, node)
+		if rj.Sequencer {
+			ref, refSeq = rj.Unsafe.Number, true
+		} else if !refSeq && rj.Unsafe.Number > ref {
+			ref = rj.Unsafe.Number
+		}
+		metrics.GetOrRegisterGauge("rollup/"+rj.Name+"/unsafe", mon.registry).Update(int64(rj.Unsafe.Number))
+		metrics.GetOrRegisterGauge("rollup/"+rj.Name+"/safe", mon.registry).Update(int64(rj.Safe.Number))
+		metrics.GetOrRegisterGauge("rollup/"+rj.Name+"/finalized", mon.registry).Update(int64(rj.Finalized.Number))
+	}
+	against := "the highest unsafe head"
+	if refSeq {
+		against = "the sequencer"
+	}
+	for _, rj := range res {
+		if rj.Status != NodeStatusOK || rj.Unsafe.Number >= ref {
+			continue
+		}
+		rj.Lag = ref - rj.Unsafe.Number
+		if rj.Lag > rm.maxLag {
+			mon.emit(EventRollupLag, SeverityWarning, []string{rj.Name},
+				"Unsafe head is %d blocks behind %v", rj.Lag, against)
+		}
+	}
+	if len(active) < 2 {
+		return res
+	}
+	mon.compareRollupOutputs(active, res)
+	return res
+}
+
+// compareRollupOutputs compares the outputs of the nodes at the lowest of
+// their unsafe heads, and flags those disagreeing with the sequencer, or with
+// the most nodes if the sequencer isn't among them
+func (mon *NodeMonitor) compareRollupOutputs(active []rollupNode, res []*rollupJson) {
+	byName := make(map[string]*rollupJson)
+	num := ^uint64(0)
+	for _, rj := range res {
+		byName[rj.Name] = rj
+		if rj.Status == NodeStatusOK && rj.Unsafe.Number < num {
+			num = rj.Unsafe.Number
+		}
+	}
+	groups := make(map[outputKey][]string)
+	var keys []outputKey
+	for _, node := range active {
+		out, err := node.OutputAt(num)
+		if err != nil {
+			log.Debug("Failed to fetch rollup output", "node", node.Name(), "block", num, "error", err)
+			continue
+		}
+		key := outputKey{out.BlockRef.Hash, out.OutputRoot}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], node.Name())
+	}
+	if len(groups) < 2 {
+		return
+	}
+	// The sequencer's output is the canonical one, otherwise the most agreed
+	sort.SliceStable(keys, func(i, j int) bool {
+		return len(groups[keys[i]]) > len(groups[keys[j]])
+	})
+	canonical := keys[0]
+	for _, key := range keys {
+		for _, name := range groups[key] {
+			if byName[name].Sequencer {
+				canonical = key
+			}
+		}
+	}
+	var diverged, sides []string
+	for _, key := range keys {
+		sides = append(sides, strings.Join(groups[key], ", "))
+		if key == canonical {
+			continue
+		}
+		for _, name := range groups[key] {
+			byName[name].Diverged = true
+			diverged = append(diverged, name)
+		}
+	}
+	sort.Strings(diverged)
+	mon.emit(EventRollupDivergence, SeverityCritical, diverged,
+		"Rollup nodes disagree about the output of L2 block %d: [%v]", num, strings.Join(sides, "] vs ["))
+}
@@ -0,0 +1,92 @@
+package nodes
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// fakeRollupNode has fixed heads, and the same block hashes as the other
+// nodes, with the output roots given
+type fakeRollupNode struct {
+	name    string
+	unsafe  uint64
+	safe    uint64
+	roots   map[uint64]common.Hash
+	offline bool
+}
+
+func (n *fakeRollupNode) Name() string {
+	return n.name
+}
+
+func (n *fakeRollupNode) SyncStatus() (*rollupSyncStatus, error) {
+	if n.offline {
+		return nil, errors.New("connection refused")
+	}
+	return &rollupSyncStatus{
+		CurrentL1:   l2BlockRef{Number: 1000},
+		UnsafeL2:    l2BlockRef{Number: n.unsafe, Hash: common.Hash{byte(n.unsafe)}},
+		SafeL2:      l2BlockRef{Number: n.safe, Hash: common.Hash{byte(n.safe)}},
+		FinalizedL2: l2BlockRef{Number: n.safe - 10, Hash: common.Hash{byte(n.safe - 10)}},
+	}, nil
+}
+
+func (n *fakeRollupNode) OutputAt(num uint64) (*rollupOutput, error) {
+	return &rollupOutput{OutputRoot: n.roots[num], BlockRef: l2BlockRef{Number: num, Hash: common.Hash{byte(num)}}}, nil
+}
+
+func TestRollups(t *testing.T) {
+	var (
+		good = map[uint64]common.Hash{100: {1}}
+		bad  = map[uint64]common.Hash{100: {2}}
+		seq  = &fakeRollupNode{name: "sequencer", unsafe: 120, safe: 90, roots: good}
+		a    = &fakeRollupNode{name: "a", unsafe: 118, safe: 90, roots: good}
+		b    = &fakeRollupNode{name: "b", unsafe: 100, safe: 90, roots: bad}
+		c    = &fakeRollupNode{name: "c", offline: true}
+	)
+	mon, err := NewMonitor(nil, nil, nil, &Config{ReloadInterval: "1s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mon.rollups = &rollupMonitor{maxLag: 10, nodes: []rollupNode{seq, a, b, c}, sequencer: "sequencer"}
+	res := mon.checkRollups()
+	if len(res) != 4 || !res[0].Sequencer || res[3].Status != NodeStatusUnreachable {
+		t.Fatalf("wrong rollup nodes: %+v", res)
+	}
+	if res[1].Lag != 2 || res[2].Lag != 20 || res[2].Safe.Number != 90 || res[2].Finalized.Number != 80 {
+		t.Errorf("wrong heads: %+v %+v", res[1], res[2])
+	}
+	if res[1].Diverged || !res[2].Diverged {
+		t.Errorf("wrong divergence: %v %v", res[1].Diverged, res[2].Diverged)
+	}
+	kinds := make(map[string][]string)
+	for _, ev := range mon.events {
+		kinds[ev.Kind] = ev.Nodes
+	}
+	if nodes := kinds[EventRollupUnreachable]; len(nodes) != 1 || nodes[0] != "c" {
+		t.Errorf("wrong unreachable nodes: %v", nodes)
+	}
+	if nodes := kinds[EventRollupLag]; len(nodes) != 1 || nodes[0] != "b" {
+		t.Errorf("wrong lagging nodes: %v", nodes)
+	}
+	if nodes := kinds[EventRollupDivergence]; len(nodes) != 1 || nodes[0] != "b" {
+		t.Errorf("wrong diverged nodes: %v", nodes)
+	}
+	// Without the sequencer, the most nodes agreeing win, and the lag is
+	// measured against the highest head
+	mon.events = nil
+	b2 := &fakeRollupNode{name: "b2", unsafe: 110, safe: 90, roots: bad}
+	mon.rollups = &rollupMonitor{maxLag: 10, nodes: []rollupNode{a, b, b2}}
+	res = mon.checkRollups()
+	if res[0].Lag != 0 || res[1].Lag != 18 || !res[0].Diverged || res[1].Diverged {
+		t.Errorf("wrong rollup nodes: %+v %+v", res[0], res[1])
+	}
+	if err := (&rollupConfig{Nodes: []rollupNodeConfig{
+		{Name: "a", Url: "http://a", Sequencer: true},
+		{Name: "b", Url: "http://b", Sequencer: true},
+	}}).validate(); err == nil {
+		t.Error("expected error for two sequencers")
+	}
+}
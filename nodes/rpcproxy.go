@@ -0,0 +1,289 @@
+package nodes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// rpcProxyMaxRequest is the max size of a request to the proxy
+const rpcProxyMaxRequest = 1024 * 1024
+
+type rpcProxyConfig struct {
+	// Enabled serves eth_blockNumber and eth_getBlockByNumber on /rpc,
+	// answering only with blocks a quorum of the nodes agrees on
+	Enabled bool
+	// Quorum is how many nodes must agree on a block. Defaults to a majority
+	// of the configured execution nodes. If it's half of them or less, and
+	// two blocks reach it, the one more nodes agree on is served, and none if
+	// that's a tie.
+	Quorum int
+}
+
+// jsonBlockNode is implemented by nodes which can serve blocks as returned
+// by eth_getBlockByNumber
+type jsonBlockNode interface {
+	Node
+	// RawBlockByNumber returns the eth_getBlockByNumber response, which is
+	// null if the node doesn't have the block
+	RawBlockByNumber(num uint64, fullTx bool) (json.RawMessage, error)
+}
+
+func (node *RPCNode) RawBlockByNumber(num uint64, fullTx bool) (json.RawMessage, error) {
+	node.throttle.Take()
+	var res json.RawMessage
+	err := node.rpcCli.CallContext(context.Background(), &res, "eth_getBlockByNumber", hexutil.Uint64(num), fullTx)
+	return res, wrapNodeError(node.name, "block", err)
+}
+
+// agreedHead is the highest block a quorum of the nodes agreed on in the last
+// cycle, along with those nodes
+type agreedHead struct {
+	number uint64
+	hash   common.Hash
+	nodes  []Node
+}
+
+// rpcProxy answers block queries with data agreed on by a quorum of nodes
+type rpcProxy struct {
+	quorum int
+	// configured is the number of execution nodes the default quorum is a
+	// majority of
+	configured int
+}
+
+// newRPCProxy returns nil if the proxy is disabled
+func newRPCProxy(conf rpcProxyConfig, nodes int) (*rpcProxy, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+	if conf.Quorum < 0 {
+		return nil, fmt.Errorf("invalid rpc proxy quorum %d", conf.Quorum)
+	}
+	return &rpcProxy{quorum: conf.Quorum, configured: nodes}, nil
+}
+
+// required returns how many nodes must agree
+func (p *rpcProxy) required() int {
+	if p.quorum > 0 {
+		return p.quorum
+	}
+	return p.configured/2 + 1
+}
+
+// agree finds the highest block the required number of nodes within the
+// quorum group have, and agree on. If several blocks reach the required
+// number, the one most nodes agree on is picked. It returns nil if there is
+// none, or if it's a tie.
+func (p *rpcProxy) agree(active []Node, q *quorumJson) *agreedHead {
+	if q == nil {
+		return nil
+	}
+	need := p.required()
+	agreeing := make(map[string]bool)
+	for _, name := range q.Agreeing {
+		agreeing[name] = true
+	}
+	var group []Node
+	for _, node := range active {
+		if agreeing[node.Name()] {
+			group = append(group, node)
+		}
+	}
+	if len(group) < need {
+		return nil
+	}
+	sort.Slice(group, func(i, j int) bool {
+		return group[i].HeadNum() > group[j].HeadNum()
+	})
+	// The highest block at least 'need' nodes have
+	num := group[need-1].HeadNum()
+	byHash := make(map[common.Hash][]Node)
+	for _, node := range group {
+		if h := node.HashAt(num, false); h != (common.Hash{}) {
+			byHash[h] = append(byHash[h], node)
+		}
+	}
+	var (
+		best *agreedHead
+		tied bool
+	)
+	for h, nodes := range byHash {
+		switch {
+		case len(nodes) < need:
+		case best == nil || len(nodes) > len(best.nodes):
+			best, tied = &agreedHead{number: num, hash: h, nodes: nodes}, false
+		case len(nodes) == len(best.nodes):
+			tied = true
+		}
+	}
+	if tied {
+		log.Warn("Conflicting blocks reach the rpc proxy quorum", "number", num, "quorum", need)
+		return nil
+	}
+	return best
+}
+
+// block fetches the block from the agreeing nodes, and returns it once the
+// required number of them returned the same block
+func (p *rpcProxy) block(head *agreedHead, num uint64, fullTx bool) (json.RawMessage, error) {
+	var (
+		need     = p.required()
+		votes    = make(map[common.Hash]int)
+		answered int
+	)
+	for _, n := range head.nodes {
+		node, ok := n.(jsonBlockNode)
+		if !ok {
+			continue
+		}
+		res, err := node.RawBlockByNumber(num, fullTx)
+		if err != nil {
+			log.Debug("Proxied block request failed", "node", node.Name(), "number", num, "error", err)
+			continue
+		}
+		answered++
+		var bl struct {
+			Hash common.Hash `json:"hash"`
+		}
+		if len(res) == 0 || string(res) == "null" || json.Unmarshal(res, &bl) != nil {
+			continue
+		}
+		if votes[bl.Hash]++; votes[bl.Hash] >= need {
+			return res, nil
+		}
+	}
+	return nil, fmt.Errorf("no quorum for block %d: %d of %d nodes answered, %d needed to agree", num, answered, len(head.nodes), need)
+}
+
+type rpcRequest struct {
+	Version string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcErrorJson   `json:"error,omitempty"`
+}
+
+type rpcErrorJson struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// parseBlockTag returns the block number of the tag, relative to the agreed
+// head
+func parseBlockTag(raw json.RawMessage, head uint64) (uint64, error) {
+	var tag string
+	if err := json.Unmarshal(raw, &tag); err != nil {
+		return 0, fmt.Errorf("invalid block number: %v", err)
+	}
+	switch tag {
+	case "latest":
+		return head, nil
+	case "earliest":
+		return 0, nil
+	}
+	if !strings.HasPrefix(tag, "0x") {
+		return 0, fmt.Errorf("unsupported block tag %q", tag)
+	}
+	return strconv.ParseUint(tag[2:], 16, 64)
+}
+
+// proxyCall answers a single request
+func (mon *NodeMonitor) proxyCall(req *rpcRequest) *rpcResponse {
+	res := &rpcResponse{Version: "2.0", ID: req.ID}
+	fail := func(code int, format string, args ...interface{}) *rpcResponse {
+		res.Error = &rpcErrorJson{Code: code, Message: fmt.Sprintf(format, args...)}
+		return res
+	}
+	mon.mu.Lock()
+	head := mon.agreed
+	mon.mu.Unlock()
+	switch req.Method {
+	case "eth_blockNumber", "eth_getBlockByNumber":
+	default:
+		return fail(-32601, "the method %v does not exist/is not available", req.Method)
+	}
+	if head == nil {
+		return fail(-32000, "no quorum among the nodes")
+	}
+	if req.Method == "eth_blockNumber" {
+		res.Result = fmt.Sprintf("0x%x", head.number)
+		return res
+	}
+	if len(req.Params) < 1 {
+		return fail(-32602, "missing block number")
+	}
+	num, err := parseBlockTag(req.Params[0], head.number)
+	if err != nil {
+		return fail(-32602, "%v", err)
+	}
+	var fullTx bool
+	if len(req.Params) > 1 {
+		json.Unmarshal(req.Params[1], &fullTx)
+	}
+	if num > head.number {
+		// Not agreed on yet, as if the block didn't exist
+		res.Result = json.RawMessage("null")
+		return res
+	}
+	bl, err := mon.rpcProxy.block(head, num, fullTx)
+	if err != nil {
+		return fail(-32000, "%v", err)
+	}
+	res.Result = bl
+	return res
+}
+
+// HandleRPC serves eth_blockNumber and eth_getBlockByNumber, including in
+// batches, answering only with blocks agreed on by a quorum of the nodes
+func (mon *NodeMonitor) HandleRPC(w http.ResponseWriter, r *http.Request) {
+	if mon.rpcProxy == nil {
+		http.Error(w, "rpc proxy not enabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, rpcProxyMaxRequest)).Decode(&raw); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if trimmed := strings.TrimSpace(string(raw)); strings.HasPrefix(trimmed, "[") {
+		var reqs []*rpcRequest
+		if err := json.Unmarshal(raw, &reqs); err != nil {
+			json.NewEncoder(w).Encode(&rpcResponse{Version: "2.0", ID: json.RawMessage("null"),
+				Error: &rpcErrorJson{Code: -32700, Message: err.Error()}})
+			return
+		}
+		res := make([]*rpcResponse, 0, len(reqs))
+		for _, req := range reqs {
+			res = append(res, mon.proxyCall(req))
+		}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		json.NewEncoder(w).Encode(&rpcResponse{Version: "2.0", ID: json.RawMessage("null"),
+			Error: &rpcErrorJson{Code: -32700, Message: err.Error()}})
+		return
+	}
+	json.NewEncoder(w).Encode(mon.proxyCall(&req))
+}
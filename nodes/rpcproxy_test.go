@@ -0,0 +1,151 @@
+package nodes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// jsonTestNode serves the blocks of its chain up to its head
+type jsonTestNode struct {
+	*testNode
+}
+
+func (n *jsonTestNode) RawBlockByNumber(num uint64, fullTx bool) (json.RawMessage, error) {
+	if num > n.HeadNum() {
+		return json.RawMessage("null"), nil
+	}
+	return json.Marshal(map[string]interface{}{"number": fmt.Sprintf("0x%x", num), "hash": n.chain[num].hash})
+}
+
+func TestRPCProxy(t *testing.T) {
+	var a, b = make([]*blockInfo, 200), make([]*blockInfo, 200)
+	for i := range a {
+		a[i] = &blockInfo{num: uint64(i), hash: common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("a :%d", i))))}
+		b[i] = a[i]
+		if i >= 150 {
+			b[i] = &blockInfo{num: uint64(i), hash: common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("b :%d", i))))}
+		}
+	}
+	nodes := []Node{
+		&jsonTestNode{newTestNode("a", 199, a)},
+		&jsonTestNode{newTestNode("b", 197, a)},
+		&jsonTestNode{newTestNode("c", 199, b)},
+	}
+	mon, err := NewMonitor(nodes, nil, nil, &Config{ReloadInterval: "1s", RPCProxy: rpcProxyConfig{Enabled: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(mon.HandleRPC))
+	defer srv.Close()
+
+	call := func(body string) []rpcResponse {
+		res, err := http.Post(srv.URL, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var out []rpcResponse
+		if !strings.HasPrefix(body, "[") {
+			out = make([]rpcResponse, 1)
+			err = json.NewDecoder(res.Body).Decode(&out[0])
+		} else {
+			err = json.NewDecoder(res.Body).Decode(&out)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	// The minority fork at 150 is ignored, and the head is the highest block
+	// two of the majority have
+	res := call(`[{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"},
+		{"jsonrpc":"2.0","id":2,"method":"eth_getBlockByNumber","params":["latest",false]},
+		{"jsonrpc":"2.0","id":3,"method":"eth_getBlockByNumber","params":["0x96",false]},
+		{"jsonrpc":"2.0","id":4,"method":"eth_getBlockByNumber","params":["0xc7",false]},
+		{"jsonrpc":"2.0","id":5,"method":"eth_getBlockByNumber","params":["pending",false]},
+		{"jsonrpc":"2.0","id":6,"method":"eth_sendRawTransaction","params":["0x00"]}]`)
+	if len(res) != 6 {
+		t.Fatalf("wrong batch response: %+v", res)
+	}
+	if res[0].Result != "0xc5" {
+		t.Errorf("wrong head: %v", res[0].Result)
+	}
+	hash := func(r rpcResponse) common.Hash {
+		var bl struct {
+			Hash common.Hash
+		}
+		data, _ := json.Marshal(r.Result)
+		json.Unmarshal(data, &bl)
+		return bl.Hash
+	}
+	if h := hash(res[1]); h != a[197].hash {
+		t.Errorf("wrong latest block: %x, error %v", h, res[1].Error)
+	}
+	if h := hash(res[2]); h != a[150].hash {
+		t.Errorf("wrong block 150: %x, error %v", h, res[2].Error)
+	}
+	if res[3].Result != nil || res[3].Error != nil {
+		t.Errorf("block past the agreed head served: %+v", res[3])
+	}
+	if res[4].Error == nil || res[4].Error.Code != -32602 {
+		t.Errorf("pending block not rejected: %+v", res[4])
+	}
+	if res[5].Error == nil || res[5].Error.Code != -32601 {
+		t.Errorf("unsupported method not rejected: %+v", res[5])
+	}
+}
+
+func TestRPCProxyNoQuorum(t *testing.T) {
+	chain := make([]*blockInfo, 10)
+	for i := range chain {
+		chain[i] = &blockInfo{num: uint64(i), hash: common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("a :%d", i))))}
+	}
+	nodes := []Node{&jsonTestNode{newTestNode("a", 9, chain)}, &brokenNode{"b"}, &brokenNode{"c"}}
+	mon, err := NewMonitor(nodes, nil, nil, &Config{ReloadInterval: "1s", RPCProxy: rpcProxyConfig{Enabled: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mon.doChecks()
+	res := mon.proxyCall(&rpcRequest{Version: "2.0", ID: json.RawMessage("1"), Method: "eth_blockNumber"})
+	if res.Error == nil {
+		t.Errorf("head served by a single node out of three: %+v", res)
+	}
+}
+
+func TestRPCProxySplitQuorum(t *testing.T) {
+	var (
+		a     = makeChain("a", 10, nil)
+		b     = makeChain("b", 10, a[:5])
+		nodes []Node
+		names []string
+	)
+	for i, chain := range [][]*blockInfo{a, a, a, b, b} {
+		node := newTestNode(fmt.Sprint(i), 9, chain)
+		nodes = append(nodes, node)
+		names = append(names, node.Name())
+	}
+	// With a quorum of two, both sides of the split reach it: the side more
+	// nodes agree on is served, every time
+	p, err := newRPCProxy(rpcProxyConfig{Enabled: true, Quorum: 2}, len(nodes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := &quorumJson{Agreeing: names}
+	for i := 0; i < 20; i++ {
+		head := p.agree(nodes, q)
+		if head == nil || head.hash != a[9].hash || len(head.nodes) != 3 {
+			t.Fatalf("wrong agreed head: %+v", head)
+		}
+	}
+	// On a tie, neither is served
+	if head := p.agree(nodes[1:], &quorumJson{Agreeing: names[1:]}); head != nil {
+		t.Errorf("head served on a tie: %+v", head)
+	}
+}
@@ -0,0 +1,197 @@
+package nodes
+
+import (
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// settleDelay is how long to wait after a head change before checking, so
+// that the other nodes get a chance to import the same block
+var settleDelay = 500 * time.Millisecond
+
+// headPoller is implemented by nodes whose head number can be polled cheaply,
+// without touching any state used by the check loop
+type headPoller interface {
+	Node
+	pollHead() (uint64, error)
+}
+
+func (node *RPCNode) pollHead() (uint64, error) {
+	node.throttle.Take()
+	num, err := node.ethCli.BlockNumber(context.Background())
+	return num, wrapNodeError(node.name, "blockNumber", err)
+}
+
+// headChanged schedules a check cycle, after the settle delay. Further
+// changes during the delay are folded into the same cycle.
+func (mon *NodeMonitor) headChanged() {
+	select {
+	case mon.headCh <- struct{}{}:
+	default:
+	}
+}
+
+// pollTargets returns the nodes whose heads should be polled: those which
+// can't push their new heads
+func pollTargets(nodes []Node) []headPoller {
+	var targets []headPoller
+	for _, node := range nodes {
+		if hs, ok := node.(headSubscriber); ok && hs.canSubscribe() {
+			continue
+		}
+		if hp, ok := node.(headPoller); ok {
+			targets = append(targets, hp)
+		}
+	}
+	return targets
+}
+
+// pollHeads polls the head numbers of the nodes, and triggers a check cycle
+// whenever one changes
+func (mon *NodeMonitor) pollHeads() {
+	defer mon.wg.Done()
+	heads := make(map[string]uint64)
+	for {
+		// The interval may be tuned while polling
+		_, interval := mon.intervals()
+		select {
+		case <-mon.quitCh:
+			return
+		case <-time.After(interval):
+		}
+		mon.mu.Lock()
+		targets := mon.polled
+		mon.mu.Unlock()
+		for _, node := range targets {
+			num, err := node.pollHead()
+			if err != nil {
+				log.Debug("Failed to poll head", "node", node.Name(), "error", err)
+				continue
+			}
+			if prev, ok := heads[node.Name()]; ok && prev != num {
+				log.Debug("Head changed", "node", node.Name(), "number", num)
+				mon.headChanged()
+			}
+			heads[node.Name()] = num
+		}
+	}
+}
+
+// autoInterval is the value of reload_interval and head_poll_interval which
+// derives the interval from the block time
+const autoInterval = "auto"
+
+// parseInterval parses a configured interval, which is either empty, a
+// duration, or "auto"
+func parseInterval(s string) (d time.Duration, auto bool, err error) {
+	switch s {
+	case "":
+		return 0, false, nil
+	case autoInterval:
+		return 0, true, nil
+	}
+	d, err = time.ParseDuration(s)
+	return d, false, err
+}
+
+const (
+	// tuneFraction is the fraction of the block time that automatic
+	// intervals are set to, so that a new block is seen well within its slot
+	tuneFraction = 3
+	// minTunedInterval bounds the automatic intervals on very fast devnets
+	minTunedInterval = 500 * time.Millisecond
+)
+
+// intervalTuner estimates the block time, to derive the automatic intervals
+// from. If a beacon node knows the slot time, that is used as-is, otherwise
+// it's estimated from the advance of the highest head across cycles.
+type intervalTuner struct {
+	reload, headPoll bool          // which intervals are tuned
+	slotTime         time.Duration // as reported by a beacon node
+	blockTime        time.Duration // estimated from the heads
+	// highest head seen, and when it last advanced. The first advance isn't
+	// sampled, since the time before it doesn't span a whole block.
+	lastHead uint64
+	lastTime time.Time
+}
+
+// observe feeds the highest head of a cycle into the estimate
+func (t *intervalTuner) observe(head uint64, now time.Time) {
+	if head <= t.lastHead {
+		return
+	}
+	if !t.lastTime.IsZero() {
+		sample := now.Sub(t.lastTime) / time.Duration(head-t.lastHead)
+		if t.blockTime == 0 {
+			t.blockTime = sample
+		} else {
+			// Exponential moving average, so a few slow or missed blocks
+			// don't throw the intervals off
+			t.blockTime = (4*t.blockTime + sample) / 5
+		}
+	}
+	if t.lastHead > 0 {
+		t.lastTime = now
+	}
+	t.lastHead = head
+}
+
+// interval returns the tuned interval, or zero if the block time is unknown
+func (t *intervalTuner) interval() time.Duration {
+	bt := t.slotTime
+	if bt == 0 {
+		bt = t.blockTime
+	}
+	if bt == 0 {
+		return 0
+	}
+	d := (bt / tuneFraction).Round(100 * time.Millisecond)
+	if d < minTunedInterval {
+		d = minTunedInterval
+	}
+	return d
+}
+
+// tuneIntervals updates the automatic intervals after a cycle
+func (mon *NodeMonitor) tuneIntervals(r *Report) {
+	t := mon.tuner
+	if t == nil {
+		return
+	}
+	for _, b := range mon.beacons {
+		if b.secondsPerSlot > 0 {
+			t.slotTime = time.Duration(b.secondsPerSlot) * time.Second
+			break
+		}
+	}
+	var highest uint64
+	for _, c := range r.Cols {
+		if c.Status == NodeStatusOK && c.Head > highest {
+			highest = c.Head
+		}
+	}
+	t.observe(highest, time.Now())
+	d := t.interval()
+	if d == 0 {
+		return
+	}
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+	if t.reload && d != mon.reloadInterval {
+		log.Info("Tuned reload interval", "interval", d)
+		mon.reloadInterval = d
+	}
+	if t.headPoll && d != mon.headPoll {
+		log.Info("Tuned head poll interval", "interval", d)
+		mon.headPoll = d
+	}
+}
+
+// intervals returns the current reload and head poll intervals
+func (mon *NodeMonitor) intervals() (reload, headPoll time.Duration) {
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+	return mon.reloadInterval, mon.headPoll
+}
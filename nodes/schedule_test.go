@@ -0,0 +1,80 @@
+package nodes
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// pollNode is a node whose head number can be polled
+type pollNode struct {
+	*brokenNode
+	head uint64
+	mu   sync.Mutex
+}
+
+func (n *pollNode) pollHead() (uint64, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.head, nil
+}
+
+func TestHeadPolling(t *testing.T) {
+	defer func(s time.Duration) { settleDelay = s }(settleDelay)
+	settleDelay = 10 * time.Millisecond
+
+	node := &pollNode{brokenNode: &brokenNode{"poll"}, head: 1}
+	mon, _ := NewMonitor([]Node{node}, nil, nil, &Config{ReloadInterval: "1h", HeadPollInterval: "10ms"})
+	mon.Start()
+	defer mon.Stop()
+
+	// Let the poller see the first head
+	time.Sleep(50 * time.Millisecond)
+	before := mon.health().LastCycle
+	node.mu.Lock()
+	node.head = 2
+	node.mu.Unlock()
+	for i := 0; i < 100; i++ {
+		if mon.health().LastCycle.After(before) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("head change didn't trigger a check cycle")
+}
+
+func TestIntervalTuner(t *testing.T) {
+	for _, s := range []string{"", "auto", "3s"} {
+		if _, _, err := parseInterval(s); err != nil {
+			t.Errorf("%q: %v", s, err)
+		}
+	}
+	if _, _, err := parseInterval("fast"); err == nil {
+		t.Error("expected error for invalid interval")
+	}
+	var (
+		tuner = &intervalTuner{reload: true}
+		now   = time.Unix(1600000000, 0)
+	)
+	// The first advance doesn't span a whole block
+	tuner.observe(100, now)
+	tuner.observe(101, now.Add(5*time.Second))
+	if d := tuner.interval(); d != 0 {
+		t.Fatalf("interval tuned too early: %v", d)
+	}
+	tuner.observe(101, now.Add(10*time.Second))
+	tuner.observe(103, now.Add(29*time.Second))
+	if d := tuner.interval(); d != 4*time.Second {
+		t.Errorf("interval = %v, want 4s", d)
+	}
+	// Slow blocks move the estimate gradually
+	tuner.observe(104, now.Add(89*time.Second))
+	if d := tuner.interval(); d != 7200*time.Millisecond {
+		t.Errorf("interval = %v, want 7.2s", d)
+	}
+	// Beacon nodes know the slot time
+	tuner.slotTime = time.Second
+	if d := tuner.interval(); d != minTunedInterval {
+		t.Errorf("interval = %v, want %v", d, minTunedInterval)
+	}
+}
@@ -0,0 +1,254 @@
+package nodes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+type healthScoreConfig struct {
+	// Enabled computes a 0-100 health score per node each cycle
+	Enabled bool
+	// Weights of the parts of the score: "status", "lag", "latency",
+	// "peers" and "sync". Missing parts weigh 1, zero leaves them out.
+	Weights map[string]float64
+	// MaxLag is the head lag, in blocks or slots, at which the lag part is
+	// zero. Defaults to 5.
+	MaxLag uint64
+	// MaxLatency is the latency at which the latency part is zero. Defaults
+	// to 2s.
+	MaxLatency string
+	// MinPeers is the peer count at which the peers part is full. Defaults
+	// to 10.
+	MinPeers int
+}
+
+// healthParts are the parts of the health score
+var healthParts = []string{"status", "lag", "latency", "peers", "sync"}
+
+// vitalsNode is implemented by execution and beacon nodes which can tell
+// their peer count and whether they're syncing
+type vitalsNode interface {
+	Name() string
+	Vitals() (*nodeVitals, error)
+}
+
+type nodeVitals struct {
+	Peers   int
+	Syncing bool
+}
+
+// Vitals fetches the peer count and sync state via net_peerCount and
+// eth_syncing
+func (node *RPCNode) Vitals() (*nodeVitals, error) {
+	node.throttle.Take()
+	var (
+		peers   hexutil.Uint64
+		syncing interface{} // false, or the sync progress
+	)
+	batch := []rpc.BatchElem{
+		{Method: "net_peerCount", Result: &peers},
+		{Method: "eth_syncing", Result: &syncing},
+	}
+	if err := node.rpcCli.BatchCallContext(context.Background(), batch); err != nil {
+		return nil, wrapNodeError(node.name, "vitals", err)
+	}
+	for _, el := range batch {
+		if el.Error != nil {
+			return nil, wrapNodeError(node.name, "vitals", el.Error)
+		}
+	}
+	return &nodeVitals{Peers: int(peers), Syncing: syncing != false}, nil
+}
+
+// Vitals fetches the peer count and sync state via /eth/v1/node/peer_count
+// and /eth/v1/node/syncing
+func (node *BeaconNode) Vitals() (*nodeVitals, error) {
+	var peers struct {
+		Connected uint64 `json:"connected,string"`
+	}
+	if err := node.get("/eth/v1/node/peer_count", &peers); err != nil {
+		return nil, err
+	}
+	var syncing struct {
+		IsSyncing bool `json:"is_syncing"`
+	}
+	if err := node.get("/eth/v1/node/syncing", &syncing); err != nil {
+		return nil, err
+	}
+	return &nodeVitals{Peers: int(peers.Connected), Syncing: syncing.IsSyncing}, nil
+}
+
+// healthScoreJson is the health score of a node, along with the inputs which
+// aren't part of the report otherwise
+type healthScoreJson struct {
+	Score   int
+	Peers   *int  `json:",omitempty"`
+	Syncing *bool `json:",omitempty"`
+}
+
+// healthScorer combines the status, lag, latency, peer count and sync state
+// of the nodes into a single 0-100 score. Parts which aren't known for a node
+// are left out, and the score is the weighted average of the others.
+type healthScorer struct {
+	weights     map[string]float64
+	maxLag      uint64
+	maxLatency  time.Duration
+	minPeers    int
+	unsupported map[string]bool
+	vitals      map[string]*nodeVitals // of the current cycle
+}
+
+// newHealthScorer returns nil if the scores are disabled
+func newHealthScorer(conf healthScoreConfig) (*healthScorer, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+	hs := &healthScorer{
+		weights:     make(map[string]float64),
+		maxLag:      conf.MaxLag,
+		maxLatency:  2 * time.Second,
+		minPeers:    conf.MinPeers,
+		unsupported: make(map[string]bool),
+		vitals:      make(map[string]*nodeVitals),
+	}
+	for _, part := range healthParts {
+		hs.weights[part] = 1
+	}
+	for part, w := range conf.Weights {
+		if _, ok := hs.weights[part]; !ok {
+			return nil, fmt.Errorf("invalid health score part %q, available: %v", part, healthParts)
+		}
+		if w < 0 {
+			return nil, fmt.Errorf("invalid health score weight %v for %v", w, part)
+		}
+		hs.weights[part] = w
+	}
+	if hs.maxLag == 0 {
+		hs.maxLag = 5
+	}
+	if len(conf.MaxLatency) > 0 {
+		d, err := time.ParseDuration(conf.MaxLatency)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max latency: %v", err)
+		}
+		hs.maxLatency = d
+	}
+	if hs.minPeers == 0 {
+		hs.minPeers = 10
+	}
+	return hs, nil
+}
+
+func (hs *healthScorer) forget(name string) {
+	delete(hs.unsupported, name)
+}
+
+// collect fetches the vitals of the reachable nodes
+func (hs *healthScorer) collect(nodes []vitalsNode) {
+	hs.vitals = make(map[string]*nodeVitals)
+	for _, node := range nodes {
+		if hs.unsupported[node.Name()] {
+			continue
+		}
+		v, err := node.Vitals()
+		if err != nil {
+			if isMethodNotFound(err) || errors.Is(err, errNotFound) {
+				log.Info("Node doesn't tell its peers and sync state", "node", node.Name())
+				hs.unsupported[node.Name()] = true
+			} else {
+				log.Debug("Failed to fetch node vitals", "node", node.Name(), "error", err)
+			}
+			continue
+		}
+		hs.vitals[node.Name()] = v
+	}
+}
+
+// below returns 1 at zero, falling linearly to 0 at max
+func below(value, max float64) float64 {
+	return math.Max(0, 1-value/max)
+}
+
+// score computes the score of a node, both without and with the latency
+// part. The latency changes on every cycle, so it's left out of the score in
+// the report, which would change on every cycle too, and is only part of the
+// gauge. The latency is negative if unknown.
+func (hs *healthScorer) score(name string, status int, lag uint64, latency time.Duration) (*healthScoreJson, int) {
+	parts := map[string]float64{"status": 0}
+	if status == NodeStatusOK {
+		parts["status"] = 1
+		parts["lag"] = below(float64(lag), float64(hs.maxLag))
+	}
+	hj := new(healthScoreJson)
+	if v, ok := hs.vitals[name]; ok && status == NodeStatusOK {
+		peers, syncing := v.Peers, v.Syncing
+		hj.Peers, hj.Syncing = &peers, &syncing
+		parts["peers"] = math.Min(1, float64(peers)/float64(hs.minPeers))
+		parts["sync"] = 1
+		if syncing {
+			parts["sync"] = 0
+		}
+	}
+	hj.Score = hs.weigh(parts)
+	live := hj.Score
+	if status == NodeStatusOK && latency >= 0 {
+		parts["latency"] = below(float64(latency), float64(hs.maxLatency))
+		live = hs.weigh(parts)
+	}
+	return hj, live
+}
+
+// weigh returns the weighted average of the parts, as a 0-100 score
+func (hs *healthScorer) weigh(parts map[string]float64) int {
+	var sum, weights float64
+	for part, value := range parts {
+		sum += hs.weights[part] * value
+		weights += hs.weights[part]
+	}
+	if weights == 0 {
+		return 0
+	}
+	return int(math.Round(100 * sum / weights))
+}
+
+// setScores adds the scores to the nodes of the report, and updates the
+// gauges of the scores, which include the latency
+func (r *Report) setScores(hs *healthScorer, reg metrics.Registry) {
+	for _, c := range r.Cols {
+		var live int
+		c.Health, live = hs.score(c.Name, c.Status, c.Lag, c.Latency)
+		metrics.GetOrRegisterGauge("health/"+c.Name, reg).Update(int64(live))
+	}
+	for _, b := range r.Beacons {
+		var live int
+		b.Health, live = hs.score(b.Name, b.Status, b.SlotLag, -1)
+		metrics.GetOrRegisterGauge("health/"+b.Name, reg).Update(int64(live))
+	}
+}
+
+// checkVitals fetches the peer counts and sync states of the reachable nodes
+func (mon *NodeMonitor) checkVitals(nodes []Node) {
+	if mon.scorer == nil {
+		return
+	}
+	var list []vitalsNode
+	for _, n := range nodes {
+		if node, ok := n.(vitalsNode); ok {
+			list = append(list, node)
+		}
+	}
+	for _, node := range mon.beacons {
+		if node.Status() == NodeStatusOK {
+			list = append(list, node)
+		}
+	}
+	mon.scorer.collect(list)
+}
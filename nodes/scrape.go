@@ -0,0 +1,282 @@
+package nodes
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+type scrapeConfig struct {
+	// Interval is how often the metrics urls of the nodes are scraped.
+	// Defaults to 1m.
+	Interval string
+	// Series maps names in the report to the scraped series, given as a
+	// metric name, optionally with labels to match, e.g.
+	// disk = 'node_filesystem_avail_bytes{mountpoint="/"}'. The samples
+	// matching a series are summed.
+	Series map[string]string
+}
+
+// seriesSelector picks the samples of a metric with the given labels
+type seriesSelector struct {
+	name   string
+	labels map[string]string
+}
+
+// parseSelector parses a metric name with optional labels, e.g.
+// name{a="b",c="d"}, which is also the form of the samples
+func parseSelector(s string) (*seriesSelector, error) {
+	s = strings.TrimSpace(s)
+	sel := &seriesSelector{name: s, labels: make(map[string]string)}
+	open := strings.IndexByte(s, '{')
+	if open < 0 {
+		if len(s) == 0 {
+			return nil, fmt.Errorf("empty series")
+		}
+		return sel, nil
+	}
+	if !strings.HasSuffix(s, "}") {
+		return nil, fmt.Errorf("invalid series %q", s)
+	}
+	sel.name = s[:open]
+	rest := s[open+1 : len(s)-1]
+	for len(strings.TrimSpace(rest)) > 0 {
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 || eq+1 >= len(rest) || rest[eq+1] != '"' {
+			return nil, fmt.Errorf("invalid labels in series %q", s)
+		}
+		key := strings.TrimSpace(rest[:eq])
+		// Find the closing quote, skipping escaped ones
+		quoted := rest[eq+1:]
+		end := 1
+		for end < len(quoted) && quoted[end] != '"' {
+			if quoted[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(quoted) {
+			return nil, fmt.Errorf("invalid labels in series %q", s)
+		}
+		value, err := strconv.Unquote(quoted[:end+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid labels in series %q", s)
+		}
+		sel.labels[key] = value
+		rest = strings.TrimPrefix(strings.TrimSpace(quoted[end+1:]), ",")
+	}
+	return sel, nil
+}
+
+// matches returns whether the sample is of the metric, with the labels
+func (sel *seriesSelector) matches(sample *seriesSelector) bool {
+	if sample.name != sel.name {
+		return false
+	}
+	for k, v := range sel.labels {
+		if sample.labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// parseSamples picks the selected series out of the Prometheus text format
+func parseSamples(data []byte, series map[string]*seriesSelector) map[string]float64 {
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		// The value follows the labels, and may be followed by a timestamp
+		end := strings.LastIndexByte(line, '}')
+		fields := strings.Fields(line[end+1:])
+		if len(fields) == 0 {
+			continue
+		}
+		key := line[:end+1]
+		if end < 0 {
+			key, fields = fields[0], fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		sample, err := parseSelector(key)
+		if err != nil {
+			continue
+		}
+		for name, sel := range series {
+			if sel.matches(sample) {
+				values[name] += value
+			}
+		}
+	}
+	return values
+}
+
+// scrapeTarget is the metrics url of a node, and the series picked from it
+type scrapeTarget struct {
+	url    string
+	series map[string]*seriesSelector
+}
+
+// metricsScraper scrapes the metrics urls of the nodes every interval, and
+// keeps the values of the selected series for the report
+type metricsScraper struct {
+	interval time.Duration
+	series   map[string]*seriesSelector
+	client   *http.Client
+	targets  map[string]*scrapeTarget // by node name
+	values   map[string]map[string]float64
+	last     time.Time
+}
+
+// newMetricsScraper returns nil if no node has a metrics url
+func newMetricsScraper(conf scrapeConfig, clients []ClientInfo) (*metricsScraper, error) {
+	s := &metricsScraper{
+		interval: time.Minute,
+		series:   make(map[string]*seriesSelector),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		targets:  make(map[string]*scrapeTarget),
+		values:   make(map[string]map[string]float64),
+	}
+	if len(conf.Interval) > 0 {
+		d, err := time.ParseDuration(conf.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scrape interval: %v", err)
+		}
+		s.interval = d
+	}
+	for name, series := range conf.Series {
+		sel, err := parseSelector(series)
+		if err != nil {
+			return nil, err
+		}
+		s.series[name] = sel
+	}
+	for _, c := range clients {
+		if err := s.add(c); err != nil {
+			return nil, err
+		}
+	}
+	if len(s.targets) == 0 {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// add scrapes the node, if it has a metrics url
+func (s *metricsScraper) add(c ClientInfo) error {
+	if len(c.MetricsUrl) == 0 {
+		return nil
+	}
+	t := &scrapeTarget{url: c.MetricsUrl, series: make(map[string]*seriesSelector)}
+	for name, sel := range s.series {
+		t.series[name] = sel
+	}
+	for name, series := range c.MetricsSeries {
+		sel, err := parseSelector(series)
+		if err != nil {
+			return fmt.Errorf("client %v: %v", c.Name, err)
+		}
+		t.series[name] = sel
+	}
+	if len(t.series) == 0 {
+		return fmt.Errorf("client %v: no metrics series to scrape", c.Name)
+	}
+	s.targets[c.Name] = t
+	return nil
+}
+
+func (s *metricsScraper) forget(name string) {
+	delete(s.targets, name)
+	delete(s.values, name)
+}
+
+// scrape fetches the selected series of the node
+func (s *metricsScraper) scrape(t *scrapeTarget) (map[string]float64, error) {
+	res, err := s.client.Get(t.url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scrape failed: %v", res.Status)
+	}
+	return parseSamples(data, t.series), nil
+}
+
+// scrapeAll scrapes the nodes if the interval passed. Nodes which fail to be
+// scraped are left out of the report until they're scraped again.
+func (s *metricsScraper) scrapeAll(now time.Time) {
+	if now.Sub(s.last) < s.interval {
+		return
+	}
+	s.last = now
+	for name, t := range s.targets {
+		values, err := s.scrape(t)
+		if err != nil {
+			log.Debug("Failed to scrape node metrics", "node", name, "error", err)
+			delete(s.values, name)
+			continue
+		}
+		s.values[name] = values
+	}
+}
+
+// checkNodeMetrics scrapes the metrics of the nodes, if it's time to
+func (mon *NodeMonitor) checkNodeMetrics() {
+	if mon.scraper != nil {
+		mon.scraper.scrapeAll(time.Now())
+	}
+}
+
+// setNodeMetrics adds the scraped values to the nodes of the report
+func (r *Report) setNodeMetrics(s *metricsScraper) {
+	copyValues := func(name string) map[string]float64 {
+		values, ok := s.values[name]
+		if !ok {
+			return nil
+		}
+		cpy := make(map[string]float64)
+		for k, v := range values {
+			cpy[k] = v
+		}
+		return cpy
+	}
+	for _, c := range r.Cols {
+		c.Metrics = copyValues(c.Name)
+	}
+	for _, b := range r.Beacons {
+		b.Metrics = copyValues(b.Name)
+	}
+}
+
+// sortedMetrics returns the names of the metrics, sorted
+func sortedMetrics(values map[string]float64) []string {
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
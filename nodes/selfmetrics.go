@@ -0,0 +1,69 @@
+package nodes
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// updateRuntimeMetrics records the goroutine count, heap usage and GC stats
+// of the monitor itself. They're process-wide, so they go to the top level
+// registry regardless of the network.
+func updateRuntimeMetrics() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	metrics.GetOrRegisterGauge("runtime/goroutines", registry).Update(int64(runtime.NumGoroutine()))
+	metrics.GetOrRegisterGauge("runtime/heap/alloc", registry).Update(int64(ms.HeapAlloc))
+	metrics.GetOrRegisterGauge("runtime/heap/inuse", registry).Update(int64(ms.HeapInuse))
+	metrics.GetOrRegisterGauge("runtime/heap/objects", registry).Update(int64(ms.HeapObjects))
+	metrics.GetOrRegisterGauge("runtime/sys", registry).Update(int64(ms.Sys))
+	metrics.GetOrRegisterGauge("runtime/gc/count", registry).Update(int64(ms.NumGC))
+	metrics.GetOrRegisterGauge("runtime/gc/pause/total", registry).Update(int64(ms.PauseTotalNs))
+	if ms.NumGC > 0 {
+		// The most recent pause is at (NumGC+255)%256
+		metrics.GetOrRegisterGauge("runtime/gc/pause/last", registry).Update(int64(ms.PauseNs[(ms.NumGC+255)%256]))
+	}
+}
+
+// cycleJson summarizes a check cycle, for the metrics and the health
+// endpoints
+type cycleJson struct {
+	Started    time.Time
+	DurationMs int64
+	// NodesOK and NodesFailed count the execution and beacon nodes which
+	// could, or couldn't, be updated. Nodes whose provider rate limited the
+	// monitor aren't failed, but counted as NodesThrottled.
+	NodesOK        int
+	NodesFailed    int
+	NodesThrottled int
+	// Written is whether the report was written. It isn't if unchanged, or
+	// when standing by.
+	Written bool
+	// WriteError is the first error writing the output, if any
+	WriteError string `json:",omitempty"`
+}
+
+func (c *cycleJson) failWrite(err error) {
+	if len(c.WriteError) == 0 {
+		c.WriteError = err.Error()
+	}
+}
+
+// recordCycle records the duration (in milliseconds), node counts and output
+// of the check cycle, and the runtime metrics as of its end
+func (mon *NodeMonitor) recordCycle(stats *cycleJson) {
+	stats.DurationMs = time.Since(stats.Started).Milliseconds()
+	metrics.GetOrRegisterGauge("cycle/duration", mon.registry).Update(stats.DurationMs)
+	metrics.GetOrRegisterGauge("cycle/nodes/ok", mon.registry).Update(int64(stats.NodesOK))
+	metrics.GetOrRegisterGauge("cycle/nodes/failed", mon.registry).Update(int64(stats.NodesFailed))
+	metrics.GetOrRegisterGauge("cycle/nodes/throttled", mon.registry).Update(int64(stats.NodesThrottled))
+	metrics.GetOrRegisterCounter("cycle/count", mon.registry).Inc(1)
+	if len(stats.WriteError) > 0 {
+		metrics.GetOrRegisterCounter("cycle/write/errors", mon.registry).Inc(1)
+	}
+	mon.mu.Lock()
+	mon.cycleStats = stats
+	mon.mu.Unlock()
+	updateRuntimeMetrics()
+}
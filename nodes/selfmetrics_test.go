@@ -0,0 +1,38 @@
+package nodes
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+func TestSelfMetrics(t *testing.T) {
+	// Gauges are no-ops unless metrics are enabled, and other tests may have
+	// registered no-op ones already
+	metrics.Enabled = true
+	defer func() { metrics.Enabled = false }()
+	for _, name := range []string{"runtime/goroutines", "runtime/heap/alloc", "cycle/duration", "cycle/nodes/failed"} {
+		registry.Unregister(name)
+	}
+	mon, err := NewMonitor([]Node{newTestNode("a", 10, makeChain("a", 20, nil)), &brokenNode{"b"}}, nil, nil, &Config{ReloadInterval: "1s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mon.doChecks()
+	if g, ok := registry.Get("runtime/goroutines").(metrics.Gauge); !ok || g.Value() <= 0 {
+		t.Errorf("goroutine count not recorded: %v", registry.Get("runtime/goroutines"))
+	}
+	if g, ok := registry.Get("runtime/heap/alloc").(metrics.Gauge); !ok || g.Value() <= 0 {
+		t.Errorf("heap usage not recorded: %v", registry.Get("runtime/heap/alloc"))
+	}
+	if _, ok := mon.registry.Get("cycle/duration").(metrics.Gauge); !ok {
+		t.Error("cycle duration not recorded")
+	}
+	if g, ok := mon.registry.Get("cycle/nodes/failed").(metrics.Gauge); !ok || g.Value() != 1 {
+		t.Errorf("failed nodes not recorded: %v", mon.registry.Get("cycle/nodes/failed"))
+	}
+	h := mon.health()
+	if h.Cycle == nil || h.Cycle.NodesOK != 1 || h.Cycle.NodesFailed != 1 || h.Cycle.Written {
+		t.Errorf("wrong cycle in health report: %+v", h.Cycle)
+	}
+}
@@ -0,0 +1,181 @@
+package nodes
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+type signingConfig struct {
+	// KeyFile holds the hex-encoded ed25519 seed the reports are signed with.
+	// A new key is generated if the file doesn't exist. Signing is disabled
+	// if empty.
+	KeyFile string
+}
+
+// signatureJson is a detached signature, as written next to the report
+type signatureJson struct {
+	Identity  string `json:"identity"`
+	PublicKey string `json:"publicKey"`
+	Signature string `json:"signature"`
+}
+
+// reportSigner signs the reports and API responses, so that consumers can
+// verify they came from the operator
+type reportSigner struct {
+	key      ed25519.PrivateKey
+	identity string
+}
+
+func newReportSigner(conf signingConfig, identity string) (*reportSigner, error) {
+	if len(conf.KeyFile) == 0 {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(conf.KeyFile)
+	if os.IsNotExist(err) {
+		seed := make([]byte, ed25519.SeedSize)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, err
+		}
+		data = []byte(hex.EncodeToString(seed))
+		if err := ioutil.WriteFile(conf.KeyFile, data, 0600); err != nil {
+			return nil, fmt.Errorf("failed to store signing key: %v", err)
+		}
+		log.Info("Generated report signing key", "file", conf.KeyFile)
+	} else if err != nil {
+		return nil, err
+	}
+	seed, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid signing key in %v, expected %d hex-encoded bytes", conf.KeyFile, ed25519.SeedSize)
+	}
+	return &reportSigner{key: ed25519.NewKeyFromSeed(seed), identity: identity}, nil
+}
+
+// publicKey returns the hex-encoded public key
+func (s *reportSigner) publicKey() string {
+	return hex.EncodeToString(s.key.Public().(ed25519.PublicKey))
+}
+
+// signedMessage is what's signed: the data, prefixed with the length and the
+// identity of the monitor, so the identity can't be swapped out
+func signedMessage(identity string, data []byte) []byte {
+	msg := []byte(fmt.Sprintf("%d:%s", len(identity), identity))
+	return append(msg, data...)
+}
+
+// sign returns the detached signature of the data
+func (s *reportSigner) sign(data []byte) *signatureJson {
+	return &signatureJson{
+		Identity:  s.identity,
+		PublicKey: s.publicKey(),
+		Signature: hex.EncodeToString(ed25519.Sign(s.key, signedMessage(s.identity, data))),
+	}
+}
+
+// parseSignature decodes a detached signature file
+func parseSignature(sigData []byte) (*signatureJson, error) {
+	var sig signatureJson
+	if err := json.Unmarshal(sigData, &sig); err != nil {
+		return nil, fmt.Errorf("invalid signature file: %v", err)
+	}
+	return &sig, nil
+}
+
+// VerifyReport checks the detached signature of a report, which must have
+// been made with the given public key. The identity of the monitor is
+// returned.
+func VerifyReport(data, sigData []byte, publicKey string) (string, error) {
+	if len(publicKey) == 0 {
+		return "", errors.New("no public key to verify against")
+	}
+	sig, err := parseSignature(sigData)
+	if err != nil {
+		return "", err
+	}
+	return verifySignature(data, sig, publicKey)
+}
+
+// SignatureKey returns the public key a detached signature claims to be made
+// with. It's only to be trusted if obtained from the operator some other way.
+func SignatureKey(sigData []byte) (string, error) {
+	sig, err := parseSignature(sigData)
+	if err != nil {
+		return "", err
+	}
+	return sig.PublicKey, nil
+}
+
+// verifySignature checks the detached signature of the data, like VerifyReport
+func verifySignature(data []byte, sig *signatureJson, publicKey string) (string, error) {
+	if !strings.EqualFold(strings.TrimPrefix(publicKey, "0x"), sig.PublicKey) {
+		return "", errors.New("report signed by a different key")
+	}
+	key, err := hex.DecodeString(sig.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return "", errors.New("invalid public key")
+	}
+	signature, err := hex.DecodeString(sig.Signature)
+	if err != nil || !ed25519.Verify(key, signedMessage(sig.Identity, data), signature) {
+		return "", errors.New("invalid signature")
+	}
+	return sig.Identity, nil
+}
+
+// bufferedResponse holds back the response body until it can be signed
+type bufferedResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	return b.body.Write(data)
+}
+
+// Signed wraps the handler so that the responses carry the signature of the
+// body, and the identity of the monitor, in headers. Without a signing key,
+// the handler is returned as is.
+func (mon *NodeMonitor) Signed(h http.HandlerFunc) http.HandlerFunc {
+	if mon.signer == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		res := &bufferedResponse{ResponseWriter: w, status: http.StatusOK}
+		h(res, r)
+		sig := mon.signer.sign(res.body.Bytes())
+		w.Header().Set("X-Monitor-Identity", sig.Identity)
+		w.Header().Set("X-Monitor-Key", sig.PublicKey)
+		w.Header().Set("X-Signature", sig.Signature)
+		w.WriteHeader(res.status)
+		w.Write(res.body.Bytes())
+	}
+}
+
+// HandleIdentity serves the identity and public key of the monitor, for
+// verifying the signed reports
+func (mon *NodeMonitor) HandleIdentity(w http.ResponseWriter, r *http.Request) {
+	if mon.signer == nil {
+		http.Error(w, "report signing not enabled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"identity":  mon.signer.identity,
+		"publicKey": mon.signer.publicKey(),
+	})
+}
@@ -0,0 +1,145 @@
+package nodes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// maxSlashings is the number of recently found slashings kept for the report
+const maxSlashings = 20
+
+type slashingJson struct {
+	Slot     uint64 // slot of the block including the slashing
+	Kind     string // proposer or attester
+	Indices  []uint64
+	Evidence []common.Hash
+}
+
+type beaconHeaderMessage struct {
+	Slot          flexUint    `json:"slot"`
+	ProposerIndex flexUint    `json:"proposer_index"`
+	ParentRoot    common.Hash `json:"parent_root"`
+	StateRoot     common.Hash `json:"state_root"`
+	BodyRoot      common.Hash `json:"body_root"`
+}
+
+type indexedAttestation struct {
+	AttestingIndices []flexUint `json:"attesting_indices"`
+	Data             struct {
+		BeaconBlockRoot common.Hash `json:"beacon_block_root"`
+		Target          checkpoint  `json:"target"`
+	} `json:"data"`
+}
+
+type slashingsBody struct {
+	ProposerSlashings []struct {
+		SignedHeader1 struct {
+			Message beaconHeaderMessage `json:"message"`
+		} `json:"signed_header_1"`
+		SignedHeader2 struct {
+			Message beaconHeaderMessage `json:"message"`
+		} `json:"signed_header_2"`
+	} `json:"proposer_slashings"`
+	AttesterSlashings []struct {
+		Attestation1 indexedAttestation `json:"attestation_1"`
+		Attestation2 indexedAttestation `json:"attestation_2"`
+	} `json:"attester_slashings"`
+}
+
+// slashingWatcher keeps the slashings found in new blocks
+type slashingWatcher struct {
+	found []*slashingJson
+}
+
+// parse extracts the slashings from a block body
+func (body *slashingsBody) parse(slot uint64) []*slashingJson {
+	var res []*slashingJson
+	for _, ps := range body.ProposerSlashings {
+		h1, h2 := ps.SignedHeader1.Message, ps.SignedHeader2.Message
+		res = append(res, &slashingJson{
+			Slot:     slot,
+			Kind:     "proposer",
+			Indices:  []uint64{uint64(h1.ProposerIndex)},
+			Evidence: []common.Hash{h1.BodyRoot, h2.BodyRoot},
+		})
+	}
+	for _, as := range body.AttesterSlashings {
+		// The slashable validators are the ones in both attestations
+		seen := make(map[uint64]bool)
+		for _, i := range as.Attestation1.AttestingIndices {
+			seen[uint64(i)] = true
+		}
+		var indices []uint64
+		for _, i := range as.Attestation2.AttestingIndices {
+			if seen[uint64(i)] {
+				indices = append(indices, uint64(i))
+			}
+		}
+		sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+		res = append(res, &slashingJson{
+			Slot:    slot,
+			Kind:    "attester",
+			Indices: indices,
+			Evidence: []common.Hash{
+				as.Attestation1.Data.BeaconBlockRoot,
+				as.Attestation2.Data.BeaconBlockRoot,
+			},
+		})
+	}
+	return res
+}
+
+// checkSlashings raises critical events for the slashings in a block
+func (mon *NodeMonitor) checkSlashings(node *BeaconNode, slot uint64, body *beaconBlockBody) {
+	for _, s := range body.parse(slot) {
+		mon.reportSlashing(node, s)
+	}
+}
+
+func (mon *NodeMonitor) reportSlashing(node *BeaconNode, s *slashingJson) {
+	sw := mon.slashings
+	sw.found = append(sw.found, s)
+	if len(sw.found) > maxSlashings {
+		sw.found = sw.found[len(sw.found)-maxSlashings:]
+	}
+	var monitored []string
+	for _, index := range s.Indices {
+		if label, ok := mon.validators.labels[index]; ok {
+			monitored = append(monitored, label)
+		}
+	}
+	msg := fmt.Sprintf("%v slashing in slot %d of validators %v, evidence %x",
+		s.Kind, s.Slot, s.Indices, s.Evidence)
+	if len(monitored) > 0 {
+		msg = fmt.Sprintf("%v, including monitored validators %v", msg, monitored)
+	}
+	mon.emit(EventSlashing, SeverityCritical, []string{node.Name()}, msg)
+}
+
+// checkSlashedValidators checks whether any of the monitored validators have
+// been slashed.
+func (mon *NodeMonitor) checkSlashedValidators(node *BeaconNode) {
+	vt := mon.validators
+	var validators []struct {
+		Index     flexUint `json:"index"`
+		Validator struct {
+			Slashed bool `json:"slashed"`
+		} `json:"validator"`
+	}
+	if err := node.get("/eth/v1/beacon/states/head/validators?id="+strings.Join(vt.indices(), ","), &validators); err != nil {
+		log.Warn("Failed to fetch validator status", "error", err)
+		return
+	}
+	for _, v := range validators {
+		index := uint64(v.Index)
+		if v.Validator.Slashed && !vt.slashed[index] {
+			vt.slashed[index] = true
+			mon.emit(EventSlashing, SeverityCritical, []string{node.Name()},
+				"Monitored validator %v has been slashed", vt.labels[index])
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package nodes
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+type missedSlot struct {
+	Slot uint64
+	// Proposer is the validator scheduled to propose, or nil if the duties
+	// couldn't be looked up
+	Proposer *uint64
+}
+
+// slotTracker keeps track of which slots produced blocks, over a rolling
+// window of the most recent slots.
+type slotTracker struct {
+	window   uint64
+	next     uint64             // next slot to check
+	produced map[uint64]bool    // slot -> whether there's a block in it
+	missed   map[uint64]*uint64 // missed slot -> scheduled proposer, if known
+	// proposer duties of the last looked up epoch
+	dutiesEpoch uint64
+	duties      map[uint64]uint64
+}
+
+func newSlotTracker(window uint64) *slotTracker {
+	if window == 0 {
+		window = 64
+	}
+	return &slotTracker{
+		window:   window,
+		produced: make(map[uint64]bool),
+		missed:   make(map[uint64]*uint64),
+	}
+}
+
+// blockAt asks the nodes, in order, whether there's a block at the slot. It
+// returns an error only if none of the nodes could give an answer.
+func blockAt(nodes []*BeaconNode, slot uint64) (bool, error) {
+	var (
+		answered bool
+		lastErr  error
+	)
+	for _, node := range nodes {
+		_, err := node.header(fmt.Sprint(slot))
+		if err == nil {
+			return true, nil
+		}
+		if errors.Is(err, errNotFound) {
+			answered = true
+			continue
+		}
+		lastErr = err
+	}
+	if answered {
+		return false, nil
+	}
+	return false, lastErr
+}
+
+// proposer returns the validator scheduled to propose in the given slot
+func (st *slotTracker) proposer(nodes []*BeaconNode, slot uint64) (uint64, bool) {
+	spe := nodes[0].slotsPerEpoch
+	if spe == 0 {
+		return 0, false
+	}
+	if epoch := slot / spe; st.duties == nil || st.dutiesEpoch != epoch {
+		var duties []struct {
+			ValidatorIndex uint64 `json:"validator_index,string"`
+			Slot           uint64 `json:"slot,string"`
+		}
+		if err := nodes[0].get(fmt.Sprintf("/eth/v1/validator/duties/proposer/%d", epoch), &duties); err != nil {
+			log.Debug("Failed to fetch proposer duties", "epoch", epoch, "error", err)
+			return 0, false
+		}
+		st.duties = make(map[uint64]uint64)
+		st.dutiesEpoch = epoch
+		for _, d := range duties {
+			st.duties[d.Slot] = d.ValidatorIndex
+		}
+	}
+	p, ok := st.duties[slot]
+	return p, ok
+}
+
+// update checks all slots since the last update, up to the highest head
+// among the nodes.
+func (st *slotTracker) update(nodes []*BeaconNode) {
+	if len(nodes) == 0 {
+		return
+	}
+	var head uint64
+	for _, node := range nodes {
+		if node.HeadSlot() > head {
+			head = node.HeadSlot()
+		}
+	}
+	// Don't look further back than the window
+	if head >= st.window && st.next < head-st.window+1 {
+		st.next = head - st.window + 1
+	}
+	for ; st.next <= head; st.next++ {
+		ok, err := blockAt(nodes, st.next)
+		if err != nil {
+			log.Warn("Failed to check slot", "slot", st.next, "error", err)
+			break
+		}
+		st.produced[st.next] = ok
+		if !ok {
+			st.missed[st.next] = nil
+			if proposer, ok := st.proposer(nodes, st.next); ok {
+				st.missed[st.next] = &proposer
+			}
+		}
+	}
+	// Drop everything that fell out of the window
+	for slot := range st.produced {
+		if slot+st.window <= head {
+			delete(st.produced, slot)
+			delete(st.missed, slot)
+		}
+	}
+}
+
+// rate returns the fraction of missed slots within the window
+func (st *slotTracker) rate() float64 {
+	if len(st.produced) == 0 {
+		return 0
+	}
+	return float64(len(st.missed)) / float64(len(st.produced))
+}
+
+// missedSlots returns the missed slots within the window, in order
+func (st *slotTracker) missedSlots() []missedSlot {
+	var missed []missedSlot
+	for slot, proposer := range st.missed {
+		missed = append(missed, missedSlot{slot, proposer})
+	}
+	sort.Slice(missed, func(i, j int) bool { return missed[i].Slot < missed[j].Slot })
+	return missed
+}
@@ -0,0 +1,183 @@
+package nodes
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Kinds of state probes
+const (
+	probeBalance = "balance"
+	probeStorage = "storage"
+	probeCall    = "call"
+)
+
+type stateProbeConfig struct {
+	// Kind is "balance", "storage" or "call"
+	Kind string
+	// Address is the account whose balance or storage is read, or the
+	// contract which is called
+	Address string
+	// Slot is the storage slot read by "storage" probes, in hex or decimal
+	Slot string
+	// Data is the hex-encoded calldata of "call" probes
+	Data string
+}
+
+// stateProbe is a read of the state, whose result all nodes on the same block
+// must agree on
+type stateProbe struct {
+	kind    string
+	address common.Address
+	slot    common.Hash
+	data    hexutil.Bytes
+}
+
+func newStateProbes(confs []stateProbeConfig) ([]*stateProbe, error) {
+	var probes []*stateProbe
+	for i, conf := range confs {
+		if !common.IsHexAddress(conf.Address) {
+			return nil, fmt.Errorf("state check %d: invalid address %q", i, conf.Address)
+		}
+		p := &stateProbe{kind: conf.Kind, address: common.HexToAddress(conf.Address)}
+		switch conf.Kind {
+		case probeBalance:
+		case probeStorage:
+			slot, ok := new(big.Int).SetString(conf.Slot, 0)
+			if !ok || slot.Sign() < 0 || slot.BitLen() > 256 {
+				return nil, fmt.Errorf("state check %d: invalid storage slot %q", i, conf.Slot)
+			}
+			p.slot = common.BytesToHash(slot.Bytes())
+		case probeCall:
+			data, err := hexutil.Decode(conf.Data)
+			if err != nil {
+				return nil, fmt.Errorf("state check %d: invalid call data: %v", i, err)
+			}
+			p.data = data
+		default:
+			return nil, fmt.Errorf("state check %d: invalid kind %q, available: [balance, storage, call]", i, conf.Kind)
+		}
+		probes = append(probes, p)
+	}
+	return probes, nil
+}
+
+func (p *stateProbe) String() string {
+	switch p.kind {
+	case probeStorage:
+		return fmt.Sprintf("storage slot %x of %v", p.slot, p.address.Hex())
+	case probeCall:
+		return fmt.Sprintf("call to %v", p.address.Hex())
+	}
+	return fmt.Sprintf("balance of %v", p.address.Hex())
+}
+
+// stateNode is implemented by nodes which can serve reads of the state
+type stateNode interface {
+	Node
+	// StateAt returns the hex-encoded result of the probe at the given block
+	StateAt(p *stateProbe, num uint64) (string, error)
+}
+
+func (node *RPCNode) StateAt(p *stateProbe, num uint64) (string, error) {
+	node.throttle.Take()
+	var (
+		res   string
+		err   error
+		ctx   = context.Background()
+		block = hexutil.EncodeUint64(num)
+	)
+	switch p.kind {
+	case probeBalance:
+		err = node.rpcCli.CallContext(ctx, &res, "eth_getBalance", p.address, block)
+	case probeStorage:
+		err = node.rpcCli.CallContext(ctx, &res, "eth_getStorageAt", p.address, p.slot, block)
+	case probeCall:
+		msg := map[string]interface{}{"to": p.address, "data": p.data}
+		err = node.rpcCli.CallContext(ctx, &res, "eth_call", msg, block)
+	}
+	return strings.ToLower(res), wrapNodeError(node.name, "state", err)
+}
+
+// checkState runs the state probes at the lowest head of the nodes. Nodes
+// which agree on the block there must also agree on the state, so any
+// difference between them is reported, even though their block hashes match.
+// Nodes which can't serve the state, e.g. because it's pruned, are skipped.
+func (mon *NodeMonitor) checkState(active []Node) {
+	if len(mon.stateProbes) == 0 {
+		return
+	}
+	var nodes []Node
+	for _, n := range active {
+		if _, ok := n.(stateNode); ok {
+			nodes = append(nodes, n)
+		}
+	}
+	lowest, groups := blockGroups(nodes)
+	for _, group := range groups {
+		for _, p := range mon.stateProbes {
+			var (
+				names   []string
+				results []string
+				seen    = make(map[string]bool)
+			)
+			for _, node := range group {
+				res, err := node.(stateNode).StateAt(p, lowest)
+				if err != nil {
+					log.Debug("State probe failed", "node", node.Name(), "probe", p, "error", err)
+					continue
+				}
+				names = append(names, node.Name())
+				results = append(results, fmt.Sprintf("%v=%v", node.Name(), res))
+				seen[res] = true
+			}
+			if len(seen) > 1 {
+				mon.emitBlock(EventStateMismatch, SeverityCritical, names, lowest, nil,
+					"State differs at block %d, %v: %v", lowest, p, strings.Join(results, ", "))
+			}
+		}
+	}
+}
+
+// blockGroups groups the nodes by their block at the lowest head among them,
+// and returns the groups of at least two nodes, ordered by block hash.
+// Nodes within a group are on the same chain up to that block.
+func blockGroups(nodes []Node) (uint64, [][]Node) {
+	if len(nodes) < 2 {
+		return 0, nil
+	}
+	lowest := nodes[0].HeadNum()
+	for _, node := range nodes {
+		if node.HeadNum() < lowest {
+			lowest = node.HeadNum()
+		}
+	}
+	byHash := make(map[common.Hash][]Node)
+	for _, node := range nodes {
+		if h := node.HashAt(lowest, false); h != (common.Hash{}) {
+			byHash[h] = append(byHash[h], node)
+		}
+	}
+	var hashes []common.Hash
+	for h, group := range byHash {
+		if len(group) > 1 {
+			hashes = append(hashes, h)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+	var groups [][]Node
+	for _, h := range hashes {
+		groups = append(groups, byHash[h])
+	}
+	return lowest, groups
+}
@@ -0,0 +1,141 @@
+package nodes
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+type statsdConfig struct {
+	// Address is the host:port of the statsd agent, e.g. localhost:8125
+	Address string
+	// Prefix is prepended to the metric names, defaults to "nodemonitor."
+	Prefix string
+	// Tags enables DogStatsD tags. Plain statsd has no tags, so the node
+	// names are made part of the metric names instead.
+	Tags bool
+}
+
+// statsdMaxPacket is the max size of a packet, so it fits in the MTU
+const statsdMaxPacket = 1432
+
+// statsdExporter sends per-node gauges, and counters of the events raised,
+// to a statsd agent after every check cycle.
+type statsdExporter struct {
+	conn   net.Conn
+	prefix string
+	tags   bool
+}
+
+func newStatsdExporter(conf statsdConfig) (*statsdExporter, error) {
+	if len(conf.Address) == 0 {
+		return nil, nil
+	}
+	conn, err := net.Dial("udp", conf.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid statsd address: %v", err)
+	}
+	prefix := conf.Prefix
+	if len(prefix) == 0 {
+		prefix = "nodemonitor."
+	}
+	return &statsdExporter{conn: conn, prefix: prefix, tags: conf.Tags}, nil
+}
+
+// statsdEscape replaces the characters with a meaning in the statsd protocol
+func statsdEscape(s string) string {
+	return strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", " ", "_", "\n", "_").Replace(s)
+}
+
+// metric formats a metric with the given tags. Without tag support, the
+// node tag becomes part of the name.
+func (e *statsdExporter) metric(name string, value interface{}, typ string, tags map[string]string) string {
+	if !e.tags {
+		if node, ok := tags["node"]; ok {
+			name = strings.Replace(name, ".", "."+statsdEscape(node)+".", 1)
+		}
+		return fmt.Sprintf("%v%v:%v|%v", e.prefix, name, value, typ)
+	}
+	var list []string
+	for k, v := range tags {
+		list = append(list, statsdEscape(k)+":"+statsdEscape(v))
+	}
+	sort.Strings(list)
+	line := fmt.Sprintf("%v%v:%v|%v", e.prefix, name, value, typ)
+	if len(list) > 0 {
+		line += "|#" + strings.Join(list, ",")
+	}
+	return line
+}
+
+// lines returns the metrics of the report
+func (e *statsdExporter) lines(r *Report) []string {
+	var lines []string
+	for _, c := range r.Cols {
+		tags := map[string]string{"node": c.Name}
+		for k, v := range c.Labels {
+			if k != "node" {
+				tags[k] = v
+			}
+		}
+		tags = withNetwork(tags, r.Network)
+		lines = append(lines,
+			e.metric("node.head", c.Head, "g", tags),
+			e.metric("node.lag", c.Lag, "g", tags),
+			e.metric("node.status", c.Status, "g", tags),
+			e.metric("node.latency", c.Latency.Milliseconds(), "ms", tags),
+		)
+		if c.Health != nil {
+			lines = append(lines, e.metric("node.health", c.Health.Score, "g", tags))
+		}
+		for _, name := range sortedMetrics(c.Metrics) {
+			lines = append(lines, e.metric("node.metric."+statsdEscape(name), c.Metrics[name], "g", tags))
+		}
+	}
+	lines = append(lines, e.metric("chain.split_depth", r.SplitDepth, "g", withNetwork(nil, r.Network)))
+	counts := make(map[string]int)
+	for _, ev := range r.Events {
+		counts[ev.Kind]++
+	}
+	var kinds []string
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		if e.tags {
+			lines = append(lines, e.metric("events", counts[kind], "c",
+				withNetwork(map[string]string{"kind": kind}, r.Network)))
+		} else {
+			lines = append(lines, e.metric("events."+statsdEscape(kind), counts[kind], "c", nil))
+		}
+	}
+	return lines
+}
+
+// Export sends the metrics, packing as many lines into a packet as fit
+func (e *statsdExporter) Export(r *Report) error {
+	var buf bytes.Buffer
+	flush := func() error {
+		if buf.Len() == 0 {
+			return nil
+		}
+		_, err := e.conn.Write(buf.Bytes())
+		buf.Reset()
+		return err
+	}
+	for _, line := range e.lines(r) {
+		if buf.Len() > 0 && buf.Len()+1+len(line) > statsdMaxPacket {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	return flush()
+}
@@ -0,0 +1,132 @@
+package nodes
+
+import (
+	"time"
+)
+
+// Types of stream messages
+const (
+	streamHead   = "head"
+	streamStatus = "status"
+	streamEvent  = "event"
+)
+
+// streamMessage is a monitoring event as published to message brokers. It's
+// flat, with every field always present, so it maps onto an Avro record as
+// well as onto json.
+type streamMessage struct {
+	// Type is "head", "status" or "event"
+	Type string `json:"type"`
+	// Time is the unix time in milliseconds
+	Time int64 `json:"time"`
+	// Node, Head and Status are set on head updates and status changes
+	Node   string `json:"node"`
+	Head   uint64 `json:"head"`
+	Status string `json:"status"`
+	// The remaining fields are those of the event, for events
+	Kind     string   `json:"kind"`
+	Severity string   `json:"severity"`
+	Nodes    []string `json:"nodes"`
+	Message  string   `json:"message"`
+	Block    uint64   `json:"block"`
+}
+
+// publisher is implemented by the message brokers the stream is sent to
+type publisher interface {
+	publish(msgs []*streamMessage) error
+}
+
+// newPublishers creates the configured publishers
+func newPublishers(c *Config) ([]publisher, error) {
+	var pubs []publisher
+	kafka, err := newKafkaPublisher(c.Kafka)
+	if err != nil {
+		return nil, err
+	}
+	if kafka != nil {
+		pubs = append(pubs, kafka)
+	}
+	nats, err := newNatsPublisher(c.Nats)
+	if err != nil {
+		return nil, err
+	}
+	if nats != nil {
+		pubs = append(pubs, nats)
+	}
+	mqtt, err := newMqttPublisher(c.Mqtt)
+	if err != nil {
+		return nil, err
+	}
+	if mqtt != nil {
+		pubs = append(pubs, mqtt)
+	}
+	return pubs, nil
+}
+
+func statusName(status int) string {
+	if status == NodeStatusOK {
+		return "ok"
+	}
+	return "unreachable"
+}
+
+// eventStream turns the reports into a stream of changes: new heads, status
+// changes, and events which weren't raised in the previous cycle. It's
+// exported like a report, and passes the messages on to the publisher.
+type eventStream struct {
+	pub    publisher
+	heads  map[string]uint64
+	status map[string]int
+	events map[string]bool // fingerprints of the previous cycle's events
+}
+
+func newEventStream(pub publisher) *eventStream {
+	return &eventStream{
+		pub:    pub,
+		heads:  make(map[string]uint64),
+		status: make(map[string]int),
+		events: make(map[string]bool),
+	}
+}
+
+// messages returns the changes since the previous report
+func (s *eventStream) messages(r *Report, now time.Time) []*streamMessage {
+	var (
+		msgs []*streamMessage
+		ts   = now.UnixNano() / int64(time.Millisecond)
+	)
+	for _, c := range r.Cols {
+		prev, known := s.status[c.Name]
+		if !known || prev != c.Status {
+			msgs = append(msgs, &streamMessage{Type: streamStatus, Time: ts, Node: c.Name,
+				Head: c.Head, Status: statusName(c.Status), Nodes: []string{}})
+		}
+		s.status[c.Name] = c.Status
+		if c.Status == NodeStatusOK && c.Head != s.heads[c.Name] {
+			msgs = append(msgs, &streamMessage{Type: streamHead, Time: ts, Node: c.Name,
+				Head: c.Head, Status: statusName(c.Status), Nodes: []string{}})
+			s.heads[c.Name] = c.Head
+		}
+	}
+	events := make(map[string]bool)
+	for _, ev := range r.Events {
+		fp := ev.fingerprint()
+		events[fp] = true
+		if s.events[fp] {
+			continue
+		}
+		nodes := append([]string{}, ev.Nodes...)
+		msgs = append(msgs, &streamMessage{Type: streamEvent, Time: ev.Time.UnixNano() / int64(time.Millisecond),
+			Kind: ev.Kind, Severity: ev.Severity.String(), Nodes: nodes, Message: ev.Message, Block: ev.Block})
+	}
+	s.events = events
+	return msgs
+}
+
+func (s *eventStream) Export(r *Report) error {
+	msgs := s.messages(r, time.Now())
+	if len(msgs) == 0 {
+		return nil
+	}
+	return s.pub.publish(msgs)
+}
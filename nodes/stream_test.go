@@ -0,0 +1,259 @@
+package nodes
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventStream(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	s := newEventStream(nil)
+	report := func(geth, besu uint64, besuStatus int, events ...*Event) []string {
+		r := NewReport(nil)
+		r.Cols = []*clientJson{{Name: "geth", Head: geth}, {Name: "besu", Head: besu, Status: besuStatus}}
+		r.Events = events
+		var types []string
+		for _, msg := range s.messages(r, now) {
+			types = append(types, msg.Type+":"+msg.Node+msg.Kind)
+		}
+		return types
+	}
+	split := &Event{Time: now, Kind: EventSplit, Severity: SeverityWarning, Nodes: []string{"geth", "besu"}}
+	check := func(have []string, want ...string) {
+		t.Helper()
+		if len(have) != len(want) {
+			t.Fatalf("have %v, want %v", have, want)
+		}
+		for i := range have {
+			if have[i] != want[i] {
+				t.Fatalf("have %v, want %v", have, want)
+			}
+		}
+	}
+	check(report(10, 10, NodeStatusOK), "status:geth", "head:geth", "status:besu", "head:besu")
+	check(report(11, 10, NodeStatusOK, split), "head:geth", "event:split")
+	// The split is still ongoing, and besu goes away
+	check(report(11, 10, NodeStatusUnreachable, split), "status:besu")
+	check(report(11, 12, NodeStatusOK), "status:besu", "head:besu")
+	check(report(11, 12, NodeStatusOK, split), "event:split")
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	var (
+		body struct {
+			KeySchema   string `json:"key_schema"`
+			ValueSchema string `json:"value_schema"`
+			Records     []struct {
+				Key   string
+				Value map[string]interface{}
+			}
+		}
+		req *http.Request
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer srv.Close()
+
+	p, err := newKafkaPublisher(kafkaConfig{RestProxy: srv.URL, Topic: "node events", Format: "avro", Username: "user", Password: "pass"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newEventStream(p)
+	r := NewReport(nil)
+	r.Cols = []*clientJson{{Name: "geth", Head: 10}}
+	r.Events = []*Event{{Kind: EventSplit, Severity: SeverityCritical, Nodes: []string{"geth", "besu"}, Block: 9}}
+	if err := s.Export(r); err != nil {
+		t.Fatal(err)
+	}
+	if req.URL.EscapedPath() != "/topics/node%20events" || req.Header.Get("Content-Type") != "application/vnd.kafka.avro.v2+json" {
+		t.Errorf("unexpected request: %v %v", req.URL.EscapedPath(), req.Header)
+	}
+	if user, pass, _ := req.BasicAuth(); user != "user" || pass != "pass" {
+		t.Errorf("wrong auth: %v %v", user, pass)
+	}
+	if body.KeySchema != `"string"` || body.ValueSchema != streamAvroSchema || len(body.Records) != 3 {
+		t.Fatalf("unexpected body: %+v", body)
+	}
+	ev := body.Records[2]
+	if ev.Key != "split" || ev.Value["severity"] != "critical" || ev.Value["block"] != 9.0 || len(ev.Value) != 10 {
+		t.Errorf("unexpected event record: %+v", ev)
+	}
+	if _, err := newKafkaPublisher(kafkaConfig{RestProxy: srv.URL}); err == nil {
+		t.Errorf("expected error for missing topic")
+	}
+}
+
+// fakeBroker accepts a single connection and runs the handler on it
+func fakeBroker(t *testing.T, handle func(conn net.Conn)) (string, <-chan struct{}) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn)
+	}()
+	return l.Addr().String(), done
+}
+
+func TestNatsPublisher(t *testing.T) {
+	var (
+		connect string
+		pubs    []string
+	)
+	addr, done := fakeBroker(t, func(conn net.Conn) {
+		conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch line = strings.TrimSpace(line); {
+			case strings.HasPrefix(line, "CONNECT "):
+				connect = strings.TrimPrefix(line, "CONNECT ")
+			case strings.HasPrefix(line, "PUB "):
+				payload, _ := r.ReadString('\n')
+				pubs = append(pubs, line+" "+strings.TrimSpace(payload))
+			case line == "PING":
+				conn.Write([]byte("PONG\r\n"))
+			}
+		}
+	})
+	p, err := newNatsPublisher(natsConfig{Url: "nats://" + addr, Subject: "mon", Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := []*streamMessage{
+		{Type: streamHead, Node: "geth", Head: 10},
+		{Type: streamEvent, Kind: EventSplit, Nodes: []string{"geth", "besu"}},
+	}
+	if err := p.publish(msgs); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	var opts map[string]interface{}
+	if err := json.Unmarshal([]byte(connect), &opts); err != nil || opts["auth_token"] != "secret" || opts["verbose"] != false {
+		t.Errorf("unexpected connect: %v", connect)
+	}
+	if len(pubs) != 2 || !strings.HasPrefix(pubs[0], "PUB mon.head ") || !strings.HasPrefix(pubs[1], "PUB mon.event ") {
+		t.Fatalf("unexpected publishes: %v", pubs)
+	}
+	data, _ := json.Marshal(msgs[0])
+	if want := fmt.Sprintf("PUB mon.head %d %s", len(data), data); pubs[0] != want {
+		t.Errorf("have %v, want %v", pubs[0], want)
+	}
+	// Errors from the server are returned
+	addr, _ = fakeBroker(t, func(conn net.Conn) {
+		conn.Write([]byte("INFO {}\r\n-ERR 'Authorization Violation'\r\n"))
+		io.Copy(ioutil.Discard, conn)
+	})
+	p, _ = newNatsPublisher(natsConfig{Url: "nats://" + addr})
+	if err := p.publish(msgs); err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("expected authorization error, got %v", err)
+	}
+	if _, err := newNatsPublisher(natsConfig{Url: "http://localhost"}); err == nil {
+		t.Errorf("expected error for wrong scheme")
+	}
+}
+
+// readMqttPacket reads a packet, returning the first byte and the body
+func readMqttPacket(r *bufio.Reader) (byte, []byte, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var n, shift int
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(b&0x7f) << shift
+		if shift += 7; b&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return typ, body, err
+}
+
+func TestMqttPublisher(t *testing.T) {
+	type packet struct {
+		typ  byte
+		body []byte
+	}
+	var packets []packet
+	addr, done := fakeBroker(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		for {
+			typ, body, err := readMqttPacket(r)
+			if err != nil {
+				return
+			}
+			packets = append(packets, packet{typ, body})
+			if typ>>4 == mqttConnect {
+				conn.Write([]byte{mqttConnack << 4, 2, 0, 0})
+			}
+		}
+	})
+	p, err := newMqttPublisher(mqttConfig{Broker: "tcp://" + addr, Username: "user", Password: "pass", Retain: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Long enough for a multi-byte remaining length
+	msg := &streamMessage{Type: streamStatus, Node: "geth/1", Status: "ok", Message: strings.Repeat("x", 200)}
+	if err := p.publish([]*streamMessage{msg}); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if len(packets) != 3 || packets[0].typ>>4 != mqttConnect || packets[2].typ>>4 != mqttDisconnect {
+		t.Fatalf("unexpected packets: %v", packets)
+	}
+	// Clean session, username and password flags, then client id, user, pass
+	connect := packets[0].body
+	if connect[7] != 0xc2 || !strings.HasSuffix(string(connect), "\x00\x0bnodemonitor\x00\x04user\x00\x04pass") {
+		t.Errorf("unexpected connect: %q", connect)
+	}
+	pub := packets[1]
+	if pub.typ != mqttPublish<<4|1 {
+		t.Errorf("expected retained publish, have %x", pub.typ)
+	}
+	n := binary.BigEndian.Uint16(pub.body)
+	if topic := string(pub.body[2 : 2+n]); topic != "nodemonitor/status/geth_1" {
+		t.Errorf("wrong topic %v", topic)
+	}
+	var have streamMessage
+	if err := json.Unmarshal(pub.body[2+n:], &have); err != nil || have.Message != msg.Message {
+		t.Errorf("wrong payload: %v", err)
+	}
+	// Refused connections
+	addr, _ = fakeBroker(t, func(conn net.Conn) {
+		readMqttPacket(bufio.NewReader(conn))
+		conn.Write([]byte{mqttConnack << 4, 2, 0, 5})
+	})
+	p, _ = newMqttPublisher(mqttConfig{Broker: "tcp://" + addr})
+	if err := p.publish([]*streamMessage{msg}); err == nil {
+		t.Errorf("expected refused connection")
+	}
+}
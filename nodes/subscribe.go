@@ -0,0 +1,112 @@
+package nodes
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// resubscribeDelay is how long to wait before resubscribing after a failed
+// subscription
+var resubscribeDelay = 10 * time.Second
+
+// headSubscriber is implemented by nodes which can push new heads
+type headSubscriber interface {
+	Node
+	// canSubscribe returns whether the node is connected via a transport
+	// supporting subscriptions (websocket or ipc)
+	canSubscribe() bool
+	subscribeHeads(ch chan<- *types.Header) (ethereum.Subscription, error)
+}
+
+// isWebsocket returns whether the url is a websocket endpoint
+func isWebsocket(url string) bool {
+	return strings.HasPrefix(url, "ws://") || strings.HasPrefix(url, "wss://")
+}
+
+func (node *RPCNode) canSubscribe() bool {
+	return node.push
+}
+
+func (node *RPCNode) subscribeHeads(ch chan<- *types.Header) (ethereum.Subscription, error) {
+	if !node.push {
+		return nil, errors.New("node doesn't support subscriptions")
+	}
+	sub, err := node.ethCli.SubscribeNewHead(context.Background(), ch)
+	return sub, wrapNodeError(node.name, "subscribe", err)
+}
+
+// startWatcher subscribes to the new heads of the node, if it supports it,
+// triggering a check cycle whenever one arrives
+func (mon *NodeMonitor) startWatcher(node Node) {
+	hs, ok := node.(headSubscriber)
+	if !ok || !hs.canSubscribe() {
+		return
+	}
+	stop := make(chan struct{})
+	mon.mu.Lock()
+	mon.watchers[node.Name()] = stop
+	mon.mu.Unlock()
+	mon.wg.Add(1)
+	go mon.watchHeads(hs, stop)
+}
+
+// stopWatcher ends the head subscription of the named node, if any
+func (mon *NodeMonitor) stopWatcher(name string) {
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+	if stop, ok := mon.watchers[name]; ok {
+		close(stop)
+		delete(mon.watchers, name)
+	}
+}
+
+func (mon *NodeMonitor) watchHeads(node headSubscriber, stop chan struct{}) {
+	defer mon.wg.Done()
+	ch := make(chan *types.Header, 16)
+	for {
+		sub, err := node.subscribeHeads(ch)
+		if err == nil {
+			log.Info("Subscribed to new heads", "node", node.Name())
+			err = mon.forwardHeads(node, ch, sub, stop)
+			sub.Unsubscribe()
+			if err == nil {
+				return
+			}
+		}
+		log.Warn("Head subscription failed", "node", node.Name(), "error", err)
+		select {
+		case <-mon.quitCh:
+			return
+		case <-stop:
+			return
+		case <-time.After(resubscribeDelay):
+		}
+	}
+}
+
+// forwardHeads triggers a check for every new head, until the subscription
+// fails or the watcher is stopped (in which case it returns nil)
+func (mon *NodeMonitor) forwardHeads(node Node, ch chan *types.Header, sub ethereum.Subscription, stop chan struct{}) error {
+	for {
+		select {
+		case <-mon.quitCh:
+			return nil
+		case <-stop:
+			return nil
+		case err := <-sub.Err():
+			if err == nil {
+				err = errors.New("subscription closed")
+			}
+			return err
+		case h := <-ch:
+			log.Debug("New head", "node", node.Name(), "number", h.Number)
+			mon.headChanged()
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package nodes
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// fakeSub is a subscription which can be made to fail
+type fakeSub struct {
+	err chan error
+}
+
+func (s *fakeSub) Unsubscribe()      {}
+func (s *fakeSub) Err() <-chan error { return s.err }
+
+// pushNode is a node which pushes heads to its subscribers
+type pushNode struct {
+	*brokenNode
+	subs chan chan<- *types.Header
+	sub  *fakeSub
+}
+
+func (n *pushNode) canSubscribe() bool { return true }
+
+func (n *pushNode) subscribeHeads(ch chan<- *types.Header) (ethereum.Subscription, error) {
+	n.subs <- ch
+	return n.sub, nil
+}
+
+func TestHeadSubscription(t *testing.T) {
+	defer func(d, s time.Duration) { resubscribeDelay, settleDelay = d, s }(resubscribeDelay, settleDelay)
+	resubscribeDelay, settleDelay = 10*time.Millisecond, 10*time.Millisecond
+
+	node := &pushNode{&brokenNode{"push"}, make(chan chan<- *types.Header, 1), &fakeSub{make(chan error, 1)}}
+	mon, _ := NewMonitor([]Node{node}, nil, nil, &Config{ReloadInterval: "1h"})
+	mon.Start()
+	defer mon.Stop()
+
+	waitCycle := func(before time.Time) bool {
+		for i := 0; i < 100; i++ {
+			if mon.health().LastCycle.After(before) {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+	ch := <-node.subs
+	before := mon.health().LastCycle
+	ch <- &types.Header{Number: big.NewInt(1)}
+	if !waitCycle(before) {
+		t.Fatalf("new head didn't trigger a check cycle")
+	}
+	// A failed subscription is renewed
+	node.sub.err <- errors.New("connection lost")
+	select {
+	case ch = <-node.subs:
+	case <-time.After(time.Second):
+		t.Fatalf("no resubscription")
+	}
+	before = mon.health().LastCycle
+	ch <- &types.Header{Number: big.NewInt(2)}
+	if !waitCycle(before) {
+		t.Fatalf("new head after resubscribing didn't trigger a check cycle")
+	}
+	// Removing the node stops the watcher
+	mon.stopWatcher("push")
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+	if len(mon.watchers) != 0 {
+		t.Errorf("watcher not removed")
+	}
+}
@@ -0,0 +1,135 @@
+package nodes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// epochsPerSyncPeriod is the number of epochs a sync committee serves
+const epochsPerSyncPeriod = 256
+
+// syncTracker follows the sync committee contributions of the monitored
+// validators, slot by slot.
+type syncTracker struct {
+	maxMisses uint64          // consecutive misses before alerting
+	period    uint64          // sync committee period of the duties
+	loaded    bool            // whether the duties have been fetched
+	current   map[uint64]bool // monitored validators in the current committee
+	next      map[uint64]bool // monitored validators in the next committee
+	nextSlot  uint64          // next slot to check contributions for
+	misses    map[uint64]uint64
+}
+
+func newSyncTracker(maxMisses uint64) *syncTracker {
+	if maxMisses == 0 {
+		maxMisses = 4
+	}
+	return &syncTracker{
+		maxMisses: maxMisses,
+		misses:    make(map[uint64]uint64),
+	}
+}
+
+// membership returns which sync committee the validator is in, if any
+func (st *syncTracker) membership(index uint64) string {
+	switch {
+	case st.current[index]:
+		return "current"
+	case st.next[index]:
+		return "next"
+	}
+	return ""
+}
+
+// syncDuties returns the monitored validators in the sync committee of the
+// given epoch.
+func syncDuties(node *BeaconNode, epoch uint64, indices []string) (map[uint64]bool, error) {
+	var duties []struct {
+		ValidatorIndex flexUint `json:"validator_index"`
+	}
+	if err := node.post(fmt.Sprintf("/eth/v1/validator/duties/sync/%d", epoch), indices, &duties); err != nil {
+		return nil, err
+	}
+	members := make(map[uint64]bool)
+	for _, d := range duties {
+		members[uint64(d.ValidatorIndex)] = true
+	}
+	return members, nil
+}
+
+// update refreshes the sync committee duties on a period change
+func (st *syncTracker) update(node *BeaconNode, indices []string) error {
+	epoch := node.currentEpoch()
+	period := epoch / epochsPerSyncPeriod
+	if st.loaded && st.period == period {
+		return nil
+	}
+	current, err := syncDuties(node, epoch, indices)
+	if err != nil {
+		return err
+	}
+	next, err := syncDuties(node, (period+1)*epochsPerSyncPeriod, indices)
+	if err != nil {
+		return err
+	}
+	for index := range next {
+		if !current[index] {
+			log.Info("Validator in next sync committee", "index", index, "period", period+1)
+		}
+	}
+	st.current, st.next = current, next
+	st.period, st.loaded = period, true
+	st.misses = make(map[uint64]uint64)
+	return nil
+}
+
+// checkSyncCommittee checks the sync committee contributions of the monitored
+// validators in all blocks since the last cycle, and alerts on validators
+// missing several in a row.
+func (mon *NodeMonitor) checkSyncCommittee(node *BeaconNode) {
+	vt, st := mon.validators, mon.validators.sync
+	if err := st.update(node, vt.indices()); err != nil {
+		log.Warn("Failed to fetch sync committee duties", "error", err)
+		return
+	}
+	head := node.HeadSlot()
+	if len(st.current) == 0 {
+		st.nextSlot = head + 1
+		return
+	}
+	var members []string
+	for index := range st.current {
+		members = append(members, fmt.Sprint(index))
+	}
+	for st.nextSlot = scanStart(st.nextSlot, head); st.nextSlot <= head; st.nextSlot++ {
+		var rewards []struct {
+			ValidatorIndex flexUint `json:"validator_index"`
+			Reward         string   `json:"reward"`
+		}
+		err := node.post(fmt.Sprintf("/eth/v1/beacon/rewards/sync_committee/%d", st.nextSlot), members, &rewards)
+		if errors.Is(err, errNotFound) {
+			continue // empty slot
+		}
+		if err != nil {
+			log.Warn("Failed to fetch sync committee rewards", "slot", st.nextSlot, "error", err)
+			return
+		}
+		for _, r := range rewards {
+			index := uint64(r.ValidatorIndex)
+			// Missing a contribution is penalized
+			if !strings.HasPrefix(r.Reward, "-") {
+				st.misses[index] = 0
+				continue
+			}
+			st.misses[index]++
+			if st.misses[index] == st.maxMisses {
+				mon.emit(EventSyncCommittee, SeverityWarning, []string{node.Name()},
+					"Validator %v missed %d sync committee contributions in a row, up to slot %d",
+					vt.labels[index], st.misses[index], st.nextSlot)
+			}
+		}
+	}
+}
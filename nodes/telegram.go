@@ -0,0 +1,60 @@
+package nodes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type telegramConfig struct {
+	// Token is the bot token, as given by @BotFather
+	Token  string
+	ChatID string
+	// Kinds are the event kinds to send. Defaults to splits and unreachable
+	// nodes.
+	Kinds []string
+}
+
+// telegramNotifier posts alerts to a Telegram chat via the bot API
+type telegramNotifier struct {
+	url    string // sendMessage endpoint
+	chatID string
+	kinds  kindFilter
+}
+
+func newTelegramNotifier(conf telegramConfig) (*telegramNotifier, error) {
+	if len(conf.Token) == 0 {
+		return nil, nil
+	}
+	if len(conf.ChatID) == 0 {
+		return nil, errors.New("telegram needs a chat_id")
+	}
+	return &telegramNotifier{
+		url:    fmt.Sprintf("https://api.telegram.org/bot%v/sendMessage", conf.Token),
+		chatID: conf.ChatID,
+		kinds:  newKindFilter(conf.Kinds, outageKinds...),
+	}, nil
+}
+
+func (n *telegramNotifier) Notify(ev *Event) error {
+	if !n.kinds.match(ev) {
+		return nil
+	}
+	text := fmt.Sprintf("%v %v: %v\nNodes: %v", severityIcon(ev.Severity), ev.Kind, ev.Message,
+		strings.Join(ev.Nodes, ", "))
+	return postJSON("POST", n.url, map[string]interface{}{
+		"chat_id":                  n.chatID,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	}, nil)
+}
+
+func severityIcon(s Severity) string {
+	switch s {
+	case SeverityCritical:
+		return "🔴"
+	case SeverityWarning:
+		return "🟠"
+	}
+	return "ℹ️"
+}
@@ -0,0 +1,198 @@
+package nodes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// traceNode is implemented by nodes which can trace the execution of blocks
+type traceNode interface {
+	Node
+	// TraceBlock traces the block with the given tracer
+	TraceBlock(hash common.Hash, tracer string) (json.RawMessage, error)
+}
+
+// traceTimeout bounds the tracing of a block. Tracing a full block can take
+// a while, but a node which doesn't answer shouldn't hold up the next ones.
+const traceTimeout = 2 * time.Minute
+
+// traceQueue is the number of blocks waiting to be traced, above which new
+// ones are dropped
+const traceQueue = 64
+
+func (node *RPCNode) TraceBlock(hash common.Hash, tracer string) (json.RawMessage, error) {
+	node.throttle.Take()
+	ctx, cancel := context.WithTimeout(context.Background(), traceTimeout)
+	defer cancel()
+	var res json.RawMessage
+	err := node.rpcCli.CallContext(ctx, &res, "debug_traceBlockByHash", hash,
+		map[string]interface{}{"tracer": tracer})
+	return res, wrapNodeError(node.name, "trace", err)
+}
+
+// splitPoint is the first diverging block of a split, as seen by one side
+type splitPoint struct {
+	node  Node
+	block uint64
+	hash  common.Hash
+}
+
+// traceJson is a stored trace of a diverging block
+type traceJson struct {
+	Node  string
+	Block uint64
+	Hash  common.Hash
+	// File is the path of the trace, relative to www/
+	File string
+}
+
+// splitTracer traces the first diverging block on both sides of splits, so
+// client developers have the execution of either side at hand. The tracing
+// happens in the background, as it can take a while.
+type splitTracer struct {
+	tracer string
+	dir    string
+	queue  chan splitPoint
+	mu     sync.Mutex
+	// traced are the traces done so far, by node and block hash. Blocks
+	// queued, or failed to trace, are nil.
+	traced map[string]*traceJson
+	// unsupported are the nodes which don't support tracing
+	unsupported map[string]bool
+	// copies are the stores which get a copy of the traces
+	copies artifactStores
+}
+
+// newSplitTracer returns nil if no tracer is configured
+func newSplitTracer(tracer string) *splitTracer {
+	if len(tracer) == 0 {
+		return nil
+	}
+	return &splitTracer{
+		tracer:      tracer,
+		dir:         "www/traces",
+		queue:       make(chan splitPoint, traceQueue),
+		traced:      make(map[string]*traceJson),
+		unsupported: make(map[string]bool),
+	}
+}
+
+func (st *splitTracer) forget(name string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.unsupported, name)
+}
+
+// traceFile returns the file name of the trace of the block by the node.
+// Characters which don't belong in a file name are replaced.
+func traceFile(node string, hash common.Hash) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, node)
+	return fmt.Sprintf("0x%x-%v.json", hash, name)
+}
+
+func traceKey(p splitPoint) string {
+	return fmt.Sprintf("%v/%x", p.node.Name(), p.hash)
+}
+
+// trace queues the split points not traced before, so each block is traced
+// once per node
+func (st *splitTracer) trace(points []splitPoint) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, p := range points {
+		key := traceKey(p)
+		if _, ok := st.traced[key]; ok || st.unsupported[p.node.Name()] {
+			continue
+		}
+		if _, ok := p.node.(traceNode); !ok {
+			continue
+		}
+		select {
+		case st.queue <- p:
+			st.traced[key] = nil
+		default:
+			log.Warn("Trace queue full, dropping split block", "node", p.node.Name(), "number", p.block)
+		}
+	}
+}
+
+// traceBlock traces the split block, and writes out the trace
+func (st *splitTracer) traceBlock(p splitPoint) {
+	name, key := p.node.Name(), traceKey(p)
+	res, err := p.node.(traceNode).TraceBlock(p.hash, st.tracer)
+	if err != nil {
+		st.mu.Lock()
+		if isMethodNotFound(err) {
+			log.Info("Node doesn't support tracing", "node", name)
+			st.unsupported[name] = true
+			delete(st.traced, key)
+		} else {
+			// The state may not be available anymore, don't retry
+			log.Warn("Failed to trace split block", "node", name, "number", p.block, "error", err)
+		}
+		st.mu.Unlock()
+		return
+	}
+	log.Info("Traced split block", "node", name, "number", p.block, "hash", p.hash)
+	fname := traceFile(name, p.hash)
+	if err := os.MkdirAll(st.dir, 0755); err != nil {
+		log.Warn("Failed to create trace directory", "error", err)
+		return
+	}
+	if err := ioutil.WriteFile(filepath.Join(st.dir, fname), res, 0644); err != nil {
+		log.Warn("Failed to write trace", "error", err)
+		return
+	}
+	st.copies.put("splits/traces/"+fname, res)
+	st.mu.Lock()
+	st.traced[key] = &traceJson{Node: name, Block: p.block, Hash: p.hash, File: "traces/" + fname}
+	st.mu.Unlock()
+}
+
+// traceSplits traces the queued split blocks until the monitor stops
+func (mon *NodeMonitor) traceSplits() {
+	defer mon.wg.Done()
+	for {
+		select {
+		case <-mon.quitCh:
+			return
+		case p := <-mon.splitTraces.queue:
+			mon.splitTraces.traceBlock(p)
+		}
+	}
+}
+
+// report lists the traces done so far, by block and node
+func (st *splitTracer) report() []*traceJson {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var list []*traceJson
+	for _, t := range st.traced {
+		if t != nil {
+			list = append(list, t)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Block != list[j].Block {
+			return list[i].Block > list[j].Block
+		}
+		return list[i].Node < list[j].Node
+	})
+	return list
+}
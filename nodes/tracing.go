@@ -0,0 +1,184 @@
+package nodes
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// span is a single timed operation within a check cycle. A nil span is valid,
+// and does nothing, which is what tracing-disabled monitors hand out.
+type span struct {
+	tracer   *tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    []string // key, value pairs
+}
+
+// tracer collects spans and ships them to an OpenTelemetry collector, using
+// the JSON encoding of OTLP over http.
+type tracer struct {
+	client   *http.Client
+	endpoint string
+	service  string
+
+	mu    sync.Mutex
+	spans []*span
+}
+
+func newTracer(conf tracingConfig) *tracer {
+	if len(conf.Endpoint) == 0 {
+		return nil
+	}
+	service := conf.ServiceName
+	if len(service) == 0 {
+		service = "nodemonitor"
+	}
+	return &tracer{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		endpoint: strings.TrimSuffix(conf.Endpoint, "/") + "/v1/traces",
+		service:  service,
+	}
+}
+
+// startSpan starts a new span. If parent is nil, the span starts a new trace.
+func (t *tracer) startSpan(parent *span, name string, attrs ...string) *span {
+	if t == nil {
+		return nil
+	}
+	s := &span{
+		tracer: t,
+		name:   name,
+		start:  time.Now(),
+		attrs:  attrs,
+	}
+	rand.Read(s.spanID[:])
+	if parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	return s
+}
+
+// finish ends the span, queueing it for export
+func (s *span) finish() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.tracer.mu.Lock()
+	s.tracer.spans = append(s.tracer.spans, s)
+	s.tracer.mu.Unlock()
+}
+
+// flush exports all finished spans in the background
+func (t *tracer) flush() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	go func() {
+		if err := t.export(spans); err != nil {
+			log.Warn("Failed to export traces", "error", err)
+		}
+	}()
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+}
+
+func toAttributes(kv []string) []otlpAttribute {
+	var attrs []otlpAttribute
+	for i := 0; i+1 < len(kv); i += 2 {
+		attrs = append(attrs, otlpAttribute{kv[i], otlpValue{kv[i+1]}})
+	}
+	return attrs
+}
+
+// payload builds an OTLP ExportTraceServiceRequest for the given spans
+func (t *tracer) payload(spans []*span) interface{} {
+	var out []otlpSpan
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:    hex.EncodeToString(s.traceID[:]),
+			SpanID:     hex.EncodeToString(s.spanID[:]),
+			Name:       s.name,
+			Kind:       1, // SPAN_KIND_INTERNAL
+			Start:      fmt.Sprint(s.start.UnixNano()),
+			End:        fmt.Sprint(s.end.UnixNano()),
+			Attributes: toAttributes(s.attrs),
+		}
+		if s.parentID != ([8]byte{}) {
+			o.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		out = append(out, o)
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": toAttributes([]string{"service.name", t.service}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "nodemonitor"},
+						"spans": out,
+					},
+				},
+			},
+		},
+	}
+}
+
+func (t *tracer) export(spans []*span) error {
+	data, err := json.Marshal(t.payload(spans))
+	if err != nil {
+		return err
+	}
+	res, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("trace export failed: %v", res.Status)
+	}
+	return nil
+}
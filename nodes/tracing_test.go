@@ -0,0 +1,36 @@
+package nodes
+
+import (
+	"testing"
+)
+
+func TestTracerSpans(t *testing.T) {
+	var nilTracer *tracer
+	// A disabled tracer hands out nil spans, which must be safe to use
+	nilTracer.startSpan(nil, "noop").finish()
+	nilTracer.flush()
+
+	tr := newTracer(tracingConfig{Endpoint: "http://localhost:4318"})
+	root := tr.startSpan(nil, "root")
+	child := tr.startSpan(root, "child", "node", "geth")
+	child.finish()
+	root.finish()
+
+	if len(tr.spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(tr.spans))
+	}
+	if child.traceID != root.traceID {
+		t.Errorf("child not in parent trace")
+	}
+	if child.parentID != root.spanID {
+		t.Errorf("child parent mismatch")
+	}
+	payload := tr.payload(tr.spans).(map[string]interface{})
+	spans := payload["resourceSpans"].([]interface{})[0].(map[string]interface{})["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]otlpSpan)
+	if spans[0].Attributes[0].Value.StringValue != "geth" {
+		t.Errorf("wrong attribute: %v", spans[0].Attributes)
+	}
+	if spans[1].ParentSpanID != "" {
+		t.Errorf("root span should have no parent")
+	}
+}
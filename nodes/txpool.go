@@ -0,0 +1,214 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+type txpoolConfig struct {
+	// Enabled polls the txpool sizes of the execution nodes each cycle, via
+	// txpool_status
+	Enabled bool
+	// MaxGrowth is the factor by which a pool may grow within the window
+	// before it's reported. Zero disables the check.
+	MaxGrowth float64
+	// Window is the time over which the growth is measured. Defaults to 10m.
+	Window string
+	// MinSize is the pool size below which pools are considered small:
+	// growth is measured from at least this size, and a node's pool is
+	// reported as empty only if the other pools are this large. Defaults to
+	// 100.
+	MinSize uint64
+}
+
+// txpoolNode is implemented by nodes which can report the size of their
+// txpool
+type txpoolNode interface {
+	Node
+	TxpoolStatus() (*txpoolJson, error)
+}
+
+// txpoolJson is the number of transactions in a txpool
+type txpoolJson struct {
+	// Pending are executable, Queued are waiting for a nonce gap to close
+	Pending uint64
+	Queued  uint64
+}
+
+func (tp *txpoolJson) size() uint64 {
+	return tp.Pending + tp.Queued
+}
+
+func (node *RPCNode) TxpoolStatus() (*txpoolJson, error) {
+	node.throttle.Take()
+	var res struct {
+		Pending hexutil.Uint64 `json:"pending"`
+		Queued  hexutil.Uint64 `json:"queued"`
+	}
+	if err := node.rpcCli.CallContext(context.Background(), &res, "txpool_status"); err != nil {
+		return nil, wrapNodeError(node.name, "txpool", err)
+	}
+	return &txpoolJson{Pending: uint64(res.Pending), Queued: uint64(res.Queued)}, nil
+}
+
+// txpoolSample is the size of a pool at some time
+type txpoolSample struct {
+	time time.Time
+	size uint64
+}
+
+// txpoolTracker keeps the txpool sizes of the nodes over the window
+type txpoolTracker struct {
+	maxGrowth   float64
+	window      time.Duration
+	minSize     uint64
+	unsupported map[string]bool
+	current     map[string]*txpoolJson
+	samples     map[string][]txpoolSample // oldest first
+}
+
+// newTxpoolTracker returns nil if the txpools aren't monitored
+func newTxpoolTracker(conf txpoolConfig) (*txpoolTracker, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+	if conf.MaxGrowth < 0 || conf.MaxGrowth > 0 && conf.MaxGrowth <= 1 {
+		return nil, fmt.Errorf("invalid txpool max growth %v, must be above 1", conf.MaxGrowth)
+	}
+	window := 10 * time.Minute
+	if len(conf.Window) > 0 {
+		var err error
+		if window, err = time.ParseDuration(conf.Window); err != nil {
+			return nil, fmt.Errorf("invalid txpool window: %v", err)
+		}
+	}
+	minSize := conf.MinSize
+	if minSize == 0 {
+		minSize = 100
+	}
+	return &txpoolTracker{
+		maxGrowth:   conf.MaxGrowth,
+		window:      window,
+		minSize:     minSize,
+		unsupported: make(map[string]bool),
+		current:     make(map[string]*txpoolJson),
+		samples:     make(map[string][]txpoolSample),
+	}, nil
+}
+
+func (t *txpoolTracker) forget(name string) {
+	delete(t.unsupported, name)
+	delete(t.current, name)
+	delete(t.samples, name)
+}
+
+// update fetches the pool sizes of the nodes. Nodes which fail to report
+// theirs are left out of the report until they do again.
+func (t *txpoolTracker) update(nodes []Node, now time.Time) {
+	t.current = make(map[string]*txpoolJson)
+	for _, n := range nodes {
+		node, ok := n.(txpoolNode)
+		if !ok || t.unsupported[node.Name()] {
+			continue
+		}
+		status, err := node.TxpoolStatus()
+		if err != nil {
+			if isMethodNotFound(err) {
+				log.Info("Node doesn't support txpool monitoring", "node", node.Name())
+				t.unsupported[node.Name()] = true
+			} else {
+				log.Debug("Failed to fetch txpool status", "node", node.Name(), "error", err)
+			}
+			continue
+		}
+		t.current[node.Name()] = status
+		samples := append(t.samples[node.Name()], txpoolSample{now, status.size()})
+		for len(samples) > 1 && now.Sub(samples[0].time) > t.window {
+			samples = samples[1:]
+		}
+		t.samples[node.Name()] = samples
+	}
+}
+
+// growth returns the factor by which the pool of the node grew within the
+// window, measured from at least the min size, and the size it grew from
+func (t *txpoolTracker) growth(name string) (float64, uint64) {
+	samples := t.samples[name]
+	if len(samples) < 2 {
+		return 1, 0
+	}
+	from, to := samples[0].size, samples[len(samples)-1].size
+	base := from
+	if base < t.minSize {
+		base = t.minSize
+	}
+	return float64(to) / float64(base), from
+}
+
+// empty returns the nodes whose pool is empty while the median pool of the
+// other nodes is at least the min size
+func (t *txpoolTracker) empty() []string {
+	var (
+		names []string
+		sizes []uint64
+	)
+	for name, status := range t.current {
+		names = append(names, name)
+		sizes = append(sizes, status.size())
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	sort.Strings(names)
+	var empty []string
+	for _, name := range names {
+		if t.current[name].size() > 0 {
+			continue
+		}
+		// The median of the others, the node itself being the smallest
+		others := sizes[1:]
+		if len(others) > 0 && others[len(others)/2] >= t.minSize {
+			empty = append(empty, name)
+		}
+	}
+	return empty
+}
+
+// checkTxpools updates the txpool sizes, and reports pools growing too fast,
+// and pools which are empty while those of the other nodes are not
+func (mon *NodeMonitor) checkTxpools(nodes []Node) {
+	t := mon.txpools
+	if t == nil {
+		return
+	}
+	t.update(nodes, time.Now())
+	if t.maxGrowth > 0 {
+		for _, node := range nodes {
+			status, ok := t.current[node.Name()]
+			if !ok {
+				continue
+			}
+			if growth, from := t.growth(node.Name()); growth > t.maxGrowth {
+				mon.emit(EventTxpoolGrowth, SeverityWarning, []string{node.Name()},
+					"Txpool grew from %d to %d transactions within %v", from, status.size(), t.window)
+			}
+		}
+	}
+	for _, name := range t.empty() {
+		mon.emit(EventTxpoolEmpty, SeverityWarning, []string{name},
+			"Txpool is empty, while those of the other nodes are not")
+	}
+}
+
+// setTxpools adds the txpool sizes to the nodes of the report
+func (r *Report) setTxpools(t *txpoolTracker) {
+	for _, c := range r.Cols {
+		if status, ok := t.current[c.Name]; ok {
+			cpy := *status
+			c.Txpool = &cpy
+		}
+	}
+}
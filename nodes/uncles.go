@@ -0,0 +1,103 @@
+package nodes
+
+import (
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// uncleTracker keeps track of which blocks include uncles, over a rolling
+// window of the most recent blocks. A spike in the uncle rate often comes
+// along with, or precedes, the nodes splitting. Post-merge, there are no
+// uncles.
+type uncleTracker struct {
+	window  uint64
+	maxRate float64
+	next    uint64          // next block to check
+	blocks  map[uint64]bool // block -> whether it includes uncles
+	uncles  int             // number of blocks with uncles within the window
+}
+
+// newUncleTracker returns nil if the uncle rate check is disabled, since it
+// costs a header lookup per block
+func newUncleTracker(window uint64, maxRate float64) *uncleTracker {
+	if maxRate == 0 {
+		return nil
+	}
+	if window == 0 {
+		window = 64
+	}
+	return &uncleTracker{
+		window:  window,
+		maxRate: maxRate,
+		blocks:  make(map[uint64]bool),
+	}
+}
+
+// update checks all blocks since the last update, up to the highest head
+// among the nodes, as seen by the node with that head.
+func (ut *uncleTracker) update(nodes []Node) {
+	var highest Node
+	for _, node := range nodes {
+		if highest == nil || node.HeadNum() > highest.HeadNum() {
+			highest = node
+		}
+	}
+	if highest == nil {
+		return
+	}
+	head := highest.HeadNum()
+	// Don't look further back than the window
+	if head >= ut.window && ut.next < head-ut.window+1 {
+		ut.next = head - ut.window + 1
+	}
+	for ; ut.next <= head; ut.next++ {
+		bl := highest.BlockAt(ut.next, false)
+		if bl == nil {
+			log.Debug("Failed to check block for uncles", "number", ut.next, "node", highest.Name())
+			break
+		}
+		ut.blocks[ut.next] = bl.uncles
+		if bl.uncles {
+			ut.uncles++
+		}
+	}
+	// Drop everything that fell out of the window
+	for num, uncles := range ut.blocks {
+		if num+ut.window <= head {
+			delete(ut.blocks, num)
+			if uncles {
+				ut.uncles--
+			}
+		}
+	}
+}
+
+// rate returns the fraction of blocks with uncles within the window
+func (ut *uncleTracker) rate() float64 {
+	if len(ut.blocks) == 0 {
+		return 0
+	}
+	return float64(ut.uncles) / float64(len(ut.blocks))
+}
+
+// checkUncles updates the uncle rate, and alerts if it's above the max. The
+// rate isn't alerted on until at least half of the window was checked.
+func (mon *NodeMonitor) checkUncles(nodes []Node) {
+	ut := mon.uncles
+	if ut == nil {
+		return
+	}
+	ut.update(nodes)
+	metrics.GetOrRegisterGaugeFloat64("chain/uncles/rate", mon.registry).Update(ut.rate())
+	if uint64(len(ut.blocks)) < ut.window/2 {
+		return
+	}
+	if rate := ut.rate(); rate > ut.maxRate {
+		var names []string
+		for _, node := range nodes {
+			names = append(names, node.Name())
+		}
+		mon.emit(EventUncleRate, SeverityWarning, names,
+			"Uncle rate of %.1f%% over the last %d blocks", 100*rate, len(ut.blocks))
+	}
+}
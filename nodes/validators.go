@@ -0,0 +1,200 @@
+package nodes
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// validatorJson is the performance of a monitored validator in the last
+// checked epoch
+type validatorJson struct {
+	Index           uint64
+	Label           string // the index or pubkey as configured
+	Epoch           uint64
+	Attested        bool
+	MissedProposals []uint64
+	// Balance is the latest sampled balance, and BalanceChange the change
+	// over the balance window, in gwei
+	Balance       uint64
+	BalanceChange int64
+	// SyncCommittee is "current" or "next" if the validator is in a sync
+	// committee, and SyncMisses its consecutive missed contributions
+	SyncCommittee string
+	SyncMisses    uint64
+}
+
+// validatorTracker checks the duties of a configured set of validators, once
+// per epoch.
+type validatorTracker struct {
+	ids       []string          // indices or pubkeys, as configured
+	labels    map[uint64]string // index -> configured id
+	lastEpoch uint64
+	results   map[uint64]*validatorJson
+	slashed   map[uint64]bool // validators already reported as slashed
+	sync      *syncTracker
+}
+
+func newValidatorTracker(ids []string, maxSyncMisses uint64) *validatorTracker {
+	return &validatorTracker{
+		ids:     ids,
+		results: make(map[uint64]*validatorJson),
+		slashed: make(map[uint64]bool),
+		sync:    newSyncTracker(maxSyncMisses),
+	}
+}
+
+// resolve looks up the indices of the configured validators
+func (vt *validatorTracker) resolve(node *BeaconNode) error {
+	var validators []struct {
+		Index     flexUint `json:"index"`
+		Validator struct {
+			Pubkey string `json:"pubkey"`
+		} `json:"validator"`
+	}
+	path := "/eth/v1/beacon/states/head/validators?id=" + strings.Join(vt.ids, ",")
+	if err := node.get(path, &validators); err != nil {
+		return err
+	}
+	if len(validators) != len(vt.ids) {
+		log.Warn("Not all validators found", "configured", len(vt.ids), "found", len(validators))
+	}
+	pubkeys := make(map[string]bool)
+	for _, id := range vt.ids {
+		pubkeys[strings.ToLower(id)] = true
+	}
+	vt.labels = make(map[uint64]string)
+	for _, v := range validators {
+		// Label them the way they were configured
+		label := fmt.Sprint(uint64(v.Index))
+		if pubkeys[strings.ToLower(v.Validator.Pubkey)] {
+			label = v.Validator.Pubkey
+		}
+		vt.labels[uint64(v.Index)] = label
+	}
+	return nil
+}
+
+// report returns the results of the last checked epoch along with the
+// balances, ordered by index
+func (vt *validatorTracker) report(bh *balanceHistory) []*validatorJson {
+	var res []*validatorJson
+	for index, label := range vt.labels {
+		v, ok := vt.results[index]
+		if !ok {
+			v = &validatorJson{Index: index, Label: label}
+		}
+		v.Balance, v.BalanceChange, _ = bh.change(index)
+		v.SyncCommittee, v.SyncMisses = vt.sync.membership(index), vt.sync.misses[index]
+		res = append(res, v)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Index < res[j].Index })
+	return res
+}
+
+func (vt *validatorTracker) indices() []string {
+	var indices []string
+	for index := range vt.labels {
+		indices = append(indices, fmt.Sprint(index))
+	}
+	return indices
+}
+
+// checkValidators checks attestation and proposal duties of the configured
+// validators, for the most recent epoch whose attestation rewards are known.
+func (mon *NodeMonitor) checkValidators(nodes []*BeaconNode) {
+	vt := mon.validators
+	if len(vt.ids) == 0 || len(nodes) == 0 {
+		return
+	}
+	node := nodes[0]
+	if vt.labels == nil {
+		if err := vt.resolve(node); err != nil {
+			log.Warn("Failed to resolve validators", "error", err)
+			return
+		}
+	}
+	mon.checkBalances(node)
+	mon.checkSyncCommittee(node)
+	// Attestations for epoch N can be included until the end of N+1
+	current := node.currentEpoch()
+	if current < 2 || vt.lastEpoch == current-2 {
+		return
+	}
+	epoch := current - 2
+	mon.checkSlashedValidators(node)
+
+	results := make(map[uint64]*validatorJson)
+	for index, label := range vt.labels {
+		results[index] = &validatorJson{Index: index, Label: label, Epoch: epoch}
+	}
+	if err := mon.checkAttestations(node, epoch, results); err != nil {
+		log.Warn("Failed to check attestations", "epoch", epoch, "error", err)
+		return
+	}
+	if err := mon.checkProposals(node, epoch, results); err != nil {
+		log.Warn("Failed to check proposals", "epoch", epoch, "error", err)
+		return
+	}
+	vt.lastEpoch = epoch
+	vt.results = results
+}
+
+// checkAttestations uses the attestation rewards to figure out whether the
+// validators' attestations were included: a non-positive source reward means
+// the attestation was missed.
+func (mon *NodeMonitor) checkAttestations(node *BeaconNode, epoch uint64, results map[uint64]*validatorJson) error {
+	var rewards struct {
+		TotalRewards []struct {
+			ValidatorIndex flexUint `json:"validator_index"`
+			Source         string   `json:"source"`
+		} `json:"total_rewards"`
+	}
+	path := fmt.Sprintf("/eth/v1/beacon/rewards/attestations/%d", epoch)
+	if err := node.post(path, mon.validators.indices(), &rewards); err != nil {
+		return err
+	}
+	for _, r := range rewards.TotalRewards {
+		if res, ok := results[uint64(r.ValidatorIndex)]; ok {
+			res.Attested = len(r.Source) > 0 && r.Source != "0" && !strings.HasPrefix(r.Source, "-")
+		}
+	}
+	for _, res := range results {
+		if !res.Attested {
+			mon.emit(EventMissedAttestation, SeverityWarning, []string{node.Name()},
+				"Validator %v missed its attestation in epoch %d", res.Label, epoch)
+		}
+	}
+	return nil
+}
+
+// checkProposals checks that all proposals scheduled for the validators made
+// it into the chain.
+func (mon *NodeMonitor) checkProposals(node *BeaconNode, epoch uint64, results map[uint64]*validatorJson) error {
+	var duties []struct {
+		ValidatorIndex flexUint `json:"validator_index"`
+		Slot           flexUint `json:"slot"`
+	}
+	if err := node.get(fmt.Sprintf("/eth/v1/validator/duties/proposer/%d", epoch), &duties); err != nil {
+		return err
+	}
+	for _, d := range duties {
+		res, ok := results[uint64(d.ValidatorIndex)]
+		if !ok {
+			continue
+		}
+		h, err := node.header(fmt.Sprint(uint64(d.Slot)))
+		if err != nil && !errors.Is(err, errNotFound) {
+			return err
+		}
+		if h == nil || h.Header.Message.ProposerIndex != uint64(d.ValidatorIndex) {
+			res.MissedProposals = append(res.MissedProposals, uint64(d.Slot))
+			mon.emit(EventMissedProposal, SeverityCritical, []string{node.Name()},
+				"Validator %v missed its proposal in slot %d", res.Label, uint64(d.Slot))
+		}
+	}
+	return nil
+}
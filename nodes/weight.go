@@ -0,0 +1,157 @@
+package nodes
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// checkWeights compares the total difficulty at the heads of the nodes. A
+// node whose head isn't on the chain of the heaviest node, and which has a
+// lower total difficulty, follows a lighter fork, even if the heads happen to
+// agree at some heights. The same block having different total difficulties
+// on two nodes means one of them has a corrupt database.
+//
+// Nodes not reporting a total difficulty are skipped. Post-merge, the total
+// difficulty no longer grows, so forks are left to the split check.
+func (mon *NodeMonitor) checkWeights(active []Node) {
+	var (
+		heaviest   Node
+		heaviestTd *big.Int
+	)
+	for _, node := range active {
+		bl := node.BlockAt(node.HeadNum(), false)
+		if bl == nil || bl.td == nil {
+			continue
+		}
+		if heaviestTd == nil || bl.td.Cmp(heaviestTd) > 0 {
+			heaviest, heaviestTd = node, bl.td
+		}
+	}
+	if heaviest == nil {
+		return
+	}
+	for _, node := range active {
+		if node == heaviest {
+			continue
+		}
+		bl := node.BlockAt(node.HeadNum(), false)
+		if bl == nil || bl.td == nil {
+			continue
+		}
+		other := heaviest.BlockAt(bl.num, false)
+		if other == nil && bl.num <= heaviest.HeadNum() {
+			continue // can't tell
+		}
+		if other != nil && other.hash == bl.hash {
+			if other.td != nil && other.td.Cmp(bl.td) != 0 {
+				mon.emitBlock(EventWeightMismatch, SeverityCritical, []string{node.Name(), heaviest.Name()},
+					bl.num, []common.Hash{bl.hash, other.hash},
+					"Total difficulty of block %d differs: %v vs %v", bl.num, bl.td, other.td)
+			}
+			continue
+		}
+		if bl.td.Cmp(heaviestTd) < 0 {
+			mon.emitBlock(EventLightChain, SeverityWarning, []string{node.Name()}, bl.num, []common.Hash{bl.hash},
+				"Following a lighter chain, total difficulty %v at block %d versus %v on %v",
+				bl.td, bl.num, heaviestTd, heaviest.Name())
+		}
+	}
+}
+
+// forkChoiceNode is a block in the fork choice tree of a beacon node
+type forkChoiceNode struct {
+	BlockRoot  common.Hash `json:"block_root"`
+	ParentRoot common.Hash `json:"parent_root"`
+	Weight     uint64      `json:"weight,string"`
+}
+
+// forkChoice is the fork choice tree of a beacon node, by block root
+type forkChoice map[common.Hash]forkChoiceNode
+
+// forkChoice fetches the fork choice tree from the debug API. Unlike the
+// other endpoints, its response isn't wrapped in a 'data' field.
+func (node *BeaconNode) forkChoice() (forkChoice, error) {
+	var res struct {
+		Nodes []forkChoiceNode `json:"fork_choice_nodes"`
+	}
+	if err := node.fetch("GET", "/eth/v1/debug/fork_choice", nil, &res); err != nil {
+		return nil, err
+	}
+	fc := make(forkChoice, len(res.Nodes))
+	for _, n := range res.Nodes {
+		fc[n.BlockRoot] = n
+	}
+	return fc, nil
+}
+
+// branches returns the children of the common ancestor of the two blocks,
+// which lead to a and b respectively. It returns false if either block is
+// unknown, or if one is an ancestor of the other.
+func (fc forkChoice) branches(a, b common.Hash) (forkChoiceNode, forkChoiceNode, bool) {
+	// The ancestors of a, along with the child leading towards a
+	towardsA := make(map[common.Hash]forkChoiceNode)
+	n, ok := fc[a]
+	if !ok {
+		return n, n, false
+	}
+	for {
+		parent, ok := fc[n.ParentRoot]
+		if !ok {
+			break
+		}
+		towardsA[parent.BlockRoot] = n
+		n = parent
+	}
+	var child forkChoiceNode
+	for root := b; ; {
+		n, ok := fc[root]
+		if !ok {
+			return n, n, false
+		}
+		if root == a {
+			return n, n, false // b descends from a
+		}
+		if ca, ok := towardsA[root]; ok {
+			if child.BlockRoot == (common.Hash{}) {
+				return n, n, false // b is an ancestor of a
+			}
+			return ca, child, true
+		}
+		child, root = n, n.ParentRoot
+	}
+}
+
+// checkForkChoice compares the heads of the beacon nodes, using the fork
+// choice weights. If two nodes are on different branches, and the branch of
+// one is lighter in its own view of the fork choice, it's reported as
+// following the lighter chain. Nodes without the debug API are skipped.
+func (mon *NodeMonitor) checkForkChoice(active []*BeaconNode) {
+	trees := make(map[*BeaconNode]forkChoice)
+	for _, node := range active {
+		fc, err := node.forkChoice()
+		if err != nil {
+			log.Debug("Failed to fetch fork choice", "node", node.name, "error", err)
+			continue
+		}
+		trees[node] = fc
+	}
+	for _, a := range active {
+		fc, ok := trees[a]
+		if !ok {
+			continue
+		}
+		for _, b := range active {
+			if a == b || a.head.Root == b.head.Root {
+				continue
+			}
+			own, other, ok := fc.branches(a.head.Root, b.head.Root)
+			if ok && own.Weight < other.Weight {
+				mon.emit(EventLightChain, SeverityWarning, []string{a.name},
+					"Head %x is on a branch of weight %d, while the branch of %v's head %x has weight %d",
+					a.head.Root, own.Weight, b.name, b.head.Root, other.Weight)
+			}
+		}
+	}
+}
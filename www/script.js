@@ -20,6 +20,13 @@ let utils = {
         hashstr =  hashstr.slice(0,8)+"…"//+hashstr.slice(-6);
         return hashstr
     },
+    // shortAddress abbreviates a 40-char hex address
+    shortAddress: function(addr){
+        if (addr.length !== 42){
+            return addr
+        }
+        return addr.slice(0,8)+"…"+addr.slice(-4)
+    },
     etherscanLink : function(hash){
         let x = document.createElement("a");
         x.setAttribute("href","https://etherscan.org/block/"+hash);
@@ -140,13 +147,30 @@ function onData(data){
         let name = client.Name
         let version = client.Version
         let status = "OK"
-        if (client.Status != 0) {
+        if (client.Status == 2) {
+            status = " (throttled)"
+        } else if (client.Status != 0) {
             status = " (unhealthy)"
         }
         let tRow = utils.tag("tr")
         tRow.append(utils.tag("td", name))
         tRow.append(utils.tag("td", version))
         tRow.append(utils.tag("td", status))
+        tRow.append(utils.tag("td", client.Head))
+        let head = client.HeadBlock
+        if (head) {
+            tRow.append(utils.tag("td", new Date(head.Time).toLocaleTimeString()))
+            tRow.append(utils.tag("td", utils.shortAddress(head.Miner)))
+            tRow.append(utils.tag("td", head.TxCount === undefined ? "" : head.TxCount))
+            tRow.append(utils.tag("td", head.GasUsed.toLocaleString()))
+            // How long after its timestamp the block reached the monitor
+            let delay = (new Date(head.Received) - new Date(head.Time)) / 1000
+            tRow.append(utils.tag("td", new Date(head.Received).toLocaleTimeString() + " (+" + delay.toFixed(1) + "s)"))
+        } else {
+            for (let i = 0; i < 5; i++) {
+                tRow.append(utils.tag("td"))
+            }
+        }
         nodeB.append(tRow)
         // Add td headings
         thead.append(utils.tag("th", name))